@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"EverythingSuckz/fsb/pkg/downloader"
+
+	"github.com/spf13/cobra"
+)
+
+var getCmd = &cobra.Command{
+	Use:                "get <link>",
+	Short:              "Download a file from a stream link.",
+	Example:            "fsb get https://example.com/stream/123?hash=abcdef --out video.mp4",
+	Args:               cobra.ExactArgs(1),
+	DisableSuggestions: false,
+	Run:                downloadFile,
+}
+
+func init() {
+	getCmd.Flags().StringP("out", "o", "", "Output file path (defaults to the name sent by the server)")
+	getCmd.Flags().IntP("segments", "s", 4, "Number of parallel ranged segments")
+	getCmd.Flags().String("sha256", "", "Expected SHA-256 checksum of the file")
+}
+
+func downloadFile(cmd *cobra.Command, args []string) {
+	out, _ := cmd.Flags().GetString("out")
+	segments, _ := cmd.Flags().GetInt("segments")
+	checksum, _ := cmd.Flags().GetString("sha256")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err := downloader.Download(ctx, downloader.Options{
+		URL:      args[0],
+		Output:   out,
+		Segments: segments,
+		SHA256:   checksum,
+	})
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("Run the same command again to resume the download.")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var healthCmd = &cobra.Command{
+	Use:                "health",
+	Short:              "Check that a running server's workers answer Telegram, for container healthchecks.",
+	Example:            "fsb health --url http://localhost:8080/health",
+	Args:               cobra.NoArgs,
+	DisableSuggestions: false,
+	Run:                checkHealth,
+}
+
+func init() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	healthCmd.Flags().String("url", "http://localhost:"+port+"/health", "URL of the server's health route")
+}
+
+// checkHealth exits with 1 unless the server's health route answers 200,
+// as the image has no curl or wget for a HEALTHCHECK to use.
+func checkHealth(cmd *cobra.Command, args []string) {
+	url, _ := cmd.Flags().GetString("url")
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	// the route is behind BASIC_AUTH_USER like the others
+	if user := os.Getenv("BASIC_AUTH_USER"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("BASIC_AUTH_PASSWORD"))
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(string(body))
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
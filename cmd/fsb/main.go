@@ -24,8 +24,13 @@ var rootCmd = &cobra.Command{
 
 func init() {
 	config.SetFlagsFromConfig(runCmd)
+	config.SetFlagsFromConfig(replayCmd)
 	rootCmd.AddCommand(runCmd)
 	rootCmd.AddCommand(sessionCmd)
+	rootCmd.AddCommand(getCmd)
+	rootCmd.AddCommand(serviceCmd)
+	rootCmd.AddCommand(replayCmd)
+	rootCmd.AddCommand(healthCmd)
 	rootCmd.SetVersionTemplate(fmt.Sprintf(`Telegram File Stream Bot version %s`, versionString))
 }
 
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+var replayCmd = &cobra.Command{
+	Use:                "replay <trace file>",
+	Short:              "Re-fetch the chunks recorded in a stream trace and report differences.",
+	Example:            "fsb replay traces/20240101-120000.000-42-0.jsonl",
+	Args:               cobra.ExactArgs(1),
+	DisableSuggestions: false,
+	Run:                replayTrace,
+}
+
+func replayTrace(cmd *cobra.Command, args []string) {
+	utils.InitLogger(config.ValueOf.Dev)
+	log := utils.Logger
+	if err := config.Load(log, cmd); err != nil {
+		log.Fatal("Failed to load config", zap.Error(err))
+	}
+	header, chunks, err := utils.ReadStreamTrace(args[0])
+	if err != nil {
+		log.Fatal("Failed to read trace", zap.Error(err))
+	}
+	cache.InitCache(log)
+	client, err := bot.StartStandaloneClient(log)
+	if err != nil {
+		log.Fatal("Failed to start client", zap.Error(err))
+	}
+	defer client.Stop()
+	ctx := context.Background()
+	file, err := utils.FileFromMessage(ctx, client, header.ChannelID, header.MessageID)
+	if err != nil {
+		log.Fatal("Failed to get file", zap.Error(err))
+	}
+	fmt.Printf("Replaying %d chunk(s) of %s (%d bytes), recorded at %s for bytes %d-%d\n",
+		len(chunks), file.FileName, file.FileSize, header.Time.Format("2006-01-02 15:04:05"), header.Start, header.End)
+	if file.FileSize != header.FileSize {
+		fmt.Printf("File size changed: recorded %d, now %d\n", header.FileSize, file.FileSize)
+	}
+	mismatches := 0
+	for _, chunk := range chunks {
+		data, err := utils.FetchChunk(ctx, client, file.Location, chunk.Offset, int64(chunk.Limit))
+		if err != nil {
+			mismatches++
+			fmt.Printf("offset %d: fetch failed: %s (recorded error: %q)\n", chunk.Offset, err, chunk.Error)
+			continue
+		}
+		checksum := utils.ChunkChecksum(data)
+		if chunk.Error != "" || len(data) != chunk.Length || checksum != chunk.SHA1 {
+			mismatches++
+			fmt.Printf("offset %d: MISMATCH recorded %d bytes sha1=%s error=%q, now %d bytes sha1=%s\n",
+				chunk.Offset, chunk.Length, chunk.SHA1, chunk.Error, len(data), checksum)
+			continue
+		}
+		fmt.Printf("offset %d: ok (%d bytes)\n", chunk.Offset, len(data))
+	}
+	fmt.Printf("%d/%d chunk(s) differ\n", mismatches, len(chunks))
+	if mismatches > 0 {
+		client.Stop()
+		os.Exit(1)
+	}
+}
@@ -2,18 +2,22 @@ package main
 
 import (
 	"EverythingSuckz/fsb/config"
-	"EverythingSuckz/fsb/internal/bot"
-	"EverythingSuckz/fsb/internal/cache"
-	"EverythingSuckz/fsb/internal/routes"
-	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/service"
 	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/fsb"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
-	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
@@ -24,53 +28,104 @@ var runCmd = &cobra.Command{
 	Run:                runApp,
 }
 
-var startTime time.Time = time.Now()
-
 func runApp(cmd *cobra.Command, args []string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := service.Run(ctx, func(ctx context.Context) {
+		startServer(ctx, cmd)
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func startServer(ctx context.Context, cmd *cobra.Command) {
 	utils.InitLogger(config.ValueOf.Dev)
 	log := utils.Logger
 	mainLogger := log.Named("Main")
-	mainLogger.Info("Starting server")
-	config.Load(log, cmd)
-	router := getRouter(log)
-
-	mainBot, err := bot.StartClient(log)
+	config.LoadFlags(log, cmd)
+	fsbServer, err := fsb.StartServer(ctx, fsb.Config{Logger: log, Version: versionString})
 	if err != nil {
-		log.Panic("Failed to start main bot", zap.Error(err))
+		log.Panic("Failed to start server", zap.Error(err))
 	}
-	cache.InitCache(log)
-	workers, err := bot.StartWorkers(log)
+
+	listener, err := service.Listen(config.ValueOf.Port)
 	if err != nil {
-		log.Panic("Failed to start workers", zap.Error(err))
-		return
+		mainLogger.Sugar().Fatalln(err)
 	}
-	workers.AddDefaultClient(mainBot, mainBot.Self)
-	bot.StartUserBot(log)
-	mainLogger.Info("Server started", zap.Int("port", config.ValueOf.Port))
+	server := &http.Server{
+		Handler: fsbServer.Handler(),
+		// stream links are short, nothing needs the default 1 MB of headers
+		MaxHeaderBytes: 16 << 10,
+	}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			mainLogger.Sugar().Fatalln(err)
+		}
+	}()
+	var adminServer *http.Server
+	if adminHandler := fsbServer.AdminHandler(); adminHandler != nil {
+		adminServer, err = startAdminServer(adminHandler)
+		if err != nil {
+			mainLogger.Sugar().Fatalln(err)
+		}
+		mainLogger.Info("Admin server started", zap.String("address", adminServer.Addr))
+	}
+	service.Notify("READY=1")
+	mainLogger.Info("Server started", zap.String("address", listener.Addr().String()))
 	mainLogger.Info("File Stream Bot", zap.String("version", versionString))
 	mainLogger.Sugar().Infof("Server is running at %s", config.ValueOf.Host)
-	err = router.Run(fmt.Sprintf(":%d", config.ValueOf.Port))
-	if err != nil {
-		mainLogger.Sugar().Fatalln(err)
+
+	<-ctx.Done()
+	mainLogger.Info("Shutting down")
+	service.Notify("STOPPING=1")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		mainLogger.Warn("Timed out waiting for active streams to finish", zap.Error(err))
+	}
+	if adminServer != nil {
+		adminServer.Shutdown(shutdownCtx)
 	}
+	fsbServer.Close()
 }
 
-func getRouter(log *zap.Logger) *gin.Engine {
-	if config.ValueOf.Dev {
-		gin.SetMode(gin.DebugMode)
-	} else {
-		gin.SetMode(gin.ReleaseMode)
+// startAdminServer serves the admin router on ADMIN_PORT over TLS, only
+// accepting clients with a certificate signed by ADMIN_CLIENT_CA.
+func startAdminServer(handler http.Handler) (*http.Server, error) {
+	caPEM, err := os.ReadFile(config.ValueOf.AdminClientCA)
+	if err != nil {
+		return nil, err
 	}
-	router := gin.Default()
-	router.Use(gin.ErrorLogger())
-	router.GET("/", func(ctx *gin.Context) {
-		ctx.JSON(http.StatusOK, types.RootResponse{
-			Message: "Server is running.",
-			Ok:      true,
-			Uptime:  utils.TimeFormat(uint64(time.Since(startTime).Seconds())),
-			Version: versionString,
-		})
-	})
-	routes.Load(log, router)
-	return router
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", config.ValueOf.AdminClientCA)
+	}
+	cert, err := tls.LoadX509KeyPair(config.ValueOf.AdminTLSCert, config.ValueOf.AdminTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", config.ValueOf.AdminPort),
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	listener, err := tls.Listen("tcp", server.Addr, server.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.Logger.Sugar().Fatalln(err)
+		}
+	}()
+	return server, nil
 }
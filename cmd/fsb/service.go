@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"EverythingSuckz/fsb/internal/service"
+
+	"github.com/spf13/cobra"
+)
+
+var serviceCmd = &cobra.Command{
+	Use:   "service",
+	Short: "Manage the bot as a system service.",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var serviceInstallCmd = &cobra.Command{
+	Use:     "install",
+	Short:   "Install the bot as a systemd (Linux) or Windows service.",
+	Example: "fsb service install --port 8080",
+	Run:     manageService(service.Install, "Installed"),
+}
+
+var serviceUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the installed service.",
+	Run:   manageService(service.Uninstall, "Uninstalled"),
+}
+
+func init() {
+	serviceCmd.PersistentFlags().String("unit-dir", "/etc/systemd/system", "Directory to write systemd units to (Linux only)")
+	serviceInstallCmd.Flags().IntP("port", "p", 8080, "Port systemd should listen on (Linux only)")
+	serviceCmd.AddCommand(serviceInstallCmd)
+	serviceCmd.AddCommand(serviceUninstallCmd)
+}
+
+func manageService(action func(service.InstallOptions) error, done string) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		unitDir, _ := cmd.Flags().GetString("unit-dir")
+		port, _ := cmd.Flags().GetInt("port")
+		exe, err := os.Executable()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		workDir, err := os.Getwd()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err = action(service.InstallOptions{
+			Executable: exe,
+			WorkingDir: workDir,
+			Port:       port,
+			UnitDir:    unitDir,
+		})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s service %s (executable %s)\n", done, service.Name, filepath.Base(exe))
+	}
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -11,6 +14,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -37,25 +41,158 @@ func (au *allowedUsers) Decode(value string) error {
 	return nil
 }
 
+// Chaos injects faults into the chunk fetches of workers, to validate
+// retries, failover and players in staging before they are needed in
+// production. It's set with CHAOS, like "fail=0.05,latency=300ms,flood=0.01",
+// and deliberately left out of the README.
+type Chaos struct {
+	// FailureRate is the probability of a chunk fetch failing.
+	FailureRate float64
+	// Latency is the most a chunk fetch is delayed by, each one is delayed
+	// by a random duration up to it.
+	Latency time.Duration
+	// FloodRate is the probability of a chunk fetch getting a FLOOD_WAIT of
+	// FloodWait seconds.
+	FloodRate float64
+	FloodWait int
+}
+
+func (c *Chaos) Decode(value string) error {
+	c.FloodWait = 3
+	if value == "" {
+		return nil
+	}
+	for _, option := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(option), "=")
+		var err error
+		switch name {
+		case "fail":
+			c.FailureRate, err = strconv.ParseFloat(arg, 64)
+		case "latency":
+			c.Latency, err = time.ParseDuration(arg)
+		case "flood":
+			c.FloodRate, err = strconv.ParseFloat(arg, 64)
+		case "flood_wait":
+			c.FloodWait, err = strconv.Atoi(arg)
+		default:
+			return fmt.Errorf("unknown chaos option %q", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid chaos option %q: %w", option, err)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether any fault is injected.
+func (c Chaos) Enabled() bool {
+	return c.FailureRate > 0 || c.Latency > 0 || c.FloodRate > 0
+}
+
 type config struct {
-	ApiID          int32        `envconfig:"API_ID" required:"true"`
-	ApiHash        string       `envconfig:"API_HASH" required:"true"`
-	BotToken       string       `envconfig:"BOT_TOKEN" required:"true"`
-	LogChannelID   int64        `envconfig:"LOG_CHANNEL" required:"true"`
-	Dev            bool         `envconfig:"DEV" default:"false"`
-	Port           int          `envconfig:"PORT" default:"8080"`
-	Host           string       `envconfig:"HOST" default:""`
-	HashLength     int          `envconfig:"HASH_LENGTH" default:"6"`
-	UseSessionFile bool         `envconfig:"USE_SESSION_FILE" default:"true"`
-	UserSession    string       `envconfig:"USER_SESSION"`
-	UsePublicIP    bool         `envconfig:"USE_PUBLIC_IP" default:"false"`
-	AllowedUsers   allowedUsers `envconfig:"ALLOWED_USERS"`
-	MultiTokens    []string
+	ApiID             int32         `envconfig:"API_ID" required:"true"`
+	ApiHash           string        `envconfig:"API_HASH" required:"true"`
+	BotToken          string        `envconfig:"BOT_TOKEN" required:"true"`
+	LogChannelID      int64         `envconfig:"LOG_CHANNEL" required:"true"`
+	Dev               bool          `envconfig:"DEV" default:"false"`
+	StreamTrace       bool          `envconfig:"STREAM_TRACE" default:"false"`
+	DebugHeaders      bool          `envconfig:"DEBUG_HEADERS" default:"false"`
+	StrictMode        bool          `envconfig:"STRICT_MODE" default:"false"`
+	RangeLog          string        `envconfig:"RANGE_LOG" default:"summary"`
+	BreakerThreshold  int           `envconfig:"BREAKER_THRESHOLD" default:"5"`
+	APICallsPerSecond int           `envconfig:"API_CALLS_PER_SECOND" default:"10"`
+	APIBurst          int           `envconfig:"API_BURST" default:"5"`
+	WorkersMin        int           `envconfig:"WORKERS_MIN"`
+	WorkersMax        int           `envconfig:"WORKERS_MAX"`
+	StreamsPerWorker  int           `envconfig:"STREAMS_PER_WORKER" default:"4"`
+	MaxResponseGB     int           `envconfig:"MAX_RESPONSE_GB"`
+	LinkRateLimitKB   int           `envconfig:"LINK_RATE_LIMIT_KB"`
+	EgressRateKB      int           `envconfig:"EGRESS_RATE_KB"`
+	QuotaMB           int           `envconfig:"QUOTA_MB"`
+	QuotaDownloads    int           `envconfig:"QUOTA_DOWNLOADS"`
+	SeekWindowMB      int           `envconfig:"SEEK_WINDOW_MB" default:"8"`
+	ChunkCacheMB      int           `envconfig:"CHUNK_CACHE_MB" default:"64"`
+	ParallelChunks    int           `envconfig:"PARALLEL_CHUNKS" default:"1"`
+	ReadAheadMB       int           `envconfig:"READ_AHEAD_MB"`
+	DiskCacheMB       int           `envconfig:"DISK_CACHE_MB"`
+	DiskCachePath     string        `envconfig:"DISK_CACHE_PATH" default:"cache"`
+	AdaptiveChunks    bool          `envconfig:"ADAPTIVE_CHUNKS" default:"false"`
+	PinStreams        bool          `envconfig:"PIN_STREAMS" default:"true"`
+	WarmupInterval    time.Duration `envconfig:"WARMUP_INTERVAL" default:"2m"`
+	Port              int           `envconfig:"PORT" default:"8080"`
+	HTTPRouter        string        `envconfig:"HTTP_ROUTER" default:"gin"`
+	ASCIIFilenames    bool          `envconfig:"ASCII_FILENAMES" default:"false"`
+	EarlyHints        bool          `envconfig:"EARLY_HINTS" default:"true"`
+	WebDir            string        `envconfig:"WEB_DIR"`
+	URLPatterns       string        `envconfig:"URL_PATTERNS"`
+	RateLimitMessages int           `envconfig:"RATE_LIMIT_MESSAGES" default:"20"`
+	RateLimitWindow   time.Duration `envconfig:"RATE_LIMIT_WINDOW" default:"1m"`
+	Host              string        `envconfig:"HOST" default:""`
+	HashLength        int           `envconfig:"HASH_LENGTH" default:"6"`
+	HashFailures      int           `envconfig:"HASH_FAILURES" default:"10"`
+	HashLockout       time.Duration `envconfig:"HASH_LOCKOUT" default:"15m"`
+	IPRequestLimit    int           `envconfig:"IP_REQUEST_LIMIT"`
+	IPRequestWindow   time.Duration `envconfig:"IP_REQUEST_WINDOW" default:"1m"`
+	IPStreamLimit     int           `envconfig:"IP_STREAM_LIMIT"`
+	UseSessionFile    bool          `envconfig:"USE_SESSION_FILE" default:"true"`
+	UserSession       string        `envconfig:"USER_SESSION"`
+	UsePublicIP       bool          `envconfig:"USE_PUBLIC_IP" default:"false"`
+	AllowedUsers      allowedUsers  `envconfig:"ALLOWED_USERS"`
+	OwnerID           int64         `envconfig:"OWNER_ID"`
+	BasicAuthUser     string        `envconfig:"BASIC_AUTH_USER"`
+	BasicAuthPassword string        `envconfig:"BASIC_AUTH_PASSWORD"`
+	AdminToken        string        `envconfig:"ADMIN_TOKEN"`
+	AdminPort         int           `envconfig:"ADMIN_PORT"`
+	AdminTLSCert      string        `envconfig:"ADMIN_TLS_CERT"`
+	AdminTLSKey       string        `envconfig:"ADMIN_TLS_KEY"`
+	AdminClientCA     string        `envconfig:"ADMIN_CLIENT_CA"`
+	CLICommands       bool          `envconfig:"CLI_COMMANDS" default:"false"`
+	EmbedSecret       string        `envconfig:"EMBED_SECRET"`
+	DatabaseURL       string        `envconfig:"DATABASE_URL" default:"fsb.db"`
+	UploadTarget      string        `envconfig:"UPLOAD_TARGET" default:"telegram"`
+	RestrictedMedia   string        `envconfig:"RESTRICTED_MEDIA" default:"refuse"`
+	HTTPUploads       bool          `envconfig:"HTTP_UPLOADS" default:"false"`
+	WebDAV            bool          `envconfig:"WEBDAV" default:"false"`
+	Browse            bool          `envconfig:"BROWSE" default:"false"`
+	MaxBodyKB         int           `envconfig:"MAX_BODY_KB" default:"64"`
+	MaxUploadMB       int           `envconfig:"MAX_UPLOAD_MB" default:"2048"`
+	Compression       string        `envconfig:"COMPRESSION" default:"zstd,br,gzip"`
+	GzipLevel         int           `envconfig:"GZIP_LEVEL" default:"6"`
+	BrotliLevel       int           `envconfig:"BROTLI_LEVEL" default:"4"`
+	ZstdLevel         int           `envconfig:"ZSTD_LEVEL" default:"3"`
+	CompressCPULoad   int           `envconfig:"COMPRESS_CPU_LOAD" default:"80"`
+	S3Endpoint        string        `envconfig:"S3_ENDPOINT" default:"https://s3.amazonaws.com"`
+	S3Region          string        `envconfig:"S3_REGION" default:"us-east-1"`
+	S3Bucket          string        `envconfig:"S3_BUCKET"`
+	S3AccessKey       string        `envconfig:"S3_ACCESS_KEY"`
+	S3SecretKey       string        `envconfig:"S3_SECRET_KEY"`
+	S3PathStyle       bool          `envconfig:"S3_PATH_STYLE" default:"true"`
+	S3GatewayBucket   string        `envconfig:"S3_GATEWAY_BUCKET" default:"fsb"`
+	S3GatewayKey      string        `envconfig:"S3_GATEWAY_KEY"`
+	S3GatewaySecret   string        `envconfig:"S3_GATEWAY_SECRET"`
+	WhisperURL        string        `envconfig:"WHISPER_URL"`
+	WhisperAPIKey     string        `envconfig:"WHISPER_API_KEY"`
+	WhisperModel      string        `envconfig:"WHISPER_MODEL" default:"whisper-1"`
+	FFprobePath       string        `envconfig:"FFPROBE_PATH"`
+	FFmpegPath        string        `envconfig:"FFMPEG_PATH"`
+	HLSSegmentSeconds int           `envconfig:"HLS_SEGMENT_SECONDS" default:"6"`
+	HLSCacheMB        int           `envconfig:"HLS_CACHE_MB" default:"256"`
+	TranscodeJobs     int           `envconfig:"TRANSCODE_JOBS" default:"2"`
+	TorrentTrackers   string        `envconfig:"TORRENT_TRACKERS"`
+	JobWorkers        int           `envconfig:"JOB_WORKERS" default:"2"`
+	FederationPeers   string        `envconfig:"FEDERATION_PEERS"`
+	FederationSecret  string        `envconfig:"FEDERATION_SECRET"`
+	Plugins           string        `envconfig:"PLUGINS"`
+	GeoIPDB           string        `envconfig:"GEOIP_DB"`
+	GeoIPHeader       string        `envconfig:"GEOIP_HEADER"`
+	TrustedProxies    []string      `envconfig:"TRUSTED_PROXIES"`
+	Chaos             Chaos         `envconfig:"CHAOS"`
+	MultiTokens       []string
 }
 
 var botTokenRegex = regexp.MustCompile(`MULTI\_TOKEN\d+=(.*)`)
 
-func (c *config) loadFromEnvFile(log *zap.Logger) {
+func (c *config) loadFromEnvFile(log *zap.Logger) error {
 	envPath := filepath.Clean("fsb.env")
 	log.Sugar().Infof("Trying to load ENV vars from %s", envPath)
 	err := godotenv.Load(envPath)
@@ -66,9 +203,10 @@ func (c *config) loadFromEnvFile(log *zap.Logger) {
 			log.Sugar().Info("For more info, refer: https://github.com/EverythingSuckz/TG-FileStreamBot/tree/golang#setting-up-things")
 			log.Sugar().Info("Please ignore this message if you are hosting it in a service like Heroku or other alternatives.")
 		} else {
-			log.Fatal("Unknown error while parsing env file.", zap.Error(err))
+			return fmt.Errorf("failed to parse env file: %w", err)
 		}
 	}
+	return nil
 }
 
 func SetFlagsFromConfig(cmd *cobra.Command) {
@@ -138,12 +276,16 @@ func (c *config) loadConfigFromArgs(log *zap.Logger, cmd *cobra.Command) {
 	}
 }
 
-func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command) {
-	c.loadFromEnvFile(log)
-	c.loadConfigFromArgs(log, cmd)
+func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command) error {
+	if err := c.loadFromEnvFile(log); err != nil {
+		return err
+	}
+	if cmd != nil {
+		c.loadConfigFromArgs(log, cmd)
+	}
 	err := envconfig.Process("", c)
 	if err != nil {
-		log.Fatal("Error while parsing env variables", zap.Error(err))
+		return fmt.Errorf("failed to parse env variables: %w", err)
 	}
 	var ipBlocked bool
 	ip, err := getIP(c.UsePublicIP)
@@ -164,19 +306,35 @@ func (c *config) setupEnvVars(log *zap.Logger, cmd *cobra.Command) {
 		log.Sugar().Info("HOST not set, automatically set to " + c.Host)
 	}
 	val := reflect.ValueOf(c).Elem()
+	// the tokens of an earlier Load aren't added again
+	c.MultiTokens = nil
 	for _, env := range os.Environ() {
 		if strings.HasPrefix(env, "MULTI_TOKEN") {
 			c.MultiTokens = append(c.MultiTokens, botTokenRegex.FindStringSubmatch(env)[1])
 		}
 	}
 	val.FieldByName("MultiTokens").Set(reflect.ValueOf(c.MultiTokens))
+	return nil
+}
+
+// LoadFlags sets the environment variables the command line flags of cmd
+// stand for, for a Load without cmd to read them.
+func LoadFlags(log *zap.Logger, cmd *cobra.Command) {
+	ValueOf.loadConfigFromArgs(log.Named("Config"), cmd)
 }
 
-func Load(log *zap.Logger, cmd *cobra.Command) {
+// Load reads the settings from the environment, fsb.env and the command
+// line flags of cmd, which may be nil.
+func Load(log *zap.Logger, cmd *cobra.Command) error {
 	log = log.Named("Config")
-	defer log.Info("Loaded config")
-	ValueOf.setupEnvVars(log, cmd)
-	ValueOf.LogChannelID = int64(stripInt(log, int(ValueOf.LogChannelID)))
+	if err := ValueOf.setupEnvVars(log, cmd); err != nil {
+		return err
+	}
+	logChannelID, err := stripInt(int(ValueOf.LogChannelID))
+	if err != nil {
+		return fmt.Errorf("invalid LOG_CHANNEL: %w", err)
+	}
+	ValueOf.LogChannelID = int64(logChannelID)
 	if ValueOf.HashLength == 0 {
 		log.Sugar().Info("HASH_LENGTH can't be 0, defaulting to 6")
 		ValueOf.HashLength = 6
@@ -189,6 +347,59 @@ func Load(log *zap.Logger, cmd *cobra.Command) {
 		log.Sugar().Info("HASH_LENGTH can't be less than 5, defaulting to 6")
 		ValueOf.HashLength = 6
 	}
+	if ValueOf.HLSSegmentSeconds < 1 {
+		log.Sugar().Info("HLS_SEGMENT_SECONDS can't be less than 1, defaulting to 6")
+		ValueOf.HLSSegmentSeconds = 6
+	}
+	if ValueOf.TranscodeJobs < 1 {
+		log.Sugar().Info("TRANSCODE_JOBS can't be less than 1, defaulting to 2")
+		ValueOf.TranscodeJobs = 2
+	}
+	if ValueOf.ParallelChunks < 1 {
+		log.Sugar().Info("PARALLEL_CHUNKS can't be less than 1, defaulting to 1")
+		ValueOf.ParallelChunks = 1
+	}
+	if ValueOf.StreamsPerWorker < 1 {
+		log.Sugar().Info("STREAMS_PER_WORKER can't be less than 1, defaulting to 4")
+		ValueOf.StreamsPerWorker = 4
+	}
+	if ValueOf.APIBurst < 1 {
+		log.Sugar().Info("API_BURST can't be less than 1, defaulting to 5")
+		ValueOf.APIBurst = 5
+	}
+	if ValueOf.JobWorkers < 1 {
+		log.Sugar().Info("JOB_WORKERS can't be less than 1, defaulting to 2")
+		ValueOf.JobWorkers = 2
+	}
+	if ValueOf.GzipLevel < 1 || ValueOf.GzipLevel > 9 {
+		log.Sugar().Info("GZIP_LEVEL must be between 1 and 9, defaulting to 6")
+		ValueOf.GzipLevel = 6
+	}
+	if ValueOf.BrotliLevel < 0 || ValueOf.BrotliLevel > 11 {
+		log.Sugar().Info("BROTLI_LEVEL must be between 0 and 11, defaulting to 4")
+		ValueOf.BrotliLevel = 4
+	}
+	if ValueOf.ZstdLevel < 1 || ValueOf.ZstdLevel > 22 {
+		log.Sugar().Info("ZSTD_LEVEL must be between 1 and 22, defaulting to 3")
+		ValueOf.ZstdLevel = 3
+	}
+	if ValueOf.CompressCPULoad < 0 || ValueOf.CompressCPULoad > 100 {
+		log.Sugar().Info("COMPRESS_CPU_LOAD must be between 0 and 100, defaulting to 80")
+		ValueOf.CompressCPULoad = 80
+	}
+	if ValueOf.AdminPort != 0 && (ValueOf.AdminTLSCert == "" || ValueOf.AdminTLSKey == "" || ValueOf.AdminClientCA == "") {
+		return errors.New("ADMIN_PORT requires ADMIN_TLS_CERT, ADMIN_TLS_KEY and ADMIN_CLIENT_CA to be set")
+	}
+	if (ValueOf.BasicAuthUser == "") != (ValueOf.BasicAuthPassword == "") {
+		return errors.New("BASIC_AUTH_USER and BASIC_AUTH_PASSWORD have to be set together")
+	}
+	if ValueOf.EmbedSecret == "" {
+		log.Sugar().Info("EMBED_SECRET not set, deriving it from BOT_TOKEN")
+		secret := sha256.Sum256([]byte(ValueOf.BotToken))
+		ValueOf.EmbedSecret = hex.EncodeToString(secret[:])
+	}
+	log.Info("Loaded config")
+	return nil
 }
 
 func getIP(public bool) (string, error) {
@@ -244,15 +455,10 @@ func checkIfIpAccessible(ip string) bool {
 	return true
 }
 
-func stripInt(log *zap.Logger, a int) int {
+func stripInt(a int) (int, error) {
 	strA := strconv.Itoa(abs(a))
 	lastDigits := strings.Replace(strA, "100", "", 1)
-	result, err := strconv.Atoi(lastDigits)
-	if err != nil {
-		log.Sugar().Fatalln(err)
-		return 0
-	}
-	return result
+	return strconv.Atoi(lastDigits)
 }
 
 func abs(x int) int {
@@ -0,0 +1,185 @@
+// Package analytics counts the hits of the links to each file and rolls
+// them up into the store, for uploaders to see with /mystats. Hits are
+// collected in memory and written once a minute, so streaming doesn't wait
+// on the store.
+package analytics
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/geoip"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// flushInterval is how often the hits collected in memory are written to
+// the store.
+const flushInterval = time.Minute
+
+type fileKey struct {
+	channelID int64
+	messageID int
+}
+
+type rollup struct {
+	hits       int64
+	views      int64
+	lastAccess time.Time
+	// visitors maps the hashed addresses that hit the file to their
+	// countries.
+	visitors map[string]string
+}
+
+var collector = struct {
+	log *zap.Logger
+
+	mu      sync.Mutex
+	pending map[fileKey]*rollup
+	started bool
+}{
+	pending: make(map[fileKey]*rollup),
+}
+
+// Start writes the collected hits to the store once a minute.
+func Start(log *zap.Logger) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	if collector.started {
+		return
+	}
+	collector.started = true
+	collector.log = log.Named("analytics")
+	go flushLoop()
+}
+
+// Hit counts a download of a file from remoteAddr. country is the country
+// a CDN said the request came from, and is looked up otherwise.
+func Hit(channelID int64, messageID int, remoteAddr string, country string) {
+	record(channelID, messageID, remoteAddr, country, 1, 0)
+}
+
+// View counts a load of a file's analytics pixel from remoteAddr.
+func View(channelID int64, messageID int, remoteAddr string, country string) {
+	record(channelID, messageID, remoteAddr, country, 0, 1)
+}
+
+func record(channelID int64, messageID int, remoteAddr string, country string, hits int64, views int64) {
+	if country == "" {
+		country = geoip.Lookup(remoteAddr)
+	}
+	visitor := visitorID(remoteAddr)
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	key := fileKey{channelID, messageID}
+	r := collector.pending[key]
+	if r == nil {
+		r = &rollup{visitors: make(map[string]string)}
+		collector.pending[key] = r
+	}
+	r.hits += hits
+	r.views += views
+	r.lastAccess = time.Now()
+	r.visitors[visitor] = country
+}
+
+// visitorID hashes an address with a secret, so visitors can be counted
+// without keeping their addresses.
+func visitorID(remoteAddr string) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.EmbedSecret))
+	mac.Write([]byte("visitor:" + remoteAddr))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func flushLoop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		collector.mu.Lock()
+		pending := collector.pending
+		collector.pending = make(map[fileKey]*rollup)
+		collector.mu.Unlock()
+		for key, r := range pending {
+			if err := flush(key, r); err != nil {
+				collector.log.Warn("Failed to save link hits", zap.Int("messageID", key.messageID), zap.Error(err))
+			}
+		}
+	}
+}
+
+func flush(key fileKey, r *rollup) error {
+	hits := &store.FileHits{
+		ChannelID:  key.channelID,
+		MessageID:  key.messageID,
+		Hits:       r.hits,
+		Views:      r.views,
+		LastAccess: r.lastAccess,
+	}
+	// hits are listed by uploader, so they only see their own files
+	if entry, err := store.GetStore().GetFile(key.channelID, key.messageID); err == nil {
+		hits.UploadedBy = entry.UploadedBy
+	}
+	visitors := make([]*store.FileVisitor, 0, len(r.visitors))
+	for visitor, country := range r.visitors {
+		visitors = append(visitors, &store.FileVisitor{
+			ChannelID: key.channelID,
+			MessageID: key.messageID,
+			Visitor:   visitor,
+			Country:   country,
+		})
+	}
+	return store.GetStore().RecordHits(hits, visitors)
+}
+
+// Stats returns the hits of the links to the files uploaded by uploadedBy,
+// most recently accessed first. Hits show up once they're written to the
+// store, within a minute.
+func Stats(uploadedBy int64, limit int) ([]types.LinkStats, error) {
+	files, err := store.GetStore().ListFileHits(uploadedBy, limit)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]types.LinkStats, 0, len(files))
+	for _, hits := range files {
+		s, err := fileStats(hits)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, *s)
+	}
+	return stats, nil
+}
+
+// FileStats returns the hits of the links to a file.
+func FileStats(channelID int64, messageID int) (*types.LinkStats, error) {
+	hits, err := store.GetStore().GetFileHits(channelID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return fileStats(hits)
+}
+
+func fileStats(hits *store.FileHits) (*types.LinkStats, error) {
+	unique, countries, err := store.GetStore().CountVisitors(hits.ChannelID, hits.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	stats := &types.LinkStats{
+		ChannelID:  hits.ChannelID,
+		MessageID:  hits.MessageID,
+		Hits:       hits.Hits,
+		Views:      hits.Views,
+		UniqueIPs:  unique,
+		Countries:  countries,
+		LastAccess: hits.LastAccess,
+	}
+	if entry, err := store.GetStore().GetFile(hits.ChannelID, hits.MessageID); err == nil {
+		stats.FileName = entry.FileName
+	}
+	return stats, nil
+}
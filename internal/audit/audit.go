@@ -0,0 +1,178 @@
+// Package audit tells uploaders when their files are downloaded, for the
+// files they turned it on for with /notify.
+package audit
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+const (
+	// batchInterval is how long downloads are collected before the uploader
+	// is sent a single message about all of them.
+	batchInterval = time.Minute
+	// settingsTTL is how long a file's notification setting is cached.
+	settingsTTL = time.Minute
+	// maxListedFiles caps how many files one message lists.
+	maxListedFiles = 20
+)
+
+type fileKey struct {
+	channelID int64
+	messageID int
+}
+
+type setting struct {
+	entry     *store.FileEntry
+	fetchedAt time.Time
+}
+
+type download struct {
+	fileName string
+	ips      []string
+}
+
+var auditor = struct {
+	log    *zap.Logger
+	client *gotgproto.Client
+
+	mu       sync.Mutex
+	settings map[fileKey]setting
+	// seen are the IPs each file was already reported for.
+	seen    map[fileKey]map[string]struct{}
+	pending map[int64]map[fileKey]*download
+	started bool
+}{
+	settings: make(map[fileKey]setting),
+	seen:     make(map[fileKey]map[string]struct{}),
+	pending:  make(map[int64]map[fileKey]*download),
+}
+
+// Start sends the uploaders the downloads of their files through client,
+// once a minute.
+func Start(log *zap.Logger, client *gotgproto.Client) {
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	if auditor.started {
+		return
+	}
+	auditor.started = true
+	auditor.log = log.Named("audit")
+	auditor.client = client
+	go flushLoop()
+}
+
+// Forget drops the cached notification setting of a file, after it was
+// changed.
+func Forget(channelID int64, messageID int) {
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	key := fileKey{channelID, messageID}
+	delete(auditor.settings, key)
+	delete(auditor.seen, key)
+}
+
+// Downloaded records a download of a file from remoteAddr, queueing a
+// message to its uploader if they asked for one.
+func Downloaded(channelID int64, messageID int, remoteAddr string) {
+	if auditor.client == nil {
+		return
+	}
+	key := fileKey{channelID, messageID}
+	entry := lookup(key)
+	if entry == nil || entry.Notify == store.NotifyOff || entry.UploadedBy == 0 {
+		return
+	}
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	seen := auditor.seen[key]
+	if seen == nil {
+		seen = make(map[string]struct{})
+		auditor.seen[key] = seen
+	}
+	if _, ok := seen[remoteAddr]; ok {
+		return
+	}
+	seen[remoteAddr] = struct{}{}
+	if entry.Notify == store.NotifyFirst {
+		// only the first download is reported
+		entry.Notify = store.NotifyOff
+		go func() {
+			if err := store.GetStore().SetNotify(channelID, messageID, store.NotifyOff); err != nil {
+				auditor.log.Warn("Failed to turn off download notifications", zap.Int("messageID", messageID), zap.Error(err))
+			}
+		}()
+	}
+	files := auditor.pending[entry.UploadedBy]
+	if files == nil {
+		files = make(map[fileKey]*download)
+		auditor.pending[entry.UploadedBy] = files
+	}
+	if files[key] == nil {
+		files[key] = &download{fileName: entry.FileName}
+	}
+	files[key].ips = append(files[key].ips, remoteAddr)
+}
+
+func lookup(key fileKey) *store.FileEntry {
+	auditor.mu.Lock()
+	cached, ok := auditor.settings[key]
+	auditor.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < settingsTTL {
+		return cached.entry
+	}
+	entry, err := store.GetStore().GetFile(key.channelID, key.messageID)
+	if err != nil {
+		entry = nil
+	}
+	auditor.mu.Lock()
+	auditor.settings[key] = setting{entry: entry, fetchedAt: time.Now()}
+	auditor.mu.Unlock()
+	return entry
+}
+
+func flushLoop() {
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		auditor.mu.Lock()
+		pending := auditor.pending
+		auditor.pending = make(map[int64]map[fileKey]*download)
+		auditor.mu.Unlock()
+		for userID, files := range pending {
+			notify(userID, files)
+		}
+	}
+}
+
+func notify(userID int64, files map[fileKey]*download) {
+	downloads := make([]*download, 0, len(files))
+	for _, d := range files {
+		downloads = append(downloads, d)
+	}
+	sort.Slice(downloads, func(i, j int) bool {
+		return downloads[i].fileName < downloads[j].fileName
+	})
+	var text strings.Builder
+	text.WriteString("Your files were downloaded:\n")
+	for i, d := range downloads {
+		if i == maxListedFiles {
+			fmt.Fprintf(&text, "\n...and %d more files", len(downloads)-maxListedFiles)
+			break
+		}
+		fmt.Fprintf(&text, "\n%s from %s", d.fileName, strings.Join(d.ips, ", "))
+	}
+	ctx := auditor.client.CreateContext()
+	_, err := ctx.SendMessage(userID, &tg.MessagesSendMessageRequest{Message: text.String(), NoWebpage: true})
+	if err != nil {
+		auditor.log.Warn("Failed to send download notification", zap.Int64("userID", userID), zap.Error(err))
+	}
+}
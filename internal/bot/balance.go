@@ -0,0 +1,45 @@
+package bot
+
+import "EverythingSuckz/fsb/internal/utils"
+
+// streamLoad is how much an active stream adds to the load of its worker,
+// about what a player keeps in flight while it plays.
+const streamLoad = utils.MaxChunkSize
+
+// loadSlack is the load a pinned worker may carry over twice the average
+// before its keys move to other workers.
+const loadSlack = 4 * streamLoad
+
+// BalanceLoad has workers picked by their load, which is the size of the
+// chunks they are fetching and their active streams. streams returns the
+// number of active streams of each worker. Until it is called only the
+// chunks being fetched count.
+func BalanceLoad(streams func() map[int]int) {
+	Workers.mut.Lock()
+	defer Workers.mut.Unlock()
+	Workers.streams = streams
+}
+
+func activeStreams() map[int]int {
+	Workers.mut.Lock()
+	streams := Workers.streams
+	Workers.mut.Unlock()
+	if streams == nil {
+		return nil
+	}
+	return streams()
+}
+
+// load is how busy the worker is, given the active streams of each worker.
+func (w *Worker) load(streams map[int]int) int64 {
+	return w.inflight.Load() + int64(streams[w.ID])*streamLoad
+}
+
+// BeginFetch counts a chunk of limit bytes towards the worker's load until
+// the returned function is called.
+func (w *Worker) BeginFetch(limit int64) func() {
+	w.inflight.Add(limit)
+	return func() {
+		w.inflight.Add(-limit)
+	}
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// breakerProbeInterval is how often a tripped worker is checked to see if it
+// can be returned to rotation.
+const breakerProbeInterval = 30 * time.Second
+
+// breaker takes a worker out of rotation after BREAKER_THRESHOLD consecutive
+// chunk fetch failures.
+type breaker struct {
+	mu       sync.Mutex
+	failures int
+	open     bool
+}
+
+// Available reports whether the worker is in rotation.
+func (w *Worker) Available() bool {
+	w.breaker.mu.Lock()
+	defer w.breaker.mu.Unlock()
+	return !w.breaker.open
+}
+
+// ReportSuccess resets the worker's consecutive failure count.
+func (w *Worker) ReportSuccess() {
+	w.breaker.mu.Lock()
+	defer w.breaker.mu.Unlock()
+	w.breaker.failures = 0
+}
+
+// ReportFailure records a failed chunk fetch, tripping the breaker once the
+// threshold is reached.
+func (w *Worker) ReportFailure(err error) {
+	threshold := config.ValueOf.BreakerThreshold
+	if threshold <= 0 {
+		return
+	}
+	w.breaker.mu.Lock()
+	defer w.breaker.mu.Unlock()
+	w.breaker.failures++
+	if w.breaker.open || w.breaker.failures < threshold {
+		return
+	}
+	w.breaker.open = true
+	w.log.Warn("Taking worker out of rotation", zap.Int("worker", w.ID), zap.Int("failures", w.breaker.failures), zap.Error(err))
+	go w.probe()
+}
+
+// probe checks the worker's connection in the background until it responds,
+// then returns it to rotation.
+func (w *Worker) probe() {
+	ticker := time.NewTicker(breakerProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := w.Client.API().HelpGetConfig(ctx)
+		cancel()
+		if err != nil {
+			w.log.Debug("Worker is still failing", zap.Int("worker", w.ID), zap.Error(err))
+			continue
+		}
+		w.breaker.mu.Lock()
+		w.breaker.open = false
+		w.breaker.failures = 0
+		w.breaker.mu.Unlock()
+		w.log.Info("Returning worker to rotation", zap.Int("worker", w.ID))
+		return
+	}
+}
@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"golang.org/x/time/rate"
+)
+
+// budgetWindow is how many seconds of calls a worker's call rate is
+// measured over.
+const budgetWindow = 10
+
+// budget keeps a worker's calls to Telegram's API within API_CALLS_PER_SECOND.
+// Calls over it are delayed until the budget refills, which spreads a burst
+// over a few hundred milliseconds instead of having Telegram answer it with
+// a FLOOD_WAIT that takes the worker out for minutes. Delayed calls go out
+// by their Priority.
+type budget struct {
+	scheduler *scheduler
+
+	mu sync.Mutex
+	// seconds counts the calls made in each of the last budgetWindow
+	// seconds, indexed by the Unix second modulo budgetWindow.
+	seconds [budgetWindow]int
+	stamps  [budgetWindow]int64
+	calls   int64
+	delayed int64
+	waited  time.Duration
+	floods  int64
+	// cooldown is when the last FLOOD_WAIT the worker got ends.
+	cooldown time.Time
+}
+
+func newBudget() *budget {
+	return &budget{
+		scheduler: &scheduler{
+			limiter: rate.NewLimiter(rate.Limit(config.ValueOf.APICallsPerSecond), config.ValueOf.APIBurst),
+		},
+	}
+}
+
+// defaultBudget is the budget of the bot started with BOT_TOKEN, set by
+// StartClient.
+var defaultBudget *budget
+
+// middleware delays calls that would go over the budget.
+func (b *budget) middleware() telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			if config.ValueOf.APICallsPerSecond > 0 {
+				started := time.Now()
+				if err := b.scheduler.wait(ctx); err != nil {
+					return err
+				}
+				b.record(time.Since(started))
+			} else {
+				b.record(0)
+			}
+			err := next.Invoke(ctx, input, output)
+			if wait, ok := tgerr.AsFloodWait(err); ok {
+				b.mu.Lock()
+				b.floods++
+				if until := time.Now().Add(wait); until.After(b.cooldown) {
+					b.cooldown = until
+				}
+				b.mu.Unlock()
+			}
+			return err
+		}
+	})
+}
+
+func (b *budget) record(waited time.Duration) {
+	now := time.Now().Unix()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	slot := now % budgetWindow
+	if b.stamps[slot] != now {
+		b.stamps[slot] = now
+		b.seconds[slot] = 0
+	}
+	b.seconds[slot]++
+	b.calls++
+	// waits this short are just the limiter's rounding
+	if waited > time.Millisecond {
+		b.delayed++
+		b.waited += waited
+	}
+}
+
+// floodCount returns how many FLOOD_WAITs the worker got.
+func (b *budget) floodCount() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.floods
+}
+
+// cooldownLeft returns how long until the worker's last FLOOD_WAIT ends.
+func (b *budget) cooldownLeft() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(time.Until(b.cooldown), 0)
+}
+
+// BudgetStats describes a worker's calls to Telegram's API.
+type BudgetStats struct {
+	// CallsPerSecond is the worker's average call rate over the last few
+	// seconds.
+	CallsPerSecond float64 `json:"calls_per_second"`
+	Calls          int64   `json:"calls"`
+	// Delayed is how many calls were held back to stay within the budget.
+	Delayed int64 `json:"delayed"`
+	// DelayedMs is how long those calls were held back for in total.
+	DelayedMs int64 `json:"delayed_ms"`
+	// FloodWaits is how many calls Telegram answered with a FLOOD_WAIT.
+	FloodWaits int64 `json:"flood_waits"`
+	// CooldownMs is how long until the worker's last FLOOD_WAIT ends.
+	CooldownMs int64 `json:"cooldown_ms"`
+}
+
+func (b *budget) stats() BudgetStats {
+	now := time.Now().Unix()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var recent int
+	for slot, stamp := range b.stamps {
+		// the current second is still filling up
+		if stamp < now && stamp > now-budgetWindow {
+			recent += b.seconds[slot]
+		}
+	}
+	return BudgetStats{
+		CallsPerSecond: float64(recent) / float64(budgetWindow-1),
+		Calls:          b.calls,
+		Delayed:        b.delayed,
+		DelayedMs:      b.waited.Milliseconds(),
+		FloodWaits:     b.floods,
+		CooldownMs:     max(time.Until(b.cooldown), 0).Milliseconds(),
+	}
+}
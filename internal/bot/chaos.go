@@ -0,0 +1,41 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// chaosMiddleware injects the faults configured with CHAOS into the chunk
+// fetches of a worker. It runs inside the flood wait middleware, so a
+// simulated FLOOD_WAIT is waited out and retried like a real one.
+func chaosMiddleware(chaos config.Chaos) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			if _, ok := input.(*tg.UploadGetFileRequest); !ok {
+				return next.Invoke(ctx, input, output)
+			}
+			if chaos.Latency > 0 {
+				select {
+				case <-time.After(time.Duration(rand.Int63n(int64(chaos.Latency)))):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if rand.Float64() < chaos.FloodRate {
+				return tgerr.New(420, fmt.Sprintf("FLOOD_WAIT_%d", chaos.FloodWait))
+			}
+			if rand.Float64() < chaos.FailureRate {
+				return tgerr.New(500, "CHAOS_INJECTED_FAILURE")
+			}
+			return next.Invoke(ctx, input, output)
+		}
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/celestix/gotgproto"
 	"github.com/celestix/gotgproto/sessionMaker"
 	"github.com/glebarez/sqlite"
+	"github.com/gotd/td/telegram"
 )
 
 var Bot *gotgproto.Client
@@ -22,6 +23,7 @@ func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 		client *gotgproto.Client
 		err    error
 	})
+	defaultBudget = newBudget()
 	go func(ctx context.Context) {
 		client, err := gotgproto.NewClient(
 			int(config.ValueOf.ApiID),
@@ -32,6 +34,7 @@ func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 					sqlite.Open("fsb.session"),
 				),
 				DisableCopyright: true,
+				Middlewares:      []telegram.Middleware{defaultBudget.middleware()},
 			},
 		)
 		resultChan <- struct {
@@ -53,3 +56,23 @@ func StartClient(log *zap.Logger) (*gotgproto.Client, error) {
 		return result.client, nil
 	}
 }
+
+// StartStandaloneClient logs in with BOT_TOKEN using an in-memory session and
+// without any command handlers, for one-off tools that must not interfere
+// with a running server.
+func StartStandaloneClient(log *zap.Logger) (*gotgproto.Client, error) {
+	client, err := gotgproto.NewClient(
+		int(config.ValueOf.ApiID),
+		config.ValueOf.ApiHash,
+		gotgproto.ClientTypeBot(config.ValueOf.BotToken),
+		&gotgproto.ClientOpts{
+			Session:          sessionMaker.SimpleSession(),
+			DisableCopyright: true,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Standalone client started", zap.String("username", client.Self.Username))
+	return client, nil
+}
@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// healthTimeout caps how long a worker may take to answer a health check.
+const healthTimeout = 5 * time.Second
+
+// healthMaxAge is how long the result of a health check is reused, so load
+// balancers polling it don't send a call to Telegram per worker each time.
+const healthMaxAge = 10 * time.Second
+
+// WorkerHealth is whether a worker's session answered a call to Telegram.
+type WorkerHealth struct {
+	WorkerID int    `json:"worker_id"`
+	Username string `json:"username"`
+	Ok       bool   `json:"ok"`
+	// Available is false while the worker's breaker keeps it out of
+	// rotation.
+	Available bool `json:"available"`
+	// LatencyMs is how long the call took.
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+var health struct {
+	mu      sync.Mutex
+	checked time.Time
+	workers []WorkerHealth
+}
+
+// CheckHealth makes a call to Telegram through every worker at once and
+// returns how each of them did. Results younger than healthMaxAge are
+// returned without checking again.
+func CheckHealth(ctx context.Context) []WorkerHealth {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if time.Since(health.checked) < healthMaxAge {
+		return health.workers
+	}
+	workers := Workers.List()
+	results := make([]WorkerHealth, len(workers))
+	var wg sync.WaitGroup
+	for i, worker := range workers {
+		wg.Add(1)
+		go func(i int, worker *Worker) {
+			defer wg.Done()
+			results[i] = worker.checkHealth(ctx)
+		}(i, worker)
+	}
+	wg.Wait()
+	health.checked, health.workers = time.Now(), results
+	return results
+}
+
+func (w *Worker) checkHealth(ctx context.Context) WorkerHealth {
+	result := WorkerHealth{WorkerID: w.ID, Available: w.Available()}
+	if w.Self != nil {
+		result.Username = w.Self.Username
+	}
+	// the check shouldn't fail because the client that asked went away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), healthTimeout)
+	defer cancel()
+	started := time.Now()
+	_, err := w.Client.API().HelpGetNearestDC(ctx)
+	result.LatencyMs = float64(time.Since(started)) / float64(time.Millisecond)
+	if err != nil {
+		w.log.Warn("Health check failed", zap.Int("worker", w.ID), zap.Error(err))
+		result.Error = err.Error()
+		return result
+	}
+	w.touch()
+	result.Ok = true
+	return result
+}
@@ -1,20 +1,28 @@
 package bot
 
 import (
-	"time"
+	"EverythingSuckz/fsb/config"
 
 	"github.com/gotd/contrib/middleware/floodwait"
-	"github.com/gotd/contrib/middleware/ratelimit"
 	"github.com/gotd/td/telegram"
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
 )
 
-func GetFloodMiddleware(log *zap.Logger) []telegram.Middleware {
+// GetFloodMiddleware returns the middlewares of a worker, which keep its
+// calls within budget and wait out the FLOOD_WAITs it gets anyway.
+func GetFloodMiddleware(log *zap.Logger, budget *budget) []telegram.Middleware {
 	waiter := floodwait.NewSimpleWaiter().WithMaxRetries(10)
-	ratelimiter := ratelimit.New(rate.Every(time.Millisecond*100), 5)
-	return []telegram.Middleware{
+	middlewares := []telegram.Middleware{
 		waiter,
-		ratelimiter,
+		budget.middleware(),
 	}
+	if chaos := config.ValueOf.Chaos; chaos.Enabled() {
+		log.Warn("Injecting faults into chunk fetches, this must never run in production",
+			zap.Float64("fail", chaos.FailureRate),
+			zap.Duration("latency", chaos.Latency),
+			zap.Float64("flood", chaos.FloodRate),
+			zap.Int("flood_wait", chaos.FloodWait))
+		middlewares = append(middlewares, chaosMiddleware(chaos))
+	}
+	return middlewares
 }
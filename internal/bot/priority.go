@@ -0,0 +1,137 @@
+package bot
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Priority is how urgently a call to Telegram is needed. When a worker's
+// calls are held back to stay within API_CALLS_PER_SECOND, the held back
+// calls go out by their priority's weight, so a batch of archive downloads
+// slows down interactive use instead of stalling it.
+type Priority int
+
+const (
+	// PriorityInteractive is for images and small documents, and for
+	// calls not made for a stream, like looking up a file.
+	PriorityInteractive Priority = iota
+	// PriorityPlayback is for audio and video played in a player.
+	PriorityPlayback
+	// PriorityBulk is for large downloads and archives.
+	PriorityBulk
+	priorityCount
+)
+
+// priorityWeights is how many calls of each priority go out for every call
+// of PriorityBulk while calls of every priority are waiting.
+var priorityWeights = [priorityCount]int{4, 2, 1}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityPlayback:
+		return "playback"
+	case PriorityBulk:
+		return "bulk"
+	}
+	return "interactive"
+}
+
+type priorityKey struct{}
+
+// WithPriority makes the calls to Telegram made with ctx go out with p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// scheduler lets a worker's calls through its limiter, picking which held
+// back call goes next with smooth weighted round robin over the priorities
+// that have calls waiting.
+type scheduler struct {
+	limiter *rate.Limiter
+
+	mu      sync.Mutex
+	queues  [priorityCount][]chan struct{}
+	current [priorityCount]int
+	running bool
+}
+
+// wait waits until the call may go out.
+func (s *scheduler) wait(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.running && s.limiter.Allow() {
+		s.mu.Unlock()
+		return nil
+	}
+	p := priorityFrom(ctx)
+	ready := make(chan struct{})
+	s.queues[p] = append(s.queues[p], ready)
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, waiting := range s.queues[p] {
+		if waiting == ready {
+			s.queues[p] = append(s.queues[p][:i], s.queues[p][i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// let through while it was being cancelled
+	return nil
+}
+
+// run lets the held back calls through as the limiter allows, until none
+// are left.
+func (s *scheduler) run() {
+	for {
+		s.limiter.Wait(context.Background())
+		s.mu.Lock()
+		ready := s.next()
+		if ready == nil {
+			s.running = false
+			s.mu.Unlock()
+			return
+		}
+		close(ready)
+		s.mu.Unlock()
+	}
+}
+
+// next takes the call that goes next off its queue, or returns nil if no
+// call is waiting.
+func (s *scheduler) next() chan struct{} {
+	best, total := Priority(-1), 0
+	for p := Priority(0); p < priorityCount; p++ {
+		if len(s.queues[p]) == 0 {
+			continue
+		}
+		s.current[p] += priorityWeights[p]
+		total += priorityWeights[p]
+		if best < 0 || s.current[p] > s.current[best] {
+			best = p
+		}
+	}
+	if best < 0 {
+		s.current = [priorityCount]int{}
+		return nil
+	}
+	s.current[best] -= total
+	ready := s.queues[best][0]
+	s.queues[best] = s.queues[best][1:]
+	return ready
+}
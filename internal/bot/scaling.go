@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"time"
+
+	"github.com/celestix/gotgproto"
+	"go.uber.org/zap"
+)
+
+// scaleInterval is how often the pool of workers is resized.
+const scaleInterval = 30 * time.Second
+
+// scaling reports whether WORKERS_MIN is set, in which case only that many
+// of the MULTI_TOKEN bots are started and the rest are started and stopped
+// as the load changes.
+func scaling() bool {
+	return config.ValueOf.WorkersMin > 0
+}
+
+// StartScaler resizes the pool of workers every 30 seconds when WORKERS_MIN
+// is set. A worker is started when there are more than STREAMS_PER_WORKER
+// streams for each running one or Telegram answered a worker with a
+// FLOOD_WAIT, and one that is idle is stopped when the others could take
+// twice the load. streams returns the number of active streams of each
+// worker.
+func StartScaler(ctx context.Context, streams func() map[int]int) {
+	if !scaling() {
+		return
+	}
+	Workers.log.Info("Scaling workers",
+		zap.Int("min", config.ValueOf.WorkersMin),
+		zap.Int("max", maxWorkers()),
+		zap.Int("streams_per_worker", config.ValueOf.StreamsPerWorker))
+	go func() {
+		ticker := time.NewTicker(scaleInterval)
+		defer ticker.Stop()
+		floods := make(map[int]int64)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			Workers.scale(streams(), floods)
+		}
+	}()
+}
+
+// maxWorkers is the most MULTI_TOKEN bots that are started at once.
+func maxWorkers() int {
+	if limit := config.ValueOf.WorkersMax; limit > 0 && limit < len(config.ValueOf.MultiTokens) {
+		return limit
+	}
+	return len(config.ValueOf.MultiTokens)
+}
+
+// scale starts or stops a worker for the current load. floods holds the
+// FLOOD_WAITs each worker had at the last check.
+func (w *BotWorkers) scale(streams map[int]int, floods map[int]int64) {
+	workers := w.List()
+	w.mut.Lock()
+	spare := len(w.spare)
+	w.mut.Unlock()
+
+	var total, started int
+	flooded := false
+	for _, worker := range workers {
+		total += streams[worker.ID]
+		if worker.token != "" {
+			started++
+		}
+		if worker.budget != nil {
+			count := worker.budget.floodCount()
+			if count > floods[worker.ID] {
+				flooded = true
+			}
+			floods[worker.ID] = count
+		}
+	}
+	perWorker := config.ValueOf.StreamsPerWorker
+	switch {
+	case (total > len(workers)*perWorker || flooded) && spare > 0 && started < maxWorkers():
+		w.grow(total, flooded)
+	case started > config.ValueOf.WorkersMin && total*2 < (len(workers)-1)*perWorker:
+		w.shrink(workers, streams)
+	}
+}
+
+// grow starts one of the spare bots. Bots that were stopped before are
+// restarted with the session they had, instead of logging in again.
+func (w *BotWorkers) grow(streams int, flooded bool) {
+	w.mut.Lock()
+	token := w.spare[0]
+	w.spare = w.spare[1:]
+	stopped := w.stopped[token]
+	w.mut.Unlock()
+	w.log.Info("Starting a worker for the load", zap.Int("streams", streams), zap.Bool("flood_wait", flooded))
+	var err error
+	if stopped != nil {
+		err = w.restart(stopped)
+	} else {
+		err = w.Add(token)
+	}
+	if err != nil {
+		w.log.Error("Failed to start worker", zap.Error(err))
+		w.mut.Lock()
+		w.spare = append(w.spare, token)
+		w.mut.Unlock()
+	}
+}
+
+// shrink stops a started bot that has no streams and wasn't used since the
+// last check, so streams spread over several workers aren't cut off.
+func (w *BotWorkers) shrink(workers []*Worker, streams map[int]int) {
+	for i := len(workers) - 1; i >= 0; i-- {
+		worker := workers[i]
+		if worker.token == "" || streams[worker.ID] > 0 || worker.idleFor() < scaleInterval {
+			continue
+		}
+		w.mut.Lock()
+		bots := make([]*Worker, 0, len(w.Bots))
+		for _, other := range w.Bots {
+			if other != worker {
+				bots = append(bots, other)
+			}
+		}
+		// the slice is replaced rather than changed in place, so the
+		// copies List returned aren't changed under their readers
+		w.Bots = bots
+		w.mut.Unlock()
+		w.log.Info("Stopping idle worker", zap.Int("worker", worker.ID))
+		worker.Client.Stop()
+		// it's only spare once stopped, so grow doesn't restart it before
+		w.mut.Lock()
+		w.spare = append(w.spare, worker.token)
+		w.stopped[worker.token] = worker
+		w.mut.Unlock()
+		return
+	}
+}
+
+// restart starts a worker that was stopped by shrink again. The client
+// keeps its session, so the bot doesn't log in again.
+func (w *BotWorkers) restart(worker *Worker) error {
+	err := worker.Client.Start(&gotgproto.ClientOpts{
+		DisableCopyright: true,
+		Middlewares:      GetFloodMiddleware(w.log.Named("Worker"), worker.budget),
+	})
+	if err != nil {
+		return err
+	}
+	// it was idle while stopped, which shouldn't get it stopped again
+	worker.touch()
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	delete(w.stopped, worker.token)
+	w.Bots = append(w.Bots, worker)
+	return nil
+}
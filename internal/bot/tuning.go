@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// tuningWindow is how many recent chunk fetches a worker's chunk size is
+	// tuned on.
+	tuningWindow = 32
+	// Workers whose fetches take longer than slowFetch on average (usually
+	// ones far from the file's DC) get bigger chunks so they pay for fewer
+	// round trips, and ones faster than fastFetch get smaller chunks so
+	// players get their first bytes sooner.
+	slowFetch = 600 * time.Millisecond
+	fastFetch = 150 * time.Millisecond
+	// minTunedChunkSize is the smallest chunk size tuning goes down to.
+	minTunedChunkSize = 128 * 1024
+)
+
+type fetchSample struct {
+	bytes int
+	took  time.Duration
+}
+
+// chunkTuner measures a worker's chunk fetches over a sliding window and
+// picks its chunk size when ADAPTIVE_CHUNKS is enabled.
+type chunkTuner struct {
+	mu        sync.Mutex
+	samples   [tuningWindow]fetchSample
+	next      int
+	filled    int
+	fresh     int
+	chunkSize int64
+}
+
+// WorkerStats describes a worker's recent chunk fetches.
+type WorkerStats struct {
+	WorkerID  int   `json:"worker_id"`
+	Available bool  `json:"available"`
+	ChunkSize int64 `json:"chunk_size"`
+	Samples   int   `json:"samples"`
+	// AvgLatencyMs is the average time a chunk fetch took.
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+	// Throughput is in bytes per second of fetching.
+	Throughput float64 `json:"throughput"`
+	// InflightBytes is the size of the chunks the worker is fetching.
+	InflightBytes int64 `json:"inflight_bytes"`
+	// API describes all the worker's calls to Telegram, not just fetches.
+	API BudgetStats `json:"api"`
+}
+
+// ChunkSize returns the size of the chunks to fetch through the worker.
+func (w *Worker) ChunkSize() int64 {
+	if !config.ValueOf.AdaptiveChunks {
+		return utils.MaxChunkSize
+	}
+	w.tuner.mu.Lock()
+	defer w.tuner.mu.Unlock()
+	if w.tuner.chunkSize == 0 {
+		return utils.MaxChunkSize
+	}
+	return w.tuner.chunkSize
+}
+
+// RecordFetch records a successful chunk fetch, retuning the worker's chunk
+// size once a full window of fetches was made with the current one.
+func (w *Worker) RecordFetch(bytes int, took time.Duration) {
+	w.touch()
+	t := &w.tuner
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples[t.next] = fetchSample{bytes, took}
+	t.next = (t.next + 1) % tuningWindow
+	t.filled = min(t.filled+1, tuningWindow)
+	t.fresh++
+	if !config.ValueOf.AdaptiveChunks || t.fresh < tuningWindow {
+		return
+	}
+	t.fresh = 0
+	current := t.chunkSize
+	if current == 0 {
+		current = utils.MaxChunkSize
+	}
+	tuned := current
+	switch latency, _ := t.stats(); {
+	case latency > slowFetch && current < utils.MaxChunkSize:
+		tuned = current * 2
+	case latency < fastFetch && current > minTunedChunkSize:
+		tuned = current / 2
+	}
+	if tuned != current {
+		w.log.Debug("Tuned chunk size", zap.Int("worker", w.ID), zap.Int64("from", current), zap.Int64("to", tuned))
+	}
+	t.chunkSize = tuned
+}
+
+// stats returns the average latency and throughput of the fetches in the
+// window. The caller must hold the lock.
+func (t *chunkTuner) stats() (time.Duration, float64) {
+	if t.filled == 0 {
+		return 0, 0
+	}
+	var bytes int
+	var took time.Duration
+	for _, sample := range t.samples[:t.filled] {
+		bytes += sample.bytes
+		took += sample.took
+	}
+	if took <= 0 {
+		return 0, 0
+	}
+	return took / time.Duration(t.filled), float64(bytes) / took.Seconds()
+}
+
+// Stats describes the worker's recent chunk fetches.
+func (w *Worker) Stats() WorkerStats {
+	chunkSize := w.ChunkSize()
+	w.tuner.mu.Lock()
+	latency, throughput := w.tuner.stats()
+	samples := w.tuner.filled
+	w.tuner.mu.Unlock()
+	stats := WorkerStats{
+		WorkerID:      w.ID,
+		Available:     w.Available(),
+		ChunkSize:     chunkSize,
+		Samples:       samples,
+		AvgLatencyMs:  float64(latency) / float64(time.Millisecond),
+		Throughput:    throughput,
+		InflightBytes: w.inflight.Load(),
+	}
+	if w.budget != nil {
+		stats.API = w.budget.stats()
+	}
+	return stats
+}
@@ -46,6 +46,12 @@ func StartUserBot(l *zap.Logger) {
 	}
 }
 
+// Client returns the client of the user session, or nil if USER_SESSION
+// isn't set or the session failed to start.
+func (u *UserBotStruct) Client() *gotgproto.Client {
+	return u.client
+}
+
 func (u *UserBotStruct) AddBotsAsAdmins() error {
 	u.log.Info("Preparing to add bots as admins")
 	ctx := u.client.CreateContext()
@@ -82,7 +88,7 @@ func (u *UserBotStruct) AddBotsAsAdmins() error {
 			currentAdmins = append(currentAdmins, user.UserID)
 		}
 	}
-	for _, bot := range Workers.Bots {
+	for _, bot := range Workers.List() {
 		isAdmin := false
 		for _, admin := range currentAdmins {
 			if admin == bot.Self.ID {
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// warmupTimeout caps how long a warm-up ping may take.
+const warmupTimeout = 10 * time.Second
+
+// touch marks the worker as used now.
+func (w *Worker) touch() {
+	w.lastUsed.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long ago the worker was last used.
+func (w *Worker) idleFor() time.Duration {
+	return time.Since(time.Unix(0, w.lastUsed.Load()))
+}
+
+// StartWarmup pings the workers that were idle for WARMUP_INTERVAL every
+// WARMUP_INTERVAL, so that their connections to Telegram stay open and the
+// first request after a quiet period doesn't wait for them to reconnect.
+func StartWarmup(ctx context.Context) {
+	interval := config.ValueOf.WarmupInterval
+	if interval <= 0 {
+		return
+	}
+	Workers.log.Sugar().Infof("Pinging idle workers every %s", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			workers := Workers.List()
+			for _, worker := range workers {
+				// tripped workers are probed by their breaker instead
+				if worker.Available() && worker.idleFor() >= interval {
+					go worker.ping(ctx)
+				}
+			}
+		}
+	}()
+}
+
+func (w *Worker) ping(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, warmupTimeout)
+	defer cancel()
+	started := time.Now()
+	if _, err := w.Client.API().HelpGetNearestDC(ctx); err != nil {
+		w.log.Warn("Warm-up ping failed", zap.Int("worker", w.ID), zap.Error(err))
+		return
+	}
+	w.touch()
+	w.log.Debug("Warm-up ping", zap.Int("worker", w.ID), zap.Duration("took", time.Since(started)))
+}
@@ -4,6 +4,7 @@ import (
 	"EverythingSuckz/fsb/config"
 	"context"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"sync"
@@ -18,10 +19,21 @@ import (
 )
 
 type Worker struct {
-	ID     int
-	Client *gotgproto.Client
-	Self   *tg.User
-	log    *zap.Logger
+	ID      int
+	Client  *gotgproto.Client
+	Self    *tg.User
+	log     *zap.Logger
+	breaker breaker
+	tuner   chunkTuner
+	budget  *budget
+	// token is the bot token of workers started from MULTI_TOKEN, which
+	// can be stopped when scaling down.
+	token string
+	// lastUsed is when the worker last fetched a chunk or was pinged, in
+	// Unix nanoseconds.
+	lastUsed atomic.Int64
+	// inflight is the size of the chunks the worker is fetching.
+	inflight atomic.Int64
 }
 
 func (w *Worker) String() string {
@@ -29,16 +41,32 @@ func (w *Worker) String() string {
 }
 
 type BotWorkers struct {
-	Bots     []*Worker
+	// Bots are the started workers. It's replaced when workers are started
+	// or stopped, so it has to be read through List.
+	Bots []*Worker
+	// spare are the MULTI_TOKEN bots that aren't started, when the workers
+	// are scaled to the load.
+	spare []string
+	// stopped are the workers of spare bots that were started before,
+	// restarted with their session when they're needed again.
+	stopped map[string]*Worker
+	// ids are the worker IDs of the MULTI_TOKEN bots, which their session
+	// files are named after.
+	ids      map[string]int
 	starting int
 	index    int
 	mut      sync.Mutex
 	log      *zap.Logger
+	// streams returns the active streams of each worker, set by
+	// BalanceLoad.
+	streams func() map[int]int
 }
 
 var Workers *BotWorkers = &BotWorkers{
-	log:  nil,
-	Bots: make([]*Worker, 0),
+	log:     nil,
+	Bots:    make([]*Worker, 0),
+	stopped: make(map[string]*Worker),
+	ids:     make(map[string]int),
 }
 
 func (w *BotWorkers) Init(log *zap.Logger) {
@@ -46,52 +74,180 @@ func (w *BotWorkers) Init(log *zap.Logger) {
 }
 
 func (w *BotWorkers) AddDefaultClient(client *gotgproto.Client, self *tg.User) {
-	if w.Bots == nil {
-		w.Bots = make([]*Worker, 0)
-	}
-	w.incStarting()
+	w.mut.Lock()
+	w.starting++
 	w.Bots = append(w.Bots, &Worker{
 		Client: client,
 		ID:     w.starting,
 		Self:   self,
 		log:    w.log,
+		budget: defaultBudget,
 	})
+	w.mut.Unlock()
 	w.log.Sugar().Info("Default bot loaded")
 }
 
-func (w *BotWorkers) incStarting() {
+// List returns the started workers. The slice is a copy, as workers are
+// started and stopped while it's read.
+func (w *BotWorkers) List() []*Worker {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return append([]*Worker(nil), w.Bots...)
+}
+
+// idFor returns the worker ID of the bot with the given token, the one it
+// was started with before if it was, so it keeps its session file.
+func (w *BotWorkers) idFor(token string) int {
 	w.mut.Lock()
 	defer w.mut.Unlock()
+	if id, ok := w.ids[token]; ok {
+		return id
+	}
 	w.starting++
+	w.ids[token] = w.starting
+	return w.starting
 }
 
 func (w *BotWorkers) Add(token string) (err error) {
-	w.incStarting()
-	var botID int = w.starting
-	client, err := startWorker(w.log, token, botID)
+	botID := w.idFor(token)
+	budget := newBudget()
+	client, err := startWorker(w.log, token, botID, budget)
 	if err != nil {
 		return err
 	}
 	w.log.Sugar().Infof("Bot @%s loaded with ID %d", client.Self.Username, botID)
+	w.mut.Lock()
+	defer w.mut.Unlock()
 	w.Bots = append(w.Bots, &Worker{
 		Client: client,
 		ID:     botID,
 		Self:   client.Self,
 		log:    w.log,
+		budget: budget,
+		token:  token,
 	})
 	return nil
 }
 
+// GetNextWorker returns the least loaded worker, skipping workers whose
+// breaker is open or that are waiting out a FLOOD_WAIT. Workers with the
+// same load take turns. When every worker is waiting one out, the one whose
+// wait ends first is returned, and its calls queue in its flood waiter until
+// then.
 func GetNextWorker() *Worker {
+	streams := activeStreams()
 	Workers.mut.Lock()
 	defer Workers.mut.Unlock()
-	index := (Workers.index + 1) % len(Workers.Bots)
-	Workers.index = index
-	worker := Workers.Bots[index]
+	var worker, best, waiting *Worker
+	var bestIndex int
+	var bestLoad int64
+	var waitingFor time.Duration
+	for i := range Workers.Bots {
+		index := (Workers.index + 1 + i) % len(Workers.Bots)
+		worker = Workers.Bots[index]
+		if !worker.Available() {
+			continue
+		}
+		if left := worker.CooldownLeft(); left > 0 {
+			if waiting == nil || left < waitingFor {
+				waiting, waitingFor = worker, left
+			}
+			continue
+		}
+		if load := worker.load(streams); best == nil || load < bestLoad {
+			best, bestIndex, bestLoad = worker, index, load
+		}
+	}
+	switch {
+	case best != nil:
+		worker = best
+		Workers.index = bestIndex
+	case waiting != nil:
+		worker = waiting
+	}
 	Workers.log.Sugar().Debugf("Using worker %d", worker.ID)
 	return worker
 }
 
+// CooldownLeft returns how long until the FLOOD_WAIT the worker last got
+// ends, or 0 if it isn't waiting one out.
+func (w *Worker) CooldownLeft() time.Duration {
+	if w.budget == nil {
+		return 0
+	}
+	return w.budget.cooldownLeft()
+}
+
+// CountReady returns how many workers could fetch a chunk right away, with
+// their breaker closed and no FLOOD_WAIT to wait out, and how many there are.
+func CountReady() (ready int, total int) {
+	Workers.mut.Lock()
+	defer Workers.mut.Unlock()
+	for _, worker := range Workers.Bots {
+		if worker.Available() && worker.CooldownLeft() == 0 {
+			ready++
+		}
+	}
+	return ready, len(Workers.Bots)
+}
+
+// GetWorker returns the worker with the given ID, or nil if there is none.
+// It's returned even when it isn't available, as it's asked for to debug it.
+func GetWorker(id int) *Worker {
+	Workers.mut.Lock()
+	defer Workers.mut.Unlock()
+	for _, worker := range Workers.Bots {
+		if worker.ID == id {
+			return worker
+		}
+	}
+	return nil
+}
+
+// GetWorkerFor returns the worker requests with the given key are pinned
+// to, picked by rendezvous hashing so that adding or removing a worker only
+// moves the keys of that worker. Workers whose breaker is open, that are
+// waiting out a FLOOD_WAIT or that carry more than twice the average load
+// are skipped, moving their keys to the next best worker until they recover.
+// An empty key gets the least loaded worker.
+func GetWorkerFor(key string) *Worker {
+	if key == "" {
+		return GetNextWorker()
+	}
+	streams := activeStreams()
+	Workers.mut.Lock()
+	ready := make([]*Worker, 0, len(Workers.Bots))
+	var total int64
+	for _, worker := range Workers.Bots {
+		if worker.Available() && worker.CooldownLeft() == 0 {
+			ready = append(ready, worker)
+			total += worker.load(streams)
+		}
+	}
+	Workers.mut.Unlock()
+	var best *Worker
+	var bestScore uint64
+	if len(ready) > 0 {
+		// the slack keeps a few streams from moving keys around
+		limit := 2*total/int64(len(ready)) + loadSlack
+		for _, worker := range ready {
+			if worker.load(streams) > limit {
+				continue
+			}
+			hash := fnv.New64a()
+			fmt.Fprintf(hash, "%s|%d", key, worker.ID)
+			if score := hash.Sum64(); best == nil || score > bestScore {
+				best, bestScore = worker, score
+			}
+		}
+	}
+	if best == nil {
+		return GetNextWorker()
+	}
+	Workers.log.Sugar().Debugf("Using worker %d", best.ID)
+	return best
+}
+
 func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 	Workers.Init(log)
 
@@ -111,7 +267,12 @@ func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 
 	var wg sync.WaitGroup
 	var successfulStarts int32
-	totalBots := len(config.ValueOf.MultiTokens)
+	tokens := config.ValueOf.MultiTokens
+	if scaling() && config.ValueOf.WorkersMin < len(tokens) {
+		Workers.spare = tokens[config.ValueOf.WorkersMin:]
+		tokens = tokens[:config.ValueOf.WorkersMin]
+	}
+	totalBots := len(tokens)
 
 	for i := 0; i < totalBots; i++ {
 		wg.Add(1)
@@ -123,7 +284,7 @@ func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 
 			done := make(chan error, 1)
 			go func() {
-				err := Workers.Add(config.ValueOf.MultiTokens[i])
+				err := Workers.Add(tokens[i])
 				done <- err
 			}()
 
@@ -145,7 +306,7 @@ func StartWorkers(log *zap.Logger) (*BotWorkers, error) {
 	return Workers, nil
 }
 
-func startWorker(l *zap.Logger, botToken string, index int) (*gotgproto.Client, error) {
+func startWorker(l *zap.Logger, botToken string, index int, budget *budget) (*gotgproto.Client, error) {
 	log := l.Named("Worker").Sugar()
 	log.Infof("Starting worker with index - %d", index)
 	var sessionType sessionMaker.SessionConstructor
@@ -161,7 +322,7 @@ func startWorker(l *zap.Logger, botToken string, index int) (*gotgproto.Client,
 		&gotgproto.ClientOpts{
 			Session:          sessionType,
 			DisableCopyright: true,
-			Middlewares:      GetFloodMiddleware(log.Desugar()),
+			Middlewares:      GetFloodMiddleware(log.Desugar(), budget),
 		},
 	)
 	if err != nil {
@@ -169,3 +330,14 @@ func startWorker(l *zap.Logger, botToken string, index int) (*gotgproto.Client,
 	}
 	return client, nil
 }
+
+// StopClients disconnects every worker, including the default bot, and the
+// userbot if one was started.
+func StopClients() {
+	for _, worker := range Workers.List() {
+		worker.Client.Stop()
+	}
+	if UserBot.client != nil {
+		UserBot.client.Stop()
+	}
+}
@@ -1,9 +1,11 @@
 package cache
 
 import (
+	"EverythingSuckz/fsb/internal/evict"
 	"EverythingSuckz/fsb/internal/types"
 	"bytes"
 	"encoding/gob"
+	"fmt"
 	"sync"
 
 	"github.com/coocood/freecache"
@@ -26,6 +28,9 @@ func InitCache(log *zap.Logger) {
 	gob.Register(tg.InputPhotoFileLocation{})
 	defer log.Sugar().Info("Initialized")
 	cache = &Cache{cache: freecache.NewCache(10 * 1024 * 1024), log: log}
+	evict.Subscribe(func(event evict.Event) {
+		cache.DeleteFile(event.ChannelID, event.MessageID)
+	})
 }
 
 func GetCache() *Cache {
@@ -66,3 +71,21 @@ func (c *Cache) Delete(key string) error {
 	cache.cache.Del([]byte(key))
 	return nil
 }
+
+// DeleteFile drops the properties of a file cached for every client.
+func (c *Cache) DeleteFile(channelID int64, messageID int) {
+	prefix := []byte(fmt.Sprintf("file:%d:%d:", channelID, messageID))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys [][]byte
+	iterator := c.cache.NewIterator()
+	for entry := iterator.Next(); entry != nil; entry = iterator.Next() {
+		if bytes.HasPrefix(entry.Key, prefix) {
+			keys = append(keys, entry.Key)
+		}
+	}
+	for _, key := range keys {
+		c.cache.Del(key)
+	}
+	c.log.Debug("Evicted file", zap.Int64("channelID", channelID), zap.Int("messageID", messageID), zap.Int("keys", len(keys)))
+}
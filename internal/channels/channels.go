@@ -0,0 +1,115 @@
+package channels
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// registry keeps the storage channels added at runtime on top of
+// LOG_CHANNEL, and the channels groups store their files in.
+type registry struct {
+	mu  sync.RWMutex
+	ids []int64
+	// groups maps a group to the channel its new files are stored in, and
+	// owners every group channel to its group.
+	groups map[int64]int64
+	owners map[int64]int64
+	log    *zap.Logger
+}
+
+var channels = &registry{groups: make(map[int64]int64), owners: make(map[int64]int64)}
+
+func Load(log *zap.Logger) error {
+	channels.log = log.Named("channels")
+	saved, err := store.GetStore().ListChannels()
+	if err != nil {
+		return err
+	}
+	channels.mu.Lock()
+	defer channels.mu.Unlock()
+	for _, channel := range saved {
+		channels.add(channel)
+	}
+	channels.log.Sugar().Infof("Loaded %d storage channel(s)", len(saved))
+	return nil
+}
+
+// add starts storing new files in the channel. The caller must hold the
+// lock.
+func (r *registry) add(channel *store.Channel) {
+	if channel.GroupID != 0 {
+		r.groups[channel.GroupID] = channel.ID
+		r.owners[channel.ID] = channel.GroupID
+		return
+	}
+	for i, id := range r.ids {
+		if id == channel.ID {
+			r.ids = append(r.ids[:i], r.ids[i+1:]...)
+			break
+		}
+	}
+	r.ids = append(r.ids, channel.ID)
+}
+
+// Register persists a storage channel and starts storing new files in it,
+// or the new files of its group if it has one.
+func Register(channel *store.Channel) error {
+	if err := store.GetStore().AddChannel(channel); err != nil {
+		return err
+	}
+	channels.mu.Lock()
+	defer channels.mu.Unlock()
+	channels.add(channel)
+	channels.log.Info("Registered storage channel",
+		zap.Int64("channelID", channel.ID),
+		zap.String("title", channel.Title),
+		zap.Int64("groupID", channel.GroupID))
+	return nil
+}
+
+// Current returns the channel new files should be stored in.
+func Current() int64 {
+	channels.mu.RLock()
+	defer channels.mu.RUnlock()
+	if len(channels.ids) == 0 {
+		return config.ValueOf.LogChannelID
+	}
+	return channels.ids[len(channels.ids)-1]
+}
+
+// ForGroup returns the channel the group's new files are stored in.
+func ForGroup(groupID int64) (int64, bool) {
+	channels.mu.RLock()
+	defer channels.mu.RUnlock()
+	channelID, ok := channels.groups[groupID]
+	return channelID, ok
+}
+
+// GroupOf returns the group whose files the channel stores, if it stores a
+// group's files.
+func GroupOf(channelID int64) (int64, bool) {
+	channels.mu.RLock()
+	defer channels.mu.RUnlock()
+	groupID, ok := channels.owners[channelID]
+	return groupID, ok
+}
+
+// IsAllowed reports whether files may be served from the given channel to
+// anyone with a link. Files in group channels are only served to members of
+// the group.
+func IsAllowed(channelID int64) bool {
+	if channelID == config.ValueOf.LogChannelID {
+		return true
+	}
+	channels.mu.RLock()
+	defer channels.mu.RUnlock()
+	for _, id := range channels.ids {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
+}
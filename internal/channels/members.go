@@ -0,0 +1,84 @@
+package channels
+
+import (
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+)
+
+// memberTTL is how long the result of a membership check is reused, so that
+// players requesting ranges don't check it on every request.
+const memberTTL = 5 * time.Minute
+
+type memberKey struct {
+	groupID int64
+	userID  int64
+}
+
+type memberCheck struct {
+	member    bool
+	checkedAt time.Time
+}
+
+var members = struct {
+	sync.Mutex
+	checks map[memberKey]memberCheck
+}{checks: make(map[memberKey]memberCheck)}
+
+// IsMember reports whether the user is a member of the group, asking
+// Telegram through api at most once every memberTTL. The user has to be known
+// to the client, which is the case once they have started the bot.
+func IsMember(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, groupID int64, userID int64) (bool, error) {
+	key := memberKey{groupID, userID}
+	members.Lock()
+	check, ok := members.checks[key]
+	members.Unlock()
+	if ok && time.Since(check.checkedAt) < memberTTL {
+		return check.member, nil
+	}
+	member, err := isMember(ctx, api, peerStorage, groupID, userID)
+	if err != nil {
+		return false, err
+	}
+	members.Lock()
+	for k, c := range members.checks {
+		if time.Since(c.checkedAt) >= memberTTL {
+			delete(members.checks, k)
+		}
+	}
+	members.checks[key] = memberCheck{member: member, checkedAt: time.Now()}
+	members.Unlock()
+	return member, nil
+}
+
+func isMember(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, groupID int64, userID int64) (bool, error) {
+	user, ok := peerStorage.GetInputPeerById(userID).(*tg.InputPeerUser)
+	if !ok {
+		return false, errors.New("unknown user, start the bot first")
+	}
+	group, err := utils.GetChannelPeer(ctx, api, peerStorage, groupID)
+	if err != nil {
+		return false, err
+	}
+	participant, err := api.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     group,
+		Participant: user,
+	})
+	if tgerr.Is(err, "USER_NOT_PARTICIPANT") {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	switch participant.Participant.(type) {
+	case *tg.ChannelParticipantLeft, *tg.ChannelParticipantBanned:
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadAddChannel(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("addchannel")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("addchannel", addChannel))
+}
+
+func addChannel(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if config.ValueOf.OwnerID == 0 || chatId != config.ValueOf.OwnerID {
+		ctx.Reply(u, "This command is only available to the bot owner.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		ctx.Reply(u, "Usage: /addchannel <channel id>", nil)
+		return dispatcher.EndGroups
+	}
+	channelID, err := strconv.ParseInt(strings.TrimPrefix(args[1], "-100"), 10, 64)
+	if err != nil {
+		ctx.Reply(u, "Invalid channel ID.", nil)
+		return dispatcher.EndGroups
+	}
+	title, err := storageChannelTitle(ctx, channelID)
+	if err != nil {
+		ctx.Reply(u, err.Error(), nil)
+		return dispatcher.EndGroups
+	}
+	err = channels.Register(&store.Channel{ID: channelID, Title: title, AddedBy: chatId})
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("Added %s (%d) as a storage channel. New files will be stored there.\nMake sure the worker bots are members of it too.", title, channelID), nil)
+	return dispatcher.EndGroups
+}
+
+// storageChannelTitle checks that the bot can post in the channel and
+// returns its title.
+func storageChannelTitle(ctx *ext.Context, channelID int64) (string, error) {
+	inputChannel, err := utils.GetChannelPeer(ctx, ctx.Raw, ctx.PeerStorage, channelID)
+	if err != nil {
+		return "", fmt.Errorf("Could not access the channel, make sure the bot is a member of it. Error - %s", err.Error())
+	}
+	participant, err := ctx.Raw.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     inputChannel,
+		Participant: &tg.InputPeerSelf{},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error - %s", err.Error())
+	}
+	switch p := participant.Participant.(type) {
+	case *tg.ChannelParticipantCreator:
+	case *tg.ChannelParticipantAdmin:
+		if !p.AdminRights.PostMessages {
+			return "", errors.New("The bot needs the \"Post Messages\" admin right in that channel.")
+		}
+	default:
+		return "", errors.New("The bot is not an admin in that channel.")
+	}
+	var title string
+	for _, chat := range participant.Chats {
+		if channel, ok := chat.(*tg.Channel); ok && channel.ID == channelID {
+			title = channel.Title
+		}
+	}
+	return title, nil
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadBind(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("bind")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("bind", bind))
+}
+
+// bind replies with a link to the file that only works from an IP address or
+// CIDR range, for sharing it with a single server.
+func bind(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if len(args) < 2 || !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, "Reply to a file with /bind <ip or range> (eg. /bind 203.0.113.7 or /bind 203.0.113.0/24) to get a link that only works from there.", nil)
+		return dispatcher.EndGroups
+	}
+	prefix, err := utils.NormalizeIPBinding(args[1])
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(fmt.Sprintf("This link only works from %s\n\n", prefix)),
+		styling.Code(stored.BoundLink(prefix)),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
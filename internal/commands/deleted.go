@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/evict"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadDeleted(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("deleted")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewAnyUpdate(evictDeleted))
+}
+
+// evictDeleted drops the cached data of files whose messages were deleted
+// from a storage channel.
+func evictDeleted(ctx *ext.Context, u *ext.Update) error {
+	deleted, ok := u.UpdateClass.(*tg.UpdateDeleteChannelMessages)
+	if !ok {
+		return dispatcher.ContinueGroups
+	}
+	if _, group := channels.GroupOf(deleted.ChannelID); !group && !channels.IsAllowed(deleted.ChannelID) {
+		return dispatcher.ContinueGroups
+	}
+	for _, messageID := range deleted.Messages {
+		evict.Publish(evict.Event{ChannelID: deleted.ChannelID, MessageID: messageID, Reason: evict.Deleted})
+	}
+	return dispatcher.EndGroups
+}
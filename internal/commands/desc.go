@@ -0,0 +1,74 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+// maxDescriptionLength caps descriptions, in characters.
+const maxDescriptionLength = 1024
+
+func (m *command) LoadDesc(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("desc")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("desc", describeFile))
+}
+
+// describeFile sets the description of the file the message replies to, or
+// shows it when no description is given. "/desc -" removes it.
+func describeFile(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	var description string
+	if _, rest, ok := strings.Cut(u.EffectiveMessage.Text, " "); ok {
+		description = strings.TrimSpace(rest)
+	}
+	if description == "" {
+		if entry.Description == "" {
+			ctx.Reply(u, fmt.Sprintf("%s has no description. Reply to it with /desc <text> to add one.", entry.FileName), nil)
+		} else {
+			ctx.Reply(u, fmt.Sprintf("Description of %s:\n\n%s", entry.FileName, entry.Description), nil)
+		}
+		return dispatcher.EndGroups
+	}
+	if description == "-" {
+		description = ""
+	}
+	if len([]rune(description)) > maxDescriptionLength {
+		ctx.Reply(u, fmt.Sprintf("Error - descriptions can be at most %d characters long", maxDescriptionLength), nil)
+		return dispatcher.EndGroups
+	}
+	err = store.GetStore().SetDescription(entry.ChannelID, entry.MessageID, description)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if description == "" {
+		ctx.Reply(u, fmt.Sprintf("Removed the description of %s.", entry.FileName), nil)
+	} else {
+		ctx.Reply(u, fmt.Sprintf("Updated the description of %s.", entry.FileName), nil)
+	}
+	return dispatcher.EndGroups
+}
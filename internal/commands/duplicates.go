@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/dedupe"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+// maxDuplicatesListed is how many duplicated files /duplicates names, the
+// rest are only counted.
+const maxDuplicatesListed = 20
+
+func (m *command) LoadDuplicates(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("duplicates")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("duplicates", duplicates))
+}
+
+// duplicates reports the files stored more than once, and with
+// "/duplicates remove" deletes their extra copies.
+func duplicates(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if config.ValueOf.OwnerID == 0 || chatId != config.ValueOf.OwnerID {
+		ctx.Reply(u, "This command is only available to the bot owner.", nil)
+		return dispatcher.EndGroups
+	}
+	report, err := dedupe.Find()
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if len(report.Groups) == 0 {
+		ctx.Reply(u, "No file is stored more than once.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) > 1 && args[1] == "remove" {
+		result, err := dedupe.Remove(ctx, ctx.Raw, ctx.PeerStorage, report)
+		if err != nil {
+			utils.Logger.Sugar().Error(err)
+			ctx.Reply(u, fmt.Sprintf("Removed %d copies (%s) before failing - %s", result.Removed, megabytes(result.ReclaimedBytes), err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(u, fmt.Sprintf("Removed %d copies, reclaiming %s. Their links now serve the copy that was kept.", result.Removed, megabytes(result.ReclaimedBytes)), nil)
+		return dispatcher.EndGroups
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d files are stored more than once, removing their %d extra copies would reclaim %s.\n\n",
+		len(report.Groups), report.Copies, megabytes(report.ReclaimableBytes))
+	for i, group := range report.Groups {
+		if i == maxDuplicatesListed {
+			fmt.Fprintf(&text, "... and %d more\n", len(report.Groups)-i)
+			break
+		}
+		fmt.Fprintf(&text, "%s (%s) - %d copies\n", group.Kept.FileName, megabytes(group.FileSize), len(group.Copies)+1)
+	}
+	text.WriteString("\nSend /duplicates remove to delete the extra copies, their links will serve the oldest copy.")
+	ctx.Reply(u, text.String(), nil)
+	return dispatcher.EndGroups
+}
+
+func megabytes(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadEmbed(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("embed")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("embed", embed))
+}
+
+func embed(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if len(args) < 2 || !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, "Reply to a file with /embed <origin> (eg. /embed https://example.com) to get a link that only plays on that site.", nil)
+		return dispatcher.EndGroups
+	}
+	origin, err := utils.NormalizeOrigin(args[1])
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	link := stored.EmbedLink(origin)
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(fmt.Sprintf("This link only works when embedded on %s\n\n", origin)),
+		styling.Code(link),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadExpire(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("expire")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("expire", expire))
+}
+
+// expire replies with a link that stops working after the given duration,
+// for sharing something only for a while.
+func expire(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if len(args) < 2 || !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, "Reply to a file with /expire <duration> (eg. /expire 24h) to get a link that stops working after that long.", nil)
+		return dispatcher.EndGroups
+	}
+	duration, err := time.ParseDuration(args[1])
+	if err != nil || duration <= 0 {
+		ctx.Reply(u, fmt.Sprintf("Error - %q is not a duration like 30m or 24h", args[1]), nil)
+		return dispatcher.EndGroups
+	}
+	stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	expires := time.Now().Add(duration)
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(fmt.Sprintf("This link stops working at %s\n\n", expires.UTC().Format("2006-01-02 15:04 MST"))),
+		styling.Code(stored.ExpiringLink(expires)),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/geoip"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadGeo(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("geo")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("geo", geo))
+}
+
+// geo replies with a link that only works in the given countries, or
+// everywhere but in them.
+func geo(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	if !geoip.Enabled() {
+		ctx.Reply(u, "Geo-fenced links need GEOIP_DB or GEOIP_HEADER to be set.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if len(args) < 2 || !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, "Reply to a file with /geo <countries> (eg. /geo US,CA) to get a link that only works in those countries, or with /geo -<countries> (eg. /geo -CN,-RU) to get one that works everywhere else.", nil)
+		return dispatcher.EndGroups
+	}
+	countries, err := geoip.ParseCountries(strings.Join(args[1:], ""))
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	id, err := utils.NewLinkID()
+	if err == nil {
+		err = store.GetStore().SaveLink(&store.Link{ID: id, MessageID: stored.MessageID, CreatedBy: chatId, Countries: countries})
+	}
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	note := fmt.Sprintf("This link only works in %s\n\n", countries)
+	if strings.HasPrefix(countries, "-") {
+		note = fmt.Sprintf("This link works everywhere but in %s\n\n", strings.ReplaceAll(countries, "-", ""))
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(note),
+		styling.Code(stored.GeoLink(id)),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// groupLinkPrefix starts the /start payloads of the buttons posted in
+// groups, followed by <channel id>_<message id>.
+const groupLinkPrefix = "g"
+
+func (m *command) LoadGroup(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("group")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("setchannel", setGroupChannel))
+}
+
+// setGroupChannel lets a group's admins choose the channel the files sent to
+// the group are stored in.
+func setGroupChannel(ctx *ext.Context, u *ext.Update) error {
+	groupID := u.EffectiveChat().GetID()
+	if ctx.PeerStorage.GetPeerById(groupID).Type != int(storage.TypeChannel) {
+		ctx.Reply(u, "Use this command in a group to choose the channel the files sent there are stored in.", nil)
+		return dispatcher.EndGroups
+	}
+	user := u.EffectiveUser()
+	if user == nil {
+		ctx.Reply(u, "Turn off \"Remain Anonymous\" to use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	admin, err := isGroupAdmin(ctx, groupID, user.ID)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if !admin {
+		ctx.Reply(u, "Only the admins of this group can use this command.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		ctx.Reply(u, "Usage: /setchannel <channel id>", nil)
+		return dispatcher.EndGroups
+	}
+	channelID, err := strconv.ParseInt(strings.TrimPrefix(args[1], "-100"), 10, 64)
+	if err != nil {
+		ctx.Reply(u, "Invalid channel ID.", nil)
+		return dispatcher.EndGroups
+	}
+	if channels.IsAllowed(channelID) {
+		ctx.Reply(u, "That channel stores the files sent to the bot directly, use a channel of your own.", nil)
+		return dispatcher.EndGroups
+	}
+	if owner, ok := channels.GroupOf(channelID); ok && owner != groupID {
+		ctx.Reply(u, "That channel stores the files of another group.", nil)
+		return dispatcher.EndGroups
+	}
+	title, err := storageChannelTitle(ctx, channelID)
+	if err != nil {
+		ctx.Reply(u, err.Error(), nil)
+		return dispatcher.EndGroups
+	}
+	err = channels.Register(&store.Channel{ID: channelID, Title: title, AddedBy: user.ID, GroupID: groupID})
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("Files sent to this group will be stored in %s (%d). Members of the group get their links from the bot.", title, channelID), nil)
+	return dispatcher.EndGroups
+}
+
+func isGroupAdmin(ctx *ext.Context, groupID int64, userID int64) (bool, error) {
+	user, ok := ctx.PeerStorage.GetInputPeerById(userID).(*tg.InputPeerUser)
+	if !ok {
+		return false, errors.New("unknown user")
+	}
+	group, err := utils.GetChannelPeer(ctx, ctx.Raw, ctx.PeerStorage, groupID)
+	if err != nil {
+		return false, err
+	}
+	participant, err := ctx.Raw.ChannelsGetParticipant(ctx, &tg.ChannelsGetParticipantRequest{
+		Channel:     group,
+		Participant: user,
+	})
+	if err != nil {
+		return false, err
+	}
+	switch participant.Participant.(type) {
+	case *tg.ChannelParticipantCreator, *tg.ChannelParticipantAdmin:
+		return true, nil
+	}
+	return false, nil
+}
+
+// sendGroupLink stores a file sent to a group in the group's channel. Links
+// to it are only handed out to members of the group, in private, so the
+// reply points them to the bot.
+func sendGroupLink(ctx *ext.Context, u *ext.Update, groupID int64) error {
+	channelID, ok := channels.ForGroup(groupID)
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	supported, err := supportedMediaFilter(u.EffectiveMessage)
+	if err != nil {
+		return err
+	}
+	user := u.EffectiveUser()
+	if !supported || user == nil {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, user.ID) {
+		return dispatcher.EndGroups
+	}
+	stored, err := forwardToChannel(ctx, groupID, channelID, u.EffectiveMessage.ID, user.ID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	start := fmt.Sprintf("https://t.me/%s?start=%s%d_%d", ctx.Self.Username, groupLinkPrefix, stored.ChannelID, stored.MessageID)
+	_, err = ctx.Reply(u, "Members of this group can get a link to this file from the bot.", &ext.ReplyOpts{
+		Markup: &tg.ReplyInlineMarkup{
+			Rows: []tg.KeyboardButtonRow{{
+				Buttons: []tg.KeyboardButtonClass{&tg.KeyboardButtonURL{Text: "Get link", URL: start}},
+			}},
+		},
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+	}
+	return dispatcher.EndGroups
+}
+
+// sendMemberLink sends a member of a group their link to one of the group's
+// files. The link is signed with their user ID, and the stream routes check
+// that they are still a member whenever it is used.
+func sendMemberLink(ctx *ext.Context, u *ext.Update, userID int64, payload string) error {
+	channelPart, messagePart, _ := strings.Cut(payload, "_")
+	channelID, err := strconv.ParseInt(channelPart, 10, 64)
+	if err != nil {
+		ctx.Reply(u, "Invalid link.", nil)
+		return dispatcher.EndGroups
+	}
+	messageID, err := strconv.Atoi(messagePart)
+	if err != nil {
+		ctx.Reply(u, "Invalid link.", nil)
+		return dispatcher.EndGroups
+	}
+	groupID, ok := channels.GroupOf(channelID)
+	if !ok {
+		ctx.Reply(u, "This file is no longer available.", nil)
+		return dispatcher.EndGroups
+	}
+	member, err := channels.IsMember(ctx, ctx.Raw, ctx.PeerStorage, groupID, userID)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if !member {
+		ctx.Reply(u, "Only members of the group can get links to its files.", nil)
+		return dispatcher.EndGroups
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	link := fmt.Sprintf("%s&member=%d&msig=%s",
+		storedFileFromEntry(entry).Link(),
+		userID,
+		utils.SignMember(channelID, messageID, userID),
+	)
+	linkCreated(channelID, messageID, entry.FileName, entry.FileSize, entry.MimeType, userID, link)
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Bold(entry.FileName),
+		styling.Plain("\n\nThis link only works while you are a member of the group.\n\n"),
+		styling.Code(link),
+	}, &ext.ReplyOpts{NoWebpage: true})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/analytics"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+// maxListedStats caps how many files /mystats lists, the link it replies
+// with lists more.
+const maxListedStats = 10
+
+func (m *command) LoadMyStats(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("mystats")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("mystats", myStats))
+}
+
+// myStats replies with the hits of the links to the user's files, or of
+// the file the message replies to along with its analytics pixel. Users
+// only ever see the stats of the files they uploaded.
+func myStats(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	if _, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader); ok {
+		return myFileStats(ctx, u, chatId)
+	}
+	stats, err := analytics.Stats(chatId, maxListedStats)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if len(stats) == 0 {
+		ctx.Reply(u, "None of your links were opened yet.", nil)
+		return dispatcher.EndGroups
+	}
+	var text strings.Builder
+	text.WriteString("Your most recently opened links:\n")
+	for _, s := range stats {
+		text.WriteString("\n" + describeStats(&s))
+	}
+	text.WriteString("\n\nAll of them as JSON: ")
+	ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(text.String()),
+		styling.Code(utils.StatsLink(chatId)),
+	}, &ext.ReplyOpts{NoWebpage: true, ReplyToMessageId: u.EffectiveMessage.ID})
+	return dispatcher.EndGroups
+}
+
+func myFileStats(ctx *ext.Context, u *ext.Update, chatId int64) error {
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	text := "Nobody opened this link yet."
+	stats, err := analytics.FileStats(entry.ChannelID, entry.MessageID)
+	if err == nil {
+		text = describeStats(stats)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	hash := utils.GetShortHash(storedFileFromEntry(entry).FullHash)
+	ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(text + "\n\nAdd this image to a page to count its views too:\n"),
+		styling.Code(fmt.Sprintf(`<img src="%s" width="1" height="1" alt="">`, utils.FileLink("pixel", entry.ChannelID, entry.MessageID, hash))),
+	}, &ext.ReplyOpts{NoWebpage: true, ReplyToMessageId: u.EffectiveMessage.ID})
+	return dispatcher.EndGroups
+}
+
+// describeStats words the stats of a file, like "video.mp4: 12 downloads,
+// 3 views from 5 addresses (US 3, DE 2), last opened 2026-10-16 12:00 UTC".
+func describeStats(s *types.LinkStats) string {
+	name := s.FileName
+	if name == "" {
+		name = fmt.Sprintf("Message %d", s.MessageID)
+	}
+	text := fmt.Sprintf("%s: %d downloads, %d views from %d addresses", name, s.Hits, s.Views, s.UniqueIPs)
+	if len(s.Countries) > 0 {
+		countries := make([]string, 0, len(s.Countries))
+		for country := range s.Countries {
+			countries = append(countries, country)
+		}
+		sort.Slice(countries, func(i, j int) bool {
+			if s.Countries[countries[i]] != s.Countries[countries[j]] {
+				return s.Countries[countries[i]] > s.Countries[countries[j]]
+			}
+			return countries[i] < countries[j]
+		})
+		for i, country := range countries {
+			countries[i] = fmt.Sprintf("%s %d", country, s.Countries[country])
+		}
+		text += " (" + strings.Join(countries, ", ") + ")"
+	}
+	return text + ", last opened " + s.LastAccess.UTC().Format("2006-01-02 15:04") + " UTC"
+}
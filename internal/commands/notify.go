@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/audit"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+func (m *command) LoadNotify(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("notify")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("notify", notifyFile))
+}
+
+// notifyFile sets when the user is messaged about downloads of the file the
+// message replies to: "first" for the first download only, "ip" for every
+// new IP address and "off" for never. Without an argument it shows the
+// current setting.
+func notifyFile(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	var mode string
+	if _, rest, ok := strings.Cut(u.EffectiveMessage.Text, " "); ok {
+		mode = strings.ToLower(strings.TrimSpace(rest))
+	}
+	switch mode {
+	case "":
+		ctx.Reply(u, fmt.Sprintf("Download notifications for %s: %s", entry.FileName, describeNotify(entry.Notify)), nil)
+		return dispatcher.EndGroups
+	case "off":
+		mode = store.NotifyOff
+	case store.NotifyFirst, store.NotifyIP:
+	default:
+		ctx.Reply(u, "Error - use /notify first, /notify ip or /notify off", nil)
+		return dispatcher.EndGroups
+	}
+	err = store.GetStore().SetNotify(entry.ChannelID, entry.MessageID, mode)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	audit.Forget(entry.ChannelID, entry.MessageID)
+	ctx.Reply(u, fmt.Sprintf("Download notifications for %s: %s", entry.FileName, describeNotify(mode)), nil)
+	return dispatcher.EndGroups
+}
+
+func describeNotify(mode string) string {
+	switch mode {
+	case store.NotifyFirst:
+		return "on the first download"
+	case store.NotifyIP:
+		return "on downloads from each new IP address"
+	default:
+		return "off"
+	}
+}
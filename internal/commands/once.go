@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadOnce(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("once")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("once", once))
+}
+
+// once replies with a link that only works for the first client that opens
+// it, optionally until it expires.
+func once(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, "Reply to a file with /once to get a link that only works for the first one to open it, or with /once <duration> (eg. /once 24h) to also have it expire.", nil)
+		return dispatcher.EndGroups
+	}
+	var expiresAt *time.Time
+	if len(args) > 1 {
+		duration, err := time.ParseDuration(args[1])
+		if err != nil || duration <= 0 {
+			ctx.Reply(u, fmt.Sprintf("Error - %q is not a duration like 30m or 24h", args[1]), nil)
+			return dispatcher.EndGroups
+		}
+		expiry := time.Now().Add(duration)
+		expiresAt = &expiry
+	}
+	stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	id, err := utils.NewLinkID()
+	if err == nil {
+		err = store.GetStore().SaveLink(&store.Link{ID: id, MessageID: stored.MessageID, CreatedBy: chatId, ExpiresAt: expiresAt})
+	}
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	note := "This link only works for the first one to open it, who can still resume and seek\n\n"
+	if expiresAt != nil {
+		note = fmt.Sprintf("This link only works for the first one to open it, who can still resume and seek, until %s\n\n", expiresAt.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(note),
+		styling.Code(stored.OnceLink(id)),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
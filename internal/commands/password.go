@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+func (m *command) LoadPassword(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("password")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("password", passwordFile))
+}
+
+// passwordFile protects the file the message replies to with a password,
+// which its links then need as ?pw= or as the basic auth password. "off"
+// removes the password again.
+func passwordFile(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	var password string
+	if _, rest, ok := strings.Cut(u.EffectiveMessage.Text, " "); ok {
+		password = strings.TrimSpace(rest)
+	}
+	if password == "" {
+		ctx.Reply(u, "Reply to a file with /password <password> to make its links ask for it, or with /password off to remove it.", nil)
+		return dispatcher.EndGroups
+	}
+	var hashed string
+	if password != "off" {
+		hashed, err = utils.HashPassword(password)
+	}
+	if err == nil {
+		err = store.GetStore().SetPassword(entry.ChannelID, entry.MessageID, hashed)
+	}
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if hashed == "" {
+		ctx.Reply(u, fmt.Sprintf("The password of %s was removed.", entry.FileName), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("The links to %s now ask for the password. Share them with &pw=<password> added, or have people enter it when their browser asks. You may want to delete your message with the password.", entry.FileName), nil)
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/quota"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+const quotaUsage = "Use /quota <user id> to see a user's quota, or /quota <user id> <size|off|default> [downloads|off|default] like /quota 123456 5GB 200 to set how much the links to their files may be downloaded per day."
+
+func (m *command) LoadQuota(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("quota")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("quota", showQuota))
+}
+
+// showQuota replies with how much the links to the user's files were
+// downloaded today and how much they may be. The owner can also see and set
+// the quota of any user.
+func showQuota(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)[1:]
+	if len(args) == 0 {
+		return replyQuota(ctx, u, chatId, "Your links")
+	}
+	if config.ValueOf.OwnerID == 0 || chatId != config.ValueOf.OwnerID {
+		ctx.Reply(u, "Only the owner can see and set the quotas of other users.", nil)
+		return dispatcher.EndGroups
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || len(args) > 3 {
+		ctx.Reply(u, quotaUsage, nil)
+		return dispatcher.EndGroups
+	}
+	if len(args) > 1 {
+		if err := setQuota(userID, args[1:]); err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+	}
+	return replyQuota(ctx, u, userID, fmt.Sprintf("The links of %d", userID))
+}
+
+// setQuota overrides the quota of the user with the size and number of
+// downloads in args, keeping the number of downloads it has if it's left
+// out.
+func setQuota(userID int64, args []string) error {
+	bytes, err := parseQuota(args[0], utils.ParseSize)
+	if err != nil {
+		return err
+	}
+	var downloads int64
+	if len(args) > 1 {
+		downloads, err = parseQuota(args[1], func(value string) (int64, error) {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%q isn't a number of downloads", value)
+			}
+			return n, nil
+		})
+		if err != nil {
+			return err
+		}
+	} else if current, err := store.GetStore().GetUserQuota(userID); err == nil {
+		downloads = current.DownloadsPerDay
+	}
+	if err := quota.Set(userID, bytes, downloads); err != nil {
+		utils.Logger.Sugar().Error(err)
+		return err
+	}
+	return nil
+}
+
+// parseQuota reads a limit of /quota, off for no limit and default for the
+// one set with QUOTA_MB or QUOTA_DOWNLOADS.
+func parseQuota(value string, parse func(string) (int64, error)) (int64, error) {
+	switch value {
+	case "off":
+		return store.QuotaUnlimited, nil
+	case "default":
+		return 0, nil
+	}
+	return parse(value)
+}
+
+func replyQuota(ctx *ext.Context, u *ext.Update, userID int64, whose string) error {
+	limits, err := quota.For(userID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	usage, err := quota.Used(userID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	bytes, downloads := "no limit", "no limit"
+	if limits.Bytes > 0 {
+		bytes = utils.FormatSize(limits.Bytes)
+	}
+	if limits.Downloads > 0 {
+		downloads = strconv.FormatInt(limits.Downloads, 10)
+	}
+	ctx.Reply(u, fmt.Sprintf(
+		"%s sent %s of %s and were downloaded %d times of %s today. The quota resets in %s.",
+		whose, utils.FormatSize(usage.Bytes), bytes, usage.Downloads, downloads,
+		quota.ResetIn().Round(time.Minute),
+	), nil)
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/ratelimit"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"go.uber.org/zap"
+)
+
+// rateLimitGroup runs before every other handler group.
+const rateLimitGroup = -1
+
+// cooledDown holds until when users that hit the rate limit were told so,
+// so they get a single reply however many messages they keep sending.
+var cooledDown = struct {
+	sync.Mutex
+	until map[int64]time.Time
+}{until: make(map[int64]time.Time)}
+
+func (m *command) LoadRateLimit(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("ratelimit")
+	if config.ValueOf.RateLimitMessages <= 0 || config.ValueOf.RateLimitWindow <= 0 {
+		log.Info("RATE_LIMIT_MESSAGES not set, rate limiting disabled")
+		return
+	}
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandlerToGroup(handlers.NewMessage(nil, rateLimit), rateLimitGroup)
+}
+
+// rateLimit drops the messages of users sending more than
+// RATE_LIMIT_MESSAGES per RATE_LIMIT_WINDOW, so a single user can't make the
+// bot hit Telegram's flood limits. The counts are kept in the store, so
+// restarting the bot doesn't reset them, and instances sharing a Redis or
+// Postgres store share the limit.
+func rateLimit(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) || chatId == config.ValueOf.OwnerID {
+		return nil
+	}
+	wait, err := ratelimit.Hit("user:"+strconv.FormatInt(chatId, 10), config.ValueOf.RateLimitMessages, config.ValueOf.RateLimitWindow)
+	if err != nil {
+		// better to let the message through than to lock everyone out
+		utils.Logger.Warn("Failed to check rate limit", zap.Int64("userID", chatId), zap.Error(err))
+		return nil
+	}
+	if wait == 0 {
+		return nil
+	}
+	cooledDown.Lock()
+	warned := time.Now().Before(cooledDown.until[chatId])
+	if !warned {
+		cooledDown.until[chatId] = time.Now().Add(wait)
+	}
+	cooledDown.Unlock()
+	if !warned {
+		seconds := int(math.Ceil(wait.Seconds()))
+		ctx.Reply(u, fmt.Sprintf("You're sending messages too fast, please wait %d seconds before sending another one.", seconds), nil)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/uploads"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/tg"
+)
+
+// restrictedViaUserbot is the RESTRICTED_MEDIA setting that has paid media
+// and protected messages stored through the user session.
+const restrictedViaUserbot = "userbot"
+
+// userSession returns the client of the user session, or nil when there is
+// none. The bot package loads the commands, so it's handed in through
+// UseUserSession instead of imported.
+var userSession func() *gotgproto.Client
+
+// UseUserSession has restricted media stored through the client session
+// returns, see storeRestricted.
+func UseUserSession(session func() *gotgproto.Client) {
+	userSession = session
+}
+
+// restriction returns why the file in a message can't be forwarded to a
+// storage channel, or nil if it can.
+func restriction(message *tg.Message) error {
+	if message.Noforwards {
+		return utils.ErrProtectedContent
+	}
+	if _, err := utils.FileFromMedia(message.Media); errors.Is(err, utils.ErrPaidMedia) {
+		return err
+	}
+	return nil
+}
+
+// storeRestricted stores the file of a paid or protected message. The bot
+// can't, but with RESTRICTED_MEDIA=userbot the user session is used for
+// messages forwarded from channels it is in, if it bought their media. The
+// file is downloaded through the session and uploaded to the storage
+// channel again, as the original can't be forwarded.
+func storeRestricted(ctx *ext.Context, message *tg.Message, restricted error) (*storedFile, error) {
+	var client *gotgproto.Client
+	if userSession != nil {
+		client = userSession()
+	}
+	if config.ValueOf.RestrictedMedia != restrictedViaUserbot || client == nil {
+		return nil, restricted
+	}
+	var channelID int64
+	var postID int
+	if header, ok := message.GetFwdFrom(); ok {
+		if channel, ok := header.FromID.(*tg.PeerChannel); ok {
+			channelID, postID = channel.ChannelID, header.ChannelPost
+		}
+	}
+	if channelID == 0 || postID == 0 {
+		return nil, fmt.Errorf("%w, and only posts forwarded from channels can be saved", restricted)
+	}
+	original, err := utils.GetTGMessage(ctx, client, channelID, postID)
+	if err != nil {
+		return nil, fmt.Errorf("%w, and the original post can't be read: %s", restricted, err.Error())
+	}
+	file, err := utils.FileFromMedia(original.Media)
+	if errors.Is(err, utils.ErrPaidMedia) {
+		return nil, errors.New("this is paid media that wasn't bought for this bot, so it can't be saved")
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload := uploads.Create(file.FileName)
+	err = upload.Run(ctx, client.API(), client.PeerStorage, download(ctx, client, file.Location, file.FileSize), file.FileSize, file.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	progress := upload.Progress()
+	entry, err := store.GetStore().GetFile(progress.ChannelID, progress.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	stored := storedFileFromEntry(entry)
+	stored.File = file
+	return stored, nil
+}
+
+// download reads a file through client as it's consumed.
+func download(ctx context.Context, client *gotgproto.Client, location tg.InputFileLocationClass, size int64) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		for offset := int64(0); offset < size; offset += utils.MaxChunkSize {
+			chunk, err := utils.FetchChunk(ctx, client, location, offset, utils.MaxChunkSize)
+			if err == nil && len(chunk) == 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil {
+				_, err = writer.Write(chunk)
+			}
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.Close()
+	}()
+	return reader
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadRevoke(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("revoke")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("revocable", revocable))
+	dispatcher.AddHandler(handlers.NewCommand("revoke", revoke))
+}
+
+// revocable replies with a link that works until it's revoked with /revoke,
+// without affecting the other links to the file.
+func revocable(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if !ok || replyTo.ReplyToMsgID == 0 {
+		ctx.Reply(u, "Reply to a file with /revocable to get a link you can revoke later with /revoke.", nil)
+		return dispatcher.EndGroups
+	}
+	stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	id, err := utils.NewLinkID()
+	if err == nil {
+		err = store.GetStore().SaveLink(&store.Link{ID: id, MessageID: stored.MessageID, CreatedBy: chatId})
+	}
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain("This link works until you send "),
+		styling.Code("/revoke " + id),
+		styling.Plain("\n\n"),
+		styling.Code(stored.RevocableLink(id)),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// revoke stops a revocable, one-time or geo-fenced link from working, given
+// the link or its ID. Only whoever created the link and the owner can revoke
+// it.
+func revoke(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		ctx.Reply(u, "Send /revoke <link> to stop a link from /revocable, /once or /geo from working.", nil)
+		return dispatcher.EndGroups
+	}
+	id := utils.LinkRecordID(args[1])
+	link, err := store.GetStore().GetLink(id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && link.CreatedBy != chatId && chatId != config.ValueOf.OwnerID) {
+		ctx.Reply(u, "No such link.", nil)
+		return dispatcher.EndGroups
+	}
+	if err == nil && link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		err = store.GetStore().SaveLink(link)
+	}
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, "The link was revoked.", nil)
+	return dispatcher.EndGroups
+}
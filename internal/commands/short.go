@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/shortlink"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+func (m *command) LoadShort(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("short")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("short", short))
+}
+
+// short replies with a short link to a file's stream link, or to a link of
+// the bot like the ones of /once or /geo, under a custom slug if one is
+// given.
+func short(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)[1:]
+	var link string
+	var messageID int
+	if len(args) > 0 && strings.HasPrefix(args[0], config.ValueOf.Host+"/") {
+		link, args = args[0], args[1:]
+		messageID = linkMessageID(link)
+	} else if replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader); ok && replyTo.ReplyToMsgID != 0 {
+		stored, err := storeMessage(ctx, chatId, replyTo.ReplyToMsgID)
+		if err != nil {
+			utils.Logger.Sugar().Error(err)
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+		link, messageID = stored.url("stream"), stored.MessageID
+	} else {
+		ctx.Reply(u, "Reply to a file with /short to get a short link to it, or send /short <link> to shorten a link of the bot. Add a slug like /short my-video to choose the end of the link.", nil)
+		return dispatcher.EndGroups
+	}
+	var slug string
+	if len(args) > 0 {
+		slug = args[0]
+	}
+	created, err := shortlink.Create(link, messageID, chatId, slug)
+	if errors.Is(err, shortlink.ErrSlugTaken) {
+		ctx.Reply(u, "That slug is already taken, try another one.", nil)
+		return dispatcher.EndGroups
+	}
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Code(shortlink.Link(created.Slug)),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// linkMessageID returns the message ID in a link of the bot, like
+// /stream/<message id>, or 0 when it has none.
+func linkMessageID(link string) int {
+	u, err := url.Parse(link)
+	if err != nil {
+		return 0
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	id, _ := strconv.Atoi(parts[len(parts)-1])
+	return id
+}
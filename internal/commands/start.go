@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"strings"
+
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/utils"
 
@@ -26,6 +28,9 @@ func start(ctx *ext.Context, u *ext.Update) error {
 		ctx.Reply(u, "You are not allowed to use this bot.", nil)
 		return dispatcher.EndGroups
 	}
+	if args := strings.Fields(u.EffectiveMessage.Text); len(args) > 1 && strings.HasPrefix(args[1], groupLinkPrefix) {
+		return sendMemberLink(ctx, u, chatId, strings.TrimPrefix(args[1], groupLinkPrefix))
+	}
 	ctx.Reply(u, "Hi, send me any file to get a direct streamble link to that file.", nil)
 	return dispatcher.EndGroups
 }
@@ -1,11 +1,22 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/objects"
+	"EverythingSuckz/fsb/internal/patterns"
+	"EverythingSuckz/fsb/internal/plugins"
+	"EverythingSuckz/fsb/internal/probe"
+	"EverythingSuckz/fsb/internal/store"
+	fsbtypes "EverythingSuckz/fsb/internal/types"
 	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/plugin"
 
 	"github.com/celestix/gotgproto/dispatcher"
 	"github.com/celestix/gotgproto/dispatcher/handlers"
@@ -14,13 +25,17 @@ import (
 	"github.com/celestix/gotgproto/types"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
 )
 
 func (m *command) LoadStream(dispatcher dispatcher.Dispatcher) {
 	log := m.log.Named("start")
 	defer log.Sugar().Info("Loaded")
-	dispatcher.AddHandler(
+	// media messages are handled in a later group so that every command
+	// handler gets a chance to match first
+	dispatcher.AddHandlerToGroup(
 		handlers.NewMessage(nil, sendLink),
+		1,
 	)
 }
 
@@ -33,6 +48,11 @@ func supportedMediaFilter(m *types.Message) (bool, error) {
 		return true, nil
 	case *tg.MessageMediaPhoto:
 		return true, nil
+	case *tg.MessageMediaInvoice:
+		// invoices with media are paid media, which sendLink explains
+		// when it can't be saved
+		_, paid := m.Media.(*tg.MessageMediaInvoice).GetExtendedMedia()
+		return paid, nil
 	case tg.MessageMediaClass:
 		return false, dispatcher.EndGroups
 	default:
@@ -43,6 +63,9 @@ func supportedMediaFilter(m *types.Message) (bool, error) {
 func sendLink(ctx *ext.Context, u *ext.Update) error {
 	chatId := u.EffectiveChat().GetID()
 	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type == int(storage.TypeChannel) {
+		return sendGroupLink(ctx, u, chatId)
+	}
 	if peerChatId.Type != int(storage.TypeUser) {
 		return dispatcher.EndGroups
 	}
@@ -58,33 +81,36 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 		ctx.Reply(u, "Sorry, this message type is unsupported.", nil)
 		return dispatcher.EndGroups
 	}
-	update, err := utils.ForwardMessages(ctx, chatId, config.ValueOf.LogChannelID, u.EffectiveMessage.ID)
-	if err != nil {
-		utils.Logger.Sugar().Error(err)
-		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
-		return dispatcher.EndGroups
+	if media, err := utils.FileFromMedia(u.EffectiveMessage.Media); err == nil && utils.IsSubtitle(media.FileName) {
+		if video := subtitleTarget(u, chatId); video != nil {
+			return sendSubtitle(ctx, u, chatId, video)
+		}
+	}
+	var stored *storedFile
+	if restricted := restriction(u.EffectiveMessage.Message); restricted != nil {
+		stored, err = storeRestricted(ctx, u.EffectiveMessage.Message, restricted)
+	} else {
+		stored, err = storeMessage(ctx, chatId, u.EffectiveMessage.ID)
 	}
-	messageID := update.Updates[0].(*tg.UpdateMessageID).ID
-	doc := update.Updates[1].(*tg.UpdateNewChannelMessage).Message.(*tg.Message).Media
-	file, err := utils.FileFromMedia(doc)
 	if err != nil {
+		utils.Logger.Sugar().Error(err)
 		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
 		return dispatcher.EndGroups
 	}
-	fullHash := utils.PackFile(
-		file.FileName,
-		file.FileSize,
-		file.MimeType,
-		file.ID,
-	)
-	hash := utils.GetShortHash(fullHash)
-	link := fmt.Sprintf("%s/stream/%d?hash=%s", config.ValueOf.Host, messageID, hash)
+	file := stored.File
+	link := stored.ShareLink(file.FileName, file.MimeType, nil)
+	linkCreated(stored.ChannelID, stored.MessageID, file.FileName, file.FileSize, file.MimeType, chatId, link)
 	text := []styling.StyledTextOption{styling.Code(link)}
+	if config.ValueOf.CLICommands {
+		for _, command := range utils.DownloadCommands(downloadLink(link), file.FileName) {
+			text = append(text, styling.Plain("\n\n"), styling.Code(command))
+		}
+	}
 	row := tg.KeyboardButtonRow{
 		Buttons: []tg.KeyboardButtonClass{
 			&tg.KeyboardButtonURL{
 				Text: "Download",
-				URL:  link + "&d=true",
+				URL:  downloadLink(link),
 			},
 		},
 	}
@@ -94,6 +120,12 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 			URL:  link,
 		})
 	}
+	if strings.Contains(file.MimeType, "video") {
+		row.Buttons = append(row.Buttons, &tg.KeyboardButtonURL{
+			Text: "Player",
+			URL:  stored.PlayerLink(),
+		})
+	}
 	markup := &tg.ReplyInlineMarkup{
 		Rows: []tg.KeyboardButtonRow{row},
 	}
@@ -115,3 +147,244 @@ func sendLink(ctx *ext.Context, u *ext.Update) error {
 	}
 	return dispatcher.EndGroups
 }
+
+type storedFile struct {
+	ChannelID int64
+	MessageID int
+	File      *fsbtypes.File
+	Hash      string
+	// FullHash is the unshortened hash, which bound links are derived from.
+	FullHash string
+}
+
+func storedFileFromEntry(entry *store.FileEntry) *storedFile {
+	fullHash := utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt)
+	return &storedFile{
+		ChannelID: entry.ChannelID,
+		MessageID: entry.MessageID,
+		Hash:      utils.GetShortHash(fullHash),
+		FullHash:  fullHash,
+	}
+}
+
+// Link returns the stream link of the stored file.
+func (s *storedFile) Link() string {
+	return s.url("stream")
+}
+
+// ShareLink returns the link handed out for the stored file: its link on
+// the first of URL_PATTERNS it matches, or its stream link.
+func (s *storedFile) ShareLink(fileName string, mimeType string, tags []string) string {
+	if link := patterns.Link(s.ChannelID, s.MessageID, s.Hash, fileName, mimeType, tags); link != "" {
+		return link
+	}
+	return s.Link()
+}
+
+// linkCreated tells the plugins a link to a stored file was sent to a user.
+func linkCreated(channelID int64, messageID int, fileName string, fileSize int64, mimeType string, userID int64, link string) {
+	plugins.LinkCreated(plugin.LinkEvent{
+		ChannelID: channelID,
+		MessageID: messageID,
+		FileName:  fileName,
+		FileSize:  fileSize,
+		MimeType:  mimeType,
+		UserID:    userID,
+		Link:      link,
+	})
+}
+
+// downloadLink asks for the file at link to be downloaded instead of shown
+// in the browser.
+func downloadLink(link string) string {
+	if strings.Contains(link, "?") {
+		return link + "&d=true"
+	}
+	return link + "?d=true"
+}
+
+// PlayerLink returns the link to the web player, which also loads the
+// file's subtitle if one was sent for it.
+func (s *storedFile) PlayerLink() string {
+	return s.url("player")
+}
+
+// BoundLink returns a stream link that only works from the addresses in
+// prefix. Its hash is derived from the file's, so the binding can't be
+// removed by dropping it from the link.
+func (s *storedFile) BoundLink(prefix string) string {
+	return utils.BoundFileLink(s.ChannelID, s.MessageID, s.FullHash, prefix)
+}
+
+// EmbedLink returns a stream link that only plays when embedded on origin,
+// with its hash derived from the file's like bound links.
+func (s *storedFile) EmbedLink(origin string) string {
+	return utils.EmbedFileLink(s.ChannelID, s.MessageID, s.FullHash, origin)
+}
+
+// PreviewLink returns a stream link that only serves the first part of the
+// file, with its hash derived from the file's like bound links.
+func (s *storedFile) PreviewLink(limit utils.Preview) string {
+	return utils.PreviewFileLink(s.ChannelID, s.MessageID, s.FullHash, limit)
+}
+
+// OnceLink returns a stream link that only works for the first download
+// session that uses it, the one-time link with the given ID.
+func (s *storedFile) OnceLink(id string) string {
+	return utils.OnceFileLink(s.ChannelID, s.MessageID, s.FullHash, id)
+}
+
+// ExpiringLink returns a stream link that stops working at expires, with
+// its hash derived from the file's like bound links.
+func (s *storedFile) ExpiringLink(expires time.Time) string {
+	return utils.ExpiringFileLink(s.ChannelID, s.MessageID, s.FullHash, expires)
+}
+
+// GeoLink returns a stream link that only works in the countries of the
+// geo-fenced link saved under id.
+func (s *storedFile) GeoLink(id string) string {
+	return utils.GeoFileLink(s.ChannelID, s.MessageID, s.FullHash, id)
+}
+
+// RevocableLink returns a stream link that works until the link saved under
+// id is revoked.
+func (s *storedFile) RevocableLink(id string) string {
+	return utils.RevocableFileLink(s.ChannelID, s.MessageID, s.FullHash, id)
+}
+
+func (s *storedFile) url(route string) string {
+	return utils.FileLink(route, s.ChannelID, s.MessageID, s.Hash)
+}
+
+// storeMessage stores the file in a message according to UPLOAD_TARGET and
+// returns the stored copy along with its file and short hash. Files are
+// forwarded to the current storage channel unless they only go to the
+// object store.
+func storeMessage(ctx *ext.Context, chatId int64, messageID int) (*storedFile, error) {
+	if config.ValueOf.UploadTarget == store.BackendS3 {
+		return storeObject(ctx, chatId, messageID)
+	}
+	return forwardToChannel(ctx, chatId, channels.Current(), messageID, chatId)
+}
+
+// forwardToChannel forwards the file in a message to a storage channel and
+// indexes it as uploaded by uploadedBy.
+func forwardToChannel(ctx *ext.Context, chatId int64, channelID int64, messageID int, uploadedBy int64) (*storedFile, error) {
+	update, err := utils.ForwardMessages(ctx, chatId, channelID, messageID)
+	if tg.IsChatForwardsRestricted(err) {
+		return nil, utils.ErrProtectedContent
+	}
+	if err != nil {
+		return nil, err
+	}
+	storedID := update.Updates[0].(*tg.UpdateMessageID).ID
+	doc := update.Updates[1].(*tg.UpdateNewChannelMessage).Message.(*tg.Message).Media
+	file, err := utils.FileFromMedia(doc)
+	if err != nil {
+		return nil, err
+	}
+	fullHash := utils.PackFile(
+		file.FileName,
+		file.FileSize,
+		file.MimeType,
+		file.ID,
+	)
+	entry := &store.FileEntry{
+		ChannelID:       channelID,
+		MessageID:       storedID,
+		FileName:        file.FileName,
+		FileSize:        file.FileSize,
+		MimeType:        file.MimeType,
+		FileID:          file.ID,
+		UploadedBy:      uploadedBy,
+		SourceMessageID: messageID,
+		Backend:         store.BackendTelegram,
+		MediaInfo:       store.MediaInfo{Duration: file.Duration, Width: file.Width, Height: file.Height},
+	}
+	err = store.GetStore().IndexFile(entry)
+	if err != nil {
+		utils.Logger.Warn("Failed to index file", zap.Int("messageID", storedID), zap.Error(err))
+	} else {
+		probe.Enqueue(*entry)
+		plugins.UploadStored(entry)
+	}
+	if config.ValueOf.UploadTarget == store.BackendBoth {
+		go copyToObjectStore(ctx.Raw, channelID, storedID, file)
+	}
+	store.GetStore().IncrStat("links", 1)
+	return &storedFile{
+		ChannelID: channelID,
+		MessageID: storedID,
+		File:      file,
+		Hash:      utils.GetShortHash(fullHash),
+		FullHash:  fullHash,
+	}, nil
+}
+
+// copyToObjectStore uploads a file that was stored in a channel to the
+// object store as well. It is served from Telegram until the copy is done.
+// Only the object is recorded on the entry then, as it may have been
+// changed, moved or deleted during the upload.
+func copyToObjectStore(api *tg.Client, channelID int64, messageID int, file *fsbtypes.File) {
+	key := objects.Key(channelID, messageID)
+	if err := objects.Upload(context.Background(), api, file, key); err != nil {
+		utils.Logger.Warn("Failed to copy file to the object store", zap.Int("messageID", messageID), zap.Error(err))
+		return
+	}
+	err := store.GetStore().SetObject(channelID, messageID, store.BackendBoth, key)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.Logger.Info("File was removed while copying it to the object store", zap.Int("messageID", messageID), zap.String("key", key))
+	} else if err != nil {
+		utils.Logger.Warn("Failed to index file", zap.Int("messageID", messageID), zap.Error(err))
+	}
+}
+
+// storeObject uploads the file in a message straight to the object store.
+// The file keeps the uploader's chat and message as its ID.
+func storeObject(ctx *ext.Context, chatId int64, messageID int) (*storedFile, error) {
+	messages, err := ctx.GetMessages(chatId, []tg.InputMessageClass{&tg.InputMessageID{ID: messageID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("message %d not found", messageID)
+	}
+	message, ok := messages[0].(*tg.Message)
+	if !ok {
+		return nil, fmt.Errorf("message %d was deleted", messageID)
+	}
+	file, err := utils.FileFromMedia(message.Media)
+	if err != nil {
+		return nil, err
+	}
+	key := objects.Key(chatId, messageID)
+	if err := objects.Upload(ctx, ctx.Raw, file, key); err != nil {
+		return nil, err
+	}
+	entry := &store.FileEntry{
+		ChannelID:       chatId,
+		MessageID:       messageID,
+		FileName:        file.FileName,
+		FileSize:        file.FileSize,
+		MimeType:        file.MimeType,
+		FileID:          file.ID,
+		UploadedBy:      chatId,
+		SourceMessageID: messageID,
+		Backend:         store.BackendS3,
+		ObjectKey:       key,
+		MediaInfo:       store.MediaInfo{Duration: file.Duration, Width: file.Width, Height: file.Height},
+	}
+	if err := store.GetStore().IndexFile(entry); err != nil {
+		return nil, err
+	}
+	plugins.UploadStored(entry)
+	store.GetStore().IncrStat("links", 1)
+	fullHash := utils.PackFile(file.FileName, file.FileSize, file.MimeType, file.ID)
+	return &storedFile{
+		ChannelID: chatId,
+		MessageID: messageID,
+		File:      file,
+		Hash:      utils.GetShortHash(fullHash),
+		FullHash:  fullHash,
+	}, nil
+}
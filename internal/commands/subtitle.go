@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/gotd/td/telegram/message/styling"
+)
+
+// subtitlePairWindow is how long after a video a subtitle sent without a
+// reply is still paired with it.
+const subtitlePairWindow = 5 * time.Minute
+
+// subtitleTarget returns the video a subtitle message belongs to: the video
+// it replies to, or else the user's last video if it was sent recently.
+func subtitleTarget(u *ext.Update, chatId int64) *store.FileEntry {
+	if video, err := repliedFile(u, chatId); err == nil {
+		return video
+	}
+	recent, err := store.GetStore().SearchFiles(store.FileQuery{UploadedBy: chatId, Limit: 1})
+	if err != nil || len(recent) == 0 {
+		return nil
+	}
+	video := recent[0]
+	if !strings.HasPrefix(video.MimeType, "video/") || time.Since(video.CreatedAt) > subtitlePairWindow {
+		return nil
+	}
+	return video
+}
+
+func sendSubtitle(ctx *ext.Context, u *ext.Update, chatId int64, video *store.FileEntry) error {
+	stored, err := storeMessage(ctx, chatId, u.EffectiveMessage.ID)
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	err = store.GetStore().SetSubtitle(&store.Subtitle{
+		ChannelID:         video.ChannelID,
+		MessageID:         video.MessageID,
+		SubtitleChannelID: stored.ChannelID,
+		SubtitleMessageID: stored.MessageID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(fmt.Sprintf("Subtitle added to %s\n\n", video.FileName)),
+		styling.Code(storedFileFromEntry(video).PlayerLink()),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	if err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+	}
+	return dispatcher.EndGroups
+}
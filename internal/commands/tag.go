@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/patterns"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+)
+
+var tagRegex = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+func (m *command) LoadTag(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("tag")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("tag", tagFile))
+	dispatcher.AddHandler(handlers.NewCommand("untag", untagFile))
+	dispatcher.AddHandler(handlers.NewCommand("files", listFiles))
+}
+
+func normalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimPrefix(name, "#"))
+	if !tagRegex.MatchString(name) {
+		return "", errors.New("tags may only contain letters, digits, _ and - (max 32 characters)")
+	}
+	return name, nil
+}
+
+// repliedFile returns the indexed file the command message replies to.
+func repliedFile(u *ext.Update, chatId int64) (*store.FileEntry, error) {
+	replyTo, ok := u.EffectiveMessage.ReplyTo.(*tg.MessageReplyHeader)
+	if !ok || replyTo.ReplyToMsgID == 0 {
+		return nil, errors.New("reply to a file you have sent to the bot")
+	}
+	entry, err := store.GetStore().GetFileBySource(chatId, replyTo.ReplyToMsgID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errors.New("no link was generated for that message")
+	}
+	return entry, err
+}
+
+func tagFile(ctx *ext.Context, u *ext.Update) error {
+	return editTags(ctx, u, true)
+}
+
+func untagFile(ctx *ext.Context, u *ext.Update) error {
+	return editTags(ctx, u, false)
+}
+
+func editTags(ctx *ext.Context, u *ext.Update, add bool) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)
+	if len(args) < 2 {
+		ctx.Reply(u, fmt.Sprintf("Usage: reply to a file with %s <name>", args[0]), nil)
+		return dispatcher.EndGroups
+	}
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	for _, arg := range args[1:] {
+		name, err := normalizeTag(arg)
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+		if add {
+			err = store.GetStore().AddTag(entry.ChannelID, entry.MessageID, name)
+		} else {
+			err = store.GetStore().RemoveTag(entry.ChannelID, entry.MessageID, name)
+		}
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+	}
+	tags, err := store.GetStore().GetTags(entry.ChannelID, entry.MessageID)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if len(tags) == 0 {
+		ctx.Reply(u, fmt.Sprintf("%s has no tags.", entry.FileName), nil)
+	} else {
+		ctx.Reply(u, fmt.Sprintf("Tags of %s: #%s", entry.FileName, strings.Join(tags, " #")), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// listFiles lists the user's own files, optionally filtered by #tag, a name
+// search, type:video or type:audio, and a duration like >1h or <10m.
+func listFiles(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	query := store.FileQuery{UploadedBy: chatId, Limit: 20}
+	var words []string
+	for _, arg := range strings.Fields(u.EffectiveMessage.Text)[1:] {
+		if strings.HasPrefix(arg, ">") || strings.HasPrefix(arg, "<") {
+			duration, err := time.ParseDuration(arg[1:])
+			if err != nil {
+				ctx.Reply(u, fmt.Sprintf("Error - invalid duration %s, use something like >1h or <90s", arg), nil)
+				return dispatcher.EndGroups
+			}
+			if arg[0] == '>' {
+				query.MinDuration = duration
+			} else {
+				query.MaxDuration = duration
+			}
+			continue
+		}
+		if kind, ok := strings.CutPrefix(arg, "type:"); ok {
+			query.MimeType = kind + "/"
+			continue
+		}
+		if strings.HasPrefix(arg, "#") {
+			tag, err := normalizeTag(arg)
+			if err != nil {
+				ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+				return dispatcher.EndGroups
+			}
+			query.Tag = tag
+			continue
+		}
+		words = append(words, arg)
+	}
+	query.Text = strings.Join(words, " ")
+	entries, err := store.GetStore().SearchFiles(query)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if len(entries) == 0 {
+		ctx.Reply(u, "No files found.", nil)
+		return dispatcher.EndGroups
+	}
+	var text []styling.StyledTextOption
+	for _, entry := range entries {
+		var tags []string
+		if patterns.Enabled() {
+			tags, _ = store.GetStore().GetTags(entry.ChannelID, entry.MessageID)
+		}
+		text = append(text,
+			styling.Bold(entry.FileName),
+			styling.Plain("\n"),
+			styling.Code(storedFileFromEntry(entry).ShareLink(entry.FileName, entry.MimeType, tags)),
+			styling.Plain("\n\n"),
+		)
+	}
+	ctx.Reply(u, text, &ext.ReplyOpts{NoWebpage: true})
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/throttle"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+)
+
+const throttleUsage = "Reply to a file with /throttle <rate> like /throttle 2MB to cap how fast its links are served, /throttle off to never cap them or /throttle default to go back to the default rate."
+
+func (m *command) LoadThrottle(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("throttle")
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("throttle", throttleFile))
+}
+
+// throttleFile caps how fast the links to the file the message replies to
+// are served, in total for everyone downloading it. The owner can also set
+// the default rate with /throttle default <rate|off>.
+func throttleFile(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	args := strings.Fields(u.EffectiveMessage.Text)[1:]
+	if len(args) == 2 && args[0] == "default" {
+		return throttleDefault(ctx, u, chatId, args[1])
+	}
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if len(args) != 1 {
+		ctx.Reply(u, throttleUsage, nil)
+		return dispatcher.EndGroups
+	}
+	var rate int64
+	switch args[0] {
+	case "default":
+	case "off":
+		rate = throttle.Unlimited
+	default:
+		rate, err = throttle.ParseRate(args[0])
+		if err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+	}
+	if err := store.GetStore().SetRateLimit(entry.ChannelID, entry.MessageID, rate); err != nil {
+		utils.Logger.Sugar().Error(err)
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("The links to %s are now %s.", entry.FileName, describeRate(throttle.Rate(rate))), nil)
+	return dispatcher.EndGroups
+}
+
+// throttleDefault sets the rate files without one of their own are served
+// at, until the bot restarts. Only the owner can change it.
+func throttleDefault(ctx *ext.Context, u *ext.Update, chatId int64, value string) error {
+	if config.ValueOf.OwnerID == 0 || chatId != config.ValueOf.OwnerID {
+		ctx.Reply(u, "Only the owner can change the default rate.", nil)
+		return dispatcher.EndGroups
+	}
+	var rate int64
+	if value != "off" {
+		var err error
+		if rate, err = throttle.ParseRate(value); err != nil {
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+	}
+	throttle.SetDefault(rate)
+	ctx.Reply(u, fmt.Sprintf("Links without a rate of their own are now %s until the bot restarts. Set LINK_RATE_LIMIT_KB to keep it.", describeRate(rate)), nil)
+	return dispatcher.EndGroups
+}
+
+func describeRate(rate int64) string {
+	if rate <= 0 {
+		return "served as fast as they can be"
+	}
+	return "capped at " + throttle.FormatRate(rate)
+}
@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	fsbtypes "EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/whisper"
+
+	"github.com/celestix/gotgproto/dispatcher"
+	"github.com/celestix/gotgproto/dispatcher/handlers"
+	"github.com/celestix/gotgproto/ext"
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxTranscribeSize is the largest file sent for transcription, the
+	// limit of OpenAI's endpoint.
+	maxTranscribeSize = 25 << 20
+	// transcribeTimeout caps how long a transcription may take.
+	transcribeTimeout = 10 * time.Minute
+	// maxTranscriptLength is how much of a transcript is sent as a message,
+	// in characters, leaving room for the message's other text.
+	maxTranscriptLength = 3500
+)
+
+var transcriber *whisper.Client
+
+func (m *command) LoadTranscribe(dispatcher dispatcher.Dispatcher) {
+	log := m.log.Named("transcribe")
+	if config.ValueOf.WhisperURL == "" {
+		log.Info("WHISPER_URL not set, /transcribe disabled")
+		return
+	}
+	var err error
+	transcriber, err = whisper.New(whisper.Options{
+		URL:    config.ValueOf.WhisperURL,
+		APIKey: config.ValueOf.WhisperAPIKey,
+		Model:  config.ValueOf.WhisperModel,
+	})
+	if err != nil {
+		log.Fatal("Invalid WHISPER_URL", zap.Error(err))
+	}
+	defer log.Sugar().Info("Loaded")
+	dispatcher.AddHandler(handlers.NewCommand("transcribe", transcribeFile))
+}
+
+// transcribeFile transcribes the audio or video the message replies to in
+// the background. The transcript is sent back as a message and as a WebVTT
+// file, which is attached to the file's player as a subtitle.
+func transcribeFile(ctx *ext.Context, u *ext.Update) error {
+	chatId := u.EffectiveChat().GetID()
+	peerChatId := ctx.PeerStorage.GetPeerById(chatId)
+	if peerChatId.Type != int(storage.TypeUser) {
+		return dispatcher.EndGroups
+	}
+	if len(config.ValueOf.AllowedUsers) != 0 && !utils.Contains(config.ValueOf.AllowedUsers, chatId) {
+		ctx.Reply(u, "You are not allowed to use this bot.", nil)
+		return dispatcher.EndGroups
+	}
+	entry, err := repliedFile(u, chatId)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if !strings.HasPrefix(entry.MimeType, "audio/") && !strings.HasPrefix(entry.MimeType, "video/") {
+		ctx.Reply(u, "Error - only audio and video files can be transcribed", nil)
+		return dispatcher.EndGroups
+	}
+	if entry.FileSize > maxTranscribeSize {
+		ctx.Reply(u, fmt.Sprintf("Error - files can be at most %d MB to be transcribed", maxTranscribeSize>>20), nil)
+		return dispatcher.EndGroups
+	}
+	messages, err := ctx.GetMessages(chatId, []tg.InputMessageClass{&tg.InputMessageID{ID: entry.SourceMessageID}})
+	if err != nil || len(messages) == 0 {
+		ctx.Reply(u, "Error - the file's message was deleted", nil)
+		return dispatcher.EndGroups
+	}
+	message, ok := messages[0].(*tg.Message)
+	if !ok {
+		ctx.Reply(u, "Error - the file's message was deleted", nil)
+		return dispatcher.EndGroups
+	}
+	file, err := utils.FileFromMedia(message.Media)
+	if err != nil {
+		ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(u, fmt.Sprintf("Transcribing %s, this can take a few minutes.", entry.FileName), nil)
+	go func() {
+		if err := transcribe(ctx, u, chatId, entry, file); err != nil {
+			utils.Logger.Warn("Failed to transcribe file", zap.Int("messageID", entry.MessageID), zap.Error(err))
+			ctx.Reply(u, fmt.Sprintf("Error - %s", err.Error()), nil)
+		}
+	}()
+	return dispatcher.EndGroups
+}
+
+func transcribe(ctx *ext.Context, u *ext.Update, chatId int64, entry *store.FileEntry, file *fsbtypes.File) error {
+	jobCtx, cancel := context.WithTimeout(context.Background(), transcribeTimeout)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	go func() {
+		for offset := int64(0); offset < file.FileSize; offset += utils.MaxChunkSize {
+			chunk, err := utils.FetchChunkWith(jobCtx, ctx.Raw, file.Location, offset, utils.MaxChunkSize)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(chunk); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	vtt, err := transcriber.Transcribe(jobCtx, entry.FileName, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+
+	vttName := strings.TrimSuffix(entry.FileName, path.Ext(entry.FileName)) + ".vtt"
+	upload, err := uploader.NewUploader(ctx.Raw).FromBytes(jobCtx, vttName, vtt)
+	if err != nil {
+		return err
+	}
+	sent, err := ctx.SendMedia(chatId, &tg.MessagesSendMediaRequest{
+		Media: &tg.InputMediaUploadedDocument{
+			File:       upload,
+			MimeType:   "text/vtt",
+			Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeFilename{FileName: vttName}},
+			ForceFile:  true,
+		},
+		ReplyTo: &tg.InputReplyToMessage{ReplyToMsgID: u.EffectiveMessage.ID},
+	})
+	if err != nil {
+		return err
+	}
+	stored, err := storeMessage(ctx, chatId, sent.ID)
+	if err != nil {
+		return err
+	}
+	err = store.GetStore().SetSubtitle(&store.Subtitle{
+		ChannelID:         entry.ChannelID,
+		MessageID:         entry.MessageID,
+		SubtitleChannelID: stored.ChannelID,
+		SubtitleMessageID: stored.MessageID,
+	})
+	if err != nil {
+		return err
+	}
+	store.GetStore().IncrStat("transcriptions", 1)
+
+	transcript := []rune(utils.VTTText(vtt))
+	if len(transcript) > maxTranscriptLength {
+		transcript = append(transcript[:maxTranscriptLength], '…')
+	}
+	if len(transcript) == 0 {
+		transcript = []rune("(no speech was recognized)")
+	}
+	_, err = ctx.Reply(u, []styling.StyledTextOption{
+		styling.Plain(fmt.Sprintf("Transcript of %s:\n\n%s\n\nWatch it with subtitles: ", entry.FileName, string(transcript))),
+		styling.Code(storedFileFromEntry(entry).PlayerLink()),
+	}, &ext.ReplyOpts{
+		NoWebpage:        true,
+		ReplyToMessageId: u.EffectiveMessage.ID,
+	})
+	return err
+}
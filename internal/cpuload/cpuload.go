@@ -0,0 +1,43 @@
+// Package cpuload samples how busy the process keeps the CPUs it may use,
+// so work that can be done cheaper, like compressing responses, backs off
+// before the streams themselves slow down.
+package cpuload
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// interval is how often the load is sampled.
+const interval = 5 * time.Second
+
+var (
+	once sync.Once
+	// load is the last sample, as the bits of a float64.
+	load atomic.Uint64
+)
+
+// Load returns the share of the CPU time of GOMAXPROCS CPUs the process
+// used over the last few seconds, from 0 to 1. Sampling starts with the
+// first call, which returns 0.
+func Load() float64 {
+	once.Do(func() { go sample() })
+	return math.Float64frombits(load.Load())
+}
+
+func sample() {
+	lastCPU, lastTime := processTime(), time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		cpu := processTime()
+		available := float64(now.Sub(lastTime)) * float64(runtime.GOMAXPROCS(0))
+		if available > 0 {
+			load.Store(math.Float64bits(max(0, min(float64(cpu-lastCPU)/available, 1))))
+		}
+		lastCPU, lastTime = cpu, now
+	}
+}
@@ -0,0 +1,23 @@
+//go:build windows
+
+package cpuload
+
+import (
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// processTime returns the user and kernel CPU time the process used.
+func processTime() time.Duration {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(windows.CurrentProcess(), &creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	return filetimeDuration(kernel) + filetimeDuration(user)
+}
+
+// filetimeDuration reads a Filetime holding a duration in 100ns units.
+func filetimeDuration(ft windows.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
@@ -0,0 +1,141 @@
+// Package dedupe finds files that were stored more than once in the storage
+// channels, and removes the extra copies to reclaim their space. Links to a
+// removed copy keep working, as they are remapped to the copy that is kept.
+package dedupe
+
+import (
+	"EverythingSuckz/fsb/internal/evict"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"fmt"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+)
+
+// deleteBatch is the most messages Telegram deletes in one call.
+const deleteBatch = 100
+
+// Group is a file stored more than once. Kept is the oldest copy, which
+// stays, and Copies are the ones Remove deletes.
+type Group struct {
+	FileID   int64              `json:"file_id"`
+	FileSize int64              `json:"file_size"`
+	Kept     *store.FileEntry   `json:"kept"`
+	Copies   []*store.FileEntry `json:"copies"`
+}
+
+// Report lists the files stored more than once, and how many bytes removing
+// their extra copies would reclaim.
+type Report struct {
+	Groups           []Group `json:"groups"`
+	Copies           int     `json:"copies"`
+	ReclaimableBytes int64   `json:"reclaimable_bytes"`
+}
+
+// Result is what Remove did.
+type Result struct {
+	Removed        int   `json:"removed"`
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+}
+
+// Find reports the files in the index with the same size and Telegram file
+// ID. Only copies kept in Telegram alone are counted, as removing a copy
+// only deletes its message, and only the ones whose links the kept copy
+// would check the same way.
+func Find() (*Report, error) {
+	duplicates, err := store.GetStore().DuplicateFiles()
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Groups: []Group{}}
+	for _, entries := range duplicates {
+		group := Group{FileID: entries[0].FileID, FileSize: entries[0].FileSize, Kept: entries[0]}
+		for _, entry := range entries[1:] {
+			if entry.Backend == store.BackendTelegram && sameLinks(entry, entries[0]) {
+				group.Copies = append(group.Copies, entry)
+			}
+		}
+		if len(group.Copies) == 0 {
+			continue
+		}
+		report.Groups = append(report.Groups, group)
+		report.Copies += len(group.Copies)
+		report.ReclaimableBytes += int64(len(group.Copies)) * group.FileSize
+	}
+	return report, nil
+}
+
+// sameLinks reports whether the links of copy work the same once they point
+// at kept: they have the same hash, so rotating kept revokes them too, the
+// same password, and count towards the same uploader's quota.
+func sameLinks(copy *store.FileEntry, kept *store.FileEntry) bool {
+	return fullHash(copy) == fullHash(kept) &&
+		copy.PasswordHash == kept.PasswordHash &&
+		copy.UploadedBy == kept.UploadedBy
+}
+
+func fullHash(entry *store.FileEntry) string {
+	return utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt)
+}
+
+// Remove deletes the extra copies in the report from their storage channels
+// and the index through api, remapping their links to the copy that is kept.
+// It stops at the first channel the bot can't delete messages from,
+// returning what was removed until then.
+func Remove(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, report *Report) (*Result, error) {
+	byChannel := make(map[int64][]*store.FileEntry)
+	kept := make(map[*store.FileEntry]*store.FileEntry)
+	for _, group := range report.Groups {
+		for _, entry := range group.Copies {
+			byChannel[entry.ChannelID] = append(byChannel[entry.ChannelID], entry)
+			kept[entry] = group.Kept
+		}
+	}
+	result := &Result{}
+	for channelID, entries := range byChannel {
+		for start := 0; start < len(entries); start += deleteBatch {
+			batch := entries[start:min(start+deleteBatch, len(entries))]
+			for _, entry := range batch {
+				// the alias is saved first, so links never point nowhere. Its
+				// links are checked against the kept copy, which has the
+				// same hash
+				err := store.GetStore().SetAlias(&store.FileAlias{
+					ChannelID:       entry.ChannelID,
+					MessageID:       entry.MessageID,
+					TargetChannelID: kept[entry].ChannelID,
+					TargetMessageID: kept[entry].MessageID,
+				})
+				if err != nil {
+					return result, err
+				}
+			}
+			if err := deleteMessages(ctx, api, peerStorage, channelID, batch); err != nil {
+				return result, fmt.Errorf("failed to delete messages in channel %d: %w", channelID, err)
+			}
+			for _, entry := range batch {
+				if err := store.GetStore().DeleteFile(entry.ChannelID, entry.MessageID); err != nil {
+					return result, err
+				}
+				evict.Publish(evict.Event{ChannelID: entry.ChannelID, MessageID: entry.MessageID, Reason: evict.Deleted})
+				result.Removed++
+				result.ReclaimedBytes += entry.FileSize
+			}
+		}
+	}
+	return result, nil
+}
+
+func deleteMessages(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, channelID int64, entries []*store.FileEntry) error {
+	channel, err := utils.GetChannelPeer(ctx, api, peerStorage, channelID)
+	if err != nil {
+		return err
+	}
+	ids := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.MessageID)
+	}
+	_, err = api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{Channel: channel, ID: ids})
+	return err
+}
@@ -0,0 +1,311 @@
+// Package diskcache keeps the chunks streamed from Telegram on local disk,
+// so files that are streamed over and over are served from disk rather than
+// fetched again every time. The cache is bounded by DISK_CACHE_MB and drops
+// the least recently read chunks first. Every chunk is stored with its
+// SHA-256, and chunks that don't match it are dropped instead of served.
+package diskcache
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/evict"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type chunkKey struct {
+	channelID int64
+	messageID int
+	offset    int64
+	limit     int64
+}
+
+type chunk struct {
+	key  chunkKey
+	size int64
+}
+
+// Stats are the counters of the cache since startup.
+type Stats struct {
+	Hits    int64
+	Misses  int64
+	Evicted int64
+	Corrupt int64
+	Size    int64
+}
+
+var (
+	log  *zap.Logger
+	root string
+
+	mu     sync.Mutex
+	lru    = list.New()
+	chunks = make(map[chunkKey]*list.Element)
+	size   int64
+
+	hits, misses, evicted, corrupt atomic.Int64
+)
+
+// Init loads the chunks cached in DISK_CACHE_PATH by earlier runs. The cache
+// stays disabled when DISK_CACHE_MB is 0.
+func Init(logger *zap.Logger) error {
+	log = logger.Named("DiskCache")
+	if config.ValueOf.DiskCacheMB <= 0 {
+		return nil
+	}
+	root = config.ValueOf.DiskCachePath
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return err
+	}
+	if err := load(); err != nil {
+		return err
+	}
+	evict.Subscribe(func(event evict.Event) {
+		dropFile(event.ChannelID, event.MessageID)
+	})
+	log.Info("Loaded disk cache",
+		zap.String("path", root),
+		zap.Int("chunks", len(chunks)),
+		zap.Int64("bytes", size))
+	return nil
+}
+
+// Enabled reports whether DISK_CACHE_MB is set.
+func Enabled() bool {
+	return root != ""
+}
+
+// load indexes the chunks on disk, the most recently read last.
+func load() error {
+	type found struct {
+		key     chunkKey
+		size    int64
+		modTime time.Time
+	}
+	var all []found
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		key, ok := parsePath(path)
+		if !ok {
+			// left behind by a write that didn't finish
+			os.Remove(path)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		all = append(all, found{key, info.Size(), info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].modTime.Before(all[j].modTime) })
+	mu.Lock()
+	defer mu.Unlock()
+	for _, f := range all {
+		chunks[f.key] = lru.PushFront(&chunk{f.key, f.size})
+		size += f.size
+	}
+	shrink()
+	return nil
+}
+
+func chunkPath(key chunkKey) string {
+	return filepath.Join(root,
+		strconv.FormatInt(key.channelID, 10),
+		strconv.Itoa(key.messageID),
+		fmt.Sprintf("%d-%d", key.offset, key.limit))
+}
+
+func parsePath(path string) (chunkKey, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return chunkKey{}, false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 3 {
+		return chunkKey{}, false
+	}
+	offset, limit, ok := strings.Cut(parts[2], "-")
+	if !ok {
+		return chunkKey{}, false
+	}
+	var key chunkKey
+	var errs [4]error
+	key.channelID, errs[0] = strconv.ParseInt(parts[0], 10, 64)
+	key.messageID, errs[1] = strconv.Atoi(parts[1])
+	key.offset, errs[2] = strconv.ParseInt(offset, 10, 64)
+	key.limit, errs[3] = strconv.ParseInt(limit, 10, 64)
+	for _, err := range errs {
+		if err != nil {
+			return chunkKey{}, false
+		}
+	}
+	return key, true
+}
+
+// Get returns the chunk of a file at offset, if it's cached and intact.
+func Get(channelID int64, messageID int, offset int64, limit int64) ([]byte, bool) {
+	key := chunkKey{channelID, messageID, offset, limit}
+	mu.Lock()
+	element, ok := chunks[key]
+	if ok {
+		lru.MoveToFront(element)
+	}
+	mu.Unlock()
+	if !ok {
+		misses.Add(1)
+		return nil, false
+	}
+	path := chunkPath(key)
+	raw, err := os.ReadFile(path)
+	if err != nil || len(raw) < sha256.Size {
+		misses.Add(1)
+		drop(key)
+		return nil, false
+	}
+	sum, data := raw[:sha256.Size], raw[sha256.Size:]
+	if actual := sha256.Sum256(data); !bytes.Equal(sum, actual[:]) {
+		corrupt.Add(1)
+		misses.Add(1)
+		log.Warn("Dropped corrupt chunk", zap.String("path", path))
+		drop(key)
+		return nil, false
+	}
+	hits.Add(1)
+	// keeps the order of the chunks across restarts
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+// Has reports whether the chunk of a file at offset is cached, without
+// reading it or counting a hit or a miss.
+func Has(channelID int64, messageID int, offset int64, limit int64) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := chunks[chunkKey{channelID, messageID, offset, limit}]
+	return ok
+}
+
+// Put caches the chunk of a file at offset.
+func Put(channelID int64, messageID int, offset int64, limit int64, data []byte) {
+	key := chunkKey{channelID, messageID, offset, limit}
+	stored := int64(sha256.Size + len(data))
+	if stored > int64(config.ValueOf.DiskCacheMB)<<20 {
+		return
+	}
+	mu.Lock()
+	_, ok := chunks[key]
+	mu.Unlock()
+	if ok {
+		return
+	}
+	if err := write(chunkPath(key), data); err != nil {
+		log.Warn("Failed to cache chunk", zap.Error(err))
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := chunks[key]; ok {
+		return
+	}
+	chunks[key] = lru.PushFront(&chunk{key, stored})
+	size += stored
+	shrink()
+}
+
+// write saves a chunk with its checksum, through a temporary file so a chunk
+// is never read half written.
+func write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	_, err = tmp.Write(sum[:])
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// shrink drops the least recently read chunks until the cache fits in
+// DISK_CACHE_MB. mu must be held.
+func shrink() {
+	limit := int64(config.ValueOf.DiskCacheMB) << 20
+	for size > limit && lru.Len() > 0 {
+		c := lru.Remove(lru.Back()).(*chunk)
+		delete(chunks, c.key)
+		size -= c.size
+		evicted.Add(1)
+		os.Remove(chunkPath(c.key))
+	}
+}
+
+func drop(key chunkKey) {
+	mu.Lock()
+	defer mu.Unlock()
+	if element, ok := chunks[key]; ok {
+		lru.Remove(element)
+		delete(chunks, key)
+		size -= element.Value.(*chunk).size
+	}
+	os.Remove(chunkPath(key))
+}
+
+// dropFile drops every cached chunk of a file.
+func dropFile(channelID int64, messageID int) {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, element := range chunks {
+		if key.channelID == channelID && key.messageID == messageID {
+			lru.Remove(element)
+			delete(chunks, key)
+			size -= element.Value.(*chunk).size
+		}
+	}
+	os.RemoveAll(filepath.Join(root, strconv.FormatInt(channelID, 10), strconv.Itoa(messageID)))
+}
+
+// GetStats returns the counters of the cache.
+func GetStats() Stats {
+	mu.Lock()
+	current := size
+	mu.Unlock()
+	return Stats{
+		Hits:    hits.Load(),
+		Misses:  misses.Load(),
+		Evicted: evicted.Load(),
+		Corrupt: corrupt.Load(),
+		Size:    current,
+	}
+}
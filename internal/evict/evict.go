@@ -0,0 +1,43 @@
+// Package evict is a bus for files whose bytes can no longer be reached,
+// because their links were revoked or expired or their message was deleted.
+// The caches holding a file's data subscribe to it to drop what they keep.
+package evict
+
+import "sync"
+
+type Reason string
+
+const (
+	Revoked Reason = "revoked"
+	Expired Reason = "expired"
+	Deleted Reason = "deleted"
+)
+
+// Event announces that a file is no longer reachable.
+type Event struct {
+	ChannelID int64
+	MessageID int
+	Reason    Reason
+}
+
+var bus struct {
+	mu          sync.RWMutex
+	subscribers []func(Event)
+}
+
+// Subscribe calls fn for every event published from now on.
+func Subscribe(fn func(Event)) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subscribers = append(bus.subscribers, fn)
+}
+
+// Publish hands the event to every subscriber, returning once all of them
+// have dropped the file.
+func Publish(event Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for _, fn := range bus.subscribers {
+		fn(event)
+	}
+}
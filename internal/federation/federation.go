@@ -0,0 +1,99 @@
+// Package federation shards storage channels over several deployments of the
+// bot. Requests for files in a peer's channels are redirected to the peer,
+// with a signature that lets the peer know another member of the federation
+// already let the request through.
+package federation
+
+import (
+	"EverythingSuckz/fsb/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// signatureTTL is how long a redirect's signature is accepted by the peer.
+const signatureTTL = 5 * time.Minute
+
+// peers maps storage channels to the deployment storing them.
+var peers map[int64]*url.URL
+
+// Init parses FEDERATION_PEERS, a comma separated list of peers like
+// https://peer.example.com=<channel id>|<channel id>.
+func Init(log *zap.Logger) error {
+	log = log.Named("federation")
+	peers = make(map[int64]*url.URL)
+	if config.ValueOf.FederationPeers == "" {
+		return nil
+	}
+	if config.ValueOf.FederationSecret == "" {
+		return fmt.Errorf("FEDERATION_PEERS requires FEDERATION_SECRET to be set")
+	}
+	for _, peer := range strings.Split(config.ValueOf.FederationPeers, ",") {
+		rawURL, channelList, ok := strings.Cut(strings.TrimSpace(peer), "=")
+		if !ok {
+			return fmt.Errorf("peer %q has no channels", peer)
+		}
+		peerURL, err := url.Parse(strings.TrimSuffix(rawURL, "/"))
+		if err != nil || peerURL.Host == "" || (peerURL.Scheme != "http" && peerURL.Scheme != "https") {
+			return fmt.Errorf("invalid peer URL %q", rawURL)
+		}
+		for _, channel := range strings.Split(channelList, "|") {
+			channelID, err := strconv.ParseInt(strings.TrimSpace(channel), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid channel %q of peer %s", channel, rawURL)
+			}
+			peers[channelID] = peerURL
+		}
+		log.Sugar().Infof("Redirecting requests for %s to %s", channelList, peerURL)
+	}
+	return nil
+}
+
+// PeerFor returns the peer storing the channel.
+func PeerFor(channelID int64) (*url.URL, bool) {
+	peer, ok := peers[channelID]
+	return peer, ok
+}
+
+// RedirectURL returns the peer's equivalent of the request's URL, signed for
+// the peer.
+func RedirectURL(peer *url.URL, r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("fsig")
+	query.Set("fexp", strconv.FormatInt(time.Now().Add(signatureTTL).Unix(), 10))
+	query.Set("fsig", sign(r.URL.Path, query))
+	target := *peer
+	target.Path = peer.Path + r.URL.Path
+	target.RawQuery = query.Encode()
+	return target.String()
+}
+
+// Verify reports whether the request was redirected by a member of the
+// federation, and the signature hasn't expired.
+func Verify(r *http.Request) bool {
+	if config.ValueOf.FederationSecret == "" {
+		return false
+	}
+	query := r.URL.Query()
+	signature := query.Get("fsig")
+	expires, err := strconv.ParseInt(query.Get("fexp"), 10, 64)
+	if signature == "" || err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	query.Del("fsig")
+	return hmac.Equal([]byte(signature), []byte(sign(r.URL.Path, query)))
+}
+
+func sign(path string, query url.Values) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.FederationSecret))
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,159 @@
+// Package geoip looks up the country requests come from, for links that
+// only work in some countries. The country is read from GEOIP_HEADER when a
+// CDN in front of the bot sets one, like Cloudflare's CF-IPCountry, and
+// looked up in GEOIP_DB otherwise, a CSV file of IP ranges and the country
+// they're in like the free DB-IP and IP2Location LITE country databases.
+package geoip
+
+import (
+	"EverythingSuckz/fsb/config"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ipRange is a range of addresses in a country, from and to included.
+type ipRange struct {
+	from    netip.Addr
+	to      netip.Addr
+	country string
+}
+
+// ranges are sorted by their first address, IPv4 ones first.
+var ranges []ipRange
+
+// Init loads GEOIP_DB, whose rows start with the first and last address of
+// a range and its two-letter country code. Addresses are written out, like
+// in DB-IP's files, or as numbers, like in IP2Location's.
+func Init(log *zap.Logger) error {
+	log = log.Named("geoip")
+	ranges = nil
+	if config.ValueOf.GeoIPDB == "" {
+		return nil
+	}
+	file, err := os.Open(config.ValueOf.GeoIPDB)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(record) < 3 {
+			return fmt.Errorf("%s:%d: expected a range and a country", config.ValueOf.GeoIPDB, line)
+		}
+		from, err := parseAddr(record[0])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", config.ValueOf.GeoIPDB, line, err)
+		}
+		to, err := parseAddr(record[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", config.ValueOf.GeoIPDB, line, err)
+		}
+		country := strings.ToUpper(strings.TrimSpace(record[2]))
+		if len(country) != 2 {
+			// "-" or "ZZ" for reserved ranges
+			continue
+		}
+		ranges = append(ranges, ipRange{from: from, to: to, country: country})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].from.Less(ranges[j].from) })
+	log.Sugar().Infof("Loaded %d IP ranges", len(ranges))
+	return nil
+}
+
+// parseAddr reads an address written out or as a number.
+func parseAddr(value string) (netip.Addr, error) {
+	value = strings.TrimSpace(value)
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return addr.Unmap(), nil
+	}
+	n, ok := new(big.Int).SetString(value, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return netip.Addr{}, fmt.Errorf("%q is not an IP address", value)
+	}
+	if n.BitLen() <= 32 {
+		var b [4]byte
+		n.FillBytes(b[:])
+		return netip.AddrFrom4(b), nil
+	}
+	var b [16]byte
+	n.FillBytes(b[:])
+	return netip.AddrFrom16(b).Unmap(), nil
+}
+
+// Enabled reports whether countries can be looked up.
+func Enabled() bool {
+	return config.ValueOf.GeoIPHeader != "" || len(ranges) > 0
+}
+
+// Lookup returns the two-letter code of the country ip is in, or "" when it
+// isn't known.
+func Lookup(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	i := sort.Search(len(ranges), func(i int) bool { return addr.Less(ranges[i].from) })
+	if i == 0 || ranges[i-1].to.Less(addr) {
+		return ""
+	}
+	return ranges[i-1].country
+}
+
+// ParseCountries reads the countries a link is restricted to, a comma
+// separated list of country codes like "US,CA" that only lets requests from
+// them through, or like "-CN,-RU" that lets requests from anywhere else
+// through. It returns them in the form Allowed reads.
+func ParseCountries(value string) (string, error) {
+	var codes []string
+	blocked := strings.HasPrefix(strings.TrimSpace(value), "-")
+	for _, code := range strings.Split(value, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if strings.HasPrefix(code, "-") != blocked {
+			return "", errors.New("countries must all be allowed or all be blocked")
+		}
+		code = strings.TrimPrefix(code, "-")
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return "", fmt.Errorf("%q is not a two-letter country code", code)
+		}
+		if blocked {
+			code = "-" + code
+		}
+		codes = append(codes, code)
+	}
+	return strings.Join(codes, ","), nil
+}
+
+// Allowed reports whether a request from country may use a link restricted
+// to countries. Requests from unknown countries are only let through when
+// the link blocks countries rather than allowing them.
+func Allowed(countries string, country string) bool {
+	if countries == "" {
+		return true
+	}
+	blocked := strings.HasPrefix(countries, "-")
+	for _, code := range strings.Split(countries, ",") {
+		if strings.TrimPrefix(code, "-") == country && country != "" {
+			return !blocked
+		}
+	}
+	return blocked
+}
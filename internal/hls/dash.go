@@ -0,0 +1,137 @@
+package hls
+
+import (
+	"EverythingSuckz/fsb/config"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// audioBandwidth is the bitrate the manifest announces for the audio, which
+// players only use to pick between representations.
+const audioBandwidth = 128000
+
+// DASHPart is a file of a DASH stream: the initialization segment or a media
+// segment of the video or audio track.
+type DASHPart struct {
+	kind  string
+	index int
+	init  bool
+}
+
+// ParseDASHPart reads the part a name like "v-init.mp4" or "a-12.m4s" is
+// for.
+func ParseDASHPart(name string) (DASHPart, bool) {
+	kind, rest, ok := strings.Cut(name, "-")
+	if !ok || (kind != kindVideo && kind != kindAudio) {
+		return DASHPart{}, false
+	}
+	if rest == "init.mp4" {
+		return DASHPart{kind: kind, init: true}, true
+	}
+	index, err := strconv.Atoi(strings.TrimSuffix(rest, ".m4s"))
+	if err != nil || index < 0 || !strings.HasSuffix(rest, ".m4s") {
+		return DASHPart{}, false
+	}
+	return DASHPart{kind: kind, index: index}, true
+}
+
+// ContentType is the type the part is served as.
+func (p DASHPart) ContentType() string {
+	if p.kind == kindAudio {
+		return "audio/mp4"
+	}
+	return "video/mp4"
+}
+
+// Manifest returns the DASH manifest of a video that is duration seconds
+// long, with an audio track when audio is set. size, width and height are
+// announced to players picking a representation, and query is appended to
+// the segment URLs so they carry the hash of the manifest's link.
+func Manifest(duration float64, size int64, width int, height int, audio bool, query string) ([]byte, error) {
+	if duration <= 0 {
+		return nil, ErrNoDuration
+	}
+	suffix := ""
+	if query != "" {
+		suffix = "?" + query
+	}
+	videoBandwidth := max(int64(float64(size*8)/duration)-audioBandwidth, audioBandwidth)
+	template := func(kind string) string {
+		return fmt.Sprintf(`<SegmentTemplate timescale="1000" duration="%d" startNumber="0" initialization="%s" media="%s"/>`,
+			config.ValueOf.HLSSegmentSeconds*1000,
+			escapeXML(kind+"-init.mp4"+suffix),
+			escapeXML(kind+"-$Number$.m4s"+suffix))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	fmt.Fprintf(&buf, `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="static" mediaPresentationDuration="PT%.3fS" minBufferTime="PT%dS">`+"\n",
+		duration, config.ValueOf.HLSSegmentSeconds)
+	buf.WriteString("  <Period start=\"PT0S\">\n")
+	buf.WriteString("    <AdaptationSet contentType=\"video\" mimeType=\"video/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n")
+	fmt.Fprintf(&buf, "      %s\n", template(kindVideo))
+	fmt.Fprintf(&buf, "      <Representation id=\"video\" bandwidth=\"%d\"", videoBandwidth)
+	if width > 0 && height > 0 {
+		fmt.Fprintf(&buf, " width=\"%d\" height=\"%d\"", width, height)
+	}
+	buf.WriteString("/>\n    </AdaptationSet>\n")
+	if audio {
+		buf.WriteString("    <AdaptationSet contentType=\"audio\" mimeType=\"audio/mp4\" segmentAlignment=\"true\" startWithSAP=\"1\">\n")
+		fmt.Fprintf(&buf, "      %s\n", template(kindAudio))
+		fmt.Fprintf(&buf, "      <Representation id=\"audio\" bandwidth=\"%d\"/>\n", audioBandwidth)
+		buf.WriteString("    </AdaptationSet>\n")
+	}
+	buf.WriteString("  </Period>\n</MPD>\n")
+	return buf.Bytes(), nil
+}
+
+// CutDASH returns a part of the DASH stream of a video that is duration
+// seconds long. Every segment is cut as a fragmented MP4 of its own, so the
+// initialization segment is the header of the first one, and media segments
+// are the fragments that follow the header.
+func CutDASH(ctx context.Context, channelID int64, messageID int, part DASHPart, duration float64, input string) ([]byte, error) {
+	data, err := getSegment(ctx, segmentKey{channelID, messageID, part.kind, part.index}, duration, input)
+	if err != nil {
+		return nil, err
+	}
+	header, err := headerSize(data)
+	if err != nil {
+		return nil, err
+	}
+	if part.init {
+		return data[:header], nil
+	}
+	return data[header:], nil
+}
+
+// headerSize returns the size of the boxes before the first fragment of a
+// fragmented MP4.
+func headerSize(data []byte) (int, error) {
+	offset := 0
+	for offset+8 <= len(data) {
+		if string(data[offset+4:offset+8]) == "moof" {
+			return offset, nil
+		}
+		size := uint64(binary.BigEndian.Uint32(data[offset:]))
+		if size == 1 && offset+16 <= len(data) {
+			size = binary.BigEndian.Uint64(data[offset+8:])
+		}
+		if size < 8 || size > uint64(len(data)-offset) {
+			break
+		}
+		offset += int(size)
+	}
+	return 0, errors.New("ffmpeg wrote a segment without fragments")
+}
+
+func escapeXML(s string) string {
+	var buf strings.Builder
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
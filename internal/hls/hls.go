@@ -0,0 +1,286 @@
+// Package hls cuts videos into HLS and DASH segments on the fly with ffmpeg,
+// so players that can't seek in large MP4s over HTTP, like the ones of
+// mobile browsers, smart TVs and ExoPlayer based Android apps, can still play
+// them. Segments are remuxed, not transcoded, and kept in a memory cache
+// bounded by HLS_CACHE_MB, along with the subtitle tracks extracted from
+// videos as WebVTT.
+package hls
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/evict"
+	"EverythingSuckz/fsb/internal/probe"
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// segmentTimeout caps how long ffmpeg may take to cut a segment.
+const segmentTimeout = 2 * time.Minute
+
+// subtitlesTimeout caps how long ffmpeg may take to extract a subtitle
+// track, which takes reading the whole video.
+const subtitlesTimeout = 10 * time.Minute
+
+var ErrNoDuration = errors.New("the length of the video isn't known, set FFPROBE_PATH to have it probed")
+
+// The kinds of segments cut from a video: MPEG-TS segments for HLS,
+// fragmented MP4s of the video and the audio for DASH, and whole subtitle
+// tracks as WebVTT, indexed by track.
+const (
+	kindTS        = "ts"
+	kindVideo     = "v"
+	kindAudio     = "a"
+	kindSubtitles = "subs"
+)
+
+type segmentKey struct {
+	channelID int64
+	messageID int
+	kind      string
+	index     int
+}
+
+type segment struct {
+	key  segmentKey
+	data []byte
+}
+
+// call is a segment being cut, which requests for the same segment wait on
+// instead of running ffmpeg again.
+type call struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var segments = struct {
+	mu       sync.Mutex
+	lru      *list.List
+	elements map[segmentKey]*list.Element
+	size     int64
+	inflight map[segmentKey]*call
+}{
+	lru:      list.New(),
+	elements: make(map[segmentKey]*list.Element),
+	inflight: make(map[segmentKey]*call),
+}
+
+var log *zap.Logger
+
+// Init drops the cached segments of files that can no longer be reached.
+func Init(logger *zap.Logger) {
+	log = logger.Named("HLS")
+	evict.Subscribe(func(event evict.Event) {
+		segments.mu.Lock()
+		defer segments.mu.Unlock()
+		for key, element := range segments.elements {
+			if key.channelID == event.ChannelID && key.messageID == event.MessageID {
+				remove(element)
+			}
+		}
+	})
+}
+
+// Enabled reports whether FFMPEG_PATH is set.
+func Enabled() bool {
+	return config.ValueOf.FFmpegPath != ""
+}
+
+func segmentSeconds() float64 {
+	return float64(config.ValueOf.HLSSegmentSeconds)
+}
+
+// Playlist returns the VOD playlist of a video that is duration seconds
+// long. query is appended to the segment URIs so they carry the hash of the
+// playlist's link.
+func Playlist(duration float64, query string) ([]byte, error) {
+	if duration <= 0 {
+		return nil, ErrNoDuration
+	}
+	count := int(math.Ceil(duration / segmentSeconds()))
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&buf, "#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n", config.ValueOf.HLSSegmentSeconds)
+	for i := 0; i < count; i++ {
+		length := math.Min(segmentSeconds(), duration-float64(i)*segmentSeconds())
+		fmt.Fprintf(&buf, "#EXTINF:%.3f,\n%d.ts", length, i)
+		if query != "" {
+			buf.WriteString("?" + query)
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("#EXT-X-ENDLIST\n")
+	return buf.Bytes(), nil
+}
+
+// ParseSegment reads the index of a segment from its name, like "12.ts".
+func ParseSegment(name string) (int, bool) {
+	index, err := strconv.Atoi(strings.TrimSuffix(name, ".ts"))
+	if err != nil || index < 0 || !strings.HasSuffix(name, ".ts") {
+		return 0, false
+	}
+	return index, true
+}
+
+// Segment returns a segment of a video that is duration seconds long as an
+// MPEG-TS, cutting it from input with ffmpeg unless it's cached.
+func Segment(ctx context.Context, channelID int64, messageID int, index int, duration float64, input string) ([]byte, error) {
+	return getSegment(ctx, segmentKey{channelID, messageID, kindTS, index}, duration, input)
+}
+
+// Subtitles returns a subtitle track of a video as WebVTT, extracting it
+// from input with ffmpeg unless it's cached. track is the index of the track
+// among the video's subtitle tracks.
+func Subtitles(ctx context.Context, channelID int64, messageID int, track int, input string) ([]byte, error) {
+	key := segmentKey{channelID, messageID, kindSubtitles, track}
+	return load(ctx, key, subtitlesTimeout, func(ctx context.Context) ([]byte, error) {
+		args := []string{"-v", "error"}
+		args = append(args, probe.HeaderArgs()...)
+		args = append(args,
+			"-i", input,
+			"-map", "0:s:"+strconv.Itoa(track),
+			"-f", "webvtt", "pipe:1",
+		)
+		return ffmpeg(ctx, key, args)
+	})
+}
+
+// getSegment returns the segment of a video that is duration seconds long for
+// key, cutting it from input with ffmpeg unless it's cached.
+func getSegment(ctx context.Context, key segmentKey, duration float64, input string) ([]byte, error) {
+	if duration <= 0 {
+		return nil, ErrNoDuration
+	}
+	start := float64(key.index) * segmentSeconds()
+	if start >= duration {
+		return nil, fmt.Errorf("the video has no segment %d", key.index)
+	}
+	return load(ctx, key, segmentTimeout, func(ctx context.Context) ([]byte, error) {
+		return cut(ctx, key, start, math.Min(segmentSeconds(), duration-start), input)
+	})
+}
+
+// load returns the cached segment for key, or runs fn for it within timeout
+// unless a request for the same segment is running it already.
+func load(ctx context.Context, key segmentKey, timeout time.Duration, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	segments.mu.Lock()
+	if element, ok := segments.elements[key]; ok {
+		segments.lru.MoveToFront(element)
+		segments.mu.Unlock()
+		return element.Value.(*segment).data, nil
+	}
+	c, ok := segments.inflight[key]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		segments.inflight[key] = c
+		go run(c, key, timeout, fn)
+	}
+	segments.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.data, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run runs fn for a segment. It isn't tied to the request that started it,
+// so the other requests waiting on it still get the segment if that one goes
+// away.
+func run(c *call, key segmentKey, timeout time.Duration, fn func(context.Context) ([]byte, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c.data, c.err = fn(ctx)
+
+	segments.mu.Lock()
+	delete(segments.inflight, key)
+	if c.err == nil {
+		keep(key, c.data)
+	}
+	segments.mu.Unlock()
+	close(c.done)
+}
+
+// cut runs ffmpeg for a segment.
+func cut(ctx context.Context, key segmentKey, start float64, length float64, input string) ([]byte, error) {
+	ss := strconv.FormatFloat(start, 'f', 3, 64)
+	args := []string{"-v", "error", "-ss", ss}
+	args = append(args, probe.HeaderArgs()...)
+	args = append(args,
+		"-i", input,
+		"-t", strconv.FormatFloat(length, 'f', 3, 64),
+	)
+	switch key.kind {
+	case kindTS:
+		args = append(args, "-map", "0:v:0?", "-map", "0:a:0?")
+	case kindVideo:
+		args = append(args, "-map", "0:v:0")
+	case kindAudio:
+		args = append(args, "-map", "0:a:0")
+	}
+	// keeps the timestamps continuous across segments
+	args = append(args, "-c", "copy", "-output_ts_offset", ss)
+	if key.kind == kindTS {
+		args = append(args, "-muxdelay", "0", "-f", "mpegts", "pipe:1")
+	} else {
+		args = append(args,
+			// a single fragment holding the whole segment
+			"-movflags", "empty_moov+default_base_moof",
+			"-frag_duration", strconv.Itoa(int(length+1)*1000000),
+			"-f", "mp4", "pipe:1",
+		)
+	}
+	return ffmpeg(ctx, key, args)
+}
+
+// ffmpeg runs ffmpeg with args and returns what it wrote to stdout.
+func ffmpeg(ctx context.Context, key segmentKey, args []string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, config.ValueOf.FFmpegPath, args...)
+	cmd.Stderr = &stderr
+	data, err := cmd.Output()
+	if err != nil {
+		log.Warn("Failed to run ffmpeg",
+			zap.Int64("channelID", key.channelID),
+			zap.Int("messageID", key.messageID),
+			zap.String("kind", key.kind),
+			zap.Int("segment", key.index),
+			zap.String("stderr", strings.TrimSpace(stderr.String())),
+			zap.Error(err))
+		return nil, err
+	}
+	return data, nil
+}
+
+// keep caches a segment, dropping the least recently used ones to stay
+// within HLS_CACHE_MB. segments.mu must be held.
+func keep(key segmentKey, data []byte) {
+	limit := int64(config.ValueOf.HLSCacheMB) << 20
+	if int64(len(data)) > limit {
+		return
+	}
+	segments.elements[key] = segments.lru.PushFront(&segment{key: key, data: data})
+	segments.size += int64(len(data))
+	for segments.size > limit {
+		remove(segments.lru.Back())
+	}
+}
+
+// remove drops a cached segment. segments.mu must be held.
+func remove(element *list.Element) {
+	seg := segments.lru.Remove(element).(*segment)
+	delete(segments.elements, seg.key)
+	segments.size -= int64(len(seg.data))
+}
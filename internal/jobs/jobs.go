@@ -0,0 +1,172 @@
+// Package jobs runs background work that has to survive failures and
+// restarts, like probing newly indexed files. Jobs are kept in the store,
+// retried with exponential backoff, and the ones that ran out of attempts
+// stay around as failed until they are retried from the admin API.
+package jobs
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxAttempts is how many times a job is run before it's failed.
+	maxAttempts = 5
+	// baseBackoff is the wait before the first retry, doubled for every
+	// retry after it.
+	baseBackoff = 30 * time.Second
+	// pollInterval is how often the workers look for due jobs when they
+	// aren't woken up by a new one.
+	pollInterval = 5 * time.Second
+	// jobTimeout caps how long a single run of a job may take.
+	jobTimeout = 10 * time.Minute
+)
+
+// Handler runs a job of one kind with its payload. Returning an error
+// schedules a retry.
+type Handler func(ctx context.Context, payload []byte) error
+
+var (
+	mu       sync.RWMutex
+	handlers = make(map[string]Handler)
+	wake     = make(chan struct{}, 1)
+	log      = zap.NewNop()
+)
+
+// Register sets the handler of a kind of job. Handlers have to be
+// registered before Start.
+func Register(kind string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[kind] = handler
+}
+
+// Enqueue saves a job of the kind with payload, encoded as JSON, to be run
+// as soon as a worker is free.
+func Enqueue(kind string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	job := &store.Job{
+		Kind:    kind,
+		Payload: string(data),
+		State:   store.JobPending,
+		RunAt:   time.Now(),
+	}
+	if err := store.GetStore().AddJob(job); err != nil {
+		return err
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Retry makes a failed job pending again, with all its attempts.
+func Retry(id int64) (*store.Job, error) {
+	job, err := store.GetStore().GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.State != store.JobFailed {
+		return nil, fmt.Errorf("job %d is %s, only failed jobs can be retried", id, job.State)
+	}
+	job.State = store.JobPending
+	job.Attempts = 0
+	job.RunAt = time.Now()
+	if err := store.GetStore().UpdateJob(job); err != nil {
+		return nil, err
+	}
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Start runs JOB_WORKERS workers until ctx is done. Jobs that were running
+// when the bot last stopped are run again.
+func Start(ctx context.Context, logger *zap.Logger) {
+	log = logger.Named("Jobs")
+	if err := store.GetStore().RequeueRunningJobs(); err != nil {
+		log.Warn("Failed to requeue interrupted jobs", zap.Error(err))
+	}
+	for i := 0; i < config.ValueOf.JobWorkers; i++ {
+		go work(ctx)
+	}
+	log.Info("Started job workers", zap.Int("workers", config.ValueOf.JobWorkers))
+}
+
+func work(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		for runNext(ctx) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// runNext runs the job that is due next, reporting whether there was one.
+func runNext(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	job, err := store.GetStore().ClaimJob(time.Now())
+	if errors.Is(err, store.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Warn("Failed to claim job", zap.Error(err))
+		return false
+	}
+	jobLog := log.With(zap.Int64("id", job.ID), zap.String("kind", job.Kind))
+
+	mu.RLock()
+	handler, ok := handlers[job.Kind]
+	mu.RUnlock()
+	if ok {
+		runCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+		err = handler(runCtx, []byte(job.Payload))
+		cancel()
+	} else {
+		err = fmt.Errorf("no handler for %s jobs", job.Kind)
+	}
+	if err == nil {
+		if err := store.GetStore().DeleteJob(job.ID); err != nil {
+			jobLog.Warn("Failed to delete finished job", zap.Error(err))
+		}
+		jobLog.Debug("Job done")
+		return true
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	if job.Attempts >= maxAttempts || !ok {
+		job.State = store.JobFailed
+		jobLog.Warn("Job failed", zap.Int("attempts", job.Attempts), zap.Error(err))
+	} else {
+		job.State = store.JobPending
+		job.RunAt = time.Now().Add(baseBackoff << (job.Attempts - 1))
+		jobLog.Info("Job will be retried", zap.Time("at", job.RunAt), zap.Error(err))
+	}
+	if err := store.GetStore().UpdateJob(job); err != nil {
+		jobLog.Warn("Failed to save job", zap.Error(err))
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package objects
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/s3"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+var client *s3.Client
+
+// Init connects to the object store when S3_BUCKET is set.
+func Init(log *zap.Logger) error {
+	log = log.Named("objects")
+	switch config.ValueOf.UploadTarget {
+	case store.BackendTelegram, store.BackendS3, store.BackendBoth:
+	default:
+		return fmt.Errorf("unknown UPLOAD_TARGET %q", config.ValueOf.UploadTarget)
+	}
+	if config.ValueOf.S3Bucket == "" {
+		if config.ValueOf.UploadTarget != store.BackendTelegram {
+			return fmt.Errorf("UPLOAD_TARGET=%s requires S3_BUCKET to be set", config.ValueOf.UploadTarget)
+		}
+		return nil
+	}
+	var err error
+	client, err = s3.New(s3.Options{
+		Endpoint:  config.ValueOf.S3Endpoint,
+		Region:    config.ValueOf.S3Region,
+		Bucket:    config.ValueOf.S3Bucket,
+		AccessKey: config.ValueOf.S3AccessKey,
+		SecretKey: config.ValueOf.S3SecretKey,
+		PathStyle: config.ValueOf.S3PathStyle,
+	})
+	if err != nil {
+		return err
+	}
+	log.Sugar().Infof("Using bucket %s, uploads go to %s", config.ValueOf.S3Bucket, config.ValueOf.UploadTarget)
+	return nil
+}
+
+// Enabled reports whether an object store is configured.
+func Enabled() bool {
+	return client != nil
+}
+
+func Client() *s3.Client {
+	return client
+}
+
+func Key(channelID int64, messageID int) string {
+	return fmt.Sprintf("files/%d/%d", channelID, messageID)
+}
+
+// Stored reports whether the file is only kept in the object store. Such
+// files are identified by the uploader's chat instead of a storage channel.
+func Stored(channelID int64, messageID int) bool {
+	if !Enabled() {
+		return false
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	return err == nil && entry.Backend == store.BackendS3
+}
+
+// Upload copies a file from Telegram to key in the object store, reading it
+// through api.
+func Upload(ctx context.Context, api *tg.Client, file *types.File, key string) error {
+	if file.FileSize == 0 {
+		// photos fit in a single chunk and don't report their size
+		data, err := utils.FetchChunkWith(ctx, api, file.Location, 0, utils.MaxChunkSize)
+		if err != nil {
+			return err
+		}
+		return client.PutObject(ctx, key, bytes.NewReader(data), int64(len(data)), file.MimeType)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		for offset := int64(0); offset < file.FileSize; offset += utils.MaxChunkSize {
+			chunk, err := utils.FetchChunkWith(ctx, api, file.Location, offset, utils.MaxChunkSize)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(chunk); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+	err := client.PutObject(ctx, key, pr, file.FileSize, file.MimeType)
+	pr.CloseWithError(err)
+	return err
+}
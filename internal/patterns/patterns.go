@@ -0,0 +1,176 @@
+// Package patterns gives files readable links on routes the operator
+// defines, like /videos/:slug for videos and /docs/:slug for files tagged
+// docs. The slug is the file's name followed by a signed token holding the
+// file's location and hash, so these links need no query string.
+package patterns
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// signatureLength is the number of bytes of the HMAC kept in tokens.
+	signatureLength = 6
+	// maxNameLength caps the name part of slugs.
+	maxNameLength = 60
+)
+
+// Pattern is a route template with the files it's used for.
+type Pattern struct {
+	// Template is the route, with a :slug parameter.
+	Template string
+	// mimeTypes are MIME types, or prefixes of them ending in a slash.
+	mimeTypes []string
+	tags      []string
+}
+
+var patterns []*Pattern
+
+// Init parses URL_PATTERNS, a comma separated list of route templates with
+// the files they are for, like /videos/:slug=video/,/docs/:slug=#docs. A
+// file is matched by a MIME type or prefix, or by a tag prefixed with #,
+// and several of them can be given separated by |.
+func Init(log *zap.Logger) error {
+	log = log.Named("Patterns")
+	patterns = nil
+	if config.ValueOf.URLPatterns == "" {
+		return nil
+	}
+	for _, option := range strings.Split(config.ValueOf.URLPatterns, ",") {
+		template, match, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok || match == "" {
+			return fmt.Errorf("invalid URL pattern %q, expected <route>=<mime type or #tag>", option)
+		}
+		if !strings.HasPrefix(template, "/") || strings.Count(template, ":") != 1 || !strings.HasSuffix(template, "/:slug") {
+			return fmt.Errorf("invalid URL pattern %q, the route has to end with /:slug", option)
+		}
+		pattern := &Pattern{Template: template}
+		for _, matcher := range strings.Split(match, "|") {
+			if tag, ok := strings.CutPrefix(matcher, "#"); ok {
+				pattern.tags = append(pattern.tags, strings.ToLower(tag))
+			} else {
+				pattern.mimeTypes = append(pattern.mimeTypes, strings.ToLower(matcher))
+			}
+		}
+		patterns = append(patterns, pattern)
+	}
+	log.Info("Loaded URL patterns", zap.Int("count", len(patterns)))
+	return nil
+}
+
+// All returns the patterns set in URL_PATTERNS.
+func All() []*Pattern {
+	return patterns
+}
+
+// Enabled reports whether any patterns are set.
+func Enabled() bool {
+	return len(patterns) > 0
+}
+
+func (p *Pattern) matches(mimeType string, tags []string) bool {
+	mimeType = strings.ToLower(mimeType)
+	for _, match := range p.mimeTypes {
+		if mimeType == match || (strings.HasSuffix(match, "/") && strings.HasPrefix(mimeType, match)) {
+			return true
+		}
+	}
+	for _, tag := range tags {
+		if utils.Contains(p.tags, strings.ToLower(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Link returns the link to a file on the first pattern it matches, or an
+// empty string when it matches none.
+func Link(channelID int64, messageID int, hash string, fileName string, mimeType string, tags []string) string {
+	for _, p := range patterns {
+		if p.matches(mimeType, tags) {
+			return config.ValueOf.Host + strings.TrimSuffix(p.Template, ":slug") + p.slug(channelID, messageID, hash, fileName)
+		}
+	}
+	return ""
+}
+
+func (p *Pattern) slug(channelID int64, messageID int, hash string, fileName string) string {
+	payload := binary.AppendVarint(nil, channelID)
+	payload = binary.AppendUvarint(payload, uint64(messageID))
+	payload = append(payload, hash...)
+	payload = append(payload, p.sign(payload)...)
+	token := base64.RawURLEncoding.EncodeToString(payload)
+	if name := slugName(fileName); name != "" {
+		return name + "." + token
+	}
+	return token
+}
+
+// Resolve reads the file a slug on the pattern links to.
+func (p *Pattern) Resolve(slug string) (channelID int64, messageID int, hash string, err error) {
+	if i := strings.LastIndexByte(slug, '.'); i >= 0 {
+		slug = slug[i+1:]
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(slug)
+	if err != nil || len(payload) <= signatureLength {
+		return 0, 0, "", errors.New("invalid link")
+	}
+	signed, signature := payload[:len(payload)-signatureLength], payload[len(payload)-signatureLength:]
+	if !hmac.Equal(signature, p.sign(signed)) {
+		return 0, 0, "", errors.New("invalid link signature")
+	}
+	channelID, n := binary.Varint(signed)
+	if n <= 0 {
+		return 0, 0, "", errors.New("invalid link")
+	}
+	id, m := binary.Uvarint(signed[n:])
+	if m <= 0 {
+		return 0, 0, "", errors.New("invalid link")
+	}
+	return channelID, int(id), string(signed[n+m:]), nil
+}
+
+// sign ties a token to the pattern, so a file's link only works on the
+// route it was made for.
+func (p *Pattern) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.EmbedSecret))
+	mac.Write([]byte("pattern:" + p.Template + ":"))
+	mac.Write(payload)
+	return mac.Sum(nil)[:signatureLength]
+}
+
+// slugName turns a file name into lowercase words joined by dashes, without
+// its extension.
+func slugName(fileName string) string {
+	if i := strings.LastIndexByte(fileName, '.'); i > 0 {
+		fileName = fileName[:i]
+	}
+	var out strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(utils.ASCIIFileName(fileName)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && out.Len() > 0 {
+				out.WriteByte('-')
+			}
+			out.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+		if out.Len() >= maxNameLength {
+			break
+		}
+	}
+	return out.String()
+}
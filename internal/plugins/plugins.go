@@ -0,0 +1,241 @@
+// Package plugins runs the programs listed in PLUGINS and calls their hooks
+// on the bot's lifecycle events, so operators can add their own auth or
+// billing without forking the bot. See pkg/plugin for writing one.
+package plugins
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/pkg/plugin"
+	"context"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// callTimeout caps how long a hook may take. Streams wait on StreamStarted,
+// so a stuck plugin must not hold them for long.
+const callTimeout = 5 * time.Second
+
+// maxRestartDelay caps the delay before a plugin that keeps exiting is
+// started again.
+const maxRestartDelay = time.Minute
+
+// ErrDenied is returned by StreamStarted when a plugin denied the stream.
+var ErrDenied = errors.New("denied")
+
+// process is a running plugin.
+type process struct {
+	path   string
+	name   string
+	mu     sync.Mutex
+	client *rpc.Client
+}
+
+var (
+	log     *zap.Logger
+	running []*process
+)
+
+// Start runs the plugins in PLUGINS, starting them again when they exit
+// until ctx is done.
+func Start(ctx context.Context, logger *zap.Logger) {
+	log = logger.Named("Plugins")
+	// the plugins of a server that failed to start stopped with its ctx
+	running = nil
+	for _, path := range strings.Split(config.ValueOf.Plugins, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		p := &process{path: path, name: filepath.Base(path)}
+		running = append(running, p)
+		go p.supervise(ctx)
+	}
+	if len(running) > 0 {
+		log.Info("Started plugins", zap.Int("count", len(running)))
+	}
+}
+
+// supervise runs the plugin until ctx is done, waiting longer between
+// restarts while it keeps exiting soon after starting.
+func (p *process) supervise(ctx context.Context) {
+	delay := time.Second
+	for {
+		started := time.Now()
+		err := p.run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxRestartDelay {
+			delay = time.Second
+		}
+		log.Warn("Plugin exited, restarting it", zap.String("plugin", p.name), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(2*delay, maxRestartDelay)
+	}
+}
+
+// run starts the plugin and serves calls to it until it exits. The pipes
+// are made here instead of with cmd.StdoutPipe, which Wait would close while
+// the client may still be reading from it.
+func (p *process) run(ctx context.Context) error {
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		return err
+	}
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = &logWriter{log: log.With(zap.String("plugin", p.name))}
+	err = cmd.Start()
+	// the plugin has its own copies of its ends now
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return err
+	}
+	client := rpc.NewClientWithCodec(jsonrpc.NewClientCodec(&pipe{stdoutReader, stdinWriter}))
+	p.mu.Lock()
+	p.client = client
+	p.mu.Unlock()
+	err = cmd.Wait()
+	p.mu.Lock()
+	p.client = nil
+	p.mu.Unlock()
+	// closes both pipes, failing the calls still waiting for an answer
+	client.Close()
+	return err
+}
+
+// call calls a hook of the plugin.
+func (p *process) call(method string, event any) error {
+	p.mu.Lock()
+	client := p.client
+	p.mu.Unlock()
+	if client == nil {
+		return errors.New("the plugin isn't running")
+	}
+	call := client.Go(plugin.ServiceName+"."+method, event, new(struct{}), make(chan *rpc.Call, 1))
+	timer := time.NewTimer(callTimeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-timer.C:
+		return fmt.Errorf("the plugin didn't answer in %s", callTimeout)
+	}
+}
+
+// notify calls a hook of every plugin in the background, logging the
+// errors they return.
+func notify(method string, event any) {
+	if len(running) == 0 {
+		return
+	}
+	go func() {
+		for _, p := range running {
+			if err := p.call(method, event); err != nil {
+				log.Warn("Plugin hook failed", zap.String("plugin", p.name), zap.String("hook", method), zap.Error(err))
+			}
+		}
+	}()
+}
+
+// LinkCreated tells the plugins a link to a file was handed out.
+func LinkCreated(event plugin.LinkEvent) {
+	notify("LinkCreated", event)
+}
+
+// StreamStarted asks the plugins whether a stream may start. A plugin that
+// denies it makes it return an error wrapping ErrDenied with the plugin's
+// reason. A plugin that can't be asked fails the stream as well, as letting
+// it through would bypass the auth plugins add.
+func StreamStarted(event plugin.StreamEvent) error {
+	for _, p := range running {
+		err := p.call("StreamStarted", event)
+		var denied rpc.ServerError
+		if errors.As(err, &denied) {
+			return fmt.Errorf("%w: %s", ErrDenied, string(denied))
+		}
+		if err != nil {
+			log.Warn("Plugin hook failed", zap.String("plugin", p.name), zap.String("hook", "StreamStarted"), zap.Error(err))
+			return fmt.Errorf("plugin %s is unavailable", p.name)
+		}
+	}
+	return nil
+}
+
+// StreamFinished tells the plugins a stream ended.
+func StreamFinished(event plugin.StreamEvent) {
+	notify("StreamFinished", event)
+}
+
+// UploadStored tells the plugins a file was stored.
+func UploadStored(entry *store.FileEntry) {
+	notify("UploadStored", plugin.UploadEvent{
+		ChannelID:  entry.ChannelID,
+		MessageID:  entry.MessageID,
+		FileName:   entry.FileName,
+		FileSize:   entry.FileSize,
+		MimeType:   entry.MimeType,
+		UploadedBy: entry.UploadedBy,
+		Backend:    entry.Backend,
+	})
+}
+
+// pipe is the connection to a plugin, reading its stdout and writing its
+// stdin.
+type pipe struct {
+	stdout *os.File
+	stdin  *os.File
+}
+
+func (p *pipe) Read(b []byte) (int, error)  { return p.stdout.Read(b) }
+func (p *pipe) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+
+func (p *pipe) Close() error {
+	return errors.Join(p.stdin.Close(), p.stdout.Close())
+}
+
+// logWriter logs what a plugin writes to stderr, a line at a time.
+type logWriter struct {
+	log *zap.Logger
+	buf []byte
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		line, rest, ok := strings.Cut(string(w.buf), "\n")
+		if !ok {
+			break
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			w.log.Info(line)
+		}
+		w.buf = []byte(rest)
+	}
+	return len(b), nil
+}
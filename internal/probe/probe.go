@@ -0,0 +1,192 @@
+// Package probe reads the duration, resolution, codecs and subtitle tracks
+// of audio and video files with ffprobe, in jobs queued when the files are
+// indexed.
+package probe
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/jobs"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// probeTimeout caps how long ffprobe may take for a file.
+const probeTimeout = time.Minute
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		Tags      struct {
+			Language string `json:"language"`
+			Title    string `json:"title"`
+		} `json:"tags"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+	} `json:"format"`
+}
+
+// textSubtitles are the codecs of the subtitle tracks ffmpeg can convert to
+// WebVTT. The others are pictures, like the ones of DVDs and Blu-rays.
+var textSubtitles = map[string]bool{
+	"subrip":   true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// jobKind is the kind of the jobs probing files.
+const jobKind = "probe"
+
+type probeJob struct {
+	ChannelID int64 `json:"channel_id"`
+	MessageID int   `json:"message_id"`
+}
+
+func init() {
+	jobs.Register(jobKind, runJob)
+}
+
+// Enqueue queues an indexed audio or video file to have its media info
+// filled in by ffprobe. It does nothing when FFPROBE_PATH isn't set.
+func Enqueue(entry store.FileEntry) {
+	if config.ValueOf.FFprobePath == "" {
+		return
+	}
+	if !strings.HasPrefix(entry.MimeType, "video/") && !strings.HasPrefix(entry.MimeType, "audio/") {
+		return
+	}
+	err := jobs.Enqueue(jobKind, probeJob{ChannelID: entry.ChannelID, MessageID: entry.MessageID})
+	if err != nil {
+		utils.Logger.Warn("Failed to queue file for probing", zap.Int("messageID", entry.MessageID), zap.Error(err))
+	}
+}
+
+// runJob fills in the media info of a file with ffprobe. The file is read
+// through the bot's own stream route, so only the ranges ffprobe asks for
+// are fetched from Telegram.
+func runJob(ctx context.Context, payload []byte) error {
+	var job probeJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	entry, err := store.GetStore().GetFile(job.ChannelID, job.MessageID)
+	if errors.Is(err, store.ErrNotFound) {
+		// the file was removed from the index since
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	info, err := run(ctx, StreamURL(entry))
+	if err != nil {
+		return fmt.Errorf("failed to probe file: %w", err)
+	}
+	// keep what Telegram told us where ffprobe can't tell
+	if info.Duration == 0 {
+		info.Duration = entry.Duration
+	}
+	if info.Width == 0 {
+		info.Width, info.Height = entry.Width, entry.Height
+	}
+	if err := store.GetStore().SetMediaInfo(entry.ChannelID, entry.MessageID, *info); err != nil {
+		return fmt.Errorf("failed to save media info: %w", err)
+	}
+	return nil
+}
+
+// StreamURL is the file's stream link on the local server, for ffmpeg and
+// ffprobe to read the file through.
+func StreamURL(entry *store.FileEntry) string {
+	hash := utils.GetShortHash(utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt))
+	query := url.Values{"hash": {hash}}
+	if entry.ChannelID != config.ValueOf.LogChannelID {
+		query.Set("channel", strconv.FormatInt(entry.ChannelID, 10))
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d/stream/%d?%s", config.ValueOf.Port, entry.MessageID, query.Encode())
+}
+
+// InternalHeader is the header ffmpeg and ffprobe send internalToken in.
+const InternalHeader = "X-FSB-Internal"
+
+// internalToken gets the requests of ffmpeg and ffprobe to StreamURL past
+// BASIC_AUTH_USER and the IP limits. It's made anew by every process and
+// only accepted from loopback, so seeing it in the process list is of no
+// use once the bot restarts or from another host.
+var internalToken = func() string {
+	token := make([]byte, 32)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}()
+
+// HeaderArgs are the ffmpeg and ffprobe options that send the internal
+// token with their requests to StreamURL.
+func HeaderArgs() []string {
+	return []string{"-headers", InternalHeader + ": " + internalToken + "\r\n"}
+}
+
+// IsInternalToken reports whether token is the one HeaderArgs sends.
+func IsInternalToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(internalToken)) == 1
+}
+
+func run(ctx context.Context, link string) (*store.MediaInfo, error) {
+	args := []string{"-v", "error", "-print_format", "json", "-show_format", "-show_streams"}
+	args = append(args, HeaderArgs()...)
+	out, err := exec.CommandContext(ctx, config.ValueOf.FFprobePath, append(args, link)...).Output()
+	if err != nil {
+		return nil, err
+	}
+	var probed ffprobeOutput
+	if err := json.Unmarshal(out, &probed); err != nil {
+		return nil, err
+	}
+	info := &store.MediaInfo{}
+	info.Duration, _ = strconv.ParseFloat(probed.Format.Duration, 64)
+	var subtitles []store.SubtitleTrack
+	subtitleIndex := 0
+	for _, stream := range probed.Streams {
+		switch {
+		case stream.CodecType == "subtitle":
+			if textSubtitles[stream.CodecName] {
+				label := stream.Tags.Title
+				if label == "" {
+					label = stream.Tags.Language
+				}
+				if label == "" {
+					label = fmt.Sprintf("Track %d", subtitleIndex+1)
+				}
+				subtitles = append(subtitles, store.SubtitleTrack{Index: subtitleIndex, Label: label})
+			}
+			subtitleIndex++
+		case stream.CodecType == "video" && info.VideoCodec == "":
+			info.VideoCodec = stream.CodecName
+			info.Width, info.Height = stream.Width, stream.Height
+		case stream.CodecType == "audio" && info.AudioCodec == "":
+			info.AudioCodec = stream.CodecName
+		}
+	}
+	info.Subtitles = store.JoinSubtitleTracks(subtitles)
+	return info, nil
+}
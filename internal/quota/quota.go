@@ -0,0 +1,128 @@
+// Package quota limits how much the links to each user's files are
+// downloaded per day, so one user sharing a popular file can't use up the
+// bandwidth of everyone else. Usage is counted in the store by UTC day, so
+// instances sharing a Redis or Postgres store share it.
+package quota
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"errors"
+	"strconv"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Limits is how much the links to a user's files may be downloaded per day,
+// with 0 for no limit.
+type Limits struct {
+	Bytes     int64
+	Downloads int64
+}
+
+// Usage is how much the links to a user's files were downloaded today.
+type Usage struct {
+	Bytes     int64
+	Downloads int64
+}
+
+// For returns the limits of the user, the ones the owner set with /quota or
+// else QUOTA_MB and QUOTA_DOWNLOADS.
+func For(userID int64) (Limits, error) {
+	limits := Limits{
+		Bytes:     int64(config.ValueOf.QuotaMB) << 20,
+		Downloads: int64(config.ValueOf.QuotaDownloads),
+	}
+	override, err := store.GetStore().GetUserQuota(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return limits, nil
+	} else if err != nil {
+		return Limits{}, err
+	}
+	limits.Bytes = pick(override.BytesPerDay, limits.Bytes)
+	limits.Downloads = pick(override.DownloadsPerDay, limits.Downloads)
+	return limits, nil
+}
+
+// pick returns the limit set for a user over the default one.
+func pick(set int64, fallback int64) int64 {
+	switch {
+	case set == store.QuotaUnlimited:
+		return 0
+	case set > 0:
+		return set
+	}
+	return fallback
+}
+
+// Set overrides the limits of the user, with 0 for QUOTA_MB or
+// QUOTA_DOWNLOADS and store.QuotaUnlimited for no limit.
+func Set(userID int64, bytesPerDay int64, downloadsPerDay int64) error {
+	return store.GetStore().SetUserQuota(&store.UserQuota{
+		UserID:          userID,
+		BytesPerDay:     bytesPerDay,
+		DownloadsPerDay: downloadsPerDay,
+	})
+}
+
+// Used returns how much the links to the user's files were downloaded
+// today.
+func Used(userID int64) (Usage, error) {
+	bytes, err := add(bytesKey(userID), 0)
+	if err != nil {
+		return Usage{}, err
+	}
+	downloads, err := add(downloadsKey(userID), 0)
+	return Usage{Bytes: bytes, Downloads: downloads}, err
+}
+
+// Exceeded reports how long until the user's quota resets if the links to
+// their files were downloaded as much as it allows today, or 0 if they may
+// be downloaded more.
+func Exceeded(userID int64) (time.Duration, error) {
+	limits, err := For(userID)
+	if err != nil || (limits.Bytes <= 0 && limits.Downloads <= 0) {
+		return 0, err
+	}
+	usage, err := Used(userID)
+	if err != nil {
+		return 0, err
+	}
+	if (limits.Bytes > 0 && usage.Bytes >= limits.Bytes) || (limits.Downloads > 0 && usage.Downloads >= limits.Downloads) {
+		return ResetIn(), nil
+	}
+	return 0, nil
+}
+
+// AddDownload counts a download of one of the user's files.
+func AddDownload(userID int64) error {
+	_, err := add(downloadsKey(userID), 1)
+	return err
+}
+
+// AddBytes counts n bytes sent of the user's files.
+func AddBytes(userID int64, n int64) error {
+	_, err := add(bytesKey(userID), n)
+	return err
+}
+
+// ResetIn returns how long until the quotas reset, at midnight UTC.
+func ResetIn() time.Duration {
+	now := time.Now()
+	return now.Truncate(day).Add(day).Sub(now)
+}
+
+// add adds delta to today's count under key and returns it.
+func add(key string, delta int64) (int64, error) {
+	current, _, err := store.GetStore().IncrWindow(key, time.Now(), day, delta)
+	return current, err
+}
+
+func bytesKey(userID int64) string {
+	return "quota:bytes:" + strconv.FormatInt(userID, 10)
+}
+
+func downloadsKey(userID int64) string {
+	return "quota:downloads:" + strconv.FormatInt(userID, 10)
+}
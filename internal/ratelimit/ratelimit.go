@@ -0,0 +1,74 @@
+// Package ratelimit limits how often something happens with sliding windows
+// kept in the store, so instances sharing a Redis or Postgres store share
+// their limits instead of each of them allowing the whole rate. A sliding
+// window is estimated from the counts of the current fixed window and the
+// one before it, weighing the previous one by how much of it the sliding
+// window still covers.
+package ratelimit
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"time"
+)
+
+// Hit counts a hit under key and reports how long to wait until another one
+// is allowed if more than limit hits happened within the last window, or 0
+// if this one is allowed. Hits that aren't allowed are counted too, so
+// clients that keep trying stay limited.
+func Hit(key string, limit int, window time.Duration) (time.Duration, error) {
+	current, previous, elapsed, err := counts(key, window, 1)
+	if err != nil {
+		return 0, err
+	}
+	if estimate(current, previous, elapsed, window) <= float64(limit) {
+		return 0, nil
+	}
+	// the next hit is allowed once counting it keeps the hits within limit
+	return until(current, previous, elapsed, window, float64(limit-1)), nil
+}
+
+// Add counts a hit under key without checking a limit.
+func Add(key string, window time.Duration) error {
+	_, _, _, err := counts(key, window, 1)
+	return err
+}
+
+// Reached reports how long until fewer than limit hits happened under key
+// within the last window, or 0 if fewer did already.
+func Reached(key string, limit int, window time.Duration) (time.Duration, error) {
+	current, previous, elapsed, err := counts(key, window, 0)
+	if err != nil {
+		return 0, err
+	}
+	if estimate(current, previous, elapsed, window) < float64(limit) {
+		return 0, nil
+	}
+	return until(current, previous, elapsed, window, float64(limit)), nil
+}
+
+// counts adds delta hits under key, and returns the hits in the current and
+// previous fixed windows, and how far into the current one it is.
+func counts(key string, window time.Duration, delta int64) (int64, int64, time.Duration, error) {
+	now := time.Now()
+	current, previous, err := store.GetStore().IncrWindow(key, now, window, delta)
+	return current, previous, time.Duration(now.UnixNano() % int64(window)), err
+}
+
+// estimate returns the hits in the window that ends elapsed into the current
+// fixed window, taking the previous window's hits as spread evenly over it.
+func estimate(current int64, previous int64, elapsed time.Duration, window time.Duration) float64 {
+	covered := 1 - float64(elapsed)/float64(window)
+	return float64(previous)*covered + float64(current)
+}
+
+// until returns how long until the estimate drops under limit, when no more
+// hits happen.
+func until(current int64, previous int64, elapsed time.Duration, window time.Duration, limit float64) time.Duration {
+	covered := 1 - float64(elapsed)/float64(window)
+	if float64(current) < limit {
+		// the previous window's hits slide out of it before this one is over
+		return time.Duration(float64(window) * (covered - (limit-float64(current))/float64(previous)))
+	}
+	// this window's hits have to slide out of the next one
+	return window - elapsed + time.Duration(float64(window)*(1-limit/float64(current)))
+}
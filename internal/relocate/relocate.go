@@ -0,0 +1,133 @@
+// Package relocate moves files between storage channels, to spread them
+// over channels or to empty one before it's retired. The links to a moved
+// file keep working, as they are remapped to where it was moved.
+package relocate
+
+import (
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/evict"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrUnknownChannel is returned for destinations that aren't storage
+	// channels, or that belong to a group.
+	ErrUnknownChannel = errors.New("not a storage channel")
+	// ErrSameChannel is returned when the file already is in the
+	// destination channel.
+	ErrSameChannel = errors.New("the file already is in that channel")
+	// ErrNotInChannel is returned for files kept in the object store alone.
+	ErrNotInChannel = errors.New("the file isn't stored in a channel")
+	// ErrGroupFile is returned for files of a group, which are only served
+	// to its members from the group's channel.
+	ErrGroupFile = errors.New("files of groups can't be moved")
+)
+
+// Result is where a file was copied to, and whether the message it was
+// moved out of was deleted.
+type Result struct {
+	File *store.FileEntry `json:"file"`
+	// SourceDeleted is false for copies, and for moves that couldn't
+	// delete the original message, which is left in its channel.
+	SourceDeleted bool `json:"source_deleted"`
+}
+
+// Move copies the message of the indexed file entry to the storage channel
+// channelID through api. Unless keep is set, the links to the file are then
+// pointed at the copy, and the original message is deleted. With keep the
+// copy is indexed as a file of its own, with links of its own.
+func Move(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, entry *store.FileEntry, channelID int64, keep bool) (*Result, error) {
+	if entry.Backend == store.BackendS3 {
+		return nil, ErrNotInChannel
+	}
+	if _, ok := channels.GroupOf(entry.ChannelID); ok {
+		return nil, ErrGroupFile
+	}
+	if channelID == entry.ChannelID {
+		return nil, ErrSameChannel
+	}
+	if _, ok := channels.GroupOf(channelID); ok || !channels.IsAllowed(channelID) {
+		return nil, ErrUnknownChannel
+	}
+	messageID, err := copyMessage(ctx, api, peerStorage, entry, channelID)
+	if err != nil {
+		return nil, err
+	}
+	moved := *entry
+	moved.ChannelID, moved.MessageID = channelID, messageID
+	if keep {
+		// a copy is a file of its own, which its uploader looks up by the
+		// message they sent
+		moved.SourceMessageID = 0
+		if err := store.GetStore().IndexFile(&moved); err != nil {
+			return nil, err
+		}
+		return &Result{File: &moved}, nil
+	}
+	err = store.GetStore().MoveFile(&moved, &store.FileAlias{
+		ChannelID:       entry.ChannelID,
+		MessageID:       entry.MessageID,
+		TargetChannelID: channelID,
+		TargetMessageID: messageID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	evict.Publish(evict.Event{ChannelID: entry.ChannelID, MessageID: entry.MessageID, Reason: evict.Deleted})
+	result := &Result{File: &moved, SourceDeleted: true}
+	if err := deleteMessage(ctx, api, peerStorage, entry); err != nil {
+		utils.Logger.Warn("Failed to delete moved message",
+			zap.Int64("channelID", entry.ChannelID), zap.Int("messageID", entry.MessageID), zap.Error(err))
+		result.SourceDeleted = false
+	}
+	return result, nil
+}
+
+// copyMessage sends a copy of the file's message to channelID, without the
+// header of a forwarded message, and returns the ID of the copy.
+func copyMessage(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, entry *store.FileEntry, channelID int64) (int, error) {
+	from, err := utils.GetChannelPeer(ctx, api, peerStorage, entry.ChannelID)
+	if err != nil {
+		return 0, err
+	}
+	to, err := utils.GetChannelPeer(ctx, api, peerStorage, channelID)
+	if err != nil {
+		return 0, err
+	}
+	updates, err := api.MessagesForwardMessages(ctx, &tg.MessagesForwardMessagesRequest{
+		RandomID:   []int64{rand.Int63()},
+		FromPeer:   &tg.InputPeerChannel{ChannelID: from.ChannelID, AccessHash: from.AccessHash},
+		ID:         []int{entry.MessageID},
+		ToPeer:     &tg.InputPeerChannel{ChannelID: to.ChannelID, AccessHash: to.AccessHash},
+		DropAuthor: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to copy message %d to channel %d: %w", entry.MessageID, channelID, err)
+	}
+	if updates, ok := updates.(*tg.Updates); ok {
+		for _, update := range updates.Updates {
+			if update, ok := update.(*tg.UpdateNewChannelMessage); ok {
+				return update.Message.GetID(), nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no message in the response to copying message %d", entry.MessageID)
+}
+
+func deleteMessage(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, entry *store.FileEntry) error {
+	channel, err := utils.GetChannelPeer(ctx, api, peerStorage, entry.ChannelID)
+	if err != nil {
+		return err
+	}
+	_, err = api.ChannelsDeleteMessages(ctx, &tg.ChannelsDeleteMessagesRequest{Channel: channel, ID: []int{entry.MessageID}})
+	return err
+}
@@ -0,0 +1,206 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+)
+
+const abortIndex = math.MaxInt / 2
+
+// Param is a named path parameter, like :messageID in /stream/:messageID.
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Context carries a request through its handlers.
+type Context struct {
+	Writer  ResponseWriter
+	Request *http.Request
+
+	params   []Param
+	fullPath string
+	clientIP func() string
+	handlers []HandlerFunc
+	index    int
+	query    url.Values
+	rawQuery string
+}
+
+func newContext(w ResponseWriter, r *http.Request, fullPath string, params []Param, handlers []HandlerFunc) *Context {
+	return &Context{
+		Writer:   w,
+		Request:  r,
+		params:   params,
+		fullPath: fullPath,
+		handlers: handlers,
+		index:    -1,
+	}
+}
+
+// Next runs the handlers after the current one.
+func (ctx *Context) Next() {
+	ctx.index++
+	for ctx.index < len(ctx.handlers) {
+		ctx.handlers[ctx.index](ctx)
+		ctx.index++
+	}
+}
+
+// Abort stops the handlers after the current one from running.
+func (ctx *Context) Abort() {
+	ctx.index = abortIndex
+}
+
+func (ctx *Context) IsAborted() bool {
+	return ctx.index >= abortIndex
+}
+
+func (ctx *Context) AbortWithStatus(code int) {
+	ctx.Writer.WriteHeader(code)
+	ctx.Abort()
+}
+
+func (ctx *Context) AbortWithStatusJSON(code int, value any) {
+	ctx.Abort()
+	ctx.JSON(code, value)
+}
+
+// FullPath is the route the request matched, like /stream/:messageID, or
+// empty when it matched none.
+func (ctx *Context) FullPath() string {
+	return ctx.fullPath
+}
+
+func (ctx *Context) Param(key string) string {
+	for _, param := range ctx.params {
+		if param.Key == key {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+func (ctx *Context) queryValues() url.Values {
+	// middleware may rewrite the query before the handlers read it
+	if ctx.query == nil || ctx.rawQuery != ctx.Request.URL.RawQuery {
+		ctx.rawQuery = ctx.Request.URL.RawQuery
+		ctx.query = ctx.Request.URL.Query()
+	}
+	return ctx.query
+}
+
+func (ctx *Context) Query(key string) string {
+	return ctx.queryValues().Get(key)
+}
+
+func (ctx *Context) QueryArray(key string) []string {
+	return ctx.queryValues()[key]
+}
+
+func (ctx *Context) GetHeader(key string) string {
+	return ctx.Request.Header.Get(key)
+}
+
+// Header sets a response header, removing it when value is empty.
+func (ctx *Context) Header(key string, value string) {
+	if value == "" {
+		ctx.Writer.Header().Del(key)
+		return
+	}
+	ctx.Writer.Header().Set(key, value)
+}
+
+// ClientIP is the address of the client, read from X-Forwarded-For or
+// X-Real-IP when the request came through one of the trusted proxies.
+func (ctx *Context) ClientIP() string {
+	if ctx.clientIP != nil {
+		return ctx.clientIP()
+	}
+	return clientIP(ctx.Request)
+}
+
+// FromTrustedProxy reports whether the request came through one of the
+// trusted proxies, whose other headers can be believed too.
+func (ctx *Context) FromTrustedProxy() bool {
+	return isTrustedProxy(remoteIP(ctx.Request))
+}
+
+// EarlyHints sends a 103 Early Hints response with the given Link header
+// values, so browsers can start fetching them while the final response is
+// being prepared. The links are kept on the final response as well.
+func (ctx *Context) EarlyHints(links ...string) {
+	if len(links) == 0 || !ctx.Request.ProtoAtLeast(1, 1) || ctx.Writer.Written() {
+		return
+	}
+	for _, link := range links {
+		ctx.Writer.Header().Add("Link", link)
+	}
+	// the writers hold back the status until the body is written, so the
+	// hints have to skip them
+	var w http.ResponseWriter = ctx.Writer
+	if unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter }); ok {
+		w = unwrapper.Unwrap()
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+}
+
+func (ctx *Context) Data(code int, contentType string, data []byte) {
+	ctx.Header("Content-Type", contentType)
+	ctx.Writer.WriteHeader(code)
+	ctx.Writer.Write(data)
+}
+
+func (ctx *Context) String(code int, format string, values ...any) {
+	ctx.Header("Content-Type", "text/plain; charset=utf-8")
+	ctx.Writer.WriteHeader(code)
+	if len(values) > 0 {
+		fmt.Fprintf(ctx.Writer, format, values...)
+		return
+	}
+	io.WriteString(ctx.Writer, format)
+}
+
+func (ctx *Context) JSON(code int, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Data(code, "application/json; charset=utf-8", data)
+}
+
+// Stream calls step until it returns false or the client goes away,
+// flushing after every call. It reports whether the client went away.
+func (ctx *Context) Stream(step func(w io.Writer) bool) bool {
+	done := ctx.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return true
+		default:
+			keepOpen := step(ctx.Writer)
+			ctx.Writer.Flush()
+			if !keepOpen {
+				return false
+			}
+		}
+	}
+}
+
+// SSEvent writes a server-sent event with the value encoded as JSON.
+func (ctx *Context) SSEvent(name string, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	header := ctx.Writer.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "text/event-stream")
+	}
+	fmt.Fprintf(ctx.Writer, "event:%s\ndata:%s\n\n", name, data)
+}
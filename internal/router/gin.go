@@ -0,0 +1,52 @@
+//go:build !nogin
+
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	engines["gin"] = newGinEngine
+}
+
+type ginEngine struct {
+	*gin.Engine
+}
+
+func newGinEngine(dev bool) engine {
+	if dev {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	e := gin.New()
+	// gin trusts every proxy unless told otherwise
+	proxies := make([]string, len(trustedProxies))
+	for i, prefix := range trustedProxies {
+		proxies[i] = prefix.String()
+	}
+	e.SetTrustedProxies(proxies)
+	e.Use(gin.Logger(), gin.Recovery())
+	return ginEngine{e}
+}
+
+func (e ginEngine) handle(method string, path string, handlers []HandlerFunc) {
+	e.Engine.Handle(method, path, ginHandler(handlers))
+}
+
+func (e ginEngine) noRoute(handlers []HandlerFunc) {
+	e.Engine.NoRoute(ginHandler(append(append([]HandlerFunc{}, handlers...), notFound)))
+}
+
+func ginHandler(handlers []HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		params := make([]Param, len(c.Params))
+		for i, param := range c.Params {
+			params[i] = Param{Key: param.Key, Value: param.Value}
+		}
+		ctx := newContext(c.Writer, c.Request, c.FullPath(), params, handlers)
+		ctx.clientIP = c.ClientIP
+		ctx.Next()
+	}
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the ranges of the proxies whose X-Forwarded-For and
+// X-Real-IP headers are believed. Requests from other addresses could send
+// any address in them.
+var trustedProxies []netip.Prefix
+
+// SetTrustedProxies sets the addresses or CIDR ranges of the proxies in
+// front of the server, for the routers created after it. Without any, the
+// client is the address the request came from.
+func SetTrustedProxies(proxies []string) error {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return fmt.Errorf("%q is not an IP address or CIDR range", proxy)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	trustedProxies = prefixes
+	return nil
+}
+
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// clientIP returns the address of the client. Behind a trusted proxy it's
+// the last address in X-Forwarded-For, or else X-Real-IP, that isn't one of
+// the trusted proxies, as the addresses before it could be made up by the
+// client. This is how gin finds it too, so both engines agree.
+func clientIP(r *http.Request) string {
+	remote := net.ParseIP(remoteIP(r))
+	if remote == nil {
+		return ""
+	}
+	if isTrustedProxy(remote.String()) {
+		for _, header := range []string{"X-Forwarded-For", "X-Real-Ip"} {
+			if ip, ok := forwardedIP(r.Header.Get(header)); ok {
+				return ip
+			}
+		}
+	}
+	return remote.String()
+}
+
+func forwardedIP(header string) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+	items := strings.Split(header, ",")
+	for i := len(items) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(items[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if i == 0 || !isTrustedProxy(ip) {
+			return ip, true
+		}
+	}
+	return "", false
+}
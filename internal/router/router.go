@@ -0,0 +1,134 @@
+// Package router is the thin HTTP layer the routes are written against, so
+// the engine underneath can be swapped. It ships with a gin engine and one
+// built only on net/http for builds that have to stay on the standard
+// library; building with the nogin tag leaves gin out of the binary.
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// HandlerFunc handles a request or, when it calls Context.Next, wraps the
+// handlers after it like a middleware.
+type HandlerFunc func(*Context)
+
+// Routes registers handlers on a router or a group of its routes.
+type Routes interface {
+	// Use adds middleware to the routes registered after it.
+	Use(handlers ...HandlerFunc)
+	Group(prefix string, handlers ...HandlerFunc) Routes
+	Handle(method string, path string, handlers ...HandlerFunc)
+	GET(path string, handlers ...HandlerFunc)
+	POST(path string, handlers ...HandlerFunc)
+}
+
+// Router is the root of the routes, serving them over HTTP.
+type Router interface {
+	Routes
+	http.Handler
+}
+
+// engine matches requests to the routes registered on it.
+type engine interface {
+	http.Handler
+	handle(method string, path string, handlers []HandlerFunc)
+	// noRoute sets the middleware run for requests matching no route.
+	noRoute(handlers []HandlerFunc)
+}
+
+var engines = map[string]func(dev bool) engine{
+	"stdlib": newStdEngine,
+}
+
+// Engines lists the engines built into the binary.
+func Engines() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns a router using the named engine, with request logging and
+// panic recovery. Dev makes the engine log its routes.
+func New(name string, dev bool) (Router, error) {
+	newEngine, ok := engines[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown HTTP router %q, available: %s", name, strings.Join(Engines(), ", "))
+	}
+	e := newEngine(dev)
+	return &root{e, &group{engine: e, root: true}}, nil
+}
+
+type root struct {
+	http.Handler
+	*group
+}
+
+type group struct {
+	engine   engine
+	prefix   string
+	handlers []HandlerFunc
+	root     bool
+}
+
+func (g *group) Use(handlers ...HandlerFunc) {
+	g.handlers = append(g.handlers, handlers...)
+	if g.root {
+		g.engine.noRoute(g.handlers)
+	}
+}
+
+func (g *group) Group(prefix string, handlers ...HandlerFunc) Routes {
+	return &group{
+		engine:   g.engine,
+		prefix:   joinPaths(g.prefix, prefix),
+		handlers: g.combine(handlers),
+	}
+}
+
+func (g *group) Handle(method string, path string, handlers ...HandlerFunc) {
+	g.engine.handle(method, joinPaths(g.prefix, path), g.combine(handlers))
+}
+
+func (g *group) GET(path string, handlers ...HandlerFunc) {
+	g.Handle(http.MethodGet, path, handlers...)
+}
+
+func (g *group) POST(path string, handlers ...HandlerFunc) {
+	g.Handle(http.MethodPost, path, handlers...)
+}
+
+// combine copies the handlers so routes registered later can't overwrite
+// each other's.
+func (g *group) combine(handlers []HandlerFunc) []HandlerFunc {
+	combined := make([]HandlerFunc, 0, len(g.handlers)+len(handlers))
+	combined = append(combined, g.handlers...)
+	return append(combined, handlers...)
+}
+
+func joinPaths(prefix string, relative string) string {
+	if relative == "" {
+		return prefix
+	}
+	joined := path.Join(prefix, relative)
+	if strings.HasSuffix(relative, "/") && !strings.HasSuffix(joined, "/") {
+		joined += "/"
+	}
+	if !strings.HasPrefix(joined, "/") {
+		joined = "/" + joined
+	}
+	return joined
+}
+
+// notFound ends the middleware chain of requests matching no route.
+func notFound(ctx *Context) {
+	if !ctx.Writer.Written() {
+		ctx.String(http.StatusNotFound, "404 page not found")
+	}
+}
@@ -0,0 +1,292 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRoutes registers the routes the engines are tested against.
+func testRoutes(r Router) {
+	r.Use(func(ctx *Context) {
+		ctx.Header("X-Root", "1")
+		ctx.Next()
+	})
+	r.GET("/stream/:messageID", func(ctx *Context) {
+		ctx.String(http.StatusOK, "id=%s route=%s", ctx.Param("messageID"), ctx.FullPath())
+	})
+	r.GET("/stream/latest", func(ctx *Context) {
+		ctx.String(http.StatusOK, "latest")
+	})
+	r.GET("/static/*path", func(ctx *Context) {
+		ctx.String(http.StatusOK, ctx.Param("path"))
+	})
+	r.GET("/query", func(ctx *Context) {
+		ctx.String(http.StatusOK, "%s %v", ctx.Query("name"), ctx.QueryArray("tag"))
+	})
+	r.POST("/data", func(ctx *Context) {
+		ctx.Header("X-Empty", "")
+		ctx.Data(http.StatusCreated, "application/octet-stream", []byte("created"))
+	})
+	r.GET("/file", func(ctx *Context) {
+		http.ServeContent(ctx.Writer, ctx.Request, "file.txt", time.Time{}, strings.NewReader("hello world"))
+	})
+	r.GET("/ip", func(ctx *Context) {
+		ctx.String(http.StatusOK, ctx.ClientIP())
+	})
+
+	chain := r.Group("/chain", func(ctx *Context) {
+		ctx.Writer.Write([]byte("a"))
+		ctx.Next()
+		ctx.Writer.Write([]byte("c"))
+	})
+	chain.GET("/next", func(ctx *Context) {
+		ctx.Writer.Write([]byte("b"))
+	})
+	admin := r.Group("/admin", func(ctx *Context) {
+		if ctx.GetHeader("Authorization") != "Bearer token" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		ctx.Next()
+	})
+	admin.GET("/stats", func(ctx *Context) {
+		if ctx.IsAborted() {
+			panic("ran after the chain was aborted")
+		}
+		ctx.JSON(http.StatusOK, map[string]int{"streams": 1})
+	})
+	admin.GET("/status", func(ctx *Context) {
+		ctx.AbortWithStatus(http.StatusNoContent)
+	})
+}
+
+func TestEngines(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		header  http.Header
+		status  int
+		headers map[string]string
+		body    string
+	}{
+		{
+			name:   "param",
+			method: http.MethodGet,
+			path:   "/stream/42",
+			status: http.StatusOK,
+			body:   "id=42 route=/stream/:messageID",
+		},
+		{
+			name:   "static before param",
+			method: http.MethodGet,
+			path:   "/stream/latest",
+			status: http.StatusOK,
+			body:   "latest",
+		},
+		{
+			name:   "wildcard",
+			method: http.MethodGet,
+			path:   "/static/js/app.js",
+			status: http.StatusOK,
+			body:   "/js/app.js",
+		},
+		{
+			name:   "query",
+			method: http.MethodGet,
+			path:   "/query?name=x&tag=a&tag=b",
+			status: http.StatusOK,
+			body:   "x [a b]",
+		},
+		{
+			name:    "status and headers",
+			method:  http.MethodPost,
+			path:    "/data",
+			status:  http.StatusCreated,
+			headers: map[string]string{"Content-Type": "application/octet-stream", "X-Root": "1", "X-Empty": ""},
+			body:    "created",
+		},
+		{
+			name:   "next",
+			method: http.MethodGet,
+			path:   "/chain/next",
+			status: http.StatusOK,
+			body:   "abc",
+		},
+		{
+			name:    "abort",
+			method:  http.MethodGet,
+			path:    "/admin/stats",
+			status:  http.StatusUnauthorized,
+			headers: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+			body:    `{"error":"unauthorized"}`,
+		},
+		{
+			name:   "authorized",
+			method: http.MethodGet,
+			path:   "/admin/stats",
+			header: http.Header{"Authorization": {"Bearer token"}},
+			status: http.StatusOK,
+			body:   `{"streams":1}`,
+		},
+		{
+			name:   "abort with status",
+			method: http.MethodGet,
+			path:   "/admin/status",
+			header: http.Header{"Authorization": {"Bearer token"}},
+			status: http.StatusNoContent,
+		},
+		{
+			name:    "no route",
+			method:  http.MethodGet,
+			path:    "/missing",
+			status:  http.StatusNotFound,
+			headers: map[string]string{"X-Root": "1"},
+			body:    "404 page not found",
+		},
+		{
+			name:   "wrong method",
+			method: http.MethodPost,
+			path:   "/stream/42",
+			status: http.StatusNotFound,
+		},
+		{
+			name:    "whole file",
+			method:  http.MethodGet,
+			path:    "/file",
+			status:  http.StatusOK,
+			headers: map[string]string{"Accept-Ranges": "bytes", "Content-Length": "11"},
+			body:    "hello world",
+		},
+		{
+			name:    "range",
+			method:  http.MethodGet,
+			path:    "/file",
+			header:  http.Header{"Range": {"bytes=0-4"}},
+			status:  http.StatusPartialContent,
+			headers: map[string]string{"Content-Range": "bytes 0-4/11"},
+			body:    "hello",
+		},
+		{
+			name:    "suffix range",
+			method:  http.MethodGet,
+			path:    "/file",
+			header:  http.Header{"Range": {"bytes=-5"}},
+			status:  http.StatusPartialContent,
+			headers: map[string]string{"Content-Range": "bytes 6-10/11"},
+			body:    "world",
+		},
+		{
+			name:    "unsatisfiable range",
+			method:  http.MethodGet,
+			path:    "/file",
+			header:  http.Header{"Range": {"bytes=20-"}},
+			status:  http.StatusRequestedRangeNotSatisfiable,
+			headers: map[string]string{"Content-Range": "bytes */11"},
+		},
+		{
+			name:   "client address",
+			method: http.MethodGet,
+			path:   "/ip",
+			// not read without trusted proxies
+			header: http.Header{"X-Forwarded-For": {"203.0.113.7"}},
+			status: http.StatusOK,
+			body:   "192.0.2.1",
+		},
+	}
+	for _, name := range Engines() {
+		r, err := New(name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRoutes(r)
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(tt.method, tt.path, nil)
+				for key, values := range tt.header {
+					req.Header[key] = values
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if w.Code != tt.status {
+					t.Errorf("status = %d, want %d", w.Code, tt.status)
+				}
+				for key, want := range tt.headers {
+					if got := w.Header().Get(key); got != want {
+						t.Errorf("header %s = %q, want %q", key, got, want)
+					}
+				}
+				if tt.body != "" && w.Body.String() != tt.body {
+					t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+				}
+			})
+		}
+	}
+}
+
+func TestMultipleRanges(t *testing.T) {
+	for _, name := range Engines() {
+		t.Run(name, func(t *testing.T) {
+			r, err := New(name, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testRoutes(r)
+			req := httptest.NewRequest(http.MethodGet, "/file", nil)
+			req.Header.Set("Range", "bytes=0-1,6-7")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusPartialContent {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusPartialContent)
+			}
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "multipart/byteranges; boundary=") {
+				t.Errorf("Content-Type = %q, want multipart/byteranges", contentType)
+			}
+			for _, part := range []string{"Content-Range: bytes 0-1/11\r\n", "\r\n\r\nhe\r\n", "Content-Range: bytes 6-7/11\r\n", "\r\n\r\nwo\r\n"} {
+				if !strings.Contains(w.Body.String(), part) {
+					t.Errorf("body is missing %q", part)
+				}
+			}
+		})
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	if err := SetTrustedProxies([]string{"192.0.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies(nil)
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"trusted proxy", "192.0.2.1:1234", "203.0.113.7", "203.0.113.7"},
+		{"trusted proxies in the chain", "192.0.2.1:1234", "203.0.113.7, 192.0.2.9", "203.0.113.7"},
+		{"spoofed by the client", "192.0.2.1:1234", "198.51.100.1, 203.0.113.7", "203.0.113.7"},
+		{"untrusted", "198.51.100.1:1234", "203.0.113.7", "198.51.100.1"},
+	}
+	for _, name := range Engines() {
+		r, err := New(name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testRoutes(r)
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+				req.RemoteAddr = tt.remoteAddr
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				if got := w.Body.String(); got != tt.want {
+					t.Errorf("client address = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
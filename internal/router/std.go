@@ -0,0 +1,137 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// stdEngine matches routes using nothing but the standard library. Like gin,
+// static segments take precedence over :params, which take precedence over
+// *wildcards, and the method has to match exactly.
+type stdEngine struct {
+	dev     bool
+	routes  map[string][]*stdRoute
+	missing []HandlerFunc
+}
+
+type stdRoute struct {
+	path     string
+	segments []string
+	handlers []HandlerFunc
+}
+
+func newStdEngine(dev bool) engine {
+	return &stdEngine{dev: dev, routes: make(map[string][]*stdRoute), missing: []HandlerFunc{notFound}}
+}
+
+func (e *stdEngine) handle(method string, path string, handlers []HandlerFunc) {
+	route := &stdRoute{path: path, segments: splitPath(path), handlers: handlers}
+	for _, existing := range e.routes[method] {
+		if existing.path == path {
+			panic(fmt.Sprintf("router: %s %s is already registered", method, path))
+		}
+	}
+	if e.dev {
+		fmt.Fprintf(os.Stdout, "[HTTP-debug] %-6s %s (%d handlers)\n", method, path, len(handlers))
+	}
+	routes := append(e.routes[method], route)
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].precedes(routes[j])
+	})
+	e.routes[method] = routes
+}
+
+func (e *stdEngine) noRoute(handlers []HandlerFunc) {
+	e.missing = append(append([]HandlerFunc{}, handlers...), notFound)
+}
+
+func (e *stdEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writer := newResponseWriter(w)
+	start := time.Now()
+	defer func() {
+		if err := recover(); err != nil {
+			if errors.Is(asError(err), http.ErrAbortHandler) {
+				panic(err)
+			}
+			fmt.Fprintf(os.Stderr, "[HTTP] panic recovered: %v\n%s", err, debug.Stack())
+			if !writer.Written() {
+				writer.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+		writer.writeHeaderNow()
+		fmt.Fprintf(os.Stdout, "[HTTP] %v | %3d | %13v | %15s | %-7s %#v\n",
+			start.Format("2006/01/02 - 15:04:05"),
+			writer.Status(),
+			time.Since(start),
+			clientIP(r),
+			r.Method,
+			r.URL.Path,
+		)
+	}()
+	segments := splitPath(r.URL.Path)
+	for _, route := range e.routes[r.Method] {
+		if params, ok := route.match(segments); ok {
+			newContext(writer, r, route.path, params, route.handlers).Next()
+			return
+		}
+	}
+	newContext(writer, r, "", nil, e.missing).Next()
+}
+
+func asError(value any) error {
+	err, _ := value.(error)
+	return err
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "/"), "/")
+}
+
+func segmentRank(segment string) int {
+	switch {
+	case strings.HasPrefix(segment, "*"):
+		return 2
+	case strings.HasPrefix(segment, ":"):
+		return 1
+	}
+	return 0
+}
+
+// precedes reports whether route should be tried before other.
+func (route *stdRoute) precedes(other *stdRoute) bool {
+	for i := 0; i < len(route.segments) && i < len(other.segments); i++ {
+		a, b := segmentRank(route.segments[i]), segmentRank(other.segments[i])
+		if a != b {
+			return a < b
+		}
+	}
+	return len(route.segments) > len(other.segments)
+}
+
+func (route *stdRoute) match(segments []string) ([]Param, bool) {
+	var params []Param
+	for i, pattern := range route.segments {
+		if strings.HasPrefix(pattern, "*") {
+			value := "/" + strings.Join(segments[min(i, len(segments)):], "/")
+			return append(params, Param{Key: pattern[1:], Value: value}), true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(pattern, ":") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params = append(params, Param{Key: pattern[1:], Value: segments[i]})
+		} else if pattern != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(route.segments)
+}
@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (e *allRoutes) LoadAdmin(r *Route) {
+	log := e.log.Named("Admin")
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, admin API disabled")
+		return
+	}
+	defer log.Info("Loaded admin routes")
+	admin := r.Admin.Group("/api/admin", limitRequest(int64(config.ValueOf.MaxBodyKB)<<10, http.MethodGet), adminAuth)
+	admin.GET("/files", listFilesRoute)
+	admin.GET("/streams", listStreamsRoute)
+	admin.GET("/workers", listWorkersRoute)
+}
+
+// adminAuth checks the admin token. Without one the admin API is only served
+// on the mTLS listener, where the client certificate already authenticated
+// the request.
+func adminAuth(ctx *router.Context) {
+	if config.ValueOf.AdminToken == "" {
+		ctx.Next()
+		return
+	}
+	if !hasAdminToken(ctx) {
+		abortWithError(ctx, http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+	ctx.Next()
+}
+
+// hasAdminToken reports whether the request sent the admin token, which it
+// never did when there is none.
+func hasAdminToken(ctx *router.Context) bool {
+	if config.ValueOf.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.ValueOf.AdminToken)) == 1
+}
+
+func listFilesRoute(ctx *router.Context) {
+	limit, cursor, ok := pageParams(ctx)
+	if !ok {
+		return
+	}
+	query := store.FileQuery{
+		Text:     ctx.Query("q"),
+		Tag:      ctx.Query("tag"),
+		MimeType: ctx.Query("type"),
+		Limit:    limit + 1,
+	}
+	for param, duration := range map[string]*time.Duration{"min_duration": &query.MinDuration, "max_duration": &query.MaxDuration} {
+		value := ctx.Query(param)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, "invalid "+param)
+			return
+		}
+		*duration = parsed
+	}
+	if uploader := ctx.Query("uploader"); uploader != "" {
+		uploaderID, err := strconv.ParseInt(uploader, 10, 64)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, "invalid uploader")
+			return
+		}
+		query.UploadedBy = uploaderID
+	}
+	if cursor != "" {
+		query.After = &store.FileCursor{}
+		if err := utils.DecodeCursor(cursor, query.After); err != nil {
+			abortWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	entries, err := store.GetStore().SearchFiles(query)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page := types.Page[*store.FileEntry]{Ok: true, Items: entries}
+	if len(entries) > limit {
+		page.Items = entries[:limit]
+		page.Next = utils.EncodeCursor(entries[limit-1].Cursor())
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func listStreamsRoute(ctx *router.Context) {
+	limit, cursor, ok := pageParams(ctx)
+	if !ok {
+		return
+	}
+	var after uint64
+	if cursor != "" {
+		if err := utils.DecodeCursor(cursor, &after); err != nil {
+			abortWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	streams := stream.ActiveStreams(after, limit+1)
+	page := types.Page[stream.ActiveStream]{Ok: true, Items: streams}
+	if len(streams) > limit {
+		page.Items = streams[:limit]
+		page.Next = utils.EncodeCursor(streams[limit-1].ID)
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+func listWorkersRoute(ctx *router.Context) {
+	started := bot.Workers.List()
+	workers := make([]bot.WorkerStats, 0, len(started))
+	for _, worker := range started {
+		workers = append(workers, worker.Stats())
+	}
+	ctx.JSON(http.StatusOK, types.Page[bot.WorkerStats]{Ok: true, Items: workers})
+}
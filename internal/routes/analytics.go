@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/analytics"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxStatsFiles caps how many files a user's link stats list.
+const maxStatsFiles = 100
+
+// pixelGIF is a transparent 1x1 GIF.
+var pixelGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+func (e *allRoutes) LoadAnalytics(r *Route) {
+	log := e.log.Named("Analytics")
+	defer log.Info("Loaded analytics routes")
+	r.Engine.GET("/pixel/:messageID", pixelRoute)
+	r.Engine.GET("/mystats/:userID", myStatsRoute)
+}
+
+// pixelRoute serves the analytics pixel of a file, counting a view of it
+// for its uploader, for pages and emails that link to the file to tell how
+// often they're seen. The pixel is served whatever the hash, so pages never
+// show a broken image, but only pixels with the file's hash count.
+func pixelRoute(ctx *router.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	if entry := pixelFile(ctx); entry != nil {
+		var country string
+		if config.ValueOf.GeoIPHeader != "" && ctx.FromTrustedProxy() {
+			country = strings.ToUpper(ctx.GetHeader(config.ValueOf.GeoIPHeader))
+		}
+		analytics.View(entry.ChannelID, entry.MessageID, ctx.ClientIP(), country)
+	}
+	ctx.Data(http.StatusOK, "image/gif", pixelGIF)
+}
+
+// pixelFile returns the indexed file the pixel is for, or nil when the
+// request doesn't have its hash.
+func pixelFile(ctx *router.Context) *store.FileEntry {
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		return nil
+	}
+	channelID := config.ValueOf.LogChannelID
+	if channel := ctx.Query("channel"); channel != "" {
+		if channelID, err = strconv.ParseInt(channel, 10, 64); err != nil {
+			return nil
+		}
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if err != nil || !utils.CheckHash(ctx.Query("hash"), linkHash(entry)) {
+		return nil
+	}
+	return entry
+}
+
+// myStatsRoute lists the hits of the links to a user's files, from the
+// signed link /mystats gives them, so they only ever see their own.
+func myStatsRoute(ctx *router.Context) {
+	userID, err := strconv.ParseInt(ctx.Param("userID"), 10, 64)
+	if err != nil || !utils.CheckStats(userID, ctx.Query("sig")) {
+		abortWithError(ctx, http.StatusForbidden, "invalid signature")
+		return
+	}
+	stats, err := analytics.Stats(userID, maxStatsFiles)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, types.Page[types.LinkStats]{Ok: true, Items: stats})
+}
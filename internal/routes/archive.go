@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// maxArchiveFiles caps how many files a single archive can contain.
+const maxArchiveFiles = 100
+
+func (e *allRoutes) LoadArchive(r *Route) {
+	log := e.log.Named("Archive")
+	defer log.Info("Loaded archive routes")
+	r.Engine.GET("/tar", func(ctx *router.Context) { e.getArchiveRoute(ctx, false) })
+	r.Engine.GET("/tar.gz", func(ctx *router.Context) { e.getArchiveRoute(ctx, true) })
+	r.Engine.GET("/zip", e.getZipRoute)
+}
+
+// getArchiveRoute exports the files given as ?f=<messageID>:<hash> (or
+// ?f=<channelID>/<messageID>:<hash> for other storage channels), repeated
+// once per file, as a single tar archive.
+func (e *allRoutes) getArchiveRoute(ctx *router.Context, compress bool) {
+	reqs, ok := archiveRequests(ctx, ctx.QueryArray("f"))
+	if !ok {
+		return
+	}
+	release, ok := e.acquireStream(ctx)
+	if !ok {
+		return
+	}
+	defer release()
+	err := e.stream.ServeTar(ctx.Request.Context(), reqs, compress, ctx.Writer)
+	writeArchiveError(ctx, err)
+}
+
+// getZipRoute exports files as a single zip archive. They are given like
+// for getArchiveRoute, or as a comma separated list in ?ids=, which keeps
+// links to bundles short.
+func (e *allRoutes) getZipRoute(ctx *router.Context) {
+	files := ctx.QueryArray("f")
+	for _, ids := range ctx.QueryArray("ids") {
+		for _, file := range strings.Split(ids, ",") {
+			if file = strings.TrimSpace(file); file != "" {
+				files = append(files, file)
+			}
+		}
+	}
+	reqs, ok := archiveRequests(ctx, files)
+	if !ok {
+		return
+	}
+	release, ok := e.acquireStream(ctx)
+	if !ok {
+		return
+	}
+	defer release()
+	err := e.stream.ServeZip(ctx.Request.Context(), reqs, ctx.Writer)
+	writeArchiveError(ctx, err)
+}
+
+// archiveRequests parses the files of an archive, writing an error response
+// if any of them are invalid.
+func archiveRequests(ctx *router.Context, files []string) ([]*stream.Request, bool) {
+	if lockedOut(ctx) {
+		return nil, false
+	}
+	if len(files) == 0 {
+		http.Error(ctx.Writer, "missing f param", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(files) > maxArchiveFiles {
+		http.Error(ctx.Writer, fmt.Sprintf("an archive can contain at most %d files", maxArchiveFiles), http.StatusBadRequest)
+		return nil, false
+	}
+	reqs := make([]*stream.Request, 0, len(files))
+	for _, file := range files {
+		req, err := parseArchiveFile(file)
+		if err != nil {
+			http.Error(ctx.Writer, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		req.RemoteAddr = ctx.ClientIP()
+		req.Password = linkPassword(ctx)
+		stream.FollowAlias(req)
+		reqs = append(reqs, req)
+	}
+	return reqs, true
+}
+
+func writeArchiveError(ctx *router.Context, err error) {
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		writeStreamError(ctx.Writer, streamErr)
+	} else if err != nil {
+		requestLog(ctx).Error("Error while writing archive", zap.Error(err))
+	}
+}
+
+func parseArchiveFile(file string) (*stream.Request, error) {
+	id, hash, ok := strings.Cut(file, ":")
+	if !ok || hash == "" {
+		return nil, fmt.Errorf("%q: expected <messageID>:<hash>", file)
+	}
+	channelID := config.ValueOf.LogChannelID
+	if channel, message, ok := strings.Cut(id, "/"); ok {
+		var err error
+		channelID, err = strconv.ParseInt(channel, 10, 64)
+		if err != nil || !channels.IsAllowed(channelID) {
+			return nil, fmt.Errorf("%q: unknown channel", file)
+		}
+		id = message
+	}
+	messageID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("%q: invalid message ID", file)
+	}
+	return &stream.Request{ChannelID: channelID, MessageID: messageID, Hash: hash}, nil
+}
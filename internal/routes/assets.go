@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/web"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadAssets(r *Route) {
+	log := e.log.Named("Assets")
+	defer log.Info("Loaded asset routes")
+	r.Engine.GET(web.AssetsPath+":name", getAssetRoute)
+	if web.Dev() {
+		log.Info("Serving the web UI from WEB_DIR", zap.String("dir", config.ValueOf.WebDir))
+		r.Engine.GET(web.ReloadPath, reloadEventsRoute)
+	}
+}
+
+// getAssetRoute serves an asset of the web UI. Assets asked for by their
+// hashed name never change, so browsers may keep them for a year.
+func getAssetRoute(ctx *router.Context) {
+	asset, ok, err := web.GetAsset(ctx.Param("name"))
+	if err != nil {
+		requestLog(ctx).Error("Failed to load web assets", zap.Error(err))
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	if asset.Immutable {
+		ctx.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		ctx.Header("Cache-Control", "no-cache")
+	}
+	ctx.Data(http.StatusOK, asset.ContentType, asset.Data)
+}
+
+// renderPage writes a page of the web UI.
+func renderPage(ctx *router.Context, name string, data any) {
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	if err := web.Render(ctx.Writer, name, data); err != nil {
+		requestLog(ctx).Error("Failed to render page", zap.String("page", name), zap.Error(err))
+	}
+}
+
+// reloadEventsRoute tells the pages open in dev mode to reload when a file in
+// WEB_DIR changes.
+func reloadEventsRoute(ctx *router.Context) {
+	changes := web.Changes(ctx.Request.Context())
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case <-changes:
+			ctx.SSEvent("reload", "")
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/federation"
+	"EverythingSuckz/fsb/internal/probe"
+	"EverythingSuckz/fsb/internal/router"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// basicAuth protects every route registered after it with the credentials in
+// BASIC_AUTH_USER and BASIC_AUTH_PASSWORD. Admin API clients can't send
+// both, so the admin token is accepted in place of the credentials, and so
+// are requests redirected by a federation peer, which checked them already.
+// WebDAV clients can only send a user and password, so they may send the
+// admin token as the password, and so may mirroring tools listing /browse,
+// and S3 clients can only sign their requests,
+// which the S3 gateway checks. ffmpeg and ffprobe reading a file through
+// the local stream link send the internal token instead.
+func basicAuth() router.HandlerFunc {
+	user := []byte(config.ValueOf.BasicAuthUser)
+	password := []byte(config.ValueOf.BasicAuthPassword)
+	return func(ctx *router.Context) {
+		if token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer "); ok && config.ValueOf.AdminToken != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.ValueOf.AdminToken)) == 1 {
+			ctx.Next()
+			return
+		}
+		if hasDAVToken(ctx) || hasBrowseToken(ctx) || hasS3Signature(ctx) || hasInternalToken(ctx) {
+			ctx.Next()
+			return
+		}
+		if federation.Verify(ctx.Request) {
+			ctx.Next()
+			return
+		}
+		givenUser, givenPassword, ok := ctx.Request.BasicAuth()
+		// check both to not reveal which one was wrong through timing
+		userOk := subtle.ConstantTimeCompare([]byte(givenUser), user) == 1
+		passwordOk := subtle.ConstantTimeCompare([]byte(givenPassword), password) == 1
+		if !ok || !userOk || !passwordOk {
+			ctx.Header("WWW-Authenticate", `Basic realm="File Stream Bot", charset="UTF-8"`)
+			ctx.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// hasInternalToken reports whether the request is one of ffmpeg or ffprobe
+// reading a file through the local stream link, which send the token made
+// by the process and connect from loopback.
+func hasInternalToken(ctx *router.Context) bool {
+	token := ctx.GetHeader(probe.InternalHeader)
+	if token == "" || !probe.IsInternalToken(token) {
+		return false
+	}
+	ip := net.ParseIP(ctx.ClientIP())
+	return ip != nil && ip.IsLoopback()
+}
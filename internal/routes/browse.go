@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// browsePath is the path the channels are listed under.
+const browsePath = "/browse"
+
+func (e *allRoutes) LoadBrowse(r *Route) {
+	log := e.log.Named("Browse")
+	if !config.ValueOf.Browse {
+		return
+	}
+	if config.ValueOf.AdminToken == "" {
+		log.Info("ADMIN_TOKEN not set, directory listing disabled")
+		return
+	}
+	defer log.Info("Loaded browse routes")
+	browse := r.Engine.Group(browsePath, browseAuth)
+	browse.GET("/*path", e.browseRoute)
+	browse.Handle(http.MethodHead, "/*path", e.browseRoute)
+}
+
+// browseAuth checks that the client sent the admin token, as a bearer token
+// like the admin API or as the basic auth password like WebDAV clients, so
+// both mirroring tools and browsers can list the channels.
+func browseAuth(ctx *router.Context) {
+	if hasAdminToken(ctx) || hasBrowseToken(ctx) {
+		ctx.Next()
+		return
+	}
+	ctx.Header("WWW-Authenticate", `Basic realm="File Stream Bot", charset="UTF-8"`)
+	ctx.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// hasBrowseToken reports whether directory listing is enabled and the
+// request to it sent the admin token as its basic auth password.
+func hasBrowseToken(ctx *router.Context) bool {
+	if !config.ValueOf.Browse || !strings.HasPrefix(ctx.Request.URL.Path, browsePath) {
+		return false
+	}
+	return hasAdminPassword(ctx)
+}
+
+// browseRoute lists the storage channels at /browse/, the files indexed
+// from a channel at /browse/<channel id>/ and serves the files under them,
+// like the index pages of a plain file server. Tools that mirror those,
+// like rclone's http backend or wget --mirror, follow the links of the HTML
+// listing, and scripts can ask for JSON with ?format=json.
+func (e *allRoutes) browseRoute(ctx *router.Context) {
+	dir, name, _ := strings.Cut(strings.TrimPrefix(ctx.Param("path"), "/"), "/")
+	if dir != "" && name == "" && !strings.HasSuffix(ctx.Request.URL.Path, "/") {
+		// relative links in the listing only resolve under the slash
+		target := ctx.Request.URL.Path + "/"
+		if ctx.Request.URL.RawQuery != "" {
+			target += "?" + ctx.Request.URL.RawQuery
+		}
+		http.Redirect(ctx.Writer, ctx.Request, target, http.StatusMovedPermanently)
+		return
+	}
+	if dir == "" {
+		items, err := browseChannels()
+		if err != nil {
+			http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeListing(ctx, "Channels", false, items)
+		return
+	}
+
+	channelID, err := strconv.ParseInt(dir, 10, 64)
+	if err != nil || !channels.IsAllowed(channelID) {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	listing, err := e.channelFiles(channelID)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		items := make([]types.BrowseEntry, 0, len(listing.names))
+		for _, name := range listing.names {
+			entry := listing.files[name]
+			modified := entry.CreatedAt
+			items = append(items, types.BrowseEntry{
+				Name:     name,
+				Size:     entry.FileSize,
+				MimeType: entry.MimeType,
+				Modified: &modified,
+				URL:      url.PathEscape(name),
+			})
+		}
+		writeListing(ctx, "Channel "+dir, true, items)
+		return
+	}
+	entry, ok := listing.files[name]
+	if !ok {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	ctx.Header("Last-Modified", entry.CreatedAt.UTC().Format(http.TimeFormat))
+	hash := utils.GetShortHash(utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt))
+	e.serveStream(ctx, &stream.Request{
+		ChannelID: entry.ChannelID,
+		MessageID: entry.MessageID,
+		Hash:      hash,
+	})
+}
+
+// browseChannels lists LOG_CHANNEL and the storage channels added at
+// runtime as directories. Channels storing the files of a group aren't
+// listed, as their files are only served to the group's members.
+func browseChannels() ([]types.BrowseEntry, error) {
+	saved, err := store.GetStore().ListChannels()
+	if err != nil {
+		return nil, err
+	}
+	items := []types.BrowseEntry{{Name: "Log channel", Dir: true, URL: strconv.FormatInt(config.ValueOf.LogChannelID, 10) + "/"}}
+	for _, channel := range saved {
+		if channel.ID == config.ValueOf.LogChannelID || !channels.IsAllowed(channel.ID) {
+			continue
+		}
+		title := channel.Title
+		if title == "" {
+			title = strconv.FormatInt(channel.ID, 10)
+		}
+		created := channel.CreatedAt
+		items = append(items, types.BrowseEntry{
+			Name:     title,
+			Dir:      true,
+			Modified: &created,
+			URL:      strconv.FormatInt(channel.ID, 10) + "/",
+		})
+	}
+	return items, nil
+}
+
+// writeListing writes a listing as JSON when the client asks for it, and as
+// an HTML index page otherwise.
+func writeListing(ctx *router.Context, title string, parent bool, items []types.BrowseEntry) {
+	if ctx.Query("format") == "json" || strings.Contains(ctx.GetHeader("Accept"), "application/json") {
+		ctx.JSON(http.StatusOK, types.Page[types.BrowseEntry]{Ok: true, Items: items})
+		return
+	}
+	type row struct {
+		types.BrowseEntry
+		Size     string
+		Modified string
+	}
+	rows := make([]row, 0, len(items))
+	for _, item := range items {
+		r := row{BrowseEntry: item, Size: "-", Modified: "-"}
+		if !item.Dir {
+			r.Size = utils.FormatSize(item.Size)
+		}
+		if item.Modified != nil {
+			r.Modified = item.Modified.UTC().Format("2006-01-02 15:04")
+		}
+		rows = append(rows, r)
+	}
+	renderPage(ctx, "browse.html", map[string]any{
+		"Title":  title,
+		"Parent": parent,
+		"Rows":   rows,
+	})
+}
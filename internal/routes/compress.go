@@ -0,0 +1,286 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/cpuload"
+	"EverythingSuckz/fsb/internal/router"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMinBytes is the smallest response worth compressing, when its
+// size is known up front.
+const compressMinBytes = 1024
+
+// compressLargeBytes is the size above which responses aren't compressed
+// while the CPU is busy, when their size is known up front.
+const compressLargeBytes = 1 << 20
+
+// compressPolicy is how hard responses are compressed, which depends on how
+// busy the CPU is.
+type compressPolicy int
+
+const (
+	// policyNormal compresses at the levels configured for each coding.
+	policyNormal compressPolicy = iota
+	// policyFast compresses at the fastest level of each coding and sends
+	// large responses as they are, while the CPU is busier than
+	// COMPRESS_CPU_LOAD.
+	policyFast
+	policyCount
+)
+
+func (p compressPolicy) String() string {
+	if p == policyFast {
+		return "fast"
+	}
+	return "normal"
+}
+
+// currentPolicy returns the policy for responses compressed now.
+func currentPolicy() compressPolicy {
+	threshold := config.ValueOf.CompressCPULoad
+	if threshold > 0 && cpuload.Load()*100 >= float64(threshold) {
+		return policyFast
+	}
+	return policyNormal
+}
+
+// encoder is a compressor that can be reused for another response.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders are the content codings COMPRESSION can list, each with a pool
+// of encoders for each policy, at the level configured for the coding or at
+// its fastest one.
+var encoders = map[string]func(policy compressPolicy) encoder{
+	"gzip": func(policy compressPolicy) encoder {
+		level := config.ValueOf.GzipLevel
+		if policy == policyFast {
+			level = gzip.BestSpeed
+		}
+		w, _ := gzip.NewWriterLevel(nil, level)
+		return w
+	},
+	"br": func(policy compressPolicy) encoder {
+		level := config.ValueOf.BrotliLevel
+		if policy == policyFast {
+			level = brotli.BestSpeed
+		}
+		return brotli.NewWriterLevel(nil, level)
+	},
+	"zstd": func(policy compressPolicy) encoder {
+		level := zstd.EncoderLevelFromZstd(config.ValueOf.ZstdLevel)
+		if policy == policyFast {
+			level = zstd.SpeedFastest
+		}
+		w, _ := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(level),
+			// a response is compressed by the goroutine serving it
+			zstd.WithEncoderConcurrency(1))
+		return w
+	},
+}
+
+// compressStats counts the responses compressed under each policy, and
+// the ones sent as they are because they were too large to compress while
+// the CPU was busy.
+var compressStats struct {
+	compressed [policyCount]atomic.Int64
+	skipped    atomic.Int64
+}
+
+// compressResponses compresses text-like responses with the first coding in
+// COMPRESSION the client accepts with the highest preference. Responses
+// that are already encoded, ranges of a file and small responses are sent
+// as they are. While the CPU is busier than COMPRESS_CPU_LOAD, responses
+// are compressed at the fastest level and large ones aren't compressed.
+func compressResponses() router.HandlerFunc {
+	var codings []string
+	pools := make(map[string]*[policyCount]sync.Pool)
+	for _, coding := range strings.Split(config.ValueOf.Compression, ",") {
+		coding = strings.TrimSpace(coding)
+		newEncoder, ok := encoders[coding]
+		if !ok || pools[coding] != nil {
+			continue
+		}
+		codings = append(codings, coding)
+		pools[coding] = &[policyCount]sync.Pool{}
+		for i := range pools[coding] {
+			policy := compressPolicy(i)
+			pools[coding][i].New = func() any { return newEncoder(policy) }
+		}
+	}
+	return func(ctx *router.Context) {
+		if len(codings) == 0 {
+			return
+		}
+		coding := negotiateEncoding(ctx.GetHeader("Accept-Encoding"), codings)
+		if coding == "" {
+			return
+		}
+		w := &compressWriter{ResponseWriter: ctx.Writer, coding: coding, pools: pools[coding]}
+		ctx.Writer = w
+		defer w.close()
+		ctx.Next()
+	}
+}
+
+// negotiateEncoding picks the coding with the highest q-value in the
+// Accept-Encoding header, preferring the ones listed first in codings on a
+// tie. It returns "" when the client accepts none of them.
+func negotiateEncoding(header string, codings []string) string {
+	if header == "" {
+		return ""
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	var best string
+	var bestQ float64
+	for _, coding := range codings {
+		q, ok := accepted[coding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether responses of contentType shrink when
+// compressed, which media and archives mostly don't.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/javascript", "application/xml",
+		"application/yaml", "application/x-yaml", "application/x-subrip", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter compresses the response once the handler starts writing
+// it, if its headers show it's worth compressing.
+type compressWriter struct {
+	router.ResponseWriter
+	coding  string
+	pools   *[policyCount]sync.Pool
+	pool    *sync.Pool
+	status  int
+	decided bool
+	encoder encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code >= http.StatusOK && w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// decide starts compressing if the response is worth it. It runs when the
+// first byte of the body is written, as the headers are final by then.
+func (w *compressWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	header := w.Header()
+	// the coding changes the body, so caches have to tell responses apart
+	header.Add("Vary", "Accept-Encoding")
+	switch {
+	case w.status == http.StatusPartialContent || w.status == http.StatusNoContent || w.status == http.StatusNotModified,
+		header.Get("Content-Encoding") != "",
+		header.Get("Content-Range") != "",
+		!compressible(header.Get("Content-Type")):
+		return
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err == nil && length < compressMinBytes {
+		return
+	}
+	policy := currentPolicy()
+	if policy == policyFast && err == nil && length > compressLargeBytes {
+		compressStats.skipped.Add(1)
+		return
+	}
+	compressStats.compressed[policy].Add(1)
+	header.Set("Content-Encoding", w.coding)
+	header.Del("Content-Length")
+	// a compressed body isn't the same bytes a range would index into
+	header.Del("Accept-Ranges")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	w.pool = &w.pools[policy]
+	w.encoder = w.pool.Get().(encoder)
+	w.encoder.Reset(w.ResponseWriter)
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.encoder.Write(data)
+}
+
+func (w *compressWriter) Flush() {
+	w.decide()
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap returns the writer of the server, skipping the writer that holds
+// back the status like router.Context.EarlyHints expects.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	if unwrapper, ok := w.ResponseWriter.(interface{ Unwrap() http.ResponseWriter }); ok {
+		return unwrapper.Unwrap()
+	}
+	return w.ResponseWriter
+}
+
+// close writes the end of the compressed body and returns the encoder to
+// its pool.
+func (w *compressWriter) close() {
+	if w.encoder == nil {
+		return
+	}
+	w.encoder.Close()
+	w.encoder.Reset(io.Discard)
+	w.pool.Put(w.encoder)
+	w.encoder = nil
+}
@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	_ "embed"
+	"net/http"
+)
+
+//go:embed static/openapi.json
+var openAPISpec []byte
+
+func (e *allRoutes) LoadDocs(r *Route) {
+	log := e.log.Named("Docs")
+	defer log.Info("Loaded docs routes")
+	r.Engine.GET("/openapi.json", func(ctx *router.Context) {
+		ctx.Data(http.StatusOK, "application/json", openAPISpec)
+	})
+	r.Engine.GET("/docs", func(ctx *router.Context) {
+		renderPage(ctx, "docs.html", nil)
+	})
+}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/dedupe"
+	"EverythingSuckz/fsb/internal/router"
+	"net/http"
+)
+
+func (e *allRoutes) LoadDuplicates(r *Route) {
+	log := e.log.Named("Duplicates")
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, duplicates API disabled")
+		return
+	}
+	defer log.Info("Loaded duplicates routes")
+	admin := r.Admin.Group("/api/admin/duplicates", limitRequest(int64(config.ValueOf.MaxBodyKB)<<10, http.MethodGet, http.MethodPost), adminAuth)
+	admin.GET("", listDuplicatesRoute)
+	admin.POST("/remove", removeDuplicatesRoute)
+}
+
+// listDuplicatesRoute reports the files stored more than once and the space
+// removing their extra copies would reclaim.
+func listDuplicatesRoute(ctx *router.Context) {
+	report, err := dedupe.Find()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
+}
+
+// removeDuplicatesRoute deletes the extra copies of every duplicated file,
+// remapping their links to the copy that is kept.
+func removeDuplicatesRoute(ctx *router.Context) {
+	report, err := dedupe.Find()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result, err := dedupe.Remove(ctx.Request.Context(), bot.Bot.API(), bot.Bot.PeerStorage, report)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadGateway, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, result)
+}
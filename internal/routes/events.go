@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"io"
+)
+
+func (e *allRoutes) LoadEvents(r *Route) {
+	log := e.log.Named("Events")
+	defer log.Info("Loaded events route")
+	r.Engine.GET("/events/bandwidth", bandwidthEventsRoute)
+}
+
+func bandwidthEventsRoute(ctx *router.Context) {
+	samples, unsubscribe := stream.SubscribeBandwidth()
+	defer unsubscribe()
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case sample := <-samples:
+			ctx.SSEvent("bandwidth", sample)
+			return true
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/router"
+	"net/http"
+)
+
+// healthResponse is returned by /health. Status is ok when every worker
+// answered, degraded when only some did and down when none did.
+type healthResponse struct {
+	Ok      bool               `json:"ok"`
+	Status  string             `json:"status"`
+	Workers []bot.WorkerHealth `json:"workers"`
+}
+
+func (e *allRoutes) LoadHealth(r *Route) {
+	log := e.log.Named("Health")
+	defer log.Info("Loaded health route")
+	r.Engine.GET("/health", healthRoute)
+	r.Engine.Handle(http.MethodHead, "/health", healthRoute)
+}
+
+// healthRoute checks that every worker's session still answers calls to
+// Telegram, so a load balancer or Docker healthcheck notices a dead session
+// instead of only a live HTTP port. It answers 503 when no worker does, and
+// reports the status as degraded when only some of them do.
+func healthRoute(ctx *router.Context) {
+	workers := bot.CheckHealth(ctx.Request.Context())
+	ok := 0
+	for _, worker := range workers {
+		if worker.Ok {
+			ok++
+		}
+	}
+	response := healthResponse{Ok: ok > 0, Status: "ok", Workers: workers}
+	status := http.StatusOK
+	switch {
+	case ok == 0:
+		response.Status = "down"
+		status = http.StatusServiceUnavailable
+	case ok < len(workers):
+		response.Status = "degraded"
+	}
+	ctx.JSON(status, response)
+}
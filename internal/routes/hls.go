@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/hls"
+	"EverythingSuckz/fsb/internal/probe"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/types"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadHLS(r *Route) {
+	log := e.log.Named("HLS")
+	if !hls.Enabled() {
+		log.Info("FFMPEG_PATH not set, HLS disabled")
+		return
+	}
+	defer log.Info("Loaded HLS, DASH and subtitle track routes")
+	r.Engine.GET("/hls/:messageID/:name", e.getHLSRoute)
+	r.Engine.GET("/dash/:messageID/:name", e.getDASHRoute)
+	r.Engine.GET("/subs/:messageID/:track", e.getSubsRoute)
+}
+
+// segmentedVideo looks up the video a request for its HLS or DASH stream, or
+// one of its subtitle tracks, is for, answering the request itself when it
+// can't be served that way.
+func (e *allRoutes) segmentedVideo(ctx *router.Context) (*stream.Request, *types.FileInfo, bool) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return nil, nil, false
+	}
+	if req.Preview.Enabled() || req.Once != "" {
+		http.Error(ctx.Writer, "not available for preview or one-time links", http.StatusForbidden)
+		return nil, nil, false
+	}
+	info, err := e.stream.Info(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		http.Error(ctx.Writer, streamErr.Message, streamErr.Status)
+		return nil, nil, false
+	}
+	if !strings.HasPrefix(info.MimeType, "video/") {
+		http.Error(ctx.Writer, "only videos can be served this way", http.StatusUnsupportedMediaType)
+		return nil, nil, false
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	return req, info, true
+}
+
+// getHLSRoute serves the playlist of a video at playlist.m3u8 and its
+// segments at <index>.ts, with the query of the playlist's link.
+func (e *allRoutes) getHLSRoute(ctx *router.Context) {
+	req, info, ok := e.segmentedVideo(ctx)
+	if !ok {
+		return
+	}
+
+	name := ctx.Param("name")
+	if name == "playlist.m3u8" {
+		playlist, err := hls.Playlist(info.Duration, ctx.Request.URL.RawQuery)
+		if err != nil {
+			http.Error(ctx.Writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		ctx.Data(http.StatusOK, "application/vnd.apple.mpegurl", playlist)
+		return
+	}
+	index, ok := hls.ParseSegment(name)
+	if !ok {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	data, err := hls.Segment(ctx.Request.Context(), req.ChannelID, req.MessageID, index, info.Duration, ffmpegInput(ctx, req))
+	if errors.Is(err, hls.ErrNoDuration) {
+		http.Error(ctx.Writer, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		requestLog(ctx).Warn("Failed to serve HLS segment", zap.Error(err))
+		http.Error(ctx.Writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+	// segments of a file never change
+	ctx.Header("Cache-Control", "public, max-age=86400")
+	ctx.Data(http.StatusOK, "video/mp2t", data)
+}
+
+// getDASHRoute serves the DASH manifest of a video at manifest.mpd, and the
+// initialization and media segments of its video and audio tracks at
+// v-init.mp4, v-<index>.m4s, a-init.mp4 and a-<index>.m4s, with the query of
+// the manifest's link.
+func (e *allRoutes) getDASHRoute(ctx *router.Context) {
+	req, info, ok := e.segmentedVideo(ctx)
+	if !ok {
+		return
+	}
+
+	name := ctx.Param("name")
+	if name == "manifest.mpd" {
+		// the audio track is left out only when ffprobe found none
+		audio := info.AudioCodec != "" || info.VideoCodec == ""
+		manifest, err := hls.Manifest(info.Duration, info.FileSize, info.Width, info.Height, audio, ctx.Request.URL.RawQuery)
+		if err != nil {
+			http.Error(ctx.Writer, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		ctx.Data(http.StatusOK, "application/dash+xml", manifest)
+		return
+	}
+	part, ok := hls.ParseDASHPart(name)
+	if !ok {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	data, err := hls.CutDASH(ctx.Request.Context(), req.ChannelID, req.MessageID, part, info.Duration, ffmpegInput(ctx, req))
+	if errors.Is(err, hls.ErrNoDuration) {
+		http.Error(ctx.Writer, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err != nil {
+		requestLog(ctx).Warn("Failed to serve DASH segment", zap.Error(err))
+		http.Error(ctx.Writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+	// segments of a file never change
+	ctx.Header("Cache-Control", "public, max-age=86400")
+	ctx.Data(http.StatusOK, part.ContentType(), data)
+}
+
+// getSubsRoute serves a subtitle track embedded in a video as WebVTT, by its
+// index among the video's subtitle tracks, like "0" or "0.vtt".
+func (e *allRoutes) getSubsRoute(ctx *router.Context) {
+	req, info, ok := e.segmentedVideo(ctx)
+	if !ok {
+		return
+	}
+
+	track, err := strconv.Atoi(strings.TrimSuffix(ctx.Param("track"), ".vtt"))
+	if err != nil || track < 0 {
+		http.Error(ctx.Writer, "invalid track", http.StatusBadRequest)
+		return
+	}
+	// the tracks are only known once ffprobe has been through the file with
+	// FFPROBE_PATH set, otherwise ffmpeg finds out
+	if len(info.Subtitles) > 0 && !slices.ContainsFunc(info.Subtitles, func(t types.SubtitleTrack) bool { return t.Track == track }) {
+		http.Error(ctx.Writer, "the video has no such subtitle track", http.StatusNotFound)
+		return
+	}
+	data, err := hls.Subtitles(ctx.Request.Context(), req.ChannelID, req.MessageID, track, ffmpegInput(ctx, req))
+	if err != nil {
+		requestLog(ctx).Warn("Failed to extract subtitle track", zap.Error(err))
+		http.Error(ctx.Writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+	// the tracks of a file never change
+	ctx.Header("Cache-Control", "public, max-age=86400")
+	ctx.Data(http.StatusOK, "text/vtt; charset=utf-8", data)
+}
+
+// ffmpegInput returns the URL ffmpeg reads the file in req from. Indexed
+// files are read through their own link, which works from here even when
+// the client's link is bound to its address.
+func ffmpegInput(ctx *router.Context, req *stream.Request) string {
+	if entry, err := store.GetStore().GetFile(req.ChannelID, req.MessageID); err == nil {
+		return probe.StreamURL(entry)
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d/stream/%d?%s", config.ValueOf.Port, req.MessageID, ctx.Request.URL.RawQuery)
+}
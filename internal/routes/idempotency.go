@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader marks responses replayed from an earlier
+	// request with the same key.
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	// idempotencyTTL is how long the response to a request with a key is
+	// kept for retries.
+	idempotencyTTL    = 24 * time.Hour
+	maxIdempotencyKey = 255
+)
+
+// idempotent makes retrying a request with the same Idempotency-Key header
+// return the response of the first one instead of running it again, so a
+// client that timed out waiting can safely send it again. Reusing a key
+// for a different request is rejected, and so is a retry that arrives
+// while the first request is still running. Server errors aren't kept, so
+// the request runs again when retried after one.
+func idempotent(ctx *router.Context) {
+	key := ctx.GetHeader(idempotencyHeader)
+	if key == "" {
+		ctx.Next()
+		return
+	}
+	if len(key) > maxIdempotencyKey {
+		abortWithError(ctx, http.StatusBadRequest, "Idempotency-Key is too long")
+		return
+	}
+	sum := sha256.Sum256([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "?" + ctx.Request.URL.Query().Encode()))
+	req := &store.IdempotentRequest{
+		Key:         key,
+		Fingerprint: hex.EncodeToString(sum[:]),
+		ExpiresAt:   time.Now().Add(idempotencyTTL),
+	}
+	existing, err := store.GetStore().ClaimIdempotencyKey(req)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing != nil {
+		switch {
+		case existing.Fingerprint != req.Fingerprint:
+			abortWithError(ctx, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		case existing.Status == 0:
+			ctx.Header("Retry-After", "1")
+			abortWithError(ctx, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+		default:
+			ctx.Header(idempotencyReplayedHeader, "true")
+			ctx.Data(existing.Status, existing.ContentType, existing.Body)
+			ctx.Abort()
+		}
+		return
+	}
+
+	w := &recordWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = w
+	completed := false
+	defer func() {
+		ctx.Writer = w.ResponseWriter
+		if !completed || w.Status() >= http.StatusInternalServerError {
+			err = store.GetStore().DeleteIdempotencyKey(key)
+		} else {
+			req.Status = w.Status()
+			req.ContentType = w.Header().Get("Content-Type")
+			req.Body = w.body.Bytes()
+			err = store.GetStore().FinishIdempotentRequest(req)
+		}
+		if err != nil {
+			requestLog(ctx).Error("Failed to save idempotent response", zap.Error(err))
+		}
+	}()
+	ctx.Next()
+	completed = true
+}
+
+// recordWriter keeps a copy of the body it writes.
+type recordWriter struct {
+	router.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.body.Write(data[:n])
+	return n, err
+}
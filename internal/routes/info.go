@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"errors"
+	"net/http"
+)
+
+func (e *allRoutes) LoadInfo(r *Route) {
+	log := e.log.Named("Info")
+	defer log.Info("Loaded info route")
+	r.Engine.GET("/info/:messageID", e.getInfoRoute)
+}
+
+func (e *allRoutes) getInfoRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	info, err := e.stream.Info(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		abortWithError(ctx, streamErr.Status, streamErr.Message)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.JSON(http.StatusOK, info)
+}
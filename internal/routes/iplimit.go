@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/ratelimit"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// streamRetryAfter is how long clients that have IP_STREAM_LIMIT streams
+// open are told to wait before opening another one.
+const streamRetryAfter = 5 * time.Second
+
+// limitIP rejects the requests of addresses that sent more than
+// IP_REQUEST_LIMIT requests within the last IP_REQUEST_WINDOW, so a scraper
+// hammering the stream routes can't keep the workers busy for everyone
+// else. The address is the one the router trusts, so it can't be changed
+// by sending X-Forwarded-For, and IPv6 addresses share the limit of their
+// /64. Requests are counted in the store like the bot's rate limit, so
+// instances sharing a Redis or Postgres store share it. Requests sending
+// the admin token or the internal token of ffmpeg aren't limited.
+func limitIP() router.HandlerFunc {
+	return func(ctx *router.Context) {
+		limit, window := config.ValueOf.IPRequestLimit, config.ValueOf.IPRequestWindow
+		if limit <= 0 || window <= 0 || hasAdminToken(ctx) || hasInternalToken(ctx) {
+			ctx.Next()
+			return
+		}
+		wait, err := ratelimit.Hit("ip:"+utils.ClientKey(ctx.ClientIP()), limit, window)
+		if err != nil {
+			// better to serve the request than to lock everyone out
+			requestLog(ctx).Warn("Failed to check IP rate limit", zap.Error(err))
+			ctx.Next()
+			return
+		}
+		if wait > 0 {
+			tooManyRequests(ctx, wait, "too many requests, try again later")
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// openStreams counts the streams each address has open.
+type openStreams struct {
+	sync.Mutex
+	addrs map[string]int
+}
+
+// acquireStream takes one of the IP_STREAM_LIMIT streams the client's
+// address may have open at once, writing 429 if it has all of them open.
+// The returned func gives it back once the stream is done.
+func (e *allRoutes) acquireStream(ctx *router.Context) (func(), bool) {
+	limit := config.ValueOf.IPStreamLimit
+	if limit <= 0 || hasAdminToken(ctx) || hasInternalToken(ctx) {
+		return func() {}, true
+	}
+	addr := utils.ClientKey(ctx.ClientIP())
+	e.streams.Lock()
+	defer e.streams.Unlock()
+	if e.streams.addrs[addr] >= limit {
+		tooManyRequests(ctx, streamRetryAfter, "too many streams open, try again later")
+		return nil, false
+	}
+	e.streams.addrs[addr]++
+	return func() {
+		e.streams.Lock()
+		defer e.streams.Unlock()
+		if e.streams.addrs[addr]--; e.streams.addrs[addr] == 0 {
+			delete(e.streams.addrs, addr)
+		}
+	}, true
+}
+
+// tooManyRequests writes 429 with a Retry-After header of wait.
+func tooManyRequests(ctx *router.Context, wait time.Duration, message string) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(ctx.Writer, message, http.StatusTooManyRequests)
+}
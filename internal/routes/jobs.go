@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/jobs"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+func (e *allRoutes) LoadJobs(r *Route) {
+	log := e.log.Named("Jobs")
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, jobs API disabled")
+		return
+	}
+	defer log.Info("Loaded jobs routes")
+	admin := r.Admin.Group("/api/admin/jobs", limitRequest(int64(config.ValueOf.MaxBodyKB)<<10, http.MethodGet, http.MethodPost), adminAuth)
+	admin.GET("", listJobsRoute)
+	admin.POST("/:id/retry", retryJobRoute)
+}
+
+// listJobsRoute lists the queued jobs, newest first. ?state= narrows them
+// down to pending, running or failed ones.
+func listJobsRoute(ctx *router.Context) {
+	limit, cursor, ok := pageParams(ctx)
+	if !ok {
+		return
+	}
+	query := store.JobQuery{State: ctx.Query("state"), Limit: limit + 1}
+	if cursor != "" {
+		if err := utils.DecodeCursor(cursor, &query.BeforeID); err != nil {
+			abortWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	list, err := store.GetStore().ListJobs(query)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	page := types.Page[*store.Job]{Ok: true, Items: list}
+	if len(list) > limit {
+		page.Items = list[:limit]
+		page.Next = utils.EncodeCursor(list[limit-1].ID)
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// retryJobRoute runs a failed job again.
+func retryJobRoute(ctx *router.Context) {
+	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid job ID")
+		return
+	}
+	job, err := jobs.Retry(id)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "job not found")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusConflict, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/utils"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// limitedBody is a request body capped by limitRequest. The body it caps is
+// kept, so route groups can replace the global limit with their own.
+type limitedBody struct {
+	io.ReadCloser
+	original io.ReadCloser
+}
+
+// limitRequest rejects requests with methods other than the given ones
+// (any method when none are given) with 405, and requests with bodies
+// larger than maxBody bytes with 413 before the handler reads them. Bodies
+// sent without a Content-Length fail to read past maxBody. A maxBody of 0
+// lifts the limit set before it.
+func limitRequest(maxBody int64, methods ...string) router.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(ctx *router.Context) {
+		r := ctx.Request
+		if len(methods) > 0 && !utils.Contains(methods, r.Method) {
+			ctx.Header("Allow", allow)
+			ctx.String(http.StatusMethodNotAllowed, "method not allowed")
+			ctx.Abort()
+			return
+		}
+		if maxBody > 0 && r.ContentLength > maxBody {
+			ctx.String(http.StatusRequestEntityTooLarge, "request body too large")
+			ctx.Abort()
+			return
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			original := r.Body
+			if limited, ok := original.(*limitedBody); ok {
+				original = limited.original
+			}
+			r.Body = original
+			if maxBody > 0 {
+				r.Body = &limitedBody{http.MaxBytesReader(ctx.Writer, original, maxBody), original}
+			}
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,395 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/evict"
+	"EverythingSuckz/fsb/internal/geoip"
+	"EverythingSuckz/fsb/internal/plugins"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/shortlink"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/plugin"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (e *allRoutes) LoadLinks(r *Route) {
+	log := e.log.Named("Links")
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, links API disabled")
+		return
+	}
+	defer log.Info("Loaded links routes")
+	r.Admin.POST("/api/links/:messageID/rotate", adminAuth, idempotent, rotateLinkRoute)
+	r.Admin.POST("/api/links/:messageID/bind", adminAuth, idempotent, bindLinkRoute)
+	r.Admin.POST("/api/links/:messageID/preview", adminAuth, idempotent, previewLinkRoute)
+	r.Admin.POST("/api/links/:messageID/once", adminAuth, idempotent, onceLinkRoute)
+	r.Admin.POST("/api/links/:messageID/expire", adminAuth, idempotent, expireLinkRoute)
+	r.Admin.POST("/api/links/:messageID/geo", adminAuth, idempotent, geoLinkRoute)
+	r.Admin.POST("/api/links/:messageID/revocable", adminAuth, idempotent, revocableLinkRoute)
+	r.Admin.POST("/api/links/:messageID/short", adminAuth, idempotent, shortLinkCreateRoute)
+	r.Admin.Handle(http.MethodDelete, "/api/links/:id", adminAuth, revokeLinkRoute)
+}
+
+// linkTarget reads the file a links route is for, writing an error response
+// when it's malformed.
+func linkTarget(ctx *router.Context) (int64, int, bool) {
+	messageID, err := strconv.Atoi(ctx.Param("messageID"))
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid message ID")
+		return 0, 0, false
+	}
+	channelID := config.ValueOf.LogChannelID
+	if channel := ctx.Query("channel"); channel != "" {
+		channelID, err = strconv.ParseInt(channel, 10, 64)
+		if err != nil {
+			abortWithError(ctx, http.StatusBadRequest, "invalid channel")
+			return 0, 0, false
+		}
+	}
+	return channelID, messageID, true
+}
+
+// linkHash is the full hash the current links of an indexed file are made
+// from.
+func linkHash(entry *store.FileEntry) string {
+	return utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt)
+}
+
+// rotateLinkRoute gives a file a new hash, which invalidates every link that
+// was shared for it, and returns the new link.
+func rotateLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err := store.GetStore().SetHashSalt(channelID, messageID, hex.EncodeToString(salt))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// nothing can reach the file through its old links anymore
+	evict.Publish(evict.Event{ChannelID: channelID, MessageID: messageID, Reason: evict.Revoked})
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hash := utils.GetShortHash(linkHash(entry))
+	link := utils.FileLink("stream", channelID, messageID, hash)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		Hash: hash,
+		Link: link,
+	})
+}
+
+// bindLinkRoute returns a link to a file that only works from the IP address
+// or CIDR range in ?ip=, or from the address of whoever requested it.
+func bindLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	ip := ctx.Query("ip")
+	if ip == "" {
+		ip = ctx.ClientIP()
+	}
+	prefix, err := utils.NormalizeIPBinding(ip)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	fullHash := utils.BindHash(linkHash(entry), prefix)
+	link := utils.BoundFileLink(channelID, messageID, linkHash(entry), prefix)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		Hash: utils.GetShortHash(fullHash),
+		Link: link,
+	})
+}
+
+// previewLinkRoute returns a link to a file that only serves as much of it
+// as ?limit= allows, a size like 50MB or a length like 2m.
+func previewLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	limit, err := utils.ParsePreview(ctx.Query("limit"))
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	link := utils.PreviewFileLink(channelID, messageID, linkHash(entry), limit)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		Hash: utils.GetShortHash(utils.PreviewHash(linkHash(entry), limit)),
+		Link: link,
+	})
+}
+
+// onceLinkRoute saves a one-time link to a file, which only works for the
+// first download session that uses it, and until ?expires= if given, a
+// duration like 24h.
+func onceLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	var expiresAt *time.Time
+	if value := ctx.Query("expires"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration <= 0 {
+			abortWithError(ctx, http.StatusBadRequest, "invalid expires")
+			return
+		}
+		expiry := time.Now().Add(duration)
+		expiresAt = &expiry
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id, err := utils.NewLinkID()
+	if err == nil {
+		err = store.GetStore().SaveLink(&store.Link{ID: id, MessageID: messageID, ExpiresAt: expiresAt})
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	link := utils.OnceFileLink(channelID, messageID, linkHash(entry), id)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		ID:   id,
+		Hash: utils.GetShortHash(utils.OnceHash(linkHash(entry), id)),
+		Link: link,
+	})
+}
+
+// expireLinkRoute returns a link to a file that stops working after ?ttl=,
+// a duration like 24h.
+func expireLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	ttl, err := time.ParseDuration(ctx.Query("ttl"))
+	if err != nil || ttl <= 0 {
+		abortWithError(ctx, http.StatusBadRequest, "invalid ttl")
+		return
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	expires := time.Now().Add(ttl)
+	link := utils.ExpiringFileLink(channelID, messageID, linkHash(entry), expires)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		Hash: utils.GetShortHash(utils.ExpiryHash(linkHash(entry), expires)),
+		Link: link,
+	})
+}
+
+// geoLinkRoute saves a link to a file that only works in the countries in
+// ?countries=, like US,CA, or everywhere but in them, like -CN,-RU.
+func geoLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	if !geoip.Enabled() {
+		abortWithError(ctx, http.StatusNotImplemented, "GEOIP_DB or GEOIP_HEADER isn't set")
+		return
+	}
+	countries, err := geoip.ParseCountries(ctx.Query("countries"))
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id, err := utils.NewLinkID()
+	if err == nil {
+		err = store.GetStore().SaveLink(&store.Link{ID: id, MessageID: messageID, Countries: countries})
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	link := utils.GeoFileLink(channelID, messageID, linkHash(entry), id)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		ID:   id,
+		Hash: utils.GetShortHash(utils.GeoHash(linkHash(entry), id)),
+		Link: link,
+	})
+}
+
+// revocableLinkRoute saves a link to a file that works until it's revoked
+// with DELETE /api/links/<id>, without affecting the other links to it.
+func revocableLinkRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id, err := utils.NewLinkID()
+	if err == nil {
+		err = store.GetStore().SaveLink(&store.Link{ID: id, MessageID: messageID})
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	link := utils.RevocableFileLink(channelID, messageID, linkHash(entry), id)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		ID:   id,
+		Hash: utils.GetShortHash(utils.RevocableHash(linkHash(entry), id)),
+		Link: link,
+	})
+}
+
+// shortLinkCreateRoute saves a short link to the stream link of a file,
+// under the slug in ?slug= or a random one.
+func shortLinkCreateRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	slug := ctx.Query("slug")
+	if slug != "" {
+		if err := shortlink.CheckSlug(slug); err != nil {
+			abortWithError(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	hash := utils.GetShortHash(linkHash(entry))
+	short, err := shortlink.Create(utils.FileLink("stream", channelID, messageID, hash), messageID, 0, slug)
+	if errors.Is(err, shortlink.ErrSlugTaken) {
+		abortWithError(ctx, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	link := shortlink.Link(short.Slug)
+	linkCreated(entry, link)
+	ctx.JSON(http.StatusOK, types.LinkResponse{
+		Ok:   true,
+		ID:   short.Slug,
+		Hash: hash,
+		Link: link,
+	})
+}
+
+// revokeLinkRoute stops the revocable, one-time or geo-fenced link saved
+// under the ID in the path from working.
+func revokeLinkRoute(ctx *router.Context) {
+	link, err := store.GetStore().GetLink(ctx.Param("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "link not found")
+		return
+	}
+	if err == nil && link.RevokedAt == nil {
+		now := time.Now()
+		link.RevokedAt = &now
+		err = store.GetStore().SaveLink(link)
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ctx.Writer.WriteHeader(http.StatusNoContent)
+}
+
+// linkCreated tells the plugins a link to entry was created with the admin
+// API.
+func linkCreated(entry *store.FileEntry, link string) {
+	plugins.LinkCreated(plugin.LinkEvent{
+		ChannelID: entry.ChannelID,
+		MessageID: entry.MessageID,
+		FileName:  entry.FileName,
+		FileSize:  entry.FileSize,
+		MimeType:  entry.MimeType,
+		Link:      link,
+	})
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listingTTL is how long the listings of the channels are reused for.
+// Explorer and Finder send a burst of PROPFINDs for every folder they open,
+// and S3 tools and mirroring tools list page after page.
+const listingTTL = 30 * time.Second
+
+// listingPageSize is how many indexed files are read from the store at a
+// time when listing the channels.
+const listingPageSize = 500
+
+// fileListing is a channel's files by the names WebDAV, the S3 gateway and
+// /browse serve them under, in the order they were indexed, newest first.
+type fileListing struct {
+	names []string
+	files map[string]*store.FileEntry
+}
+
+// listingCache keeps the listings of the channels for listingTTL.
+type listingCache struct {
+	mu       sync.Mutex
+	channels map[int64]*fileListing
+	built    time.Time
+}
+
+// channelFiles returns the listing of a channel's indexed files, reading
+// the index from the store again when it's older than listingTTL.
+func (e *allRoutes) channelFiles(channelID int64) (*fileListing, error) {
+	e.listings.mu.Lock()
+	defer e.listings.mu.Unlock()
+	if e.listings.channels == nil || time.Since(e.listings.built) >= listingTTL {
+		listings, err := buildListings()
+		if err != nil {
+			return nil, err
+		}
+		e.listings.channels = listings
+		e.listings.built = time.Now()
+	}
+	if listing, ok := e.listings.channels[channelID]; ok {
+		return listing, nil
+	}
+	return &fileListing{files: map[string]*store.FileEntry{}}, nil
+}
+
+// buildListings reads the whole index and lists the files of each channel.
+func buildListings() (map[int64]*fileListing, error) {
+	entries := make(map[int64][]*store.FileEntry)
+	query := store.FileQuery{Limit: listingPageSize}
+	for {
+		page, err := store.GetStore().SearchFiles(query)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range page {
+			entries[entry.ChannelID] = append(entries[entry.ChannelID], entry)
+		}
+		if len(page) < listingPageSize {
+			break
+		}
+		cursor := page[len(page)-1].Cursor()
+		query.After = &cursor
+	}
+
+	listings := make(map[int64]*fileListing, len(entries))
+	for channelID, entries := range entries {
+		// files sent with the same name are told apart by their message ID
+		counts := make(map[string]int, len(entries))
+		for _, entry := range entries {
+			counts[listingName(entry)]++
+		}
+		listing := &fileListing{files: make(map[string]*store.FileEntry, len(entries))}
+		for _, entry := range entries {
+			name := listingName(entry)
+			if counts[name] > 1 {
+				ext := path.Ext(name)
+				name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), entry.MessageID, ext)
+			}
+			listing.names = append(listing.names, name)
+			listing.files[name] = entry
+		}
+		listings[channelID] = listing
+	}
+	return listings, nil
+}
+
+// listingName is the name of a file in the listing, which can't contain
+// slashes.
+func listingName(entry *store.FileEntry) string {
+	name := strings.Trim(strings.ReplaceAll(entry.FileName, "/", "_"), " ")
+	if name == "" || name == "." || name == ".." {
+		return strconv.Itoa(entry.MessageID)
+	}
+	return name
+}
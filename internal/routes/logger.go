@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"go.uber.org/zap"
+)
+
+// requestLogger gives every request a logger carrying the route and the file
+// it is for. Handlers get it with requestLog, and code further down reads it
+// from the request context with utils.LoggerFrom.
+func requestLogger(base *zap.Logger) router.HandlerFunc {
+	return func(ctx *router.Context) {
+		fields := []zap.Field{
+			zap.String("route", ctx.FullPath()),
+			zap.String("clientIP", ctx.ClientIP()),
+		}
+		if messageID := ctx.Param("messageID"); messageID != "" {
+			fields = append(fields, zap.String("messageID", messageID))
+		}
+		if channel := ctx.Query("channel"); channel != "" {
+			fields = append(fields, zap.String("channel", channel))
+		}
+		ctx.Request = ctx.Request.WithContext(utils.WithLogger(ctx.Request.Context(), base.With(fields...)))
+		ctx.Next()
+	}
+}
+
+func requestLog(ctx *router.Context) *zap.Logger {
+	return utils.LoggerFrom(ctx.Request.Context())
+}
@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cpuload"
+	"EverythingSuckz/fsb/internal/diskcache"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/stream"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+func (e *allRoutes) LoadMetrics(r *Route) {
+	log := e.log.Named("Metrics")
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, metrics disabled")
+		return
+	}
+	defer log.Info("Loaded metrics route")
+	r.Admin.GET("/metrics", adminAuth, metricsRoute)
+}
+
+// byteStats are the stats that count bytes rather than events, exported as
+// fsb_bytes_total.
+var byteStats = map[string]string{
+	"served_bytes":  "served",
+	"fetched_bytes": "fetched",
+}
+
+// metricsRoute exposes the bot's counters in the Prometheus text format.
+func metricsRoute(ctx *router.Context) {
+	var out strings.Builder
+
+	stats, err := store.GetStore().GetStats()
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		if _, ok := byteStats[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	out.WriteString("# TYPE fsb_events_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&out, "fsb_events_total{event=%q} %d\n", name, stats[name])
+	}
+	// unlike fsb_sent_bytes_total and fsb_fetched_bytes_total these are kept
+	// across restarts
+	out.WriteString("# TYPE fsb_bytes_total counter\n")
+	for _, name := range []string{"served_bytes", "fetched_bytes"} {
+		fmt.Fprintf(&out, "fsb_bytes_total{direction=%q} %d\n", byteStats[name], stats[name])
+	}
+
+	writeWorkerBytes(&out, "fsb_sent_bytes_total", stream.SentBytes())
+	// bytes fetched from Telegram, the difference to fsb_sent_bytes_total is
+	// what was served from caches
+	writeWorkerBytes(&out, "fsb_fetched_bytes_total", stream.FetchedBytes())
+
+	hits, misses := stream.ChunkCacheStats()
+	out.WriteString("# TYPE fsb_chunk_cache_total counter\n")
+	fmt.Fprintf(&out, "fsb_chunk_cache_total{result=\"hit\"} %d\n", hits)
+	fmt.Fprintf(&out, "fsb_chunk_cache_total{result=\"miss\"} %d\n", misses)
+	if diskcache.Enabled() {
+		cacheStats := diskcache.GetStats()
+		out.WriteString("# TYPE fsb_disk_cache_total counter\n")
+		fmt.Fprintf(&out, "fsb_disk_cache_total{result=\"hit\"} %d\n", cacheStats.Hits)
+		fmt.Fprintf(&out, "fsb_disk_cache_total{result=\"miss\"} %d\n", cacheStats.Misses)
+		fmt.Fprintf(&out, "fsb_disk_cache_total{result=\"evicted\"} %d\n", cacheStats.Evicted)
+		fmt.Fprintf(&out, "fsb_disk_cache_total{result=\"corrupt\"} %d\n", cacheStats.Corrupt)
+		out.WriteString("# TYPE fsb_disk_cache_bytes gauge\n")
+		fmt.Fprintf(&out, "fsb_disk_cache_bytes %d\n", cacheStats.Size)
+	}
+
+	aheadUsed, aheadDegraded := stream.ReadAheadStats()
+	out.WriteString("# TYPE fsb_read_ahead_bytes gauge\n")
+	fmt.Fprintf(&out, "fsb_read_ahead_bytes %d\n", aheadUsed)
+	out.WriteString("# TYPE fsb_read_ahead_degraded_total counter\n")
+	fmt.Fprintf(&out, "fsb_read_ahead_degraded_total %d\n", aheadDegraded)
+
+	writeCompressionStats(&out)
+
+	out.WriteString("# TYPE fsb_active_streams gauge\n")
+	fmt.Fprintf(&out, "fsb_active_streams %d\n", len(stream.ActiveStreams(0, 0)))
+	out.WriteString("# TYPE fsb_workers gauge\n")
+	fmt.Fprintf(&out, "fsb_workers %d\n", len(bot.Workers.List()))
+
+	ctx.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(out.String()))
+}
+
+// writeCompressionStats exports the CPU load COMPRESS_CPU_LOAD is compared
+// to, the compression policy it picks now, and how many responses each
+// policy compressed.
+func writeCompressionStats(out *strings.Builder) {
+	out.WriteString("# TYPE fsb_cpu_load gauge\n")
+	fmt.Fprintf(out, "fsb_cpu_load %.3f\n", cpuload.Load())
+	current := currentPolicy()
+	out.WriteString("# TYPE fsb_compression_policy gauge\n")
+	for policy := compressPolicy(0); policy < policyCount; policy++ {
+		active := 0
+		if policy == current {
+			active = 1
+		}
+		fmt.Fprintf(out, "fsb_compression_policy{policy=%q} %d\n", policy, active)
+	}
+	out.WriteString("# TYPE fsb_compressed_responses_total counter\n")
+	for policy := compressPolicy(0); policy < policyCount; policy++ {
+		fmt.Fprintf(out, "fsb_compressed_responses_total{policy=%q} %d\n", policy, compressStats.compressed[policy].Load())
+	}
+	// large responses sent uncompressed while the CPU was busy
+	fmt.Fprintf(out, "fsb_compressed_responses_total{policy=\"skipped\"} %d\n", compressStats.skipped.Load())
+}
+
+func writeWorkerBytes(out *strings.Builder, metric string, bytes map[int]int64) {
+	workers := make([]int, 0, len(bytes))
+	for id := range bytes {
+		workers = append(workers, id)
+	}
+	sort.Ints(workers)
+	fmt.Fprintf(out, "# TYPE %s counter\n", metric)
+	for _, id := range workers {
+		fmt.Fprintf(out, "%s{worker=\"%d\"} %d\n", metric, id, bytes[id])
+	}
+}
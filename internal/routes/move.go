@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/relocate"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// moveResponse is returned by POST /api/files/<id>/move, with the link to
+// the file where it is now.
+type moveResponse struct {
+	Ok   bool   `json:"ok"`
+	Link string `json:"link"`
+	*relocate.Result
+}
+
+func (e *allRoutes) LoadMove(r *Route) {
+	log := e.log.Named("Move")
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, move API disabled")
+		return
+	}
+	defer log.Info("Loaded move route")
+	r.Admin.POST("/api/files/:messageID/move", adminAuth, idempotent, moveFileRoute)
+}
+
+// moveFileRoute moves an indexed file to the storage channel in ?to=. Its
+// old links are remapped to the moved file, so they keep working. With
+// ?copy=1 the file is copied instead, and the copy gets links of its own.
+func moveFileRoute(ctx *router.Context) {
+	channelID, messageID, ok := linkTarget(ctx)
+	if !ok {
+		return
+	}
+	to, err := strconv.ParseInt(ctx.Query("to"), 10, 64)
+	if err != nil {
+		abortWithError(ctx, http.StatusBadRequest, "invalid destination channel")
+		return
+	}
+	entry, err := store.GetStore().GetFile(channelID, messageID)
+	if errors.Is(err, store.ErrNotFound) {
+		abortWithError(ctx, http.StatusNotFound, "file not found in the index")
+		return
+	}
+	if err != nil {
+		abortWithError(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result, err := relocate.Move(ctx.Request.Context(), bot.Bot.API(), bot.Bot.PeerStorage, entry, to, ctx.Query("copy") == "1")
+	switch {
+	case errors.Is(err, relocate.ErrUnknownChannel), errors.Is(err, relocate.ErrSameChannel), errors.Is(err, relocate.ErrNotInChannel), errors.Is(err, relocate.ErrGroupFile):
+		abortWithError(ctx, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		abortWithError(ctx, http.StatusBadGateway, err.Error())
+		return
+	}
+	link := utils.FileLink("stream", result.File.ChannelID, result.File.MessageID, utils.GetShortHash(linkHash(result.File)))
+	linkCreated(result.File, link)
+	ctx.JSON(http.StatusOK, moveResponse{Ok: true, Link: link, Result: result})
+}
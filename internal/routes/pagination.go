@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/types"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageParams reads the ?limit= and ?cursor= query parameters shared by all
+// listing endpoints.
+func pageParams(ctx *router.Context) (int, string, bool) {
+	limit := defaultPageSize
+	if value := ctx.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			abortWithError(ctx, http.StatusBadRequest, "limit must be a positive number")
+			return 0, "", false
+		}
+		limit = min(parsed, maxPageSize)
+	}
+	return limit, ctx.Query("cursor"), true
+}
+
+func abortWithError(ctx *router.Context, status int, message string) {
+	ctx.AbortWithStatusJSON(status, types.ErrorResponse{Ok: false, Message: message})
+}
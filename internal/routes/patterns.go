@@ -0,0 +1,35 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/patterns"
+	"EverythingSuckz/fsb/internal/router"
+	"net/http"
+)
+
+func (e *allRoutes) LoadPatterns(r *Route) {
+	log := e.log.Named("Patterns")
+	if !patterns.Enabled() {
+		return
+	}
+	defer log.Info("Loaded URL pattern routes")
+	for _, pattern := range patterns.All() {
+		r.Engine.GET(pattern.Template, e.patternRoute(pattern))
+	}
+}
+
+// patternRoute streams the files linked to on one of URL_PATTERNS, which
+// carry their location and hash in the slug instead of the query.
+func (e *allRoutes) patternRoute(pattern *patterns.Pattern) router.HandlerFunc {
+	return func(ctx *router.Context) {
+		channelID, messageID, hash, err := pattern.Resolve(ctx.Param("slug"))
+		if err != nil {
+			http.Error(ctx.Writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		req, ok := checkedFileRequest(ctx, channelID, messageID, hash)
+		if !ok {
+			return
+		}
+		e.serveStream(ctx, req)
+	}
+}
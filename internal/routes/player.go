@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/hls"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+)
+
+func (e *allRoutes) LoadPlayer(r *Route) {
+	log := e.log.Named("Player")
+	defer log.Info("Loaded player routes")
+	r.Engine.GET("/player/:messageID", e.getPlayerRoute)
+	r.Engine.GET("/subtitle/:messageID", e.getSubtitleRoute)
+	r.Engine.GET("/thumb/:messageID", e.getThumbRoute)
+}
+
+func (e *allRoutes) getPlayerRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	query := url.Values{"hash": {req.Hash}}
+	if req.ChannelID != config.ValueOf.LogChannelID {
+		query.Set("channel", strconv.FormatInt(req.ChannelID, 10))
+	}
+	for _, key := range []string{"member", "msig", "ip", "preview", "expires", "geo", "link", "pw"} {
+		if value := ctx.Query(key); value != "" {
+			query.Set(key, value)
+		}
+	}
+	streamURL := "/stream/" + strconv.Itoa(req.MessageID) + "?" + query.Encode()
+	subtitleURL := "/subtitle/" + strconv.Itoa(req.MessageID) + "?" + query.Encode()
+	thumbURL := "/thumb/" + strconv.Itoa(req.MessageID) + "?" + query.Encode()
+	if config.ValueOf.EarlyHints {
+		// sent before the file is looked up on Telegram, which is most of
+		// the time the page takes
+		ctx.EarlyHints(
+			"<"+streamURL+">; rel=preload; as=video; crossorigin=anonymous",
+			"<"+subtitleURL+">; rel=preload; as=track; crossorigin=anonymous",
+		)
+	}
+	info, err := e.stream.Info(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		http.Error(ctx.Writer, streamErr.Message, streamErr.Status)
+		return
+	}
+	data := map[string]any{
+		"Title":       info.FileName,
+		"Description": info.Description,
+		"Stream":      streamURL,
+		"Subtitle":    subtitleURL,
+	}
+	// lets the page lay the video out before its metadata is loaded, and
+	// link previews show its size and length
+	if info.Width > 0 && info.Height > 0 {
+		data["Width"] = strconv.Itoa(info.Width)
+		data["Height"] = strconv.Itoa(info.Height)
+	}
+	if info.Duration > 0 {
+		data["Duration"] = strconv.Itoa(int(info.Duration))
+	}
+	// shown until the video plays, and as the image of link previews
+	if info.Thumbnail {
+		data["Poster"] = thumbURL
+		data["Image"] = config.ValueOf.Host + thumbURL
+	}
+	// the tracks embedded in the video, next to the subtitle paired with it
+	if hls.Enabled() {
+		var tracks []map[string]string
+		for _, track := range info.Subtitles {
+			tracks = append(tracks, map[string]string{
+				"Label": track.Label,
+				"URL":   "/subs/" + strconv.Itoa(req.MessageID) + "/" + strconv.Itoa(track.Track) + "?" + query.Encode(),
+			})
+		}
+		data["Tracks"] = tracks
+	}
+	renderPage(ctx, "player.html", data)
+}
+
+func (e *allRoutes) getSubtitleRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	vtt, err := e.stream.Subtitle(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		http.Error(ctx.Writer, streamErr.Message, streamErr.Status)
+		return
+	}
+	ctx.Data(http.StatusOK, "text/vtt; charset=utf-8", vtt)
+}
+
+// getThumbRoute serves the thumbnail of a file, for players to show as a
+// poster before loading the file itself.
+func (e *allRoutes) getThumbRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	thumb, err := e.stream.Thumb(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		http.Error(ctx.Writer, streamErr.Message, streamErr.Status)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	// a file's thumbnail never changes
+	ctx.Header("Cache-Control", "public, max-age=86400")
+	ctx.Data(http.StatusOK, "image/jpeg", thumb)
+}
@@ -0,0 +1,42 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+func (e *allRoutes) LoadProbe(r *Route) {
+	log := e.log.Named("Probe")
+	defer log.Info("Loaded probe route")
+	r.Engine.GET("/probe/:messageID", e.probeRoute)
+	r.Engine.Handle(http.MethodHead, "/probe/:messageID", e.probeRoute)
+}
+
+// probeRoute tells front-ends how soon a file could start playing, so they
+// can show something like "ready in ~2s" before starting playback. The
+// estimate is also sent in headers, for HEAD requests.
+func (e *allRoutes) probeRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	probe, err := e.stream.Probe(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		abortWithError(ctx, streamErr.Status, streamErr.Message)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Header("Access-Control-Expose-Headers", "X-FSB-Ready, X-FSB-Estimated-Start")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("X-FSB-Ready", strconv.FormatBool(probe.Ready))
+	ctx.Header("X-FSB-Estimated-Start", strconv.FormatInt(probe.EstimatedStartMs, 10))
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Writer.WriteHeader(http.StatusOK)
+		return
+	}
+	ctx.JSON(http.StatusOK, probe)
+}
@@ -1,32 +1,61 @@
 package routes
 
 import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"net/http"
 	"reflect"
 
-	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
 type Route struct {
 	Name   string
-	Engine *gin.Engine
+	Engine router.Router
+	// Admin serves the admin API and metrics. It is the same engine as
+	// Engine unless ADMIN_PORT runs them on a separate listener.
+	Admin router.Router
 }
 
-func (r *Route) Init(engine *gin.Engine) {
+func (r *Route) Init(engine router.Router) {
 	r.Engine = engine
 }
 
+// allowedMethods are the methods any route answers to, others are rejected
+// before they are routed.
+var allowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions, davMethod}
+
+// allRoutes holds the state of the routes loaded on an engine, so routes
+// loaded on several engines don't share it.
 type allRoutes struct {
 	log *zap.Logger
+	// stream serves the files the routes stream.
+	stream   *stream.Service
+	listings listingCache
+	streams  openStreams
 }
 
-func Load(log *zap.Logger, r *gin.Engine) {
+// Load registers the routes on r, and the admin API on admin, serving files
+// through service. The middleware is added before any route, so routes
+// registered on r after Load are behind it as well.
+func Load(log *zap.Logger, r router.Router, admin router.Router, service *stream.Service) {
 	log = log.Named("routes")
 	defer log.Sugar().Info("Loaded all API Routes")
-	route := &Route{Name: "/", Engine: r}
+	route := &Route{Name: "/", Engine: r, Admin: admin}
 	route.Init(r)
-	Type := reflect.TypeOf(&allRoutes{log})
-	Value := reflect.ValueOf(&allRoutes{log})
+	limit := limitRequest(int64(config.ValueOf.MaxBodyKB)<<10, allowedMethods...)
+	compress := compressResponses()
+	r.Use(validateRequest(), limit, requestLogger(log), limitIP(), compress)
+	if admin != r {
+		admin.Use(validateRequest(), limit, requestLogger(log), compress)
+	}
+	if config.ValueOf.BasicAuthUser != "" {
+		r.Use(basicAuth())
+	}
+	e := &allRoutes{log: log, stream: service, streams: openStreams{addrs: make(map[string]int)}}
+	Type := reflect.TypeOf(e)
+	Value := reflect.ValueOf(e)
 	for i := 0; i < Type.NumMethod(); i++ {
 		Type.Method(i).Func.Call([]reflect.Value{Value, reflect.ValueOf(route)})
 	}
@@ -0,0 +1,255 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/s3"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3GatewayPath is the path the S3 gateway is served under, which clients
+// take as the endpoint and address the bucket under path-style.
+const s3GatewayPath = "/s3"
+
+// s3MaxKeys is the most keys a listing returns, like S3's own limit.
+const s3MaxKeys = 1000
+
+func (e *allRoutes) LoadS3Gateway(r *Route) {
+	log := e.log.Named("S3Gateway")
+	if config.ValueOf.S3GatewaySecret == "" {
+		return
+	}
+	if config.ValueOf.S3GatewayKey == "" {
+		log.Info("S3_GATEWAY_KEY not set, S3 gateway disabled")
+		return
+	}
+	defer log.Info("Loaded S3 gateway routes")
+	gateway := r.Engine.Group(s3GatewayPath, limitRequest(int64(config.ValueOf.MaxBodyKB)<<10, http.MethodGet, http.MethodHead), s3Auth)
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		gateway.Handle(method, "", e.listBucketsRoute)
+		gateway.Handle(method, "/", e.listBucketsRoute)
+		gateway.Handle(method, "/:bucket", e.s3BucketRoute)
+		gateway.Handle(method, "/:bucket/*key", e.s3BucketRoute)
+	}
+}
+
+// s3Auth checks the AWS Signature Version 4 of requests to the gateway.
+func s3Auth(ctx *router.Context) {
+	err := s3.Verify(ctx.Request, config.ValueOf.S3GatewayKey, config.ValueOf.S3GatewaySecret, time.Now())
+	if errors.Is(err, s3.ErrSignature) {
+		s3Error(ctx, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		ctx.Abort()
+		return
+	}
+	if err != nil {
+		s3Error(ctx, http.StatusForbidden, "AccessDenied", err.Error())
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+// hasS3Signature reports whether the S3 gateway is enabled and the request
+// is signed with its credentials.
+func hasS3Signature(ctx *router.Context) bool {
+	if config.ValueOf.S3GatewaySecret == "" || config.ValueOf.S3GatewayKey == "" {
+		return false
+	}
+	if !strings.HasPrefix(ctx.Request.URL.Path, s3GatewayPath) {
+		return false
+	}
+	return s3.Verify(ctx.Request, config.ValueOf.S3GatewayKey, config.ValueOf.S3GatewaySecret, time.Now()) == nil
+}
+
+type s3ErrorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func s3Error(ctx *router.Context, status int, code string, message string) {
+	s3XML(ctx, status, s3ErrorResponse{Code: code, Message: message})
+}
+
+func s3XML(ctx *router.Context, status int, value any) {
+	body, err := xml.Marshal(value)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	ctx.Data(status, "application/xml", append([]byte(xml.Header), body...))
+}
+
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketsResult struct {
+	XMLName xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	OwnerID string     `xml:"Owner>ID"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+// listBucketsRoute lists the single bucket the gateway serves, created when
+// the oldest file in it was indexed.
+func (e *allRoutes) listBucketsRoute(ctx *router.Context) {
+	listing, err := e.channelFiles(config.ValueOf.LogChannelID)
+	if err != nil {
+		s3Error(ctx, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	created := time.Now()
+	for _, entry := range listing.files {
+		if entry.CreatedAt.Before(created) {
+			created = entry.CreatedAt
+		}
+	}
+	s3XML(ctx, http.StatusOK, listBucketsResult{
+		OwnerID: "fsb",
+		Buckets: []s3Bucket{{Name: config.ValueOf.S3GatewayBucket, CreationDate: created.UTC().Format(time.RFC3339)}},
+	})
+}
+
+// s3BucketRoute serves ListObjects and ListObjectsV2 on the bucket, and
+// GetObject and HeadObject on the files in it.
+func (e *allRoutes) s3BucketRoute(ctx *router.Context) {
+	if ctx.Param("bucket") != config.ValueOf.S3GatewayBucket {
+		s3Error(ctx, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	listing, err := e.channelFiles(config.ValueOf.LogChannelID)
+	if err != nil {
+		s3Error(ctx, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	if key == "" {
+		listObjects(ctx, listing)
+		return
+	}
+	entry, ok := listing.files[key]
+	if !ok {
+		s3Error(ctx, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	ctx.Header("ETag", s3ETag(entry.MessageID, entry.FileSize))
+	ctx.Header("Last-Modified", entry.CreatedAt.UTC().Format(http.TimeFormat))
+	hash := utils.GetShortHash(utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt))
+	e.serveStream(ctx, &stream.Request{
+		ChannelID: entry.ChannelID,
+		MessageID: entry.MessageID,
+		Hash:      hash,
+	})
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listObjectsResult struct {
+	XMLName               xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string     `xml:"Name"`
+	Prefix                string     `xml:"Prefix"`
+	Delimiter             string     `xml:"Delimiter,omitempty"`
+	MaxKeys               int        `xml:"MaxKeys"`
+	IsTruncated           bool       `xml:"IsTruncated"`
+	Marker                string     `xml:"Marker,omitempty"`
+	NextMarker            string     `xml:"NextMarker,omitempty"`
+	KeyCount              *int       `xml:"KeyCount"`
+	ContinuationToken     string     `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string     `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string     `xml:"StartAfter,omitempty"`
+	Contents              []s3Object `xml:"Contents"`
+}
+
+// listObjects lists the files in key order, starting after ?marker= for
+// ListObjects and after ?continuation-token= or ?start-after= for
+// ListObjectsV2. Keys never contain slashes, so there are no common
+// prefixes to group them by.
+func listObjects(ctx *router.Context, listing *fileListing) {
+	maxKeys := s3MaxKeys
+	if value := ctx.Query("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			s3Error(ctx, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = min(n, s3MaxKeys)
+	}
+	prefix := ctx.Query("prefix")
+	v2 := ctx.Query("list-type") == "2"
+	after := ctx.Query("marker")
+	if v2 {
+		after = ctx.Query("start-after")
+		if token := ctx.Query("continuation-token"); token != "" {
+			if err := utils.DecodeCursor(token, &after); err != nil {
+				s3Error(ctx, http.StatusBadRequest, "InvalidArgument", "invalid continuation-token")
+				return
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(listing.names))
+	for _, name := range listing.names {
+		if strings.HasPrefix(name, prefix) && name > after {
+			keys = append(keys, name)
+		}
+	}
+	sort.Strings(keys)
+	result := listObjectsResult{
+		Name:      config.ValueOf.S3GatewayBucket,
+		Prefix:    prefix,
+		Delimiter: ctx.Query("delimiter"),
+		MaxKeys:   maxKeys,
+		Contents:  []s3Object{},
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+	}
+	for _, key := range keys {
+		entry := listing.files[key]
+		result.Contents = append(result.Contents, s3Object{
+			Key:          key,
+			LastModified: entry.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         s3ETag(entry.MessageID, entry.FileSize),
+			Size:         entry.FileSize,
+			StorageClass: "STANDARD",
+		})
+	}
+	if v2 {
+		count := len(keys)
+		result.KeyCount = &count
+		result.ContinuationToken = ctx.Query("continuation-token")
+		result.StartAfter = ctx.Query("start-after")
+		if result.IsTruncated && len(keys) > 0 {
+			result.NextContinuationToken = utils.EncodeCursor(keys[len(keys)-1])
+		}
+	} else {
+		result.Marker = ctx.Query("marker")
+		if result.IsTruncated && len(keys) > 0 {
+			result.NextMarker = keys[len(keys)-1]
+		}
+	}
+	s3XML(ctx, http.StatusOK, result)
+}
+
+// s3ETag is the ETag of a file. It isn't an MD5 of the file like the ETags
+// of S3, which clients that check them tell from its format.
+func s3ETag(messageID int, size int64) string {
+	return fmt.Sprintf(`"fsb-%d-%d"`, messageID, size)
+}
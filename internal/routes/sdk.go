@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	_ "embed"
+	"net/http"
+)
+
+// sdkVersion is bumped whenever the SDK changes incompatibly. Older versions
+// aren't kept, /sdk/v<version>.js just lets integrators pin the one they
+// were written against and notice when it goes away.
+const sdkVersion = "1"
+
+//go:embed static/sdk.js
+var sdkScript []byte
+
+func (e *allRoutes) LoadSDK(r *Route) {
+	log := e.log.Named("SDK")
+	defer log.Info("Loaded SDK route")
+	r.Engine.GET("/sdk.js", sdkRoute)
+	r.Engine.GET("/sdk/v"+sdkVersion+".js", sdkRoute)
+}
+
+func sdkRoute(ctx *router.Context) {
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Header("X-FSB-SDK-Version", sdkVersion)
+	ctx.Data(http.StatusOK, "text/javascript; charset=utf-8", sdkScript)
+}
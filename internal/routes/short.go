@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/shortlink"
+	"EverythingSuckz/fsb/internal/store"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+func (e *allRoutes) LoadShortLinks(r *Route) {
+	log := e.log.Named("ShortLinks")
+	defer log.Info("Loaded short link routes")
+	r.Engine.GET(shortlink.Path+":slug", shortLinkRoute)
+	r.Engine.Handle(http.MethodHead, shortlink.Path+":slug", shortLinkRoute)
+}
+
+// shortLinkRoute redirects a short link to the link it was made for. The
+// query of the short link is added to the target's, so &d=true and the like
+// work on short links too.
+func shortLinkRoute(ctx *router.Context) {
+	link, err := store.GetStore().GetShortLink(ctx.Param("slug"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(ctx.Writer, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	target := link.Target
+	if query := ctx.Request.URL.RawQuery; query != "" {
+		if strings.Contains(target, "?") {
+			target += "&" + query
+		} else {
+			target += "?" + query
+		}
+	}
+	http.Redirect(ctx.Writer, ctx.Request, target, http.StatusFound)
+}
@@ -0,0 +1,65 @@
+/*
+ * File Stream Bot JS SDK
+ *
+ * <script src="https://your-host/sdk.js"></script>
+ * <script>
+ *   const url = FSB.buildStreamUrl(123, "abc123");
+ *   FSB.probeInfo(123, "abc123").then(info => console.log(info.size));
+ *   FSB.attachPlayer(document.getElementById("player"), 123, "abc123");
+ * </script>
+ */
+(function (global) {
+  "use strict";
+
+  var VERSION = "1";
+  var script = document.currentScript;
+  var baseUrl = script ? new URL(script.src).origin : location.origin;
+
+  // buildStreamUrl returns the stream link of a message. options may set
+  // channel (for files outside the default storage channel) and download
+  // (to force a download instead of inline playback).
+  function buildStreamUrl(id, hash, options) {
+    options = options || {};
+    var url = new URL("/stream/" + encodeURIComponent(id), baseUrl);
+    url.searchParams.set("hash", hash);
+    if (options.channel) url.searchParams.set("channel", options.channel);
+    if (options.download) url.searchParams.set("d", "true");
+    return url.toString();
+  }
+
+  // probeInfo fetches a file's size, type and name without downloading it.
+  function probeInfo(id, hash, options) {
+    return fetch(buildStreamUrl(id, hash, options), { method: "HEAD" }).then(function (res) {
+      if (!res.ok) throw new Error("fsb: " + res.status + " " + res.statusText);
+      var disposition = res.headers.get("Content-Disposition") || "";
+      var name = /filename="([^"]*)"/.exec(disposition);
+      return {
+        size: Number(res.headers.get("Content-Length")),
+        mimeType: res.headers.get("Content-Type"),
+        fileName: name ? name[1] : "",
+        acceptsRanges: res.headers.get("Accept-Ranges") === "bytes",
+      };
+    });
+  }
+
+  // attachPlayer plays a file in el, which is either a <video>/<audio>
+  // element or a container a <video> element is created in.
+  function attachPlayer(el, id, hash, options) {
+    var player = el;
+    if (!(el instanceof HTMLMediaElement)) {
+      player = document.createElement("video");
+      player.style.width = "100%";
+      el.appendChild(player);
+    }
+    player.controls = true;
+    player.src = buildStreamUrl(id, hash, options);
+    return player;
+  }
+
+  global.FSB = {
+    version: VERSION,
+    buildStreamUrl: buildStreamUrl,
+    probeInfo: probeInfo,
+    attachPlayer: attachPlayer,
+  };
+})(window);
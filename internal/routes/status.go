@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+)
+
+func (e *allRoutes) LoadStatus(r *Route) {
+	log := e.log.Named("Status")
+	defer log.Info("Loaded status route")
+	r.Engine.GET("/status", func(ctx *router.Context) {
+		renderPage(ctx, "status.html", nil)
+	})
+}
@@ -1,133 +1,308 @@
 package routes
 
 import (
+	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/federation"
+	"EverythingSuckz/fsb/internal/objects"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
 	"EverythingSuckz/fsb/internal/utils"
-	"fmt"
-	"io"
+	"errors"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/gotd/td/tg"
-	range_parser "github.com/quantumsheep/range-parser"
 	"go.uber.org/zap"
-
-	"github.com/gin-gonic/gin"
 )
 
-var log *zap.Logger
-
 func (e *allRoutes) LoadHome(r *Route) {
-	log = e.log.Named("Stream")
+	log := e.log.Named("Stream")
 	defer log.Info("Loaded stream route")
-	r.Engine.GET("/stream/:messageID", getStreamRoute)
+	r.Engine.GET("/stream/:messageID", e.getStreamRoute)
+}
+
+func (e *allRoutes) getStreamRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	e.serveStream(ctx, req)
 }
 
-func getStreamRoute(ctx *gin.Context) {
+// serveStream streams the file in req, taking the rest of the request's
+// options from its headers and query.
+func (e *allRoutes) serveStream(ctx *router.Context, req *stream.Request) {
 	w := ctx.Writer
 	r := ctx.Request
 
+	req.Range = r.Header.Get("Range")
+	if req.Range == "" {
+		// continuation links carry the range in the query
+		req.Range = ctx.Query("range")
+	}
+	req.URL = r.URL.RequestURI()
+	req.Download = ctx.Query("d") == "true"
+	// link preview bots only get the headers, otherwise every link shared
+	// in a chat would download the file from Telegram
+	req.Head = r.Method == "HEAD" || utils.IsLinkPreviewer(r.UserAgent())
+	req.EmbedOrigin = ctx.Query("origin")
+	req.RequestOrigin = requestOrigin(r)
+	req.RemoteAddr = ctx.ClientIP()
+	req.Strip = ctx.Query("strip") == "1"
+	req.Decompress = ctx.Query("decompress") == "1"
+	req.PhotoSize = ctx.Query("size")
+	req.ASCIIFileName = config.ValueOf.ASCIIFilenames
+	if ascii := ctx.Query("ascii"); ascii != "" {
+		req.ASCIIFileName = ascii == "1"
+	}
+	req.Debug = config.ValueOf.DebugHeaders || hasAdminToken(ctx)
+
+	// lets the JS SDK probe files from other sites
+	exposed := "Content-Length, Content-Range, Content-Disposition, Accept-Ranges, Link, " + stream.OnceHeader
+	if req.Debug {
+		exposed += ", " + strings.Join(stream.DebugHeaders, ", ")
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", exposed)
+
+	if !req.Head {
+		release, ok := e.acquireStream(ctx)
+		if !ok {
+			return
+		}
+		defer release()
+	}
+	err := e.stream.Serve(r.Context(), req, w)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		writeStreamError(w, streamErr)
+	} else if err != nil {
+		requestLog(ctx).Error("Error while copying stream", zap.Error(err))
+	}
+}
+
+// writeStreamError writes the error a stream request was rejected with,
+// asking browsers for the password of password protected files.
+func writeStreamError(w http.ResponseWriter, err *stream.Error) {
+	if err.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Password protected file", charset="UTF-8"`)
+	}
+	http.Error(w, err.Message, err.Status)
+}
+
+// linkPassword returns the password sent for a password protected file, as
+// ?pw= or as the basic auth password unless BASIC_AUTH_PASSWORD takes that.
+func linkPassword(ctx *router.Context) string {
+	if password := ctx.Query("pw"); password != "" || config.ValueOf.BasicAuthUser != "" {
+		return password
+	}
+	_, password, _ := ctx.Request.BasicAuth()
+	return password
+}
+
+// fileRequest reads the message ID, hash and channel that identify a file
+// from the request, writing an error response if any of them are invalid.
+func fileRequest(ctx *router.Context) (*stream.Request, bool) {
+	w := ctx.Writer
+
 	messageIDParm := ctx.Param("messageID")
 	messageID, err := strconv.Atoi(messageIDParm)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
 	authHash := ctx.Query("hash")
 	if authHash == "" {
 		http.Error(w, "missing hash param", http.StatusBadRequest)
-		return
+		return nil, false
 	}
 
-	worker := bot.GetNextWorker()
-
-	file, err := utils.FileFromMessage(ctx, worker.Client, messageID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	channelID := config.ValueOf.LogChannelID
+	if channelParam := ctx.Query("channel"); channelParam != "" {
+		channelID, err = strconv.ParseInt(channelParam, 10, 64)
+		if err != nil {
+			http.Error(w, "unknown channel", http.StatusNotFound)
+			return nil, false
+		}
 	}
+	return checkedFileRequest(ctx, channelID, messageID, authHash)
+}
 
-	expectedHash := utils.PackFile(
-		file.FileName,
-		file.FileSize,
-		file.MimeType,
-		file.ID,
-	)
-	if !utils.CheckHash(authHash, expectedHash) {
-		http.Error(w, "invalid hash", http.StatusBadRequest)
-		return
+// lockedOut turns away addresses that sent wrong hashes for many files, as
+// they are guessing links, writing the error response.
+func lockedOut(ctx *router.Context) bool {
+	wait := utils.LockedOut(ctx.ClientIP())
+	if wait <= 0 {
+		return false
 	}
+	ctx.Writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(ctx.Writer, "too many invalid links, try again later", http.StatusTooManyRequests)
+	return true
+}
 
-	// for photo messages
-	if file.FileSize == 0 {
-		res, err := worker.Client.API().UploadGetFile(ctx, &tg.UploadGetFileRequest{
-			Location: file.Location,
-			Offset:   0,
-			Limit:    1024 * 1024,
-		})
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		result, ok := res.(*tg.UploadFile)
-		if !ok {
-			http.Error(w, "unexpected response", http.StatusInternalServerError)
-			return
+// checkedFileRequest checks that the file can be served to the client,
+// writing an error response or redirect otherwise.
+func checkedFileRequest(ctx *router.Context, channelID int64, messageID int, authHash string) (*stream.Request, bool) {
+	w := ctx.Writer
+	if lockedOut(ctx) {
+		return nil, false
+	}
+	if channelID != config.ValueOf.LogChannelID {
+		if peer, ok := federation.PeerFor(channelID); ok && !channels.IsAllowed(channelID) {
+			// a peer's request for a channel it doesn't store either
+			// would only be sent back here
+			if federation.Verify(ctx.Request) {
+				http.Error(w, "unknown channel", http.StatusNotFound)
+				return nil, false
+			}
+			http.Redirect(w, ctx.Request, federation.RedirectURL(peer, ctx.Request), http.StatusTemporaryRedirect)
+			return nil, false
 		}
-		fileBytes := result.GetBytes()
-		ctx.Header("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", file.FileName))
-		if r.Method != "HEAD" {
-			ctx.Data(http.StatusOK, file.MimeType, fileBytes)
+		if groupID, ok := channels.GroupOf(channelID); ok {
+			if !checkMember(ctx, groupID, channelID, messageID) {
+				return nil, false
+			}
+		} else if !(channels.IsAllowed(channelID) || objects.Stored(channelID, messageID)) {
+			http.Error(w, "unknown channel", http.StatusNotFound)
+			return nil, false
 		}
-		return
 	}
-
-	ctx.Header("Accept-Ranges", "bytes")
-	var start, end int64
-	rangeHeader := r.Header.Get("Range")
-
-	if rangeHeader == "" {
-		start = 0
-		end = file.FileSize - 1
-		w.WriteHeader(http.StatusOK)
-	} else {
-		ranges, err := range_parser.Parse(file.FileSize, r.Header.Get("Range"))
-		if err != nil {
+	// links made with /bind only work from their range, and their hash
+	// is checked against it
+	boundIP := ctx.Query("ip")
+	if boundIP != "" && !utils.IPInBinding(boundIP, ctx.ClientIP()) {
+		http.Error(w, "this link can only be used from "+boundIP, http.StatusForbidden)
+		return nil, false
+	}
+	var preview utils.Preview
+	if value := ctx.Query("preview"); value != "" {
+		var err error
+		if preview, err = utils.ParsePreview(value); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+			return nil, false
 		}
-		start = ranges[0].Start
-		end = ranges[0].End
-		ctx.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
-		log.Info("Content-Range", zap.Int64("start", start), zap.Int64("end", end), zap.Int64("fileSize", file.FileSize))
-		w.WriteHeader(http.StatusPartialContent)
 	}
-
-	contentLength := end - start + 1
-	mimeType := file.MimeType
-
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	// links made with /expire carry when they expire, which their hash is
+	// checked against
+	var expires time.Time
+	if value := ctx.Query("expires"); value != "" {
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || unix <= 0 {
+			http.Error(w, "invalid expires", http.StatusBadRequest)
+			return nil, false
+		}
+		expires = time.Unix(unix, 0)
 	}
+	worker, ok := forcedWorker(ctx)
+	if !ok {
+		return nil, false
+	}
+	// links made with /once carry their ID, and the client that claimed
+	// one sends back the session token it was given
+	once := ctx.Query("once")
+	var onceSession string
+	if once != "" {
+		onceSession = ctx.GetHeader(stream.OnceHeader)
+		if cookie, err := ctx.Request.Cookie(stream.OnceCookie + once); err == nil && onceSession == "" {
+			onceSession = cookie.Value
+		}
+		if onceSession == "" {
+			onceSession = ctx.Query("session")
+		}
+	}
+	// links made with /geo carry the ID their countries are saved under
+	geo := ctx.Query("geo")
+	// the country is also counted in the uploader's link stats. Clients
+	// could send the CDN's header themselves, so it's only read from the
+	// trusted proxies
+	var country string
+	if config.ValueOf.GeoIPHeader != "" && ctx.FromTrustedProxy() {
+		country = strings.ToUpper(ctx.GetHeader(config.ValueOf.GeoIPHeader))
+	}
+	req := &stream.Request{
+		ChannelID:   channelID,
+		MessageID:   messageID,
+		Hash:        authHash,
+		BoundIP:     boundIP,
+		Preview:     preview,
+		Once:        once,
+		OnceSession: onceSession,
+		Expires:     expires,
+		Geo:         geo,
+		Country:     country,
+		// links made with /revocable carry the ID they're saved under
+		Revocable: ctx.Query("link"),
+		Password:  linkPassword(ctx),
+		// the hash check counts misses against the client
+		RemoteAddr: ctx.ClientIP(),
+		Worker:     worker,
+	}
+	stream.FollowAlias(req)
+	return req, true
+}
 
-	ctx.Header("Content-Type", mimeType)
-	ctx.Header("Content-Length", strconv.FormatInt(contentLength, 10))
-
-	disposition := "inline"
-
-	if ctx.Query("d") == "true" {
-		disposition = "attachment"
+// forcedWorker returns the ID of the worker the request asks to be served
+// by with ?worker= or the X-FSB-Worker header, or 0 when it doesn't ask for
+// one. Only requests with the admin token may, as it's for debugging issues
+// of a single worker. It writes an error response otherwise.
+func forcedWorker(ctx *router.Context) (int, bool) {
+	value := ctx.Query("worker")
+	if value == "" {
+		value = ctx.GetHeader(stream.WorkerHeader)
+	}
+	if value == "" {
+		return 0, true
+	}
+	if !hasAdminToken(ctx) {
+		http.Error(ctx.Writer, "forcing a worker needs the admin token", http.StatusUnauthorized)
+		return 0, false
 	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id == 0 || bot.GetWorker(id) == nil {
+		http.Error(ctx.Writer, "unknown worker", http.StatusBadRequest)
+		return 0, false
+	}
+	// confirms which worker the response came from
+	ctx.Header(stream.WorkerHeader, strconv.Itoa(id))
+	return id, true
+}
 
-	ctx.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, file.FileName))
+// checkMember checks that the link to a group's file was given to a member
+// of the group who still is one, writing an error response otherwise.
+func checkMember(ctx *router.Context, groupID int64, channelID int64, messageID int) bool {
+	memberID, err := strconv.ParseInt(ctx.Query("member"), 10, 64)
+	if err != nil || !utils.CheckMember(channelID, messageID, memberID, ctx.Query("msig")) {
+		http.Error(ctx.Writer, "this file is only available to members of its group, get a link from the bot", http.StatusForbidden)
+		return false
+	}
+	member, err := channels.IsMember(ctx.Request.Context(), bot.Bot.API(), bot.Bot.PeerStorage, groupID, memberID)
+	if err != nil {
+		requestLog(ctx).Warn("Failed to check group membership", zap.Int64("groupID", groupID), zap.Error(err))
+		http.Error(ctx.Writer, "failed to check group membership", http.StatusBadGateway)
+		return false
+	}
+	if !member {
+		http.Error(ctx.Writer, "this file is only available to members of its group", http.StatusForbidden)
+		return false
+	}
+	return true
+}
 
-	if r.Method != "HEAD" {
-    lr, _ := utils.NewTelegramReader(ctx, worker.Client, file.Location, start, end, contentLength)
-    // Use a larger buffer (1MB instead of default 32KB) for faster streaming
-    buf := make([]byte, 1<<20) // 1MB buffer
-    if _, err := io.CopyBuffer(w, lr, buf); err != nil {
-        log.Error("Error while copying stream", zap.Error(err))
-    }
-  }
+// requestOrigin returns the origin a browser request was made from, preferring
+// the Origin header and falling back to the Referer.
+func requestOrigin(r *http.Request) string {
+	for _, header := range []string{"Origin", "Referer"} {
+		if value := r.Header.Get(header); value != "" {
+			origin, err := utils.NormalizeOrigin(value)
+			if err == nil {
+				return origin
+			}
+		}
+	}
+	return ""
 }
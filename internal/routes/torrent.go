@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/utils"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadTorrent(r *Route) {
+	log := e.log.Named("Torrent")
+	defer log.Info("Loaded torrent route")
+	r.Engine.GET("/torrent/:messageID", e.getTorrentRoute)
+}
+
+// getTorrentRoute serves a .torrent of the file, with the query of the
+// file's stream link, seeded from that link.
+func (e *allRoutes) getTorrentRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	if req.Preview.Enabled() || req.Once != "" {
+		http.Error(ctx.Writer, "not available for preview or one-time links", http.StatusForbidden)
+		return
+	}
+	req.RemoteAddr = ctx.ClientIP()
+	webSeed := fmt.Sprintf("%s/stream/%d?%s", config.ValueOf.Host, req.MessageID, ctx.Request.URL.RawQuery)
+	torrent, fileName, err := e.stream.Torrent(ctx.Request.Context(), req, webSeed)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		http.Error(ctx.Writer, streamErr.Message, streamErr.Status)
+		return
+	}
+	if err != nil {
+		requestLog(ctx).Warn("Failed to make torrent", zap.Error(err))
+		http.Error(ctx.Writer, err.Error(), http.StatusBadGateway)
+		return
+	}
+	ctx.Header("Access-Control-Allow-Origin", "*")
+	ctx.Header("Content-Disposition", utils.ContentDisposition("attachment", fileName+".torrent", false))
+	ctx.Data(http.StatusOK, "application/x-bittorrent", torrent)
+}
@@ -0,0 +1,74 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/transcode"
+	"errors"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadTranscode(r *Route) {
+	log := e.log.Named("Transcode")
+	if !transcode.Enabled() {
+		log.Info("FFMPEG_PATH not set, transcoding disabled")
+		return
+	}
+	defer log.Info("Loaded transcode route")
+	r.Engine.GET("/transcode/:messageID", e.getTranscodeRoute)
+}
+
+// getTranscodeRoute streams a video re-encoded to ?codec= (h264 or vp9),
+// scaled down to ?height= when it's given, for videos browsers can't play.
+func (e *allRoutes) getTranscodeRoute(ctx *router.Context) {
+	req, ok := fileRequest(ctx)
+	if !ok {
+		return
+	}
+	if req.Preview.Enabled() || req.Once != "" {
+		http.Error(ctx.Writer, "not available for preview or one-time links", http.StatusForbidden)
+		return
+	}
+	opts, err := transcode.ParseOptions(ctx.Query("codec"), ctx.Query("height"))
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, err := e.stream.Info(ctx.Request.Context(), req)
+	var streamErr *stream.Error
+	if errors.As(err, &streamErr) {
+		http.Error(ctx.Writer, streamErr.Message, streamErr.Status)
+		return
+	}
+	if !strings.HasPrefix(info.MimeType, "video/") {
+		http.Error(ctx.Writer, "only videos can be transcoded", http.StatusUnsupportedMediaType)
+		return
+	}
+	w := ctx.Writer
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if ctx.Request.Method == http.MethodHead {
+		w.Header().Set("Content-Type", opts.ContentType())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	began := false
+	err = transcode.Run(ctx.Request.Context(), ffmpegInput(ctx, req), opts, w, func() {
+		began = true
+		w.Header().Set("Content-Type", opts.ContentType())
+		// the length isn't known until the video is transcoded
+		w.Header().Set("Accept-Ranges", "none")
+		w.WriteHeader(http.StatusOK)
+	})
+	switch {
+	case errors.Is(err, transcode.ErrBusy):
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil && !began:
+		http.Error(w, "failed to transcode the video", http.StatusBadGateway)
+	case err != nil:
+		requestLog(ctx).Debug("Transcoding stopped", zap.Error(err))
+	}
+}
@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/uploads"
+	"errors"
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+func (e *allRoutes) LoadUploads(r *Route) {
+	log := e.log.Named("Uploads")
+	if !config.ValueOf.HTTPUploads {
+		return
+	}
+	if config.ValueOf.AdminToken == "" && config.ValueOf.AdminPort == 0 {
+		log.Info("ADMIN_TOKEN not set, HTTP uploads disabled")
+		return
+	}
+	defer log.Info("Loaded upload routes")
+	// files are sent as the body, which the global limit is far too small for
+	limit := limitRequest(int64(config.ValueOf.MaxUploadMB)<<20, http.MethodGet, http.MethodPost, http.MethodPut)
+	api := r.Admin.Group("/api/uploads", limit, adminAuth)
+	api.POST("", createUploadRoute)
+	api.Handle(http.MethodPut, "/:id", runUploadRoute)
+	api.GET("/:id/progress", uploadProgressRoute)
+	api.GET("/:id/events", uploadEventsRoute)
+}
+
+// createUploadRoute registers an upload, whose ID the client can watch the
+// progress of while sending the file to runUploadRoute.
+func createUploadRoute(ctx *router.Context) {
+	name := ctx.Query("name")
+	if name == "" {
+		abortWithError(ctx, http.StatusBadRequest, "missing name param")
+		return
+	}
+	ctx.JSON(http.StatusCreated, uploads.Create(name).Progress())
+}
+
+func runUploadRoute(ctx *router.Context) {
+	upload, ok := uploads.Get(ctx.Param("id"))
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, "unknown upload")
+		return
+	}
+	mimeType := ctx.GetHeader("Content-Type")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	err := upload.Run(ctx.Request.Context(), bot.Bot.API(), bot.Bot.PeerStorage, ctx.Request.Body, ctx.Request.ContentLength, mimeType)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		abortWithError(ctx, http.StatusRequestEntityTooLarge, "file too large")
+		return
+	}
+	if err != nil {
+		requestLog(ctx).Warn("Upload failed", zap.Error(err))
+		abortWithError(ctx, http.StatusBadGateway, err.Error())
+		return
+	}
+	ctx.JSON(http.StatusOK, upload.Progress())
+}
+
+func uploadProgressRoute(ctx *router.Context) {
+	upload, ok := uploads.Get(ctx.Param("id"))
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, "unknown upload")
+		return
+	}
+	ctx.JSON(http.StatusOK, upload.Progress())
+}
+
+func uploadEventsRoute(ctx *router.Context) {
+	upload, ok := uploads.Get(ctx.Param("id"))
+	if !ok {
+		abortWithError(ctx, http.StatusNotFound, "unknown upload")
+		return
+	}
+	progress, unsubscribe := upload.Subscribe()
+	defer unsubscribe()
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case state, ok := <-progress:
+			if !ok {
+				return false
+			}
+			ctx.SSEvent("progress", state)
+			return !state.Done
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/internal/router"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Limits on the parts of a request handlers read. Header sizes as a whole
+// are limited by the server's MaxHeaderBytes.
+const (
+	maxPathLength  = 1024
+	maxQueryLength = 4096
+	maxRangeLength = 512
+	// players never ask for more than a couple of ranges, thousands of them
+	// are only used to make the server do useless work
+	maxRanges = 8
+)
+
+// validateRequest rejects requests with oversized paths, queries or Range
+// headers before any handler runs, and trims the whitespace some clients
+// leave around query values when links are copied.
+func validateRequest() router.HandlerFunc {
+	return func(ctx *router.Context) {
+		r := ctx.Request
+		if len(r.URL.Path) > maxPathLength || len(r.URL.RawQuery) > maxQueryLength {
+			ctx.AbortWithStatus(http.StatusRequestURITooLong)
+			return
+		}
+		query, err := url.ParseQuery(r.URL.RawQuery)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "malformed query")
+			ctx.Abort()
+			return
+		}
+		if !normalizeQuery(query) {
+			r.URL.RawQuery = query.Encode()
+		}
+		for _, byteRange := range []string{r.Header.Get("Range"), query.Get("range")} {
+			if !validRange(byteRange) {
+				ctx.String(http.StatusRequestedRangeNotSatisfiable, "too many ranges")
+				ctx.Abort()
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// normalizeQuery trims the values of query in place, reporting whether they
+// were already trimmed.
+func normalizeQuery(query url.Values) bool {
+	clean := true
+	for _, values := range query {
+		for i, value := range values {
+			if trimmed := strings.TrimSpace(value); trimmed != value {
+				values[i] = trimmed
+				clean = false
+			}
+		}
+	}
+	return clean
+}
+
+func validRange(byteRange string) bool {
+	return len(byteRange) <= maxRangeLength && strings.Count(byteRange, ",") < maxRanges
+}
@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/utils"
+	"crypto/subtle"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// davMethod is the WebDAV method listing collections and describing files.
+const davMethod = "PROPFIND"
+
+func (e *allRoutes) LoadWebDAV(r *Route) {
+	log := e.log.Named("WebDAV")
+	if !config.ValueOf.WebDAV {
+		return
+	}
+	if config.ValueOf.AdminToken == "" {
+		log.Info("ADMIN_TOKEN not set, WebDAV disabled")
+		return
+	}
+	defer log.Info("Loaded WebDAV routes")
+	methods := []string{http.MethodGet, http.MethodHead, http.MethodOptions, davMethod}
+	dav := r.Engine.Group("/dav", limitRequest(int64(config.ValueOf.MaxBodyKB)<<10, methods...), davAuth)
+	for _, method := range methods {
+		dav.Handle(method, "/*path", e.davRoute)
+	}
+}
+
+// davAuth checks that the client sent the admin token as its password, which
+// is all WebDAV clients can send. The user name is ignored.
+func davAuth(ctx *router.Context) {
+	if !hasDAVToken(ctx) {
+		ctx.Header("WWW-Authenticate", `Basic realm="File Stream Bot WebDAV", charset="UTF-8"`)
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	ctx.Next()
+}
+
+// hasDAVToken reports whether WebDAV is enabled and the request sent the
+// admin token as its basic auth password.
+func hasDAVToken(ctx *router.Context) bool {
+	if !config.ValueOf.WebDAV {
+		return false
+	}
+	return hasAdminPassword(ctx)
+}
+
+// hasAdminPassword reports whether the request sent the admin token as its
+// basic auth password, for clients that can't send it any other way. The
+// user name is ignored.
+func hasAdminPassword(ctx *router.Context) bool {
+	if config.ValueOf.AdminToken == "" {
+		return false
+	}
+	_, password, ok := ctx.Request.BasicAuth()
+	return ok && subtle.ConstantTimeCompare([]byte(password), []byte(config.ValueOf.AdminToken)) == 1
+}
+
+// davRoute serves the files indexed from the log channel as a read-only
+// WebDAV collection at /dav/, so it can be mounted as a network drive.
+func (e *allRoutes) davRoute(ctx *router.Context) {
+	name := strings.Trim(ctx.Param("path"), "/")
+	if ctx.Request.Method == http.MethodOptions {
+		// class 1 only, which makes Finder mount the drive read-only
+		ctx.Header("DAV", "1")
+		ctx.Header("Allow", "OPTIONS, GET, HEAD, "+davMethod)
+		ctx.Writer.WriteHeader(http.StatusOK)
+		return
+	}
+	listing, err := e.channelFiles(config.ValueOf.LogChannelID)
+	if err != nil {
+		http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var entry *store.FileEntry
+	if name != "" {
+		var ok bool
+		if entry, ok = listing.files[name]; !ok {
+			http.Error(ctx.Writer, "not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	if ctx.Request.Method == davMethod {
+		responses := []davResponse{}
+		if entry == nil {
+			responses = append(responses, davCollection())
+			if ctx.GetHeader("Depth") != "0" {
+				for _, name := range listing.names {
+					responses = append(responses, davFile(name, listing.files[name]))
+				}
+			}
+		} else {
+			responses = append(responses, davFile(name, entry))
+		}
+		body, err := xml.Marshal(davMultistatus{Namespace: "DAV:", Responses: responses})
+		if err != nil {
+			http.Error(ctx.Writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ctx.Data(http.StatusMultiStatus, "application/xml; charset=utf-8", append([]byte(xml.Header), body...))
+		return
+	}
+
+	if entry == nil {
+		ctx.Header("Allow", "OPTIONS, "+davMethod)
+		http.Error(ctx.Writer, "mount this URL with a WebDAV client", http.StatusMethodNotAllowed)
+		return
+	}
+	hash := utils.GetShortHash(utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt))
+	e.serveStream(ctx, &stream.Request{
+		ChannelID: entry.ChannelID,
+		MessageID: entry.MessageID,
+		Hash:      hash,
+	})
+}
+
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	Namespace string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified,omitempty"`
+	CreationDate  string          `xml:"D:creationdate,omitempty"`
+	ETag          string          `xml:"D:getetag,omitempty"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+func davCollection() davResponse {
+	return davResponse{
+		Href: "/dav/",
+		Propstat: davPropstat{
+			Prop:   davProp{DisplayName: "dav", ResourceType: davResourceType{Collection: &struct{}{}}},
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
+
+func davFile(name string, entry *store.FileEntry) davResponse {
+	mimeType := entry.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return davResponse{
+		Href: "/dav/" + url.PathEscape(name),
+		Propstat: davPropstat{
+			Prop: davProp{
+				DisplayName:   name,
+				ContentLength: &entry.FileSize,
+				ContentType:   mimeType,
+				LastModified:  entry.CreatedAt.UTC().Format(http.TimeFormat),
+				CreationDate:  entry.CreatedAt.UTC().Format(time.RFC3339),
+				ETag:          fmt.Sprintf(`"%d-%d"`, entry.MessageID, entry.FileSize),
+			},
+			Status: "HTTP/1.1 200 OK",
+		},
+	}
+}
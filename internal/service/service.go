@@ -0,0 +1,55 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// Name is the name the bot is registered under with the service manager.
+const Name = "fsb"
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+type InstallOptions struct {
+	Executable string
+	WorkingDir string
+	Port       int
+	UnitDir    string
+}
+
+// Listen returns the socket passed in by systemd socket activation, or a new
+// TCP listener on the given port when the process was not socket activated.
+func Listen(port int) (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err == nil && pid == os.Getpid() {
+		fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		if err == nil && fds > 0 {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+			file := os.NewFile(uintptr(listenFdsStart), "LISTEN_FD_3")
+			defer file.Close()
+			return net.FileListener(file)
+		}
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", port))
+}
+
+// Notify sends a state update (eg. READY=1) to systemd. It does nothing when
+// the process is not supervised by systemd.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,71 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+const serviceUnit = `[Unit]
+Description=Telegram File Stream Bot
+After=network-online.target
+Wants=network-online.target
+Requires=%[1]s.socket
+
+[Service]
+Type=notify
+WorkingDirectory=%[2]s
+ExecStart=%[3]s run
+Restart=on-failure
+KillSignal=SIGTERM
+TimeoutStopSec=30
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const socketUnit = `[Unit]
+Description=Telegram File Stream Bot socket
+
+[Socket]
+ListenStream=%[2]d
+
+[Install]
+WantedBy=sockets.target
+`
+
+// Install writes a systemd service and socket unit for the bot.
+func Install(opts InstallOptions) error {
+	if runtime.GOOS != "linux" {
+		return errors.New("service installation is only supported on Linux (systemd) and Windows")
+	}
+	service := fmt.Sprintf(serviceUnit, Name, opts.WorkingDir, opts.Executable)
+	socket := fmt.Sprintf(socketUnit, Name, opts.Port)
+	if err := os.WriteFile(filepath.Join(opts.UnitDir, Name+".service"), []byte(service), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(opts.UnitDir, Name+".socket"), []byte(socket), 0o644)
+}
+
+// Uninstall removes the unit files written by Install.
+func Uninstall(opts InstallOptions) error {
+	for _, unit := range []string{Name + ".service", Name + ".socket"} {
+		err := os.Remove(filepath.Join(opts.UnitDir, unit))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run calls run with a context that is cancelled when the process is asked
+// to stop.
+func Run(ctx context.Context, run func(ctx context.Context)) error {
+	run(ctx)
+	return nil
+}
@@ -0,0 +1,105 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Install registers the bot with the Windows service manager.
+func Install(opts InstallOptions) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(Name)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", Name)
+	}
+	s, err = m.CreateService(Name, opts.Executable, mgr.Config{
+		DisplayName: "Telegram File Stream Bot",
+		Description: "Generates direct streamable links for telegram media.",
+		StartType:   mgr.StartAutomatic,
+	}, "run")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return nil
+}
+
+// Uninstall removes the service registered by Install.
+func Uninstall(opts InstallOptions) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(Name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", Name)
+	}
+	defer s.Close()
+	return s.Delete()
+}
+
+type handler struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				h.cancel()
+				<-h.done
+				return false, 0
+			}
+		case <-h.done:
+			return false, 0
+		}
+	}
+}
+
+// Run calls run with a context that is cancelled when the process is asked
+// to stop. When started by the Windows service manager, stop requests from
+// the manager cancel the context as well.
+func Run(ctx context.Context, run func(ctx context.Context)) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		run(ctx)
+		return nil
+	}
+	// services start in System32, but fsb.env and the session files live
+	// next to the binary
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(ctx)
+	}()
+	return svc.Run(Name, &handler{cancel: cancel, done: done})
+}
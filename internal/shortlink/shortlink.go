@@ -0,0 +1,102 @@
+// Package shortlink makes short links, /s/<slug>, that redirect to longer
+// links, for sharing them where length is limited.
+package shortlink
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Path is the path short links are served under.
+const Path = "/s/"
+
+const (
+	alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	// slugLength is the length of generated slugs, which grow by a
+	// character whenever tries slugs in a row were taken.
+	slugLength = 6
+	tries      = 5
+	// minSlug and maxSlug bound the length of custom slugs.
+	minSlug = 3
+	maxSlug = 64
+)
+
+// ErrSlugTaken is returned when a custom slug is used by another link.
+var ErrSlugTaken = errors.New("this slug is already taken")
+
+// Create saves a short link to link, a link made by the bot, under slug, or
+// under a random slug when it's empty. Random slugs that collide with an
+// existing one are drawn again.
+func Create(link string, messageID int, createdBy int64, slug string) (*store.ShortLink, error) {
+	target := strings.TrimPrefix(link, config.ValueOf.Host)
+	if !strings.HasPrefix(target, "/") {
+		return nil, fmt.Errorf("not a link to this bot: %s", link)
+	}
+	short := &store.ShortLink{Target: target, MessageID: messageID, CreatedBy: createdBy}
+	if slug != "" {
+		if err := CheckSlug(slug); err != nil {
+			return nil, err
+		}
+		short.Slug = slug
+		err := store.GetStore().CreateShortLink(short)
+		if errors.Is(err, store.ErrExists) {
+			return nil, ErrSlugTaken
+		}
+		return short, err
+	}
+	for length := slugLength; ; length++ {
+		for i := 0; i < tries; i++ {
+			slug, err := randomSlug(length)
+			if err != nil {
+				return nil, err
+			}
+			short.Slug = slug
+			err = store.GetStore().CreateShortLink(short)
+			if !errors.Is(err, store.ErrExists) {
+				return short, err
+			}
+		}
+	}
+}
+
+// Link returns the short link saved under slug.
+func Link(slug string) string {
+	return config.ValueOf.Host + Path + slug
+}
+
+// CheckSlug checks that a custom slug is 3 to 64 letters, digits, dashes or
+// underscores, so it needs no escaping in a link.
+func CheckSlug(slug string) error {
+	if len(slug) < minSlug || len(slug) > maxSlug {
+		return fmt.Errorf("slugs must be %d to %d characters long", minSlug, maxSlug)
+	}
+	for _, c := range slug {
+		if !strings.ContainsRune(alphabet+"-_", c) {
+			return errors.New("slugs may only contain letters, digits, dashes and underscores")
+		}
+	}
+	return nil
+}
+
+// randomSlug draws a slug of length characters from alphabet, rejecting the
+// bytes that would make some characters likelier than others.
+func randomSlug(length int) (string, error) {
+	const limit = 256 - 256%len(alphabet)
+	slug := make([]byte, 0, length)
+	buf := make([]byte, length*2)
+	for len(slug) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(slug) < length {
+				slug = append(slug, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(slug), nil
+}
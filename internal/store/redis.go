@@ -0,0 +1,964 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisPrefix      = "fsb:"
+	redisBansKey     = redisPrefix + "bans"
+	redisStatsKey    = redisPrefix + "stats"
+	redisFilesKey    = redisPrefix + "files"
+	redisChannelsKey = redisPrefix + "channels"
+	redisWindowKey   = redisPrefix + "window:"
+	redisHitsKey     = redisPrefix + "hits:"
+	redisVisitorsKey = redisPrefix + "visitors:"
+	redisJobsKey     = redisPrefix + "jobs"
+	redisJobIDKey    = redisPrefix + "jobs:id"
+	// redisDueJobsKey holds the IDs of pending jobs, scored by when they
+	// are due.
+	redisDueJobsKey = redisPrefix + "jobs:due"
+	// redisUploaderHitsKey holds the files an uploader's links were hit
+	// for, scored by when they were last accessed.
+	redisUploaderHitsKey = redisPrefix + "hits:by:"
+)
+
+// incrWindowScript adds to the count of a window and reads the count of the
+// one before it atomically. A window's count is kept until the window after
+// it is over.
+var incrWindowScript = redis.NewScript(`
+local current = redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+local previous = tonumber(redis.call('GET', KEYS[2])) or 0
+return {current, previous}
+`)
+
+// recordHitsScript adds to the hits of a file and keeps the later of the
+// last accesses, moving the file up in its uploader's list when it's newer.
+var recordHitsScript = redis.NewScript(`
+redis.call('HINCRBY', KEYS[1], 'hits', ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'views', ARGV[2])
+redis.call('HSET', KEYS[1], 'uploaded_by', ARGV[3])
+local last = tonumber(redis.call('HGET', KEYS[1], 'last_access')) or 0
+if tonumber(ARGV[4]) > last then
+	redis.call('HSET', KEYS[1], 'last_access', ARGV[4])
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[5])
+end
+`)
+
+type redisStore struct {
+	client *redis.Client
+}
+
+func newRedisStore(dsn string) (*redisStore, error) {
+	opts, err := redis.ParseURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return &redisStore{client: client}, nil
+}
+
+func (s *redisStore) getJSON(key string, value any) error {
+	data, err := s.client.Get(context.Background(), key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func (s *redisStore) setJSON(key string, value any, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(context.Background(), key, data, ttl).Err()
+}
+
+func (s *redisStore) SaveLink(link *Link) error {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	var ttl time.Duration
+	if link.ExpiresAt != nil {
+		ttl = time.Until(*link.ExpiresAt)
+		if ttl <= 0 {
+			return nil
+		}
+	}
+	return s.setJSON(redisPrefix+"link:"+link.ID, link, ttl)
+}
+
+func (s *redisStore) GetLink(id string) (*Link, error) {
+	var link Link
+	if err := s.getJSON(redisPrefix+"link:"+id, &link); err != nil {
+		return nil, err
+	}
+	// claimed apart from the link, so instances can't both claim it
+	session, err := s.client.Get(context.Background(), redisPrefix+"linksession:"+id).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	link.Session = session
+	return &link, nil
+}
+
+func (s *redisStore) DeleteLink(id string) error {
+	return s.client.Del(context.Background(), redisPrefix+"link:"+id, redisPrefix+"linksession:"+id).Err()
+}
+
+func (s *redisStore) ClaimLink(id string, session string) (string, error) {
+	ctx := context.Background()
+	ttl, err := s.client.PTTL(ctx, redisPrefix+"link:"+id).Result()
+	if err != nil {
+		return "", err
+	}
+	// -2 when the link doesn't exist, -1 when it doesn't expire
+	switch ttl {
+	case -2:
+		return "", ErrNotFound
+	case -1:
+		ttl = 0
+	}
+	if err := s.client.SetNX(ctx, redisPrefix+"linksession:"+id, session, ttl).Err(); err != nil {
+		return "", err
+	}
+	return s.client.Get(ctx, redisPrefix+"linksession:"+id).Result()
+}
+
+func (s *redisStore) CreateShortLink(link *ShortLink) error {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	data, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	created, err := s.client.SetNX(context.Background(), redisPrefix+"short:"+link.Slug, data, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrExists
+	}
+	return nil
+}
+
+func (s *redisStore) GetShortLink(slug string) (*ShortLink, error) {
+	var link ShortLink
+	if err := s.getJSON(redisPrefix+"short:"+slug, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (s *redisStore) ClaimIdempotencyKey(req *IdempotentRequest) (*IdempotentRequest, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	claimed, err := s.client.SetNX(ctx, redisPrefix+"idempotency:"+req.Key, data, time.Until(req.ExpiresAt)).Result()
+	if err != nil || claimed {
+		return nil, err
+	}
+	var existing IdempotentRequest
+	if err := s.getJSON(redisPrefix+"idempotency:"+req.Key, &existing); err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (s *redisStore) FinishIdempotentRequest(req *IdempotentRequest) error {
+	ttl := time.Until(req.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.setJSON(redisPrefix+"idempotency:"+req.Key, req, ttl)
+}
+
+func (s *redisStore) DeleteIdempotencyKey(key string) error {
+	return s.client.Del(context.Background(), redisPrefix+"idempotency:"+key).Err()
+}
+
+func (s *redisStore) RecordHits(hits *FileHits, visitors []*FileVisitor) error {
+	ctx := context.Background()
+	file := fmt.Sprintf("%d:%d", hits.ChannelID, hits.MessageID)
+	keys := []string{redisHitsKey + file, redisUploaderHitsKey + strconv.FormatInt(hits.UploadedBy, 10)}
+	err := recordHitsScript.Run(ctx, s.client, keys, hits.Hits, hits.Views, hits.UploadedBy, hits.LastAccess.UnixMilli(), file).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if len(visitors) == 0 {
+		return nil
+	}
+	pipe := s.client.Pipeline()
+	for _, visitor := range visitors {
+		pipe.HSetNX(ctx, redisVisitorsKey+file, visitor.Visitor, visitor.Country)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisStore) ListFileHits(uploadedBy int64, limit int) ([]*FileHits, error) {
+	files, err := s.client.ZRevRange(context.Background(), redisUploaderHitsKey+strconv.FormatInt(uploadedBy, 10), 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	hits := make([]*FileHits, 0, len(files))
+	for _, file := range files {
+		channel, message, _ := strings.Cut(file, ":")
+		channelID, _ := strconv.ParseInt(channel, 10, 64)
+		messageID, _ := strconv.Atoi(message)
+		fileHits, err := s.GetFileHits(channelID, messageID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, fileHits)
+	}
+	return hits, nil
+}
+
+func (s *redisStore) GetFileHits(channelID int64, messageID int) (*FileHits, error) {
+	values, err := s.client.HGetAll(context.Background(), fmt.Sprintf("%s%d:%d", redisHitsKey, channelID, messageID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+	hits := &FileHits{ChannelID: channelID, MessageID: messageID}
+	hits.UploadedBy, _ = strconv.ParseInt(values["uploaded_by"], 10, 64)
+	hits.Hits, _ = strconv.ParseInt(values["hits"], 10, 64)
+	hits.Views, _ = strconv.ParseInt(values["views"], 10, 64)
+	lastAccess, _ := strconv.ParseInt(values["last_access"], 10, 64)
+	hits.LastAccess = time.UnixMilli(lastAccess)
+	return hits, nil
+}
+
+func (s *redisStore) CountVisitors(channelID int64, messageID int) (int64, map[string]int64, error) {
+	values, err := s.client.HGetAll(context.Background(), fmt.Sprintf("%s%d:%d", redisVisitorsKey, channelID, messageID)).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	countries := make(map[string]int64)
+	for _, country := range values {
+		if country != "" {
+			countries[country]++
+		}
+	}
+	return int64(len(values)), countries, nil
+}
+
+func (s *redisStore) Ban(userID int64, reason string) error {
+	data, err := json.Marshal(&Ban{UserID: userID, Reason: reason, CreatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), redisBansKey, strconv.FormatInt(userID, 10), data).Err()
+}
+
+func (s *redisStore) Unban(userID int64) error {
+	return s.client.HDel(context.Background(), redisBansKey, strconv.FormatInt(userID, 10)).Err()
+}
+
+func (s *redisStore) IsBanned(userID int64) (bool, error) {
+	return s.client.HExists(context.Background(), redisBansKey, strconv.FormatInt(userID, 10)).Result()
+}
+
+func (s *redisStore) SetUserQuota(quota *UserQuota) error {
+	quota.UpdatedAt = time.Now()
+	return s.setJSON(redisPrefix+"quota:"+strconv.FormatInt(quota.UserID, 10), quota, 0)
+}
+
+func (s *redisStore) GetUserQuota(userID int64) (*UserQuota, error) {
+	var quota UserQuota
+	if err := s.getJSON(redisPrefix+"quota:"+strconv.FormatInt(userID, 10), &quota); err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+func (s *redisStore) IncrStat(name string, delta int64) (int64, error) {
+	return s.client.HIncrBy(context.Background(), redisStatsKey, name, delta).Result()
+}
+
+func (s *redisStore) IncrWindow(key string, now time.Time, window time.Duration, delta int64) (int64, int64, error) {
+	index := windowIndex(now, window)
+	keys := []string{
+		fmt.Sprintf("%s%s:%d", redisWindowKey, key, index),
+		fmt.Sprintf("%s%s:%d", redisWindowKey, key, index-1),
+	}
+	counts, err := incrWindowScript.Run(context.Background(), s.client, keys, delta, (2 * window).Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts[0], counts[1], nil
+}
+
+func (s *redisStore) AddJob(job *Job) error {
+	id, err := s.client.Incr(context.Background(), redisJobIDKey).Result()
+	if err != nil {
+		return err
+	}
+	job.ID = id
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+	return s.UpdateJob(job)
+}
+
+func (s *redisStore) ClaimJob(now time.Time) (*Job, error) {
+	ctx := context.Background()
+	for {
+		ids, err := s.client.ZRangeByScore(ctx, redisDueJobsKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(now.UnixMilli(), 10),
+			Count: 1,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, ErrNotFound
+		}
+		// whoever removes the job from the due set owns it
+		removed, err := s.client.ZRem(ctx, redisDueJobsKey, ids[0]).Result()
+		if err != nil {
+			return nil, err
+		}
+		if removed == 0 {
+			continue
+		}
+		id, _ := strconv.ParseInt(ids[0], 10, 64)
+		job, err := s.GetJob(id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		job.State = JobRunning
+		return job, s.UpdateJob(job)
+	}
+}
+
+func (s *redisStore) UpdateJob(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	id := strconv.FormatInt(job.ID, 10)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, redisJobsKey, id, data)
+		if job.State == JobPending {
+			pipe.ZAdd(ctx, redisDueJobsKey, redis.Z{Score: float64(job.RunAt.UnixMilli()), Member: id})
+		} else {
+			pipe.ZRem(ctx, redisDueJobsKey, id)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) DeleteJob(id int64) error {
+	ctx := context.Background()
+	member := strconv.FormatInt(id, 10)
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, redisJobsKey, member)
+		pipe.ZRem(ctx, redisDueJobsKey, member)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) GetJob(id int64) (*Job, error) {
+	data, err := s.client.HGet(context.Background(), redisJobsKey, strconv.FormatInt(id, 10)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	return &job, json.Unmarshal(data, &job)
+}
+
+// allJobs returns every job, newest first. Jobs are deleted when they
+// succeed, so there are never many of them.
+func (s *redisStore) allJobs() ([]*Job, error) {
+	values, err := s.client.HGetAll(context.Background(), redisJobsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*Job, 0, len(values))
+	for _, value := range values {
+		var job Job
+		if err := json.Unmarshal([]byte(value), &job); err != nil {
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID > jobs[j].ID
+	})
+	return jobs, nil
+}
+
+func (s *redisStore) ListJobs(query JobQuery) ([]*Job, error) {
+	jobs, err := s.allJobs()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		if (query.State != "" && job.State != query.State) || (query.BeforeID != 0 && job.ID >= query.BeforeID) {
+			continue
+		}
+		result = append(result, job)
+		if query.Limit > 0 && len(result) == query.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (s *redisStore) RequeueRunningJobs() error {
+	jobs, err := s.allJobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if job.State != JobRunning {
+			continue
+		}
+		job.State = JobPending
+		if err := s.UpdateJob(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisStore) GetStats() (map[string]int64, error) {
+	values, err := s.client.HGetAll(context.Background(), redisStatsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(values))
+	for name, value := range values {
+		result[name], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return result, nil
+}
+
+func (s *redisStore) IndexFile(entry *FileEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	id := fileMember(entry.ChannelID, entry.MessageID)
+	if err := s.setJSON(redisPrefix+"file:"+id, entry, 0); err != nil {
+		return err
+	}
+	if entry.SourceMessageID != 0 {
+		err := s.client.Set(context.Background(), sourceKey(entry.UploadedBy, entry.SourceMessageID), id, 0).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return s.client.ZAdd(context.Background(), redisFilesKey, redis.Z{
+		Score:  float64(entry.CreatedAt.UnixMilli()),
+		Member: id,
+	}).Err()
+}
+
+func (s *redisStore) GetFile(channelID int64, messageID int) (*FileEntry, error) {
+	return s.getFile(fileMember(channelID, messageID))
+}
+
+func (s *redisStore) getFile(id string) (*FileEntry, error) {
+	var entry FileEntry
+	if err := s.getJSON(redisPrefix+"file:"+id, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func fileMember(channelID int64, messageID int) string {
+	return strconv.FormatInt(channelID, 10) + ":" + strconv.Itoa(messageID)
+}
+
+func (s *redisStore) GetFileBySource(uploadedBy int64, sourceMessageID int) (*FileEntry, error) {
+	id, err := s.client.Get(context.Background(), sourceKey(uploadedBy, sourceMessageID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.getFile(id)
+}
+
+func sourceKey(uploadedBy int64, sourceMessageID int) string {
+	return redisPrefix + "source:" + strconv.FormatInt(uploadedBy, 10) + ":" + strconv.Itoa(sourceMessageID)
+}
+
+func (s *redisStore) matches(entry *FileEntry, query FileQuery) bool {
+	if query.UploadedBy != 0 && entry.UploadedBy != query.UploadedBy {
+		return false
+	}
+	if !strings.HasPrefix(entry.MimeType, query.MimeType) {
+		return false
+	}
+	if query.MinDuration > 0 && entry.Duration < query.MinDuration.Seconds() {
+		return false
+	}
+	if query.MaxDuration > 0 && entry.Duration > query.MaxDuration.Seconds() {
+		return false
+	}
+	text := strings.ToLower(query.Text)
+	return strings.Contains(strings.ToLower(entry.FileName), text) ||
+		strings.Contains(strings.ToLower(entry.Description), text)
+}
+
+// before reports whether a comes after b in the newest-first order of the
+// file index. Scores are stored in milliseconds (float64 can't hold
+// nanoseconds) and ties are broken by member in reverse lexical order, just
+// like ZREVRANGE does.
+func before(a FileCursor, b FileCursor) bool {
+	aScore, bScore := a.CreatedAt/int64(time.Millisecond), b.CreatedAt/int64(time.Millisecond)
+	if aScore != bScore {
+		return aScore < bScore
+	}
+	return fileMember(a.ChannelID, a.MessageID) < fileMember(b.ChannelID, b.MessageID)
+}
+
+// SearchFiles walks the file index newest first, since redis has no
+// substring index to match file names against. Tag queries only walk the
+// files with that tag.
+func (s *redisStore) SearchFiles(query FileQuery) ([]*FileEntry, error) {
+	ctx := context.Background()
+	var entries []*FileEntry
+	if query.Tag != "" {
+		ids, err := s.client.SMembers(ctx, redisPrefix+"tag:"+query.Tag).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			entry, err := s.getFile(id)
+			if err != nil || !s.matches(entry, query) {
+				continue
+			}
+			if query.After != nil && !before(entry.Cursor(), *query.After) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return before(entries[j].Cursor(), entries[i].Cursor())
+		})
+		if query.Limit > 0 && len(entries) > query.Limit {
+			entries = entries[:query.Limit]
+		}
+		return entries, nil
+	}
+	max := "+inf"
+	if query.After != nil {
+		max = strconv.FormatInt(query.After.CreatedAt/int64(time.Millisecond), 10)
+	}
+	const batch = 100
+	for offset := int64(0); query.Limit <= 0 || len(entries) < query.Limit; offset += batch {
+		ids, err := s.client.ZRevRangeByScore(ctx, redisFilesKey, &redis.ZRangeBy{
+			Max:    max,
+			Min:    "-inf",
+			Offset: offset,
+			Count:  batch,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			entry, err := s.getFile(id)
+			if err != nil || !s.matches(entry, query) {
+				continue
+			}
+			if query.After != nil && !before(entry.Cursor(), *query.After) {
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) == query.Limit {
+				break
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (s *redisStore) SetHashSalt(channelID int64, messageID int, salt string) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.HashSalt = salt
+	})
+}
+
+func (s *redisStore) SetDescription(channelID int64, messageID int, description string) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.Description = description
+	})
+}
+
+func (s *redisStore) SetNotify(channelID int64, messageID int, notify string) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.Notify = notify
+	})
+}
+
+func (s *redisStore) SetPassword(channelID int64, messageID int, passwordHash string) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.PasswordHash = passwordHash
+	})
+}
+
+func (s *redisStore) SetRateLimit(channelID int64, messageID int, rateLimit int64) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.RateLimit = rateLimit
+	})
+}
+
+func (s *redisStore) SetObject(channelID int64, messageID int, backend string, objectKey string) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.Backend, entry.ObjectKey = backend, objectKey
+	})
+}
+
+func (s *redisStore) SetMediaInfo(channelID int64, messageID int, info MediaInfo) error {
+	return s.updateFile(channelID, messageID, func(entry *FileEntry) {
+		entry.MediaInfo = info
+	})
+}
+
+func (s *redisStore) DeleteFile(channelID int64, messageID int) error {
+	ctx := context.Background()
+	id := fileMember(channelID, messageID)
+	entry, err := s.getFile(id)
+	if err != nil {
+		return err
+	}
+	tags, err := s.client.SMembers(ctx, redisPrefix+"filetags:"+id).Result()
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisPrefix+"file:"+id, redisPrefix+"filetags:"+id)
+		pipe.ZRem(ctx, redisFilesKey, id)
+		for _, tag := range tags {
+			pipe.SRem(ctx, redisPrefix+"tag:"+tag, id)
+		}
+		return nil
+	})
+	if err != nil || entry.SourceMessageID == 0 {
+		return err
+	}
+	// the source may have been stored again since, pointing at another copy
+	key := sourceKey(entry.UploadedBy, entry.SourceMessageID)
+	if current, err := s.client.Get(ctx, key).Result(); err == nil && current == id {
+		return s.client.Del(ctx, key).Err()
+	}
+	return nil
+}
+
+// DuplicateFiles walks the whole file index oldest first, since redis can't
+// group the files by their content.
+func (s *redisStore) DuplicateFiles() ([][]*FileEntry, error) {
+	ctx := context.Background()
+	var entries []*FileEntry
+	const batch = 100
+	for offset := int64(0); ; offset += batch {
+		ids, err := s.client.ZRange(ctx, redisFilesKey, offset, offset+batch-1).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		for _, id := range ids {
+			if entry, err := s.getFile(id); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return groupDuplicates(entries), nil
+}
+
+func (s *redisStore) SetAlias(alias *FileAlias) error {
+	if alias.CreatedAt.IsZero() {
+		alias.CreatedAt = time.Now()
+	}
+	return s.setJSON(redisPrefix+"alias:"+fileMember(alias.ChannelID, alias.MessageID), alias, 0)
+}
+
+func (s *redisStore) GetAlias(channelID int64, messageID int) (*FileAlias, error) {
+	var alias FileAlias
+	if err := s.getJSON(redisPrefix+"alias:"+fileMember(channelID, messageID), &alias); err != nil {
+		return nil, err
+	}
+	return &alias, nil
+}
+
+func (s *redisStore) MoveFile(entry *FileEntry, alias *FileAlias) error {
+	ctx := context.Background()
+	from := fileMember(alias.ChannelID, alias.MessageID)
+	to := fileMember(entry.ChannelID, entry.MessageID)
+	old, err := s.getFile(from)
+	if err != nil {
+		return err
+	}
+	tags, err := s.client.SMembers(ctx, redisPrefix+"filetags:"+from).Result()
+	if err != nil {
+		return err
+	}
+	// the source may have been stored again since, pointing at another copy
+	var source string
+	if old.SourceMessageID != 0 {
+		key := sourceKey(old.UploadedBy, old.SourceMessageID)
+		if current, err := s.client.Get(ctx, key).Result(); err == nil && current == from {
+			source = key
+		}
+	}
+	hits, err := s.GetFileHits(alias.ChannelID, alias.MessageID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	var hitsAt float64
+	if hits != nil {
+		hitsAt, err = s.client.ZScore(ctx, redisUploaderHitsKey+strconv.FormatInt(hits.UploadedBy, 10), from).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+	}
+	visitors, err := s.client.Exists(ctx, redisVisitorsKey+from).Result()
+	if err != nil {
+		return err
+	}
+	subtitles, err := s.movedSubtitles(from, entry)
+	if err != nil {
+		return err
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if alias.CreatedAt.IsZero() {
+		alias.CreatedAt = time.Now()
+	}
+	entryData, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	aliasData, err := json.Marshal(alias)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisPrefix+"file:"+to, entryData, 0)
+		pipe.ZAdd(ctx, redisFilesKey, redis.Z{Score: float64(entry.CreatedAt.UnixMilli()), Member: to})
+		if source != "" {
+			pipe.Set(ctx, source, to, 0)
+		}
+		for _, tag := range tags {
+			pipe.SRem(ctx, redisPrefix+"tag:"+tag, from)
+			pipe.SAdd(ctx, redisPrefix+"tag:"+tag, to)
+			pipe.SAdd(ctx, redisPrefix+"filetags:"+to, tag)
+		}
+		if hits != nil {
+			pipe.Rename(ctx, redisHitsKey+from, redisHitsKey+to)
+			if hitsAt != 0 {
+				key := redisUploaderHitsKey + strconv.FormatInt(hits.UploadedBy, 10)
+				pipe.ZAdd(ctx, key, redis.Z{Score: hitsAt, Member: to})
+				pipe.ZRem(ctx, key, from)
+			}
+		}
+		if visitors > 0 {
+			pipe.Rename(ctx, redisVisitorsKey+from, redisVisitorsKey+to)
+		}
+		for key, data := range subtitles {
+			pipe.Set(ctx, key, data, 0)
+		}
+		pipe.Del(ctx, redisPrefix+"subtitle:"+from)
+		pipe.Set(ctx, redisPrefix+"alias:"+from, aliasData, 0)
+		pipe.Del(ctx, redisPrefix+"file:"+from, redisPrefix+"filetags:"+from)
+		pipe.ZRem(ctx, redisFilesKey, from)
+		return nil
+	})
+	return err
+}
+
+// movedSubtitles returns the subtitle pairings to save when the file from
+// is moved to entry, by their keys: the one of the file itself, and the
+// ones of the files it's the subtitle of, which redis has to scan for.
+func (s *redisStore) movedSubtitles(from string, entry *FileEntry) (map[string][]byte, error) {
+	ctx := context.Background()
+	subtitles := make(map[string][]byte)
+	iter := s.client.Scan(ctx, 0, redisPrefix+"subtitle:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		var subtitle Subtitle
+		err := s.getJSON(key, &subtitle)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fileMember(subtitle.ChannelID, subtitle.MessageID) == from {
+			subtitle.ChannelID, subtitle.MessageID = entry.ChannelID, entry.MessageID
+			key = redisPrefix + "subtitle:" + fileMember(entry.ChannelID, entry.MessageID)
+		} else if fileMember(subtitle.SubtitleChannelID, subtitle.SubtitleMessageID) != from {
+			continue
+		}
+		if fileMember(subtitle.SubtitleChannelID, subtitle.SubtitleMessageID) == from {
+			subtitle.SubtitleChannelID, subtitle.SubtitleMessageID = entry.ChannelID, entry.MessageID
+		}
+		data, err := json.Marshal(&subtitle)
+		if err != nil {
+			return nil, err
+		}
+		subtitles[key] = data
+	}
+	return subtitles, iter.Err()
+}
+
+// updateFile updates the entry optimistically, retrying if it changed while
+// being updated.
+func (s *redisStore) updateFile(channelID int64, messageID int, update func(entry *FileEntry)) error {
+	ctx := context.Background()
+	key := redisPrefix + "file:" + fileMember(channelID, messageID)
+	for {
+		err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+			data, err := tx.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+			var entry FileEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			update(&entry)
+			data, err = json.Marshal(&entry)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, data, 0)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+}
+
+func (s *redisStore) AddTag(channelID int64, messageID int, name string) error {
+	id := fileMember(channelID, messageID)
+	_, err := s.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.SAdd(context.Background(), redisPrefix+"tag:"+name, id)
+		pipe.SAdd(context.Background(), redisPrefix+"filetags:"+id, name)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) RemoveTag(channelID int64, messageID int, name string) error {
+	id := fileMember(channelID, messageID)
+	_, err := s.client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.SRem(context.Background(), redisPrefix+"tag:"+name, id)
+		pipe.SRem(context.Background(), redisPrefix+"filetags:"+id, name)
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) GetTags(channelID int64, messageID int) ([]string, error) {
+	tags, err := s.client.SMembers(context.Background(), redisPrefix+"filetags:"+fileMember(channelID, messageID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (s *redisStore) SetSubtitle(subtitle *Subtitle) error {
+	if subtitle.CreatedAt.IsZero() {
+		subtitle.CreatedAt = time.Now()
+	}
+	return s.setJSON(redisPrefix+"subtitle:"+fileMember(subtitle.ChannelID, subtitle.MessageID), subtitle, 0)
+}
+
+func (s *redisStore) GetSubtitle(channelID int64, messageID int) (*Subtitle, error) {
+	var subtitle Subtitle
+	if err := s.getJSON(redisPrefix+"subtitle:"+fileMember(channelID, messageID), &subtitle); err != nil {
+		return nil, err
+	}
+	return &subtitle, nil
+}
+
+func (s *redisStore) AddChannel(channel *Channel) error {
+	if channel.CreatedAt.IsZero() {
+		channel.CreatedAt = time.Now()
+	}
+	data, err := json.Marshal(channel)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(context.Background(), redisChannelsKey, strconv.FormatInt(channel.ID, 10), data).Err()
+}
+
+func (s *redisStore) ListChannels() ([]*Channel, error) {
+	values, err := s.client.HGetAll(context.Background(), redisChannelsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	channels := make([]*Channel, 0, len(values))
+	for _, value := range values {
+		var channel Channel
+		if err := json.Unmarshal([]byte(value), &channel); err != nil {
+			continue
+		}
+		channels = append(channels, &channel)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].CreatedAt.Before(channels[j].CreatedAt)
+	})
+	return channels, nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,560 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// sqlStore backs both the SQLite and Postgres stores.
+type sqlStore struct {
+	db *gorm.DB
+}
+
+func newSQLiteStore(path string) (*sqlStore, error) {
+	return newSQLStore(sqlite.Open(path))
+}
+
+func newPostgresStore(dsn string) (*sqlStore, error) {
+	return newSQLStore(postgres.Open(dsn))
+}
+
+func newSQLStore(dialector gorm.Dialector) (*sqlStore, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return nil, err
+	}
+	err = db.AutoMigrate(&Link{}, &ShortLink{}, &Ban{}, &Stat{}, &RateWindow{}, &IdempotentRequest{}, &FileHits{}, &FileVisitor{}, &Job{}, &FileEntry{}, &FileTag{}, &Channel{}, &Subtitle{}, &FileAlias{}, &UserQuota{})
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStore{db: db}, nil
+}
+
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *sqlStore) SaveLink(link *Link) error {
+	return s.db.Save(link).Error
+}
+
+func (s *sqlStore) GetLink(id string) (*Link, error) {
+	var link Link
+	if err := s.db.First(&link, "id = ?", id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &link, nil
+}
+
+func (s *sqlStore) DeleteLink(id string) error {
+	return s.db.Delete(&Link{}, "id = ?", id).Error
+}
+
+func (s *sqlStore) ClaimLink(id string, session string) (string, error) {
+	err := s.db.Model(&Link{}).Where("id = ? AND session = ?", id, "").Update("session", session).Error
+	if err != nil {
+		return "", err
+	}
+	link, err := s.GetLink(id)
+	if err != nil {
+		return "", err
+	}
+	return link.Session, nil
+}
+
+func (s *sqlStore) CreateShortLink(link *ShortLink) error {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(link)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrExists
+	}
+	return nil
+}
+
+func (s *sqlStore) GetShortLink(slug string) (*ShortLink, error) {
+	var link ShortLink
+	if err := s.db.First(&link, "slug = ?", slug).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &link, nil
+}
+
+func (s *sqlStore) ClaimIdempotencyKey(req *IdempotentRequest) (*IdempotentRequest, error) {
+	err := s.db.Delete(&IdempotentRequest{}, "key = ? AND expires_at <= ?", req.Key, time.Now()).Error
+	if err != nil {
+		return nil, err
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(req)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return nil, nil
+	}
+	var existing IdempotentRequest
+	if err := s.db.First(&existing, "key = ?", req.Key).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &existing, nil
+}
+
+func (s *sqlStore) FinishIdempotentRequest(req *IdempotentRequest) error {
+	return s.db.Save(req).Error
+}
+
+func (s *sqlStore) DeleteIdempotencyKey(key string) error {
+	return s.db.Delete(&IdempotentRequest{}, "key = ?", key).Error
+}
+
+func (s *sqlStore) RecordHits(hits *FileHits, visitors []*FileVisitor) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "channel_id"}, {Name: "message_id"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"uploaded_by": hits.UploadedBy,
+				"hits":        gorm.Expr("file_hits.hits + ?", hits.Hits),
+				"views":       gorm.Expr("file_hits.views + ?", hits.Views),
+				"last_access": gorm.Expr("CASE WHEN file_hits.last_access > ? THEN file_hits.last_access ELSE ? END", hits.LastAccess, hits.LastAccess),
+			}),
+		}).Create(hits).Error
+		if err != nil || len(visitors) == 0 {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(visitors).Error
+	})
+}
+
+func (s *sqlStore) ListFileHits(uploadedBy int64, limit int) ([]*FileHits, error) {
+	var hits []*FileHits
+	err := s.db.Where("uploaded_by = ?", uploadedBy).Order("last_access DESC").Limit(limit).Find(&hits).Error
+	return hits, err
+}
+
+func (s *sqlStore) GetFileHits(channelID int64, messageID int) (*FileHits, error) {
+	var hits FileHits
+	if err := s.db.First(&hits, "channel_id = ? AND message_id = ?", channelID, messageID).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &hits, nil
+}
+
+func (s *sqlStore) CountVisitors(channelID int64, messageID int) (int64, map[string]int64, error) {
+	var rows []struct {
+		Country string
+		Count   int64
+	}
+	err := s.db.Model(&FileVisitor{}).
+		Select("country, COUNT(*) AS count").
+		Where("channel_id = ? AND message_id = ?", channelID, messageID).
+		Group("country").
+		Scan(&rows).Error
+	if err != nil {
+		return 0, nil, err
+	}
+	var total int64
+	countries := make(map[string]int64)
+	for _, row := range rows {
+		total += row.Count
+		if row.Country != "" {
+			countries[row.Country] = row.Count
+		}
+	}
+	return total, countries, nil
+}
+
+func (s *sqlStore) Ban(userID int64, reason string) error {
+	return s.db.Save(&Ban{UserID: userID, Reason: reason}).Error
+}
+
+func (s *sqlStore) Unban(userID int64) error {
+	return s.db.Delete(&Ban{}, "user_id = ?", userID).Error
+}
+
+func (s *sqlStore) IsBanned(userID int64) (bool, error) {
+	var count int64
+	err := s.db.Model(&Ban{}).Where("user_id = ?", userID).Count(&count).Error
+	return count > 0, err
+}
+
+func (s *sqlStore) SetUserQuota(quota *UserQuota) error {
+	return s.db.Save(quota).Error
+}
+
+func (s *sqlStore) GetUserQuota(userID int64) (*UserQuota, error) {
+	var quota UserQuota
+	if err := s.db.First(&quota, "user_id = ?", userID).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &quota, nil
+}
+
+func (s *sqlStore) IncrStat(name string, delta int64) (int64, error) {
+	stat := Stat{Name: name, Value: delta}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.Assignments(map[string]any{"value": gorm.Expr("stats.value + ?", delta)}),
+	}).Create(&stat).Error
+	if err != nil {
+		return 0, err
+	}
+	err = s.db.First(&stat, "name = ?", name).Error
+	return stat.Value, err
+}
+
+// IncrWindow moves the window on and adds delta to it in a single upsert,
+// so instances sharing the database can't lose each other's hits. The row
+// stays locked until the transaction ends, so the counts read back are the
+// ones this call left.
+func (s *sqlStore) IncrWindow(key string, now time.Time, window time.Duration, delta int64) (int64, int64, error) {
+	index := windowIndex(now, window)
+	var counts RateWindow
+	if delta == 0 {
+		err := s.db.First(&counts, "name = ?", key).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, 0, err
+		}
+		counts.incr(index, 0)
+		return counts.Count, counts.Previous, nil
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "name"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				// every assignment reads the counts from before the update
+				"previous": gorm.Expr(`CASE WHEN rate_windows."index" = ? THEN rate_windows.previous WHEN rate_windows."index" = ? THEN rate_windows.count ELSE 0 END`, index, index-1),
+				"count":    gorm.Expr(`CASE WHEN rate_windows."index" = ? THEN rate_windows.count + ? ELSE ? END`, index, delta, delta),
+				"index":    index,
+			}),
+		}).Create(&RateWindow{Name: key, Index: index, Count: delta}).Error
+		if err != nil {
+			return err
+		}
+		return tx.First(&counts, "name = ?", key).Error
+	})
+	return counts.Count, counts.Previous, err
+}
+
+func (s *sqlStore) AddJob(job *Job) error {
+	return s.db.Create(job).Error
+}
+
+func (s *sqlStore) ClaimJob(now time.Time) (*Job, error) {
+	for {
+		var job Job
+		err := s.db.Where("state = ? AND run_at <= ?", JobPending, now).Order("run_at").First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		// another worker may have claimed it in the meantime
+		res := s.db.Model(&Job{}).Where("id = ? AND state = ?", job.ID, JobPending).Update("state", JobRunning)
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			job.State = JobRunning
+			return &job, nil
+		}
+	}
+}
+
+func (s *sqlStore) UpdateJob(job *Job) error {
+	return s.db.Save(job).Error
+}
+
+func (s *sqlStore) DeleteJob(id int64) error {
+	return s.db.Delete(&Job{}, id).Error
+}
+
+func (s *sqlStore) GetJob(id int64) (*Job, error) {
+	var job Job
+	err := s.db.First(&job, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	return &job, err
+}
+
+func (s *sqlStore) ListJobs(query JobQuery) ([]*Job, error) {
+	var jobs []*Job
+	db := s.db.Model(&Job{})
+	if query.State != "" {
+		db = db.Where("state = ?", query.State)
+	}
+	if query.BeforeID != 0 {
+		db = db.Where("id < ?", query.BeforeID)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	err := db.Order("id DESC").Find(&jobs).Error
+	return jobs, err
+}
+
+func (s *sqlStore) RequeueRunningJobs() error {
+	return s.db.Model(&Job{}).Where("state = ?", JobRunning).Update("state", JobPending).Error
+}
+
+func (s *sqlStore) GetStats() (map[string]int64, error) {
+	var stats []Stat
+	if err := s.db.Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(stats))
+	for _, stat := range stats {
+		result[stat.Name] = stat.Value
+	}
+	return result, nil
+}
+
+func (s *sqlStore) IndexFile(entry *FileEntry) error {
+	return s.db.Save(entry).Error
+}
+
+func (s *sqlStore) GetFile(channelID int64, messageID int) (*FileEntry, error) {
+	var entry FileEntry
+	if err := s.db.First(&entry, "channel_id = ? AND message_id = ?", channelID, messageID).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &entry, nil
+}
+
+func (s *sqlStore) GetFileBySource(uploadedBy int64, sourceMessageID int) (*FileEntry, error) {
+	var entry FileEntry
+	err := s.db.
+		Where("uploaded_by = ? AND source_message_id = ?", uploadedBy, sourceMessageID).
+		Order("created_at DESC").
+		First(&entry).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &entry, nil
+}
+
+func (s *sqlStore) SearchFiles(query FileQuery) ([]*FileEntry, error) {
+	var entries []*FileEntry
+	db := s.db.Model(&FileEntry{})
+	if query.Text != "" {
+		pattern := "%" + strings.ToLower(query.Text) + "%"
+		db = db.Where("LOWER(file_entries.file_name) LIKE ? OR LOWER(file_entries.description) LIKE ?", pattern, pattern)
+	}
+	if query.UploadedBy != 0 {
+		db = db.Where("file_entries.uploaded_by = ?", query.UploadedBy)
+	}
+	if query.MimeType != "" {
+		db = db.Where("file_entries.mime_type LIKE ?", query.MimeType+"%")
+	}
+	if query.MinDuration > 0 {
+		db = db.Where("file_entries.duration >= ?", query.MinDuration.Seconds())
+	}
+	if query.MaxDuration > 0 {
+		db = db.Where("file_entries.duration <= ?", query.MaxDuration.Seconds())
+	}
+	if query.Tag != "" {
+		db = db.
+			Joins("JOIN file_tags ON file_tags.channel_id = file_entries.channel_id AND file_tags.message_id = file_entries.message_id").
+			Where("file_tags.name = ?", query.Tag)
+	}
+	if query.After != nil {
+		createdAt := time.Unix(0, query.After.CreatedAt)
+		db = db.Where(
+			"file_entries.created_at < ? OR (file_entries.created_at = ? AND (file_entries.channel_id < ? OR (file_entries.channel_id = ? AND file_entries.message_id < ?)))",
+			createdAt, createdAt, query.After.ChannelID, query.After.ChannelID, query.After.MessageID,
+		)
+	}
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	err := db.
+		Order("file_entries.created_at DESC").
+		Order("file_entries.channel_id DESC").
+		Order("file_entries.message_id DESC").
+		Find(&entries).Error
+	return entries, err
+}
+
+func (s *sqlStore) SetHashSalt(channelID int64, messageID int, salt string) error {
+	return s.updateFile(channelID, messageID, map[string]any{"hash_salt": salt})
+}
+
+func (s *sqlStore) SetDescription(channelID int64, messageID int, description string) error {
+	return s.updateFile(channelID, messageID, map[string]any{"description": description})
+}
+
+func (s *sqlStore) SetNotify(channelID int64, messageID int, notify string) error {
+	return s.updateFile(channelID, messageID, map[string]any{"notify": notify})
+}
+
+func (s *sqlStore) SetPassword(channelID int64, messageID int, passwordHash string) error {
+	return s.updateFile(channelID, messageID, map[string]any{"password_hash": passwordHash})
+}
+
+func (s *sqlStore) SetRateLimit(channelID int64, messageID int, rateLimit int64) error {
+	return s.updateFile(channelID, messageID, map[string]any{"rate_limit": rateLimit})
+}
+
+func (s *sqlStore) SetObject(channelID int64, messageID int, backend string, objectKey string) error {
+	return s.updateFile(channelID, messageID, map[string]any{"backend": backend, "object_key": objectKey})
+}
+
+func (s *sqlStore) SetMediaInfo(channelID int64, messageID int, info MediaInfo) error {
+	return s.updateFile(channelID, messageID, map[string]any{
+		"duration":    info.Duration,
+		"width":       info.Width,
+		"height":      info.Height,
+		"video_codec": info.VideoCodec,
+		"audio_codec": info.AudioCodec,
+		"subtitles":   info.Subtitles,
+	})
+}
+
+func (s *sqlStore) DeleteFile(channelID int64, messageID int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(&FileEntry{}, "channel_id = ? AND message_id = ?", channelID, messageID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Delete(&FileTag{}, "channel_id = ? AND message_id = ?", channelID, messageID).Error
+	})
+}
+
+func (s *sqlStore) DuplicateFiles() ([][]*FileEntry, error) {
+	duplicated := s.db.Model(&FileEntry{}).
+		Select("file_id, file_size").
+		Where("file_id <> 0").
+		Group("file_id, file_size").
+		Having("COUNT(*) > 1")
+	var entries []*FileEntry
+	err := s.db.
+		Joins("JOIN (?) AS duplicated ON duplicated.file_id = file_entries.file_id AND duplicated.file_size = file_entries.file_size", duplicated).
+		Order("file_entries.created_at").
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return groupDuplicates(entries), nil
+}
+
+func (s *sqlStore) SetAlias(alias *FileAlias) error {
+	return s.db.Save(alias).Error
+}
+
+func (s *sqlStore) GetAlias(channelID int64, messageID int) (*FileAlias, error) {
+	var alias FileAlias
+	if err := s.db.First(&alias, "channel_id = ? AND message_id = ?", channelID, messageID).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &alias, nil
+}
+
+func (s *sqlStore) MoveFile(entry *FileEntry, alias *FileAlias) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(entry).Error; err != nil {
+			return err
+		}
+		to := map[string]any{"channel_id": entry.ChannelID, "message_id": entry.MessageID}
+		for _, model := range []any{&FileTag{}, &Subtitle{}, &FileHits{}, &FileVisitor{}} {
+			err := tx.Model(model).
+				Where("channel_id = ? AND message_id = ?", alias.ChannelID, alias.MessageID).
+				Updates(to).Error
+			if err != nil {
+				return err
+			}
+		}
+		// the file may be the subtitle paired with other files
+		err := tx.Model(&Subtitle{}).
+			Where("subtitle_channel_id = ? AND subtitle_message_id = ?", alias.ChannelID, alias.MessageID).
+			Updates(map[string]any{"subtitle_channel_id": entry.ChannelID, "subtitle_message_id": entry.MessageID}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Save(alias).Error; err != nil {
+			return err
+		}
+		res := tx.Delete(&FileEntry{}, "channel_id = ? AND message_id = ?", alias.ChannelID, alias.MessageID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) updateFile(channelID int64, messageID int, columns map[string]any) error {
+	res := s.db.Model(&FileEntry{}).
+		Where("channel_id = ? AND message_id = ?", channelID, messageID).
+		Updates(columns)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) AddTag(channelID int64, messageID int, name string) error {
+	return s.db.Save(&FileTag{ChannelID: channelID, MessageID: messageID, Name: name}).Error
+}
+
+func (s *sqlStore) RemoveTag(channelID int64, messageID int, name string) error {
+	return s.db.Delete(&FileTag{ChannelID: channelID, MessageID: messageID, Name: name}).Error
+}
+
+func (s *sqlStore) GetTags(channelID int64, messageID int) ([]string, error) {
+	var tags []string
+	err := s.db.Model(&FileTag{}).
+		Where("channel_id = ? AND message_id = ?", channelID, messageID).
+		Order("name").
+		Pluck("name", &tags).Error
+	return tags, err
+}
+
+func (s *sqlStore) SetSubtitle(subtitle *Subtitle) error {
+	return s.db.Save(subtitle).Error
+}
+
+func (s *sqlStore) GetSubtitle(channelID int64, messageID int) (*Subtitle, error) {
+	var subtitle Subtitle
+	if err := s.db.First(&subtitle, "channel_id = ? AND message_id = ?", channelID, messageID).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &subtitle, nil
+}
+
+func (s *sqlStore) AddChannel(channel *Channel) error {
+	return s.db.Save(channel).Error
+}
+
+func (s *sqlStore) ListChannels() ([]*Channel, error) {
+	var channels []*Channel
+	err := s.db.Order("created_at").Find(&channels).Error
+	return channels, err
+}
+
+func (s *sqlStore) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
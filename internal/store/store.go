@@ -0,0 +1,482 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrExists is returned when saving something under a key that's taken.
+var ErrExists = errors.New("already exists")
+
+type Link struct {
+	ID        string `gorm:"primaryKey"`
+	MessageID int    `gorm:"index"`
+	Hash      string
+	CreatedBy int64 `gorm:"index"`
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+	// Session is the download session that claimed a one-time link, empty
+	// until the link is first used.
+	Session string
+	// Countries are the countries a geo-fenced link works in, like "US,CA",
+	// or doesn't work in, like "-CN,-RU".
+	Countries string
+	// RevokedAt is when the link was revoked with /revoke, nil while it
+	// works.
+	RevokedAt *time.Time
+}
+
+// ShortLink maps the slug of a short link, /s/<slug>, to the link it
+// redirects to.
+type ShortLink struct {
+	Slug string `gorm:"primaryKey"`
+	// Target is the path and query of the link, like
+	// /stream/123?hash=abcdef, so it keeps working when HOST changes.
+	Target    string
+	MessageID int   `gorm:"index"`
+	CreatedBy int64 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// FileHits are the hits of the links to a file, rolled up by the analytics
+// package. Hits count downloads from the start of the file and Views the
+// loads of its analytics pixel.
+type FileHits struct {
+	ChannelID  int64 `gorm:"primaryKey;autoIncrement:false"`
+	MessageID  int   `gorm:"primaryKey;autoIncrement:false"`
+	UploadedBy int64 `gorm:"index"`
+	Hits       int64
+	Views      int64
+	LastAccess time.Time
+}
+
+// FileVisitor is an address that hit the links to a file, kept to count the
+// unique visitors of a file and the countries they're from. Visitor is a
+// hash of the address, so the addresses themselves aren't kept.
+type FileVisitor struct {
+	ChannelID int64  `gorm:"primaryKey;autoIncrement:false"`
+	MessageID int    `gorm:"primaryKey;autoIncrement:false"`
+	Visitor   string `gorm:"primaryKey"`
+	Country   string
+}
+
+type Ban struct {
+	UserID    int64 `gorm:"primaryKey;autoIncrement:false"`
+	Reason    string
+	CreatedAt time.Time
+}
+
+// UserQuota is how much the links to a user's files may be downloaded per
+// day, set by the owner with /quota. Either limit is 0 for QUOTA_MB or
+// QUOTA_DOWNLOADS, and QuotaUnlimited for no limit.
+type UserQuota struct {
+	UserID          int64 `gorm:"primaryKey;autoIncrement:false"`
+	BytesPerDay     int64
+	DownloadsPerDay int64
+	UpdatedAt       time.Time
+}
+
+// QuotaUnlimited is a limit of a UserQuota that doesn't limit anything.
+const QuotaUnlimited = -1
+
+type Stat struct {
+	Name  string `gorm:"primaryKey"`
+	Value int64
+}
+
+// RateWindow counts the hits of a rate limit in the fixed window numbered
+// Index, and in the window before it.
+type RateWindow struct {
+	Name     string `gorm:"primaryKey"`
+	Index    int64
+	Count    int64
+	Previous int64
+}
+
+// IdempotentRequest is an API request sent with an Idempotency-Key, along
+// with the response it got once it's done, which retries sending the same
+// key get instead of running the request again.
+type IdempotentRequest struct {
+	Key string `gorm:"primaryKey"`
+	// Fingerprint tells a retry apart from another request reusing the key.
+	Fingerprint string
+	// Status is 0 until the request is done.
+	Status      int
+	ContentType string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+type FileEntry struct {
+	ChannelID  int64 `gorm:"primaryKey;autoIncrement:false"`
+	MessageID  int   `gorm:"primaryKey;autoIncrement:false"`
+	FileName   string
+	FileSize   int64
+	MimeType   string
+	FileID     int64
+	UploadedBy int64 `gorm:"index"`
+	// SourceMessageID is the ID of the message the file was sent in, in the
+	// uploader's chat with the bot.
+	SourceMessageID int
+	// Backend is where the file's bytes are kept, and ObjectKey its key in
+	// the object store when Backend is BackendS3 or BackendBoth.
+	Backend   string `gorm:"default:telegram"`
+	ObjectKey string
+	// HashSalt is set when the file's links are rotated, changing its hash.
+	HashSalt string
+	// Description is set by the uploader with /desc and searched along with
+	// the file name.
+	Description string
+	MediaInfo   `gorm:"embedded"`
+	// Notify is when the uploader is sent a message about the file being
+	// downloaded, one of the Notify constants.
+	Notify string
+	// PasswordHash is set when the uploader protected the file with a
+	// password with /password, hashed by utils.HashPassword.
+	PasswordHash string
+	// RateLimit is the rate in bytes per second the uploader capped the
+	// file's links at with /throttle, 0 for the default rate and
+	// throttle.Unlimited for none.
+	RateLimit int64
+	CreatedAt time.Time
+}
+
+const (
+	NotifyOff   = ""
+	NotifyFirst = "first"
+	NotifyIP    = "ip"
+)
+
+// MediaInfo describes an audio or video file. It's taken from the file's
+// Telegram attributes when it's indexed, and filled in by ffprobe when
+// FFPROBE_PATH is set.
+type MediaInfo struct {
+	// Duration is in seconds.
+	Duration   float64
+	Width      int
+	Height     int
+	VideoCodec string
+	AudioCodec string
+	// Subtitles lists the subtitle tracks ffmpeg can convert to WebVTT, see
+	// SubtitleTracks.
+	Subtitles string
+}
+
+// SubtitleTrack is a subtitle track embedded in a video. Index is its index
+// among the video's subtitle tracks, counting the ones that can't be
+// converted to WebVTT too.
+type SubtitleTrack struct {
+	Index int
+	Label string
+}
+
+// JoinSubtitleTracks lists tracks the way MediaInfo.Subtitles keeps them,
+// as "<index>:<label>" separated by "|".
+func JoinSubtitleTracks(tracks []SubtitleTrack) string {
+	parts := make([]string, len(tracks))
+	for i, track := range tracks {
+		parts[i] = strconv.Itoa(track.Index) + ":" + strings.ReplaceAll(track.Label, "|", "/")
+	}
+	return strings.Join(parts, "|")
+}
+
+// SubtitleTracks returns the tracks listed in Subtitles.
+func (m MediaInfo) SubtitleTracks() []SubtitleTrack {
+	if m.Subtitles == "" {
+		return nil
+	}
+	var tracks []SubtitleTrack
+	for _, part := range strings.Split(m.Subtitles, "|") {
+		index, label, _ := strings.Cut(part, ":")
+		n, err := strconv.Atoi(index)
+		if err != nil {
+			continue
+		}
+		tracks = append(tracks, SubtitleTrack{Index: n, Label: label})
+	}
+	return tracks
+}
+
+const (
+	BackendTelegram = "telegram"
+	BackendS3       = "s3"
+	BackendBoth     = "both"
+)
+
+type FileTag struct {
+	ChannelID int64  `gorm:"primaryKey;autoIncrement:false"`
+	MessageID int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"primaryKey;index"`
+}
+
+// Subtitle pairs a video with the subtitle file that was sent for it.
+type Subtitle struct {
+	ChannelID         int64 `gorm:"primaryKey;autoIncrement:false"`
+	MessageID         int   `gorm:"primaryKey;autoIncrement:false"`
+	SubtitleChannelID int64
+	SubtitleMessageID int
+	CreatedAt         time.Time
+}
+
+// FileAlias points the links of a file deleted as a duplicate at the copy
+// that was kept, or the links of a file moved to another channel at where
+// it was moved. The links are checked against the file they point at now,
+// so rotating it revokes them too. Hash is only set on aliases of
+// duplicates removed by older versions, as the full hash their links were
+// made with.
+type FileAlias struct {
+	ChannelID       int64 `gorm:"primaryKey;autoIncrement:false"`
+	MessageID       int   `gorm:"primaryKey;autoIncrement:false"`
+	TargetChannelID int64
+	TargetMessageID int
+	Hash            string
+	CreatedAt       time.Time
+}
+
+// groupDuplicates groups entries by size and Telegram file ID, keeping the
+// groups with more than one file. The order of entries is kept within each
+// group, and groups are ordered by their first file.
+func groupDuplicates(entries []*FileEntry) [][]*FileEntry {
+	type content struct {
+		fileID int64
+		size   int64
+	}
+	index := make(map[content]int)
+	var groups [][]*FileEntry
+	for _, entry := range entries {
+		if entry.FileID == 0 {
+			continue
+		}
+		key := content{entry.FileID, entry.FileSize}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], entry)
+	}
+	duplicates := groups[:0]
+	for _, group := range groups {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	return duplicates
+}
+
+// FileCursor is the position of a file in the newest-first file index.
+type FileCursor struct {
+	CreatedAt int64 `json:"t"`
+	ChannelID int64 `json:"c"`
+	MessageID int   `json:"m"`
+}
+
+func (e *FileEntry) Cursor() FileCursor {
+	return FileCursor{CreatedAt: e.CreatedAt.UnixNano(), ChannelID: e.ChannelID, MessageID: e.MessageID}
+}
+
+// FileQuery filters indexed files. Zero values match everything, Text
+// matches file names and descriptions and MimeType is a prefix of the
+// files' MIME type, like "video/". Results
+// are ordered newest first and start right after After when it is set.
+type FileQuery struct {
+	Text        string
+	Tag         string
+	UploadedBy  int64
+	MimeType    string
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	Limit       int
+	After       *FileCursor
+}
+
+type Channel struct {
+	ID      int64 `gorm:"primaryKey;autoIncrement:false"`
+	Title   string
+	AddedBy int64
+	// GroupID is the group whose members' files the channel stores, 0 for
+	// channels storing the files sent to the bot directly.
+	GroupID   int64 `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// Job states. Jobs that succeed are deleted.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+)
+
+// Job is a piece of background work, retried with backoff until it
+// succeeds or runs out of attempts.
+type Job struct {
+	ID        int64     `gorm:"primaryKey" json:"id"`
+	Kind      string    `json:"kind"`
+	Payload   string    `json:"payload"`
+	State     string    `gorm:"index" json:"state"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error,omitempty"`
+	RunAt     time.Time `gorm:"index" json:"run_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// JobQuery lists jobs newest first. BeforeID is the cursor, only jobs with a
+// lower ID are returned when it's set.
+type JobQuery struct {
+	State    string
+	BeforeID int64
+	Limit    int
+}
+
+// windowIndex numbers the fixed windows of length window since the epoch.
+func windowIndex(now time.Time, window time.Duration) int64 {
+	return now.UnixNano() / int64(window)
+}
+
+// incr moves the counts on to the window numbered index and adds delta to
+// it.
+func (w *RateWindow) incr(index int64, delta int64) {
+	switch {
+	case index == w.Index+1:
+		w.Previous, w.Count = w.Count, 0
+	case index != w.Index:
+		w.Previous, w.Count = 0, 0
+	}
+	w.Index = index
+	w.Count += delta
+}
+
+// Store persists the bot's metadata. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	SaveLink(link *Link) error
+	GetLink(id string) (*Link, error)
+	DeleteLink(id string) error
+	// ClaimLink sets the session of a link unless another one claimed it
+	// first, and returns the session that holds the link.
+	ClaimLink(id string, session string) (string, error)
+
+	// CreateShortLink saves a short link, or returns ErrExists when its slug
+	// is taken.
+	CreateShortLink(link *ShortLink) error
+	GetShortLink(slug string) (*ShortLink, error)
+
+	// ClaimIdempotencyKey saves req unless a request that hasn't expired
+	// was saved with its key already, which it returns instead.
+	ClaimIdempotencyKey(req *IdempotentRequest) (*IdempotentRequest, error)
+	// FinishIdempotentRequest saves the response of a claimed request.
+	FinishIdempotentRequest(req *IdempotentRequest) error
+	DeleteIdempotencyKey(key string) error
+
+	// RecordHits adds the hits and views in hits to the file's, keeps the
+	// later of the last accesses and saves the visitors it didn't have yet.
+	RecordHits(hits *FileHits, visitors []*FileVisitor) error
+	// ListFileHits returns the hits of the files uploaded by uploadedBy,
+	// most recently accessed first.
+	ListFileHits(uploadedBy int64, limit int) ([]*FileHits, error)
+	GetFileHits(channelID int64, messageID int) (*FileHits, error)
+	// CountVisitors returns how many visitors hit a file's links, and how
+	// many of them came from each country.
+	CountVisitors(channelID int64, messageID int) (int64, map[string]int64, error)
+
+	Ban(userID int64, reason string) error
+	Unban(userID int64) error
+	IsBanned(userID int64) (bool, error)
+
+	SetUserQuota(quota *UserQuota) error
+	GetUserQuota(userID int64) (*UserQuota, error)
+
+	IncrStat(name string, delta int64) (int64, error)
+	GetStats() (map[string]int64, error)
+
+	// IncrWindow adds delta to the hits under key in the fixed window of
+	// length window that now falls in, and returns the hits in it and in the
+	// window before it. Sliding window rate limits are estimated from them.
+	IncrWindow(key string, now time.Time, window time.Duration, delta int64) (current int64, previous int64, err error)
+
+	// AddJob saves a new job, setting its ID.
+	AddJob(job *Job) error
+	// ClaimJob marks the pending job that has been due the longest as
+	// running and returns it, or ErrNotFound if no job is due.
+	ClaimJob(now time.Time) (*Job, error)
+	UpdateJob(job *Job) error
+	DeleteJob(id int64) error
+	GetJob(id int64) (*Job, error)
+	ListJobs(query JobQuery) ([]*Job, error)
+	// RequeueRunningJobs makes the jobs left running by a previous run of
+	// the bot pending again.
+	RequeueRunningJobs() error
+
+	IndexFile(entry *FileEntry) error
+	GetFile(channelID int64, messageID int) (*FileEntry, error)
+	GetFileBySource(uploadedBy int64, sourceMessageID int) (*FileEntry, error)
+	SearchFiles(query FileQuery) ([]*FileEntry, error)
+	SetHashSalt(channelID int64, messageID int, salt string) error
+	SetDescription(channelID int64, messageID int, description string) error
+	SetMediaInfo(channelID int64, messageID int, info MediaInfo) error
+	SetNotify(channelID int64, messageID int, notify string) error
+	SetPassword(channelID int64, messageID int, passwordHash string) error
+	SetRateLimit(channelID int64, messageID int, rateLimit int64) error
+	// SetObject records that the file is kept in the object store under
+	// objectKey as well, returning ErrNotFound if it was removed from the
+	// index in the meantime.
+	SetObject(channelID int64, messageID int, backend string, objectKey string) error
+	// DeleteFile removes the file from the index along with its tags.
+	DeleteFile(channelID int64, messageID int) error
+	// DuplicateFiles returns the groups of indexed files that have the same
+	// size and Telegram file ID, oldest first within each group.
+	DuplicateFiles() ([][]*FileEntry, error)
+	SetAlias(alias *FileAlias) error
+	GetAlias(channelID int64, messageID int) (*FileAlias, error)
+	// MoveFile indexes entry at its new location and removes the file at
+	// the location of alias from the index, moving its tags, subtitle
+	// pairings, hits and visitors over and pointing its links at entry with
+	// alias, all at once.
+	MoveFile(entry *FileEntry, alias *FileAlias) error
+
+	AddTag(channelID int64, messageID int, name string) error
+	RemoveTag(channelID int64, messageID int, name string) error
+	GetTags(channelID int64, messageID int) ([]string, error)
+
+	SetSubtitle(subtitle *Subtitle) error
+	GetSubtitle(channelID int64, messageID int) (*Subtitle, error)
+
+	AddChannel(channel *Channel) error
+	ListChannels() ([]*Channel, error)
+
+	Close() error
+}
+
+var store Store
+
+// InitStore opens the store described by dsn. The scheme selects the
+// backend: postgres://, redis:// or sqlite:// (the default when no scheme is
+// given).
+func InitStore(log *zap.Logger, dsn string) error {
+	log = log.Named("store")
+	var err error
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		store, err = newPostgresStore(dsn)
+	case strings.HasPrefix(dsn, "redis://"), strings.HasPrefix(dsn, "rediss://"):
+		store, err = newRedisStore(dsn)
+	default:
+		store, err = newSQLiteStore(strings.TrimPrefix(dsn, "sqlite://"))
+	}
+	if err != nil {
+		return err
+	}
+	log.Sugar().Infof("Initialized %T", store)
+	return nil
+}
+
+func GetStore() Store {
+	return store
+}
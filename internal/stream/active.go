@@ -0,0 +1,131 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// ActiveStream describes a response that is currently being streamed.
+type ActiveStream struct {
+	ID         uint64    `json:"id"`
+	ChannelID  int64     `json:"channel_id"`
+	MessageID  int       `json:"message_id"`
+	FileName   string    `json:"file_name"`
+	WorkerID   int       `json:"worker_id"`
+	Start      int64     `json:"start"`
+	End        int64     `json:"end"`
+	Sent       int64     `json:"sent"`
+	Fetched    int64     `json:"fetched"`
+	RemoteAddr string    `json:"remote_addr"`
+	StartedAt  time.Time `json:"started_at"`
+}
+
+type trackedStream struct {
+	info    ActiveStream
+	sent    atomic.Int64
+	fetched atomic.Int64
+}
+
+func (t *trackedStream) Write(p []byte) (int, error) {
+	t.sent.Add(int64(len(p)))
+	bandwidth.add(t.info.WorkerID, int64(len(p)))
+	return len(p), nil
+}
+
+type activeStreams struct {
+	mu      sync.RWMutex
+	nextID  uint64
+	streams map[uint64]*trackedStream
+}
+
+var active = &activeStreams{streams: make(map[uint64]*trackedStream)}
+
+func (a *activeStreams) add(info ActiveStream) *trackedStream {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nextID++
+	info.ID = a.nextID
+	info.StartedAt = time.Now()
+	tracked := &trackedStream{info: info}
+	a.streams[info.ID] = tracked
+	return tracked
+}
+
+func (a *activeStreams) remove(id uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.streams, id)
+}
+
+// ActiveStreams returns up to limit streams with an ID greater than after,
+// ordered by ID. A limit of 0 returns all of them.
+func ActiveStreams(after uint64, limit int) []ActiveStream {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+	streams := make([]ActiveStream, 0, len(active.streams))
+	for id, tracked := range active.streams {
+		if id <= after {
+			continue
+		}
+		info := tracked.info
+		info.Sent = tracked.sent.Load()
+		info.Fetched = tracked.fetched.Load()
+		streams = append(streams, info)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].ID < streams[j].ID
+	})
+	if limit > 0 && len(streams) > limit {
+		streams = streams[:limit]
+	}
+	return streams
+}
+
+// track counts the bytes written through w towards the stream.
+func (t *trackedStream) track(w io.Writer) io.Writer {
+	return io.MultiWriter(w, t)
+}
+
+// recordBytes adds the bytes the stream sent and fetched to the stats once
+// it's done.
+func recordBytes(t *trackedStream) {
+	store.GetStore().IncrStat("served_bytes", t.sent.Load())
+	if fetched := t.fetched.Load(); fetched > 0 {
+		store.GetStore().IncrStat("fetched_bytes", fetched)
+	}
+}
+
+// countFetched counts the bytes fetcher fetches from Telegram towards the
+// stream, the ones that weren't served from a cache.
+func (t *trackedStream) countFetched(fetcher ChunkFetcher) ChunkFetcher {
+	return &countingFetcher{fetcher: fetcher, stream: t}
+}
+
+type countingFetcher struct {
+	fetcher ChunkFetcher
+	stream  *trackedStream
+}
+
+func (f *countingFetcher) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	data, err := f.fetcher.FetchChunk(ctx, location, offset, limit)
+	f.stream.fetched.Add(int64(len(data)))
+	return data, err
+}
+
+// WorkerStreams returns the number of active streams of each worker.
+func WorkerStreams() map[int]int {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+	streams := make(map[int]int)
+	for _, tracked := range active.streams {
+		streams[tracked.info.WorkerID]++
+	}
+	return streams
+}
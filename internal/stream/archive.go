@@ -0,0 +1,270 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/plugin"
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type archiveEntry struct {
+	req    *Request
+	source Source
+	file   *types.File
+	name   string
+	// indexed is the file's index entry, nil if it isn't indexed, and
+	// uploader the user whose quota it's counted towards
+	indexed  *store.FileEntry
+	uploader int64
+	// photos don't report their size, so they are fetched up front
+	photo []byte
+	// event is the entry's stream the plugins allowed
+	event plugin.StreamEvent
+}
+
+func (e *archiveEntry) size() int64 {
+	if e.photo != nil {
+		return int64(len(e.photo))
+	}
+	return e.file.FileSize
+}
+
+func (e *archiveEntry) header(modTime time.Time) *tar.Header {
+	return &tar.Header{
+		Name:    e.name,
+		Mode:    0o644,
+		Size:    e.size(),
+		ModTime: modTime,
+	}
+}
+
+// countingWriter counts the bytes written to it, to size a body before
+// writing it.
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// tarSize returns the size of the tar archive of entries, which is known up
+// front since tar has no compression or central directory.
+func tarSize(entries []*archiveEntry, modTime time.Time) int64 {
+	var size int64 = 1024 // two empty blocks at the end
+	for _, entry := range entries {
+		// headers can take more than one block with long or non-ASCII
+		// names, so write them out to measure them
+		var header countingWriter
+		tar.NewWriter(&header).WriteHeader(entry.header(modTime))
+		size += int64(header) + (entry.size()+511)/512*512
+	}
+	return size
+}
+
+// entryError prefixes the error a file of an archive was turned away with
+// with its message ID, keeping its status.
+func entryError(req *Request, err error) error {
+	status := http.StatusBadRequest
+	var streamErr *Error
+	if errors.As(err, &streamErr) {
+		status = streamErr.Status
+	}
+	return &Error{status, fmt.Sprintf("%d: %s", req.MessageID, err.Error())}
+}
+
+// entryWriter is the part of an archive a file is written to, for the
+// writers that throttle and meter a file's response to wrap.
+type entryWriter struct {
+	io.Writer
+}
+
+func (entryWriter) Header() http.Header {
+	return http.Header{}
+}
+
+func (entryWriter) WriteHeader(int) {}
+
+// archiveEntries resolves the files in reqs and checks their hashes and
+// their uploaders' quotas, before anything of an archive is written to w.
+func (s *Service) archiveEntries(ctx context.Context, reqs []*Request, w ResponseWriter) ([]*archiveEntry, error) {
+	entries := make([]*archiveEntry, 0, len(reqs))
+	names := make(map[string]int)
+	for _, req := range reqs {
+		source := s.source(req)
+		file, err := source.File(ctx, req.ChannelID, req.MessageID)
+		if err != nil {
+			return nil, &Error{http.StatusBadRequest, fmt.Sprintf("%d: %s", req.MessageID, err.Error())}
+		}
+		indexed := fileEntry(req)
+		if !checkHash(req, fileHash(req, indexed, file)) {
+			return nil, &Error{http.StatusBadRequest, fmt.Sprintf("%d: invalid hash", req.MessageID)}
+		}
+		if err := checkLink(req); err != nil {
+			return nil, entryError(req, err)
+		}
+		if config.ValueOf.StrictMode && !sentByBot(indexed, file) {
+			return nil, &Error{http.StatusNotFound, fmt.Sprintf("%d: file not found", req.MessageID)}
+		}
+		if err := checkPassword(req, indexed); err != nil {
+			return nil, entryError(req, err)
+		}
+		uploader, err := checkQuota(indexed, w)
+		if err != nil {
+			return nil, entryError(req, err)
+		}
+		event := streamEvent(req, file.FileName, file.FileSize, file.MimeType, source.WorkerID())
+		if err := allowStream(event); err != nil {
+			return nil, err
+		}
+		entry := &archiveEntry{
+			req:      req,
+			source:   source,
+			file:     file,
+			name:     archiveName(file, req, names),
+			indexed:  indexed,
+			uploader: uploader,
+			event:    event,
+		}
+		if file.FileSize == 0 {
+			entry.photo, err = source.FetchChunk(ctx, file.Location, 0, utils.MaxChunkSize)
+			if err != nil {
+				return nil, &Error{http.StatusBadGateway, err.Error()}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// copyEntry streams the file of entry to out, at the rate its links are
+// served at and counted towards its uploader's quota like a download of it.
+func (s *Service) copyEntry(ctx context.Context, entry *archiveEntry, out io.Writer) error {
+	ctx = bot.WithPriority(ctx, bot.PriorityBulk)
+	started := time.Now()
+	w, release := throttled(ctx, entry.req, entry.indexed, entryWriter{out})
+	defer release()
+	w, flush := metered(entry.req, w, entry.uploader)
+	defer flush()
+	if entry.photo != nil {
+		n, err := w.Write(entry.photo)
+		finishStream(entry.event, started, int64(n), err)
+		return err
+	}
+	tracked := active.add(ActiveStream{
+		ChannelID:  entry.req.ChannelID,
+		MessageID:  entry.req.MessageID,
+		FileName:   entry.file.FileName,
+		WorkerID:   entry.source.WorkerID(),
+		End:        entry.file.FileSize - 1,
+		RemoteAddr: entry.req.RemoteAddr,
+	})
+	defer active.remove(tracked.info.ID)
+	fetcher := &failoverFetcher{service: s, req: entry.req, source: entry.source}
+	reader, _ := NewTelegramReader(ctx, fetcher, entry.source.ChunkSize(), entry.file.Location, 0, entry.file.FileSize-1, entry.file.FileSize, nil)
+	defer reader.Close()
+	sent, err := io.Copy(tracked.track(w), reader)
+	finishStream(entry.event, started, sent, err)
+	return err
+}
+
+// ServeTar streams the files in reqs as a tar archive, compressed with gzip
+// if compress is set. Every file is resolved and its hash checked before
+// anything is written, and files are then streamed one after another, so
+// memory use doesn't grow with the size or number of files.
+func (s *Service) ServeTar(ctx context.Context, reqs []*Request, compress bool, w ResponseWriter) error {
+	entries, err := s.archiveEntries(ctx, reqs, w)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Truncate(time.Second)
+	fileName := "files.tar"
+	contentType := "application/x-tar"
+	if compress {
+		fileName += ".gz"
+		contentType = "application/gzip"
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(tarSize(entries, now), 10))
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	w.WriteHeader(http.StatusOK)
+
+	var out io.Writer = w
+	if compress {
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+	defer tw.Close()
+	for _, entry := range entries {
+		if err := tw.WriteHeader(entry.header(now)); err != nil {
+			return err
+		}
+		if err := s.copyEntry(ctx, entry, tw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeZip streams the files in reqs as a zip archive like ServeTar. Files
+// are stored as they are, media doesn't get any smaller by deflating it, and
+// their checksums follow their data so nothing has to be read twice.
+func (s *Service) ServeZip(ctx context.Context, reqs []*Request, w ResponseWriter) error {
+	entries, err := s.archiveEntries(ctx, reqs, w)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"files.zip\"")
+	w.WriteHeader(http.StatusOK)
+
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+	for _, entry := range entries {
+		file, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     entry.name,
+			Method:   zip.Store,
+			Modified: now,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.copyEntry(ctx, entry, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveName returns a unique name for a file in an archive.
+func archiveName(file *types.File, req *Request, seen map[string]int) string {
+	name := path.Base(strings.ReplaceAll(file.FileName, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		name = strconv.Itoa(req.MessageID)
+	}
+	seen[name]++
+	if n := seen[name]; n > 1 {
+		ext := path.Ext(name)
+		name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+	}
+	return name
+}
@@ -0,0 +1,111 @@
+package stream
+
+import (
+	"sync"
+	"time"
+)
+
+// BandwidthSample is the throughput over the last second, in bytes per
+// second, in total and for each worker. Fetched is what was fetched from
+// Telegram in that second, which is less than Total when chunks are served
+// from a cache.
+type BandwidthSample struct {
+	Time    time.Time     `json:"time"`
+	Total   int64         `json:"total"`
+	Fetched int64         `json:"fetched"`
+	Workers map[int]int64 `json:"workers"`
+}
+
+type bandwidthMeter struct {
+	mu           sync.Mutex
+	sent         map[int]int64
+	total        map[int]int64
+	fetched      int64
+	fetchedTotal map[int]int64
+	subscribers  map[chan BandwidthSample]struct{}
+	once         sync.Once
+}
+
+var bandwidth = &bandwidthMeter{
+	sent:         make(map[int]int64),
+	total:        make(map[int]int64),
+	fetchedTotal: make(map[int]int64),
+	subscribers:  make(map[chan BandwidthSample]struct{}),
+}
+
+func (b *bandwidthMeter) add(workerID int, n int64) {
+	b.mu.Lock()
+	b.sent[workerID] += n
+	b.total[workerID] += n
+	b.mu.Unlock()
+}
+
+// addFetched counts bytes a worker fetched from Telegram.
+func (b *bandwidthMeter) addFetched(workerID int, n int64) {
+	b.mu.Lock()
+	b.fetched += n
+	b.fetchedTotal[workerID] += n
+	b.mu.Unlock()
+}
+
+func (b *bandwidthMeter) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		b.mu.Lock()
+		sample := BandwidthSample{Time: now, Fetched: b.fetched, Workers: b.sent}
+		for _, n := range b.sent {
+			sample.Total += n
+		}
+		b.sent = make(map[int]int64, len(sample.Workers))
+		b.fetched = 0
+		for ch := range b.subscribers {
+			select {
+			case ch <- sample:
+			default:
+				// slow subscriber, drop the sample rather than block streams
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// SubscribeBandwidth returns a channel receiving a BandwidthSample every
+// second, and a function to stop the subscription.
+func SubscribeBandwidth() (<-chan BandwidthSample, func()) {
+	bandwidth.once.Do(func() { go bandwidth.run() })
+	ch := make(chan BandwidthSample, 1)
+	bandwidth.mu.Lock()
+	bandwidth.subscribers[ch] = struct{}{}
+	bandwidth.mu.Unlock()
+	return ch, func() {
+		bandwidth.mu.Lock()
+		delete(bandwidth.subscribers, ch)
+		bandwidth.mu.Unlock()
+	}
+}
+
+// SentBytes returns the number of bytes each worker has streamed since
+// startup.
+func SentBytes() map[int]int64 {
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	sent := make(map[int]int64, len(bandwidth.total))
+	for id, n := range bandwidth.total {
+		sent[id] = n
+	}
+	return sent
+}
+
+// FetchedBytes returns the number of bytes each worker has fetched from
+// Telegram since startup. Chunks served from a cache are counted by
+// SentBytes but not here.
+func FetchedBytes() map[int]int64 {
+	bandwidth.mu.Lock()
+	defer bandwidth.mu.Unlock()
+	fetched := make(map[int]int64, len(bandwidth.fetchedTotal))
+	for id, n := range bandwidth.fetchedTotal {
+		fetched[id] = n
+	}
+	return fetched
+}
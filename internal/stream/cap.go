@@ -0,0 +1,34 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"fmt"
+	"net/url"
+)
+
+// capResponse shortens the range [start, end] to MAX_RESPONSE_GB. When it
+// does, it returns the new end and points the client to the rest of the file
+// with a Link header.
+func capResponse(req *Request, w ResponseWriter, start, end int64) (int64, bool) {
+	limit := int64(config.ValueOf.MaxResponseGB) << 30
+	if limit <= 0 || end-start+1 <= limit {
+		return end, false
+	}
+	end = start + limit - 1
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", continuationURL(config.ValueOf.Host+req.URL, end+1)))
+	return end, true
+}
+
+// continuationURL returns the request URL with its range query parameter set
+// to start at offset. The stream route reads the range from the query when
+// there is no Range header, so the link can be followed as is.
+func continuationURL(requestURI string, offset int64) string {
+	u, err := url.Parse(requestURI)
+	if err != nil {
+		return requestURI
+	}
+	query := u.Query()
+	query.Set("range", fmt.Sprintf("bytes=%d-", offset))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
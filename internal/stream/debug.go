@@ -0,0 +1,49 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/diskcache"
+	"EverythingSuckz/fsb/internal/utils"
+	"strconv"
+)
+
+// The headers debug responses report how they're served in.
+const (
+	// WorkerHeader is the ID of the worker serving the response, 0 for
+	// files served from the object store.
+	WorkerHeader = "X-FSB-Worker"
+	// CacheHeader is hit when every chunk of the range is cached, miss when
+	// none are and partial otherwise.
+	CacheHeader = "X-FSB-Cache"
+	// ChunkSizeHeader is the size of the chunks the range is fetched in.
+	ChunkSizeHeader = "X-FSB-Chunk-Size"
+)
+
+// DebugHeaders lists the headers debug responses are sent with.
+var DebugHeaders = []string{WorkerHeader, CacheHeader, ChunkSizeHeader}
+
+// debugHeaders reports the chunk size the bytes from start to end are
+// fetched in, and how many of those chunks the client's window, the shared
+// chunk cache or the disk cache hold. Chunks evicted before the stream gets
+// to them are fetched anyway, so a hit can still be slow.
+func (s *Service) debugHeaders(req *Request, source Source, start int64, end int64, w ResponseWriter) {
+	plan := utils.NewChunkPlanner(source.ChunkSize()).Plan(start, end)
+	window := s.windows.peek(req)
+	cached := 0
+	for part := 1; part <= plan.Parts; part++ {
+		offset := plan.PartOffset(part)
+		if (window != nil && window.has(chunkKey{offset, plan.ChunkSize})) ||
+			sharedCached(req.ChannelID, req.MessageID, offset, plan.ChunkSize) ||
+			(diskcache.Enabled() && diskcache.Has(req.ChannelID, req.MessageID, offset, plan.ChunkSize)) {
+			cached++
+		}
+	}
+	status := "partial"
+	switch cached {
+	case 0:
+		status = "miss"
+	case plan.Parts:
+		status = "hit"
+	}
+	w.Header().Set(CacheHeader, status)
+	w.Header().Set(ChunkSizeHeader, strconv.FormatInt(plan.ChunkSize, 10))
+}
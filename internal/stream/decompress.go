@@ -0,0 +1,73 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// serveDecompressed serves a gzipped document decompressed, with the type
+// and name of the file inside, so browsers show .gz logs and the like
+// directly. Its size isn't known until it's decompressed, so range requests
+// aren't supported.
+func (s *Service) serveDecompressed(ctx context.Context, req *Request, source Source, file *types.File, w ResponseWriter) error {
+	if req.Preview.Enabled() {
+		return &Error{http.StatusBadRequest, "preview links can't be decompressed"}
+	}
+	fetcher := cached(req, s.fetcher(ctx, req, source))
+	reader, _ := NewTelegramReader(ctx, fetcher, source.ChunkSize(), file.Location, 0, file.FileSize-1, file.FileSize, nil)
+	defer reader.Close()
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		return &Error{http.StatusUnsupportedMediaType, "the file isn't gzipped"}
+	}
+	defer gz.Close()
+	// only a single member, like gunzip -c of a file made by gzip
+	gz.Multistream(false)
+
+	name := decompressedName(file.FileName, gz.Name)
+	body := bufio.NewReaderSize(gz, 512)
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if mimeType == "" {
+		head, _ := body.Peek(512)
+		mimeType = http.DetectContentType(head)
+	}
+	disposition := "inline"
+	if req.Download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, name, req.ASCIIFileName))
+	w.WriteHeader(http.StatusOK)
+	if req.Head {
+		return nil
+	}
+	buf, release := copyBuffer()
+	defer release()
+	_, err = io.CopyBuffer(w, body, buf)
+	return err
+}
+
+// decompressedName is the name of the file inside a gzipped file: the name
+// without .gz, or the one saved in its gzip header when it doesn't end in
+// .gz.
+func decompressedName(fileName string, headerName string) string {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".tgz"):
+		return fileName[:len(fileName)-len(".tgz")] + ".tar"
+	case strings.HasSuffix(lower, ".gz") && len(fileName) > len(".gz"):
+		return fileName[:len(fileName)-len(".gz")]
+	case headerName != "":
+		return path.Base(headerName)
+	}
+	return fileName
+}
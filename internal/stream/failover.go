@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// maxFailovers caps how many times a single stream moves to another worker.
+const maxFailovers = 3
+
+// failoverFetcher fetches the chunks of a stream through source and moves the
+// stream to another source when a fetch fails. File locations are only valid
+// for the client that resolved them, so the file is looked up again through
+// the new source and the location passed to FetchChunk is ignored after the
+// first failover. Chunks read ahead are fetched concurrently, so the fields
+// are guarded by mu.
+type failoverFetcher struct {
+	service   *Service
+	req       *Request
+	mu        sync.Mutex
+	source    Source
+	location  tg.InputFileLocationClass
+	failovers int
+}
+
+func (f *failoverFetcher) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	for {
+		f.mu.Lock()
+		if f.location == nil {
+			f.location = location
+		}
+		source, fileLocation := f.source, f.location
+		f.mu.Unlock()
+		data, err := source.FetchChunk(ctx, fileLocation, offset, limit)
+		if err == nil || ctx.Err() != nil || !f.failover(ctx, source, offset, err) {
+			return data, err
+		}
+	}
+}
+
+// failover moves the stream off from after a fetch through it failed with
+// err, reporting whether the fetch should be tried again. A fetch that
+// failed on a source another fetch already moved away from is just tried
+// again.
+func (f *failoverFetcher) failover(ctx context.Context, from Source, offset int64, err error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.source != from {
+		return true
+	}
+	if f.failovers >= maxFailovers || f.req.Worker != 0 {
+		return false
+	}
+	next := f.service.pick("")
+	if next.WorkerID() == f.source.WorkerID() {
+		return false
+	}
+	file, lookupErr := next.File(ctx, f.req.ChannelID, f.req.MessageID)
+	if lookupErr != nil {
+		return false
+	}
+	utils.LoggerFrom(ctx).Warn("Moving stream to another worker",
+		zap.Int("from", f.source.WorkerID()),
+		zap.Int("to", next.WorkerID()),
+		zap.Int64("offset", offset),
+		zap.Error(err))
+	f.source, f.location = next, file.Location
+	f.failovers++
+	return true
+}
+
+// spreadFetcher fetches the chunks of a stream through several workers, so
+// the chunks read ahead with PARALLEL_CHUNKS are fetched by different
+// workers at once. The chunk at an offset always goes through the same one.
+type spreadFetcher struct {
+	fetchers []ChunkFetcher
+}
+
+func (f *spreadFetcher) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	return f.fetchers[int(offset/limit)%len(f.fetchers)].FetchChunk(ctx, location, offset, limit)
+}
+
+// fetcher returns the fetcher of the stream in req, which starts on source
+// and is spread over up to PARALLEL_CHUNKS workers when there are several.
+func (s *Service) fetcher(ctx context.Context, req *Request, source Source) ChunkFetcher {
+	primary := &failoverFetcher{service: s, req: req, source: source}
+	parallel := config.ValueOf.ParallelChunks
+	if parallel <= 1 || req.Worker != 0 {
+		return primary
+	}
+	fetchers := []ChunkFetcher{primary}
+	seen := map[int]bool{source.WorkerID(): true}
+	for i := 0; i < parallel*2 && len(fetchers) < parallel; i++ {
+		// workers are picked by load, so the same one would come up
+		// again and again without a key of its own for every try
+		next := s.pick(fmt.Sprintf("%s/%d/%d/%d", req.RemoteAddr, req.ChannelID, req.MessageID, i))
+		if seen[next.WorkerID()] {
+			continue
+		}
+		seen[next.WorkerID()] = true
+		// the location the stream is started with is only valid for
+		// source, so the others look the file up themselves
+		file, err := next.File(ctx, req.ChannelID, req.MessageID)
+		if err != nil {
+			continue
+		}
+		fetchers = append(fetchers, &failoverFetcher{service: s, req: req, source: next, location: file.Location})
+	}
+	if len(fetchers) == 1 {
+		return primary
+	}
+	return &spreadFetcher{fetchers: fetchers}
+}
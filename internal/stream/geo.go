@@ -0,0 +1,27 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/geoip"
+	"net/http"
+)
+
+// checkGeo turns away requests for links created with /geo from the
+// countries the link doesn't work in, with 451 as the file is held back
+// for legal reasons like licensing.
+func checkGeo(req *Request) error {
+	if req.Geo == "" {
+		return nil
+	}
+	link, err := linkRecord(req.Geo)
+	if err != nil {
+		return err
+	}
+	country := req.Country
+	if country == "" {
+		country = geoip.Lookup(req.RemoteAddr)
+	}
+	if !geoip.Allowed(link.Countries, country) {
+		return &Error{http.StatusUnavailableForLegalReasons, "this link isn't available in your country"}
+	}
+	return nil
+}
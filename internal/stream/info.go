@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"context"
+	"net/http"
+)
+
+// Info describes the file in req, with the description and tags it was
+// given in the file index.
+func (s *Service) Info(ctx context.Context, req *Request) (*types.FileInfo, error) {
+	file, err := s.source(req).File(ctx, req.ChannelID, req.MessageID)
+	if err != nil {
+		return nil, lookupError(err)
+	}
+	entry := fileEntry(req)
+	if !checkHash(req, fileHash(req, entry, file)) {
+		return nil, &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if err := checkLink(req); err != nil {
+		return nil, err
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return nil, err
+	}
+	if config.ValueOf.StrictMode && !sentByBot(entry, file) {
+		return nil, &Error{http.StatusNotFound, "file not found"}
+	}
+	info := &types.FileInfo{
+		Ok:        true,
+		FileName:  file.FileName,
+		FileSize:  file.FileSize,
+		MimeType:  file.MimeType,
+		Duration:  file.Duration,
+		Width:     file.Width,
+		Height:    file.Height,
+		Thumbnail: file.ThumbSize != "",
+	}
+	if entry != nil {
+		info.Description = entry.Description
+		info.Tags, _ = store.GetStore().GetTags(req.ChannelID, req.MessageID)
+		if entry.Duration > 0 {
+			info.Duration = entry.Duration
+		}
+		if entry.Width > 0 {
+			info.Width, info.Height = entry.Width, entry.Height
+		}
+		info.VideoCodec, info.AudioCodec = entry.VideoCodec, entry.AudioCodec
+		for _, track := range entry.SubtitleTracks() {
+			info.Subtitles = append(info.Subtitles, types.SubtitleTrack{Track: track.Index, Label: track.Label})
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+
+	range_parser "github.com/quantumsheep/range-parser"
+)
+
+func partHeader(mimeType string, r *range_parser.Range, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {mimeType},
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)},
+	}
+}
+
+// serveMultipart answers requests for several ranges of a file with a
+// multipart/byteranges response, as download managers like IDM and aria2
+// ask for them.
+func (s *Service) serveMultipart(ctx context.Context, req *Request, source Source, file *types.File, ranges []*range_parser.Range, w ResponseWriter) error {
+	var fetcher ChunkFetcher
+	return writeMultipart(req, file.FileName, file.MimeType, file.FileSize, source.WorkerID(), ranges, w, func(tracked *trackedStream, r *range_parser.Range) (io.ReadCloser, error) {
+		if fetcher == nil {
+			fetcher = cached(req, tracked.countFetched(s.fetcher(ctx, req, source)))
+		}
+		return NewTelegramReader(ctx, fetcher, source.ChunkSize(), file.Location, r.Start, r.End, r.End-r.Start+1, nil)
+	})
+}
+
+// writeMultipart writes the multipart/byteranges response for ranges of a
+// file of the given size, reading each range from the reader open returns
+// for it.
+func writeMultipart(req *Request, fileName string, mimeType string, size int64, workerID int, ranges []*range_parser.Range, w ResponseWriter, open func(tracked *trackedStream, r *range_parser.Range) (io.ReadCloser, error)) error {
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	// sized by writing the part headers and boundaries only
+	var counter countingWriter
+	sizer := multipart.NewWriter(&counter)
+	var contentLength int64
+	for _, r := range ranges {
+		sizer.CreatePart(partHeader(mimeType, r, size))
+		contentLength += r.End - r.Start + 1
+	}
+	sizer.Close()
+	contentLength += int64(counter)
+	if limit := int64(config.ValueOf.MaxResponseGB) << 30; limit > 0 && contentLength > limit {
+		return &Error{http.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("the requested ranges add up to more than %d GB", config.ValueOf.MaxResponseGB)}
+	}
+
+	disposition := "inline"
+	if req.Download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+sizer.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, fileName, req.ASCIIFileName))
+	w.WriteHeader(http.StatusPartialContent)
+	if req.Head {
+		return nil
+	}
+
+	tracked := active.add(ActiveStream{
+		ChannelID:  req.ChannelID,
+		MessageID:  req.MessageID,
+		FileName:   fileName,
+		WorkerID:   workerID,
+		Start:      ranges[0].Start,
+		End:        ranges[len(ranges)-1].End,
+		RemoteAddr: req.RemoteAddr,
+	})
+	defer active.remove(tracked.info.ID)
+	defer recordBytes(tracked)
+
+	body := multipart.NewWriter(tracked.track(w))
+	body.SetBoundary(sizer.Boundary())
+	buf, release := copyBuffer()
+	defer release()
+	for _, r := range ranges {
+		part, err := body.CreatePart(partHeader(mimeType, r, size))
+		if err != nil {
+			return err
+		}
+		reader, err := open(tracked, r)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyBuffer(part, reader, buf)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return body.Close()
+}
@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/audit"
+	"EverythingSuckz/fsb/internal/objects"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"EverythingSuckz/fsb/pkg/s3"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	range_parser "github.com/quantumsheep/range-parser"
+	"go.uber.org/zap"
+)
+
+// objectStoreWorker is the worker ID streams served from the object store are
+// accounted under.
+const objectStoreWorker = 0
+
+// serveObject streams a file from the object store, asking the store for the
+// range requested.
+func (s *Service) serveObject(ctx context.Context, req *Request, entry *store.FileEntry, w ResponseWriter) (err error) {
+	fullHash := req.LinkHash
+	if fullHash == "" {
+		fullHash = utils.SaltFile(utils.PackFile(entry.FileName, entry.FileSize, entry.MimeType, entry.FileID), entry.HashSalt)
+	}
+	expectedHash := boundHash(req, fullHash)
+	if !checkHash(req, expectedHash) {
+		return &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if err := checkLink(req); err != nil {
+		return err
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return err
+	}
+	if err := checkEmbed(req, w); err != nil {
+		return err
+	}
+	uploader, err := checkQuota(entry, w)
+	if err != nil {
+		return err
+	}
+	if err := claimOnce(req, expectedHash, w); err != nil {
+		return err
+	}
+	if req.Debug {
+		w.Header().Set(WorkerHeader, strconv.Itoa(objectStoreWorker))
+	}
+	if !req.Head {
+		pw, rejected := startStream(streamEvent(req, entry.FileName, entry.FileSize, entry.MimeType, objectStoreWorker), w)
+		if rejected != nil {
+			return rejected
+		}
+		w = pw
+		defer func() { pw.finish(err) }()
+		var release func()
+		w, release = throttled(ctx, req, entry, w)
+		defer release()
+		var flush func()
+		w, flush = metered(req, w, uploader)
+		defer flush()
+	}
+
+	store.GetStore().IncrStat("streams", 1)
+	if !req.Head {
+		audit.Downloaded(req.ChannelID, req.MessageID, req.RemoteAddr)
+		countHit(req)
+	}
+
+	method := http.MethodGet
+	if req.Head {
+		method = http.MethodHead
+	}
+	// the range is parsed here rather than by the store, so several ranges
+	// are answered like they are for files in Telegram
+	var byteRange string
+	start, end := int64(0), entry.FileSize-1
+	if req.Range != "" {
+		ranges, err := range_parser.Parse(entry.FileSize, strings.ReplaceAll(req.Range, " ", ""))
+		if err != nil {
+			return &Error{http.StatusBadRequest, err.Error()}
+		}
+		if len(ranges) > 1 {
+			return writeMultipart(req, entry.FileName, entry.MimeType, entry.FileSize, objectStoreWorker, ranges, w, func(_ *trackedStream, r *range_parser.Range) (io.ReadCloser, error) {
+				res, err := objects.Client().GetObject(ctx, http.MethodGet, entry.ObjectKey, fmt.Sprintf("bytes=%d-%d", r.Start, r.End))
+				if err != nil {
+					return nil, err
+				}
+				return res.Body, nil
+			})
+		}
+		start, end = ranges[0].Start, ranges[0].End
+		byteRange = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	if capped, ok := capResponse(req, w, start, end); ok {
+		byteRange = fmt.Sprintf("bytes=%d-%d", start, capped)
+	}
+	res, err := objects.Client().GetObject(ctx, method, entry.ObjectKey, byteRange)
+	if err != nil {
+		return objectError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	mimeType := entry.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	disposition := "inline"
+	if req.Download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", res.Header.Get("Content-Length"))
+	if contentRange := res.Header.Get("Content-Range"); contentRange != "" {
+		w.Header().Set("Content-Range", contentRange)
+	}
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, entry.FileName, req.ASCIIFileName))
+	w.WriteHeader(res.StatusCode)
+	if req.Head {
+		return nil
+	}
+
+	tracked := active.add(ActiveStream{
+		ChannelID:  req.ChannelID,
+		MessageID:  req.MessageID,
+		FileName:   entry.FileName,
+		WorkerID:   objectStoreWorker,
+		RemoteAddr: req.RemoteAddr,
+	})
+	defer active.remove(tracked.info.ID)
+	defer recordBytes(tracked)
+	_, err = io.Copy(tracked.track(w), res.Body)
+	return err
+}
+
+// objectError is the error of a request the object store couldn't answer.
+// The store's own error documents aren't passed on to clients, which would
+// take them for the file.
+func objectError(ctx context.Context, err error) *Error {
+	var statusErr *s3.StatusError
+	if !errors.As(err, &statusErr) {
+		utils.LoggerFrom(ctx).Warn("Failed to read from the object store", zap.Error(err))
+		return &Error{http.StatusBadGateway, "failed to read the file from the object store"}
+	}
+	switch statusErr.StatusCode {
+	case http.StatusNotFound:
+		return &Error{http.StatusNotFound, "file not found in the object store"}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return &Error{http.StatusRequestedRangeNotSatisfiable, "requested range not satisfiable"}
+	}
+	utils.LoggerFrom(ctx).Warn("Object store answered with an error", zap.Error(err))
+	return &Error{http.StatusBadGateway, "the object store answered " + statusErr.Status}
+}
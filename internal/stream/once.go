@@ -0,0 +1,66 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"net/http"
+)
+
+// OnceCookie is the prefix of the cookies holding the session tokens of
+// one-time links, followed by the link's ID.
+const OnceCookie = "fsb_once_"
+
+// OnceHeader is the header the session token of a one-time link is sent
+// back in, for clients that don't keep cookies.
+const OnceHeader = "X-FSB-Session"
+
+// claimOnce lets only one download session use a one-time link. The first
+// request claims the link and is given a session token signed with
+// linkHash, the full hash of the link, and later requests are only served
+// with that token, so clients resuming or seeking with range requests keep
+// working while fresh clients are turned away. HEAD requests, like the ones
+// of link previews, don't claim the link.
+func claimOnce(req *Request, linkHash string, w ResponseWriter) error {
+	if req.Once == "" {
+		return nil
+	}
+	link, err := linkRecord(req.Once)
+	if err != nil {
+		return err
+	}
+	if session, ok := utils.CheckOnceSession(linkHash, req.OnceSession); ok && session == link.Session {
+		return nil
+	}
+	if link.Session != "" {
+		return &Error{http.StatusGone, "this one-time link was already used"}
+	}
+	if req.Head {
+		return nil
+	}
+
+	session, err := utils.NewLinkID()
+	if err != nil {
+		return &Error{http.StatusInternalServerError, err.Error()}
+	}
+	claimed, err := store.GetStore().ClaimLink(req.Once, session)
+	if err != nil {
+		return &Error{http.StatusInternalServerError, err.Error()}
+	}
+	if claimed != session {
+		return &Error{http.StatusGone, "this one-time link was already used"}
+	}
+	token := utils.SignOnceSession(linkHash, session)
+	cookie := &http.Cookie{
+		Name:     OnceCookie + req.Once,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if link.ExpiresAt != nil {
+		cookie.Expires = *link.ExpiresAt
+	}
+	w.Header().Add("Set-Cookie", cookie.String())
+	w.Header().Set(OnceHeader, token)
+	return nil
+}
@@ -0,0 +1,23 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"net/http"
+)
+
+// checkPassword turns away requests for files their uploader protected with
+// /password that don't send the password. Files that aren't indexed can't
+// have one.
+func checkPassword(req *Request, entry *store.FileEntry) error {
+	if entry == nil || entry.PasswordHash == "" {
+		return nil
+	}
+	if req.Password == "" {
+		return &Error{http.StatusUnauthorized, "this file is password protected"}
+	}
+	if !utils.CheckPassword(entry.PasswordHash, req.Password) {
+		return &Error{http.StatusUnauthorized, "wrong password"}
+	}
+	return nil
+}
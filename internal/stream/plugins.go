@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/plugins"
+	"EverythingSuckz/fsb/pkg/plugin"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// streamEvent describes the stream of req to the plugins.
+func streamEvent(req *Request, fileName string, fileSize int64, mimeType string, worker int) plugin.StreamEvent {
+	return plugin.StreamEvent{
+		ChannelID:  req.ChannelID,
+		MessageID:  req.MessageID,
+		FileName:   fileName,
+		FileSize:   fileSize,
+		MimeType:   mimeType,
+		RemoteAddr: req.RemoteAddr,
+		Range:      req.Range,
+		Worker:     worker,
+	}
+}
+
+// allowStream asks the plugins whether the stream may start, rejecting it
+// with a 403 when a plugin denies it and a 503 when one can't be asked.
+func allowStream(event plugin.StreamEvent) error {
+	err := plugins.StreamStarted(event)
+	if errors.Is(err, plugins.ErrDenied) {
+		return &Error{http.StatusForbidden, err.Error()}
+	}
+	if err != nil {
+		return &Error{http.StatusServiceUnavailable, err.Error()}
+	}
+	return nil
+}
+
+// finishStream tells the plugins a stream that started at started ended
+// after sending sent bytes, with err if it failed.
+func finishStream(event plugin.StreamEvent, started time.Time, sent int64, err error) {
+	event.Sent = sent
+	event.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		event.Error = err.Error()
+	}
+	plugins.StreamFinished(event)
+}
+
+// pluginWriter counts the bytes of a stream the plugins allowed, to tell
+// them how much was sent when it ends.
+type pluginWriter struct {
+	ResponseWriter
+	event   plugin.StreamEvent
+	started time.Time
+	sent    int64
+}
+
+// startStream asks the plugins whether the stream may start, returning the
+// writer to write it to when it may.
+func startStream(event plugin.StreamEvent, w ResponseWriter) (*pluginWriter, error) {
+	if err := allowStream(event); err != nil {
+		return nil, err
+	}
+	return &pluginWriter{ResponseWriter: w, event: event, started: time.Now()}, nil
+}
+
+func (w *pluginWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.sent += int64(n)
+	return n, err
+}
+
+// finish tells the plugins the stream ended, with err if it failed.
+func (w *pluginWriter) finish(err error) {
+	finishStream(w.event, w.started, w.sent, err)
+}
@@ -0,0 +1,25 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"strings"
+)
+
+// interactiveSize is the size up to which documents are fetched with
+// bot.PriorityInteractive, as they're usually opened and looked at right
+// away.
+const interactiveSize = 16 << 20
+
+// streamPriority is the priority the chunks of a file are fetched with:
+// images and small documents first, then audio and video played in a
+// player, and downloads of anything larger last.
+func streamPriority(req *Request, file *types.File) bot.Priority {
+	switch {
+	case strings.HasPrefix(file.MimeType, "image/"), file.FileSize <= interactiveSize:
+		return bot.PriorityInteractive
+	case !req.Download && (strings.HasPrefix(file.MimeType, "video/") || strings.HasPrefix(file.MimeType, "audio/")):
+		return bot.PriorityPlayback
+	}
+	return bot.PriorityBulk
+}
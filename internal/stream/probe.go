@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/diskcache"
+	"EverythingSuckz/fsb/internal/objects"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// defaultFetchLatency is what a call to Telegram is taken to take for
+// workers that haven't fetched enough chunks to tell.
+const defaultFetchLatency = time.Second
+
+// Probe tells how soon the file in req could start streaming, from whether
+// a worker is ready to serve it and which caches hold its metadata and its
+// first chunk. Nothing is fetched from Telegram unless the file's metadata
+// isn't cached, which streaming it would have to look up anyway.
+func (s *Service) Probe(ctx context.Context, req *Request) (*types.ProbeInfo, error) {
+	source := s.source(req)
+	worker := bot.GetWorker(source.WorkerID())
+	probe := &types.ProbeInfo{Ok: true, Worker: source.WorkerID()}
+	probe.ReadyWorkers, probe.TotalWorkers = bot.CountReady()
+
+	var cooldown time.Duration
+	latency := defaultFetchLatency
+	if worker != nil {
+		probe.MetadataCached = utils.FileCached(worker.Client, req.ChannelID, req.MessageID)
+		cooldown = worker.CooldownLeft()
+		if stats := worker.Stats(); stats.Samples > 0 {
+			latency = time.Duration(stats.AvgLatencyMs * float64(time.Millisecond))
+		}
+		probe.Ready = worker.Available() && cooldown == 0
+	}
+	probe.CooldownMs = cooldown.Milliseconds()
+
+	file, err := source.File(ctx, req.ChannelID, req.MessageID)
+	if err != nil {
+		return nil, lookupError(err)
+	}
+	entry := fileEntry(req)
+	if !checkHash(req, fileHash(req, entry, file)) {
+		return nil, &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if config.ValueOf.StrictMode && !sentByBot(entry, file) {
+		return nil, &Error{http.StatusNotFound, "file not found"}
+	}
+	if err := checkLink(req); err != nil {
+		return nil, err
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return nil, err
+	}
+
+	if objects.Enabled() && !req.Preview.Enabled() && entry != nil && entry.ObjectKey != "" {
+		probe.ObjectStore = true
+	}
+	limit := utils.NewChunkPlanner(source.ChunkSize()).ChunkSize
+	probe.FirstChunkCached = sharedCached(req.ChannelID, req.MessageID, 0, limit) ||
+		(diskcache.Enabled() && diskcache.Has(req.ChannelID, req.MessageID, 0, limit))
+
+	var estimate time.Duration
+	if !probe.MetadataCached {
+		estimate += latency
+	}
+	switch {
+	case probe.ObjectStore, probe.FirstChunkCached:
+		// served without a worker fetching anything
+		probe.Ready = true
+	default:
+		estimate += cooldown + latency
+	}
+	probe.EstimatedStartMs = estimate.Milliseconds()
+	probe.Estimate = describeEstimate(estimate)
+	return probe, nil
+}
+
+// describeEstimate words how long until a file starts streaming for people,
+// rounded up to whole seconds.
+func describeEstimate(estimate time.Duration) string {
+	if estimate < 500*time.Millisecond {
+		return "ready now"
+	}
+	return fmt.Sprintf("ready in ~%ds", int(math.Ceil(estimate.Seconds())))
+}
@@ -0,0 +1,80 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/quota"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// quotaFlushBytes is how many bytes a response sends before they're counted
+// towards its uploader's quota, so long downloads count while they run.
+const quotaFlushBytes = 64 << 20
+
+// checkQuota turns away the request if the uploader of the indexed file
+// entry used up their daily QUOTA_MB or QUOTA_DOWNLOADS, returning the
+// uploader the response is counted for, or 0 for files that aren't indexed.
+func checkQuota(entry *store.FileEntry, w ResponseWriter) (int64, error) {
+	if entry == nil || entry.UploadedBy == 0 {
+		return 0, nil
+	}
+	wait, err := quota.Exceeded(entry.UploadedBy)
+	if err != nil {
+		// better to serve the file than to lock everyone out
+		utils.Logger.Warn("Failed to check quota", zap.Int64("userID", entry.UploadedBy), zap.Error(err))
+		return entry.UploadedBy, nil
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return 0, &Error{http.StatusTooManyRequests, "the uploader of this file used up their daily quota"}
+	}
+	return entry.UploadedBy, nil
+}
+
+// metered counts the response towards the daily quota of uploader: a
+// download, when it's from the start of the file like countHit, and the
+// bytes written to w. The returned func counts the bytes not counted yet
+// once the response is done.
+func metered(req *Request, w ResponseWriter, uploader int64) (ResponseWriter, func()) {
+	if uploader == 0 {
+		return w, func() {}
+	}
+	ranges := strings.ReplaceAll(req.Range, " ", "")
+	if ranges == "" || strings.HasPrefix(ranges, "bytes=0-") {
+		if err := quota.AddDownload(uploader); err != nil {
+			utils.Logger.Warn("Failed to count download", zap.Int64("userID", uploader), zap.Error(err))
+		}
+	}
+	mw := &meteredWriter{ResponseWriter: w, uploader: uploader}
+	return mw, mw.flush
+}
+
+type meteredWriter struct {
+	ResponseWriter
+	uploader int64
+	pending  int64
+}
+
+func (w *meteredWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if w.pending += int64(n); w.pending >= quotaFlushBytes {
+		w.flush()
+	}
+	return n, err
+}
+
+// flush counts the bytes written since the last flush.
+func (w *meteredWriter) flush() {
+	if w.pending == 0 {
+		return
+	}
+	if err := quota.AddBytes(w.uploader, w.pending); err != nil {
+		utils.Logger.Warn("Failed to count bytes", zap.Int64("userID", w.uploader), zap.Error(err))
+	}
+	w.pending = 0
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rangeLogIdle is how long a client has to stay quiet after its last range
+// request before its activity on a file is summarized.
+const rangeLogIdle = 30 * time.Second
+
+type rangeKey struct {
+	remoteAddr string
+	channelID  int64
+	messageID  int
+}
+
+type rangeSession struct {
+	requests  int
+	active    int
+	sent      int64
+	minStart  int64
+	maxEnd    int64
+	startedAt time.Time
+	lastAt    time.Time
+	timer     *time.Timer
+}
+
+// rangeLogger aggregates the many small range requests video players make
+// into one log entry per client and file, depending on RANGE_LOG:
+// "summary" logs the aggregate, "all" also logs every request and "off"
+// logs nothing.
+type rangeLogger struct {
+	log      *zap.Logger
+	mu       sync.Mutex
+	sessions map[rangeKey]*rangeSession
+}
+
+func newRangeLogger(log *zap.Logger) *rangeLogger {
+	return &rangeLogger{log: log, sessions: make(map[rangeKey]*rangeSession)}
+}
+
+// begin records the start of a range request and returns a function to call
+// with the number of bytes sent once it is done.
+func (l *rangeLogger) begin(req *Request, start, end, fileSize int64) func(sent int64) {
+	mode := config.ValueOf.RangeLog
+	if mode == "off" {
+		return func(int64) {}
+	}
+	if mode == "all" {
+		l.log.Info("Content-Range",
+			zap.String("remoteAddr", req.RemoteAddr),
+			zap.Int("messageID", req.MessageID),
+			zap.Int64("start", start),
+			zap.Int64("end", end),
+			zap.Int64("fileSize", fileSize))
+	}
+	key := rangeKey{req.RemoteAddr, req.ChannelID, req.MessageID}
+	l.mu.Lock()
+	session, ok := l.sessions[key]
+	if !ok {
+		session = &rangeSession{minStart: start, maxEnd: end, startedAt: time.Now()}
+		l.sessions[key] = session
+	}
+	if session.timer != nil {
+		session.timer.Stop()
+		session.timer = nil
+	}
+	session.requests++
+	session.active++
+	session.minStart = min(session.minStart, start)
+	session.maxEnd = max(session.maxEnd, end)
+	l.mu.Unlock()
+
+	return func(sent int64) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		session.active--
+		session.sent += sent
+		session.lastAt = time.Now()
+		if session.active == 0 {
+			session.timer = time.AfterFunc(rangeLogIdle, func() { l.flush(key, session) })
+		}
+	}
+}
+
+func (l *rangeLogger) flush(key rangeKey, session *rangeSession) {
+	l.mu.Lock()
+	if session.active > 0 || l.sessions[key] != session {
+		l.mu.Unlock()
+		return
+	}
+	delete(l.sessions, key)
+	l.mu.Unlock()
+	l.log.Info("Stream finished",
+		zap.String("remoteAddr", key.remoteAddr),
+		zap.Int64("channelID", key.channelID),
+		zap.Int("messageID", key.messageID),
+		zap.Int("requests", session.requests),
+		zap.Int64("sent", session.sent),
+		zap.Int64("minStart", session.minStart),
+		zap.Int64("maxEnd", session.maxEnd),
+		zap.Duration("duration", session.lastAt.Sub(session.startedAt)))
+}
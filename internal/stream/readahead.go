@@ -0,0 +1,76 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"sync"
+	"sync/atomic"
+)
+
+// copyBufferSize is the buffer responses are copied through while the
+// read-ahead budget allows, and smallCopyBufferSize the one they fall back
+// to once it doesn't.
+const (
+	copyBufferSize      = 1 << 20
+	smallCopyBufferSize = 32 << 10
+)
+
+// readAheadBudget keeps the memory held by the chunks streams read ahead
+// and by their copy buffers within READ_AHEAD_MB. Streams that don't get
+// their share read fewer chunks ahead, down to fetching each one only when
+// it's sent, and copy through a smaller buffer, so they get slower instead
+// of the bot running out of memory.
+type readAheadBudget struct {
+	mu   sync.Mutex
+	used int64
+	// degraded counts the chunks not read ahead and the buffers made
+	// smaller for lack of budget.
+	degraded atomic.Int64
+}
+
+var aheadBudget = &readAheadBudget{}
+
+// reserve takes n bytes of the budget, reporting false if there isn't that
+// much left.
+func (b *readAheadBudget) reserve(n int64) bool {
+	limit := int64(config.ValueOf.ReadAheadMB) << 20
+	if limit <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > limit {
+		b.degraded.Add(1)
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release gives back n bytes taken with reserve.
+func (b *readAheadBudget) release(n int64) {
+	if config.ValueOf.ReadAheadMB <= 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+}
+
+// copyBuffer returns the buffer a response is copied through and the func
+// giving its memory back to the budget once the response is done.
+func copyBuffer() ([]byte, func()) {
+	if !aheadBudget.reserve(copyBufferSize) {
+		return make([]byte, smallCopyBufferSize), func() {}
+	}
+	return make([]byte, copyBufferSize), func() { aheadBudget.release(copyBufferSize) }
+}
+
+// ReadAheadStats returns the bytes of the read-ahead budget in use, and how
+// often a stream read less ahead or copied through a smaller buffer because
+// it was used up.
+func ReadAheadStats() (used int64, degraded int64) {
+	aheadBudget.mu.Lock()
+	used = aheadBudget.used
+	aheadBudget.mu.Unlock()
+	return used, aheadBudget.degraded.Load()
+}
@@ -0,0 +1,187 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"io"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+type telegramReader struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	log           *zap.Logger
+	fetcher       ChunkFetcher
+	location      tg.InputFileLocationClass
+	start         int64
+	end           int64
+	next          func() ([]byte, error)
+	buffer        []byte
+	bytesread     int64
+	planner       *utils.ChunkPlanner
+	i             int64
+	contentLength int64
+	trace         *utils.StreamTrace
+	// ahead are the parts fetched while the previous one is written to the
+	// client, in order, so playback doesn't stall on every call to Telegram.
+	ahead []*readAhead
+}
+
+type readAhead struct {
+	part   int
+	result chan chunkResult
+	// reserved is the memory taken from the read-ahead budget for the part.
+	reserved int64
+}
+
+type chunkResult struct {
+	data []byte
+	err  error
+}
+
+func (r *telegramReader) Close() error {
+	r.cancel()
+	r.dropAhead()
+	return r.trace.Close()
+}
+
+// dropAhead waits for the parts being read ahead to be fetched and discards
+// them.
+func (r *telegramReader) dropAhead() {
+	for _, ahead := range r.ahead {
+		<-ahead.result
+		aheadBudget.release(ahead.reserved)
+	}
+	r.ahead = nil
+}
+
+func NewTelegramReader(
+	ctx context.Context,
+	fetcher ChunkFetcher,
+	chunkSize int64,
+	location tg.InputFileLocationClass,
+	start int64,
+	end int64,
+	contentLength int64,
+	trace *utils.StreamTrace,
+) (io.ReadCloser, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	r := &telegramReader{
+		ctx:           ctx,
+		cancel:        cancel,
+		log:           utils.Logger.Named("telegramReader"),
+		location:      location,
+		fetcher:       fetcher,
+		start:         start,
+		end:           end,
+		planner:       utils.NewChunkPlanner(chunkSize),
+		contentLength: contentLength,
+		trace:         trace,
+	}
+	r.log.Sugar().Debug("Start")
+	r.next = r.partStream()
+	return r, nil
+}
+
+func (r *telegramReader) Read(p []byte) (n int, err error) {
+
+	if r.bytesread == r.contentLength {
+		r.log.Sugar().Debug("EOF (bytesread == contentLength)")
+		return 0, io.EOF
+	}
+
+	if r.i >= int64(len(r.buffer)) {
+		r.buffer, err = r.next()
+		r.log.Debug("Next Buffer", zap.Int64("len", int64(len(r.buffer))))
+		if err != nil {
+			return 0, err
+		}
+		if len(r.buffer) == 0 {
+			r.next = r.partStream()
+			r.buffer, err = r.next()
+			if err != nil {
+				return 0, err
+			}
+
+		}
+		r.i = 0
+	}
+	n = copy(p, r.buffer[r.i:])
+	r.i += int64(n)
+	r.bytesread += int64(n)
+	return n, nil
+}
+
+func (r *telegramReader) chunk(offset int64, limit int64) ([]byte, error) {
+	data, err := r.fetcher.FetchChunk(r.ctx, r.location, offset, limit)
+	r.trace.Record(offset, int(limit), data, err)
+	return data, err
+}
+
+func (r *telegramReader) partStream() func() ([]byte, error) {
+	r.dropAhead()
+
+	plan := r.planner.Plan(r.start, r.end)
+	currentPart := 1
+
+	fetch := func(part int) ([]byte, error) {
+		res, err := r.chunk(plan.PartOffset(part), plan.ChunkSize)
+		if err != nil || len(res) == 0 {
+			return res, err
+		}
+		return plan.Trim(part, res), nil
+	}
+
+	readData := func() ([]byte, error) {
+		if currentPart > plan.Parts {
+			return make([]byte, 0), nil
+		}
+		var res []byte
+		var err error
+		if len(r.ahead) > 0 && r.ahead[0].part == currentPart {
+			result := <-r.ahead[0].result
+			aheadBudget.release(r.ahead[0].reserved)
+			r.ahead = r.ahead[1:]
+			res, err = result.data, result.err
+		} else {
+			res, err = fetch(currentPart)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(res) == 0 {
+			return res, nil
+		}
+
+		currentPart++
+		r.log.Sugar().Debugf("Part %d/%d", currentPart, plan.Parts)
+		r.readAhead(currentPart, plan.Parts, plan.ChunkSize, fetch)
+		return res, nil
+	}
+	return readData
+}
+
+// readAhead starts fetching the parts from part on in the background, up to
+// PARALLEL_CHUNKS of them at a time, so a client that stops reading costs at
+// most that many chunks. Fewer are read ahead while READ_AHEAD_MB is used
+// up.
+func (r *telegramReader) readAhead(part int, parts int, chunkSize int64, fetch func(part int) ([]byte, error)) {
+	if len(r.ahead) > 0 {
+		part = r.ahead[len(r.ahead)-1].part + 1
+	}
+	for ; len(r.ahead) < config.ValueOf.ParallelChunks && part <= parts; part++ {
+		if !aheadBudget.reserve(chunkSize) {
+			return
+		}
+		ahead := &readAhead{part: part, result: make(chan chunkResult, 1), reserved: chunkSize}
+		r.ahead = append(r.ahead, ahead)
+		go func(part int) {
+			data, err := fetch(part)
+			ahead.result <- chunkResult{data, err}
+		}(part)
+	}
+}
@@ -0,0 +1,37 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// linkRecord returns the link saved under id, for the links that are kept in
+// the store, turning away the ones that were revoked, removed or expired.
+func linkRecord(id string) (*store.Link, error) {
+	link, err := store.GetStore().GetLink(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, &Error{http.StatusGone, "this link no longer exists"}
+	}
+	if err != nil {
+		return nil, &Error{http.StatusInternalServerError, err.Error()}
+	}
+	if link.RevokedAt != nil {
+		return nil, &Error{http.StatusGone, "this link was revoked"}
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return nil, &Error{http.StatusGone, "this link has expired"}
+	}
+	return link, nil
+}
+
+// checkRevocable turns away revocable links created with /revocable once
+// they're revoked.
+func checkRevocable(req *Request) error {
+	if req.Revocable == "" {
+		return nil
+	}
+	_, err := linkRecord(req.Revocable)
+	return err
+}
@@ -0,0 +1,164 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/diskcache"
+	"EverythingSuckz/fsb/internal/evict"
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gotd/td/tg"
+)
+
+type sharedKey struct {
+	channelID int64
+	messageID int
+	offset    int64
+	limit     int64
+}
+
+type sharedChunk struct {
+	key  sharedKey
+	data []byte
+}
+
+// chunkCall is a chunk being fetched, which other viewers of the file wait
+// on instead of fetching it again.
+type chunkCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// sharedChunks keeps the chunks fetched most recently for any client, within
+// CHUNK_CACHE_MB, so the viewers of a popular file share their fetches from
+// Telegram. Unlike a client's window it outlives the client's requests.
+var sharedChunks = struct {
+	mu       sync.Mutex
+	once     sync.Once
+	lru      *list.List
+	elements map[sharedKey]*list.Element
+	inflight map[sharedKey]*chunkCall
+	size     int64
+
+	hits, misses atomic.Int64
+}{
+	lru:      list.New(),
+	elements: make(map[sharedKey]*list.Element),
+	inflight: make(map[sharedKey]*chunkCall),
+}
+
+// ChunkCacheStats returns how many chunks were served from the shared chunk
+// cache and how many had to be fetched since startup.
+func ChunkCacheStats() (hits int64, misses int64) {
+	return sharedChunks.hits.Load(), sharedChunks.misses.Load()
+}
+
+// sharedCached reports whether the shared chunk cache holds the chunk of a
+// file at offset.
+func sharedCached(channelID int64, messageID int, offset int64, limit int64) bool {
+	sharedChunks.mu.Lock()
+	defer sharedChunks.mu.Unlock()
+	_, ok := sharedChunks.elements[sharedKey{channelID, messageID, offset, limit}]
+	return ok
+}
+
+func sharedCacheEnabled() bool {
+	sharedChunks.once.Do(func() {
+		evict.Subscribe(func(event evict.Event) {
+			sharedChunks.mu.Lock()
+			defer sharedChunks.mu.Unlock()
+			for key, element := range sharedChunks.elements {
+				if key.channelID == event.ChannelID && key.messageID == event.MessageID {
+					removeShared(element)
+				}
+			}
+		})
+	})
+	return config.ValueOf.ChunkCacheMB > 0
+}
+
+// cached wraps fetcher with the caches that are enabled, the shared chunk
+// cache in front of the disk cache.
+func cached(req *Request, fetcher ChunkFetcher) ChunkFetcher {
+	if diskcache.Enabled() {
+		fetcher = &diskFetcher{channelID: req.ChannelID, messageID: req.MessageID, fetcher: fetcher}
+	}
+	if sharedCacheEnabled() {
+		fetcher = &sharedFetcher{channelID: req.ChannelID, messageID: req.MessageID, fetcher: fetcher}
+	}
+	return fetcher
+}
+
+// sharedFetcher serves chunks from the shared chunk cache before falling
+// back to fetcher. A chunk another viewer is fetching is waited on.
+type sharedFetcher struct {
+	channelID int64
+	messageID int
+	fetcher   ChunkFetcher
+}
+
+func (f *sharedFetcher) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	key := sharedKey{f.channelID, f.messageID, offset, limit}
+	for {
+		sharedChunks.mu.Lock()
+		if element, ok := sharedChunks.elements[key]; ok {
+			sharedChunks.lru.MoveToFront(element)
+			sharedChunks.mu.Unlock()
+			sharedChunks.hits.Add(1)
+			return element.Value.(*sharedChunk).data, nil
+		}
+		if c, ok := sharedChunks.inflight[key]; ok {
+			sharedChunks.mu.Unlock()
+			select {
+			case <-c.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if c.err == nil {
+				sharedChunks.hits.Add(1)
+				return c.data, nil
+			}
+			// the fetch failed for the viewer who made it, which may
+			// just have gone away, so try again
+			continue
+		}
+		c := &chunkCall{done: make(chan struct{})}
+		sharedChunks.inflight[key] = c
+		sharedChunks.mu.Unlock()
+		sharedChunks.misses.Add(1)
+
+		c.data, c.err = f.fetcher.FetchChunk(ctx, location, offset, limit)
+		sharedChunks.mu.Lock()
+		delete(sharedChunks.inflight, key)
+		if c.err == nil {
+			keepShared(key, c.data)
+		}
+		sharedChunks.mu.Unlock()
+		close(c.done)
+		return c.data, c.err
+	}
+}
+
+// keepShared caches a chunk, dropping the least recently used ones to stay
+// within CHUNK_CACHE_MB. sharedChunks.mu must be held.
+func keepShared(key sharedKey, data []byte) {
+	limit := int64(config.ValueOf.ChunkCacheMB) << 20
+	if int64(len(data)) > limit {
+		return
+	}
+	sharedChunks.elements[key] = sharedChunks.lru.PushFront(&sharedChunk{key: key, data: data})
+	sharedChunks.size += int64(len(data))
+	for sharedChunks.size > limit {
+		removeShared(sharedChunks.lru.Back())
+	}
+}
+
+// removeShared drops a cached chunk. sharedChunks.mu must be held.
+func removeShared(element *list.Element) {
+	chunk := sharedChunks.lru.Remove(element).(*sharedChunk)
+	delete(sharedChunks.elements, chunk.key)
+	sharedChunks.size -= int64(len(chunk.data))
+}
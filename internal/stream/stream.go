@@ -0,0 +1,529 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/analytics"
+	"EverythingSuckz/fsb/internal/audit"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/evict"
+	"EverythingSuckz/fsb/internal/objects"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+	range_parser "github.com/quantumsheep/range-parser"
+	"go.uber.org/zap"
+)
+
+// MetadataLookup resolves the file stored in a channel message.
+type MetadataLookup interface {
+	File(ctx context.Context, channelID int64, messageID int) (*types.File, error)
+}
+
+// ChunkFetcher reads a single chunk of a file from Telegram.
+type ChunkFetcher interface {
+	FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error)
+}
+
+// Source is a Telegram client that files can be streamed through.
+type Source interface {
+	MetadataLookup
+	ChunkFetcher
+	WorkerID() int
+	// ChunkSize is the size of the chunks to fetch through the source.
+	ChunkSize() int64
+}
+
+// ResponseWriter is the part of http.ResponseWriter the service writes to.
+type ResponseWriter interface {
+	Header() http.Header
+	WriteHeader(statusCode int)
+	Write(p []byte) (int, error)
+}
+
+type Request struct {
+	ChannelID int64
+	MessageID int
+	Hash      string
+	Range     string
+	Download  bool
+	Head      bool
+	// EmbedOrigin is set for links created with /embed, RequestOrigin is
+	// the origin the request was made from.
+	EmbedOrigin   string
+	RequestOrigin string
+	RemoteAddr    string
+	// BoundIP is the CIDR range links created with /bind only work from.
+	BoundIP string
+	// Preview is how much of the file links created with /preview serve.
+	Preview utils.Preview
+	// Once is the ID of a one-time link created with /once, and
+	// OnceSession the session token the client got when it claimed it.
+	Once        string
+	OnceSession string
+	// Expires is when links created with /expire stop working, zero for
+	// links that don't expire.
+	Expires time.Time
+	// Geo is the ID of a link created with /geo, which only works in some
+	// countries. Country is the country the request comes from when a CDN
+	// told, and is looked up from RemoteAddr otherwise.
+	Geo     string
+	Country string
+	// Revocable is the ID of a link created with /revocable, which stops
+	// working once it's revoked.
+	Revocable string
+	// Password is the password sent for a file protected with /password.
+	Password string
+	// Worker is the ID of the worker the request is forced onto, to
+	// reproduce issues of a single worker. The request doesn't fail over to
+	// other workers or spread its chunks over them. 0 lets any worker serve
+	// it.
+	Worker int
+	// LinkHash is the full hash of the file the link was made for, set by
+	// FollowAlias when that file was deleted as a duplicate of this one.
+	LinkHash string
+	// Strip removes metadata from images, and PhotoSize picks one of the
+	// sizes a photo is available in.
+	Strip     bool
+	PhotoSize string
+	// Decompress serves gzipped documents decompressed.
+	Decompress bool
+	// ASCIIFileName transliterates the file name in Content-Disposition for
+	// clients that mangle UTF-8 names, keeping the original in filename*.
+	ASCIIFileName bool
+	// URL is the request URI, used to build continuation links.
+	URL string
+	// Debug reports which worker and caches serve the response in the
+	// X-FSB-Worker, X-FSB-Cache and X-FSB-Chunk-Size headers.
+	Debug bool
+}
+
+// Error is returned when a request is rejected before anything was written
+// to the response.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// lookupError is the error of a request whose file couldn't be looked up.
+// Paid media and protected messages get statuses of their own, so clients
+// can tell them apart from links that are wrong.
+func lookupError(err error) *Error {
+	switch {
+	case errors.Is(err, utils.ErrPaidMedia):
+		return &Error{http.StatusPaymentRequired, err.Error()}
+	case errors.Is(err, utils.ErrProtectedContent):
+		return &Error{http.StatusForbidden, err.Error()}
+	}
+	return &Error{http.StatusBadRequest, err.Error()}
+}
+
+type Service struct {
+	log     *zap.Logger
+	pick    func(key string) Source
+	force   func(id int) Source
+	ranges  *rangeLogger
+	windows *windows
+}
+
+// NewService returns a streaming service that serves every request through
+// the source returned by pick. Requests with the same key should get the same
+// source while it is healthy, an empty key can get any source. Requests
+// forced onto a worker are served through the source force returns for its
+// ID.
+func NewService(log *zap.Logger, pick func(key string) Source, force func(id int) Source) *Service {
+	s := &Service{log: log, pick: pick, force: force, ranges: newRangeLogger(log), windows: newWindows()}
+	evict.Subscribe(s.windows.evict)
+	return s
+}
+
+// source returns the source to serve req through. All the requests a client
+// makes for a file go through the same source, so the ranges a player asks
+// for hit the caches of a single client on Telegram's side.
+func (s *Service) source(req *Request) Source {
+	if req.Worker != 0 {
+		return s.force(req.Worker)
+	}
+	if !config.ValueOf.PinStreams {
+		return s.pick("")
+	}
+	return s.pick(fmt.Sprintf("%s/%d/%d", req.RemoteAddr, req.ChannelID, req.MessageID))
+}
+
+// fileEntry returns the index entry of the file in req, or nil for files
+// that aren't indexed. It's looked up once per request and passed to the
+// checks that read it.
+func fileEntry(req *Request) *store.FileEntry {
+	entry, err := store.GetStore().GetFile(req.ChannelID, req.MessageID)
+	if err != nil {
+		return nil
+	}
+	return entry
+}
+
+// sentByBot reports whether the message was posted by the bot. Channels only
+// expose the author when they sign messages, otherwise the message has to be
+// one the bot recorded in the file index when forwarding it.
+func sentByBot(entry *store.FileEntry, file *types.File) bool {
+	if file.AuthorID != 0 {
+		return bot.Bot != nil && file.AuthorID == bot.Bot.Self.ID
+	}
+	return entry != nil
+}
+
+// checkHash reports whether the hash of req matches expected, counting the
+// miss against the client when it doesn't.
+func checkHash(req *Request, expected string) bool {
+	if utils.CheckHash(req.Hash, expected) {
+		return true
+	}
+	utils.HashFailed(req.RemoteAddr, req.MessageID)
+	return false
+}
+
+// fileHash returns the full hash that links to the file must match, taking
+// rotated and bound links into account.
+func fileHash(req *Request, entry *store.FileEntry, file *types.File) string {
+	if req.LinkHash != "" {
+		return boundHash(req, req.LinkHash)
+	}
+	fullHash := utils.PackFile(file.FileName, file.FileSize, file.MimeType, file.ID)
+	if entry != nil {
+		fullHash = utils.SaltFile(fullHash, entry.HashSalt)
+	}
+	return boundHash(req, fullHash)
+}
+
+// maxAliasHops is how many aliases FollowAlias follows, for files that were
+// moved again after their links were first remapped.
+const maxAliasHops = 8
+
+// FollowAlias points req at the copy kept when the file it asks for was
+// deleted as a duplicate, or at where it was moved to, so the links to it
+// keep working.
+func FollowAlias(req *Request) {
+	for i := 0; i < maxAliasHops; i++ {
+		alias, err := store.GetStore().GetAlias(req.ChannelID, req.MessageID)
+		if err != nil {
+			return
+		}
+		req.ChannelID, req.MessageID = alias.TargetChannelID, alias.TargetMessageID
+		if req.LinkHash == "" {
+			req.LinkHash = alias.Hash
+		}
+	}
+}
+
+// boundHash derives the hash of links locked to an embedding origin, bound
+// to an IP range, limited to a preview, usable once, expiring, geo-fenced or
+// revocable from fullHash.
+func boundHash(req *Request, fullHash string) string {
+	if req.EmbedOrigin != "" {
+		fullHash = utils.EmbedHash(fullHash, req.EmbedOrigin)
+	}
+	if req.BoundIP != "" {
+		fullHash = utils.BindHash(fullHash, req.BoundIP)
+	}
+	if req.Preview.Enabled() {
+		fullHash = utils.PreviewHash(fullHash, req.Preview)
+	}
+	if req.Once != "" {
+		fullHash = utils.OnceHash(fullHash, req.Once)
+	}
+	if !req.Expires.IsZero() {
+		fullHash = utils.ExpiryHash(fullHash, req.Expires)
+	}
+	if req.Geo != "" {
+		fullHash = utils.GeoHash(fullHash, req.Geo)
+	}
+	if req.Revocable != "" {
+		fullHash = utils.RevocableHash(fullHash, req.Revocable)
+	}
+	return fullHash
+}
+
+// previewed cuts file down to the part a preview link serves, so ranges,
+// Content-Length and Content-Range all stay within it.
+func previewed(req *Request, entry *store.FileEntry, file *types.File) (*types.File, error) {
+	if !req.Preview.Enabled() {
+		return file, nil
+	}
+	length := file.Duration
+	if entry != nil && entry.Duration > 0 {
+		length = entry.Duration
+	}
+	limit, err := req.Preview.Limit(file.FileSize, length)
+	if err != nil {
+		return nil, &Error{http.StatusUnprocessableEntity, err.Error()}
+	}
+	cut := *file
+	cut.FileSize = limit
+	return &cut, nil
+}
+
+// checkExpiry turns away links created with /expire once they expired. It
+// runs after the hash check, so the expiry it reads is the one the link was
+// signed with.
+func checkExpiry(req *Request) error {
+	if !req.Expires.IsZero() && time.Now().After(req.Expires) {
+		return &Error{http.StatusGone, "this link has expired"}
+	}
+	return nil
+}
+
+// checkLink turns away links that are expired, revoked or don't work where
+// the request comes from. Every entry point runs it right after the hash
+// check, so the values it reads are the ones the link was signed with.
+func checkLink(req *Request) error {
+	if err := checkExpiry(req); err != nil {
+		return err
+	}
+	if err := checkGeo(req); err != nil {
+		return err
+	}
+	return checkRevocable(req)
+}
+
+// countHit counts a download for the analytics of the file's uploader.
+// Later range requests of a download only seek or resume it, so only the
+// ones from the start of the file count.
+func countHit(req *Request) {
+	ranges := strings.ReplaceAll(req.Range, " ", "")
+	if ranges == "" || strings.HasPrefix(ranges, "bytes=0-") {
+		analytics.Hit(req.ChannelID, req.MessageID, req.RemoteAddr, req.Country)
+	}
+}
+
+// checkEmbed turns away links created with /embed when they're used from
+// another origin, and restricts where the response may be framed. It runs
+// after the hash check, so the origin it reads is the one the link was made
+// for.
+func checkEmbed(req *Request, w ResponseWriter) error {
+	if req.EmbedOrigin == "" {
+		return nil
+	}
+	if req.RequestOrigin != req.EmbedOrigin {
+		return &Error{http.StatusForbidden, "this link can only be embedded on " + req.EmbedOrigin}
+	}
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+req.EmbedOrigin)
+	return nil
+}
+
+func (s *Service) Serve(ctx context.Context, req *Request, w ResponseWriter) (err error) {
+	// previews are cut from the file in Telegram, and decompressed as it's
+	// read from there
+	entry := fileEntry(req)
+	if objects.Enabled() && !req.Preview.Enabled() && !req.Decompress && entry != nil && entry.ObjectKey != "" {
+		return s.serveObject(ctx, req, entry, w)
+	}
+
+	source := s.source(req)
+	log := utils.LoggerFrom(ctx).With(zap.Int("worker", source.WorkerID()))
+
+	file, err := source.File(ctx, req.ChannelID, req.MessageID)
+	if err != nil {
+		return lookupError(err)
+	}
+
+	linkHash := fileHash(req, entry, file)
+	if !checkHash(req, linkHash) {
+		return &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if err := checkLink(req); err != nil {
+		return err
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return err
+	}
+
+	if config.ValueOf.StrictMode && !sentByBot(entry, file) {
+		return &Error{http.StatusNotFound, "file not found"}
+	}
+
+	if err := checkEmbed(req, w); err != nil {
+		return err
+	}
+	uploader, err := checkQuota(entry, w)
+	if err != nil {
+		return err
+	}
+	if err := claimOnce(req, linkHash, w); err != nil {
+		return err
+	}
+	ctx = bot.WithPriority(ctx, streamPriority(req, file))
+	if req.Debug {
+		w.Header().Set(WorkerHeader, strconv.Itoa(source.WorkerID()))
+	}
+
+	if !req.Head {
+		pw, rejected := startStream(streamEvent(req, file.FileName, file.FileSize, file.MimeType, source.WorkerID()), w)
+		if rejected != nil {
+			return rejected
+		}
+		w = pw
+		defer func() { pw.finish(err) }()
+		var release func()
+		w, release = throttled(ctx, req, entry, w)
+		defer release()
+		var flush func()
+		w, flush = metered(req, w, uploader)
+		defer flush()
+	}
+
+	store.GetStore().IncrStat("streams", 1)
+	if !req.Head {
+		audit.Downloaded(req.ChannelID, req.MessageID, req.RemoteAddr)
+		countHit(req)
+	}
+
+	// for photo messages
+	if file.FileSize == 0 {
+		location := file.Location
+		if req.PhotoSize != "" {
+			photo, ok := file.Location.(*tg.InputPhotoFileLocation)
+			if !ok || !utils.Contains(file.PhotoSizes, req.PhotoSize) {
+				return &Error{http.StatusBadRequest, fmt.Sprintf("unknown photo size, available sizes: %s", strings.Join(file.PhotoSizes, ", "))}
+			}
+			resized := *photo
+			resized.ThumbSize = req.PhotoSize
+			location = &resized
+		}
+		fileBytes, err := source.FetchChunk(ctx, location, 0, utils.MaxChunkSize)
+		if err != nil {
+			return &Error{http.StatusInternalServerError, err.Error()}
+		}
+		mimeType := file.MimeType
+		if req.Strip {
+			fileBytes, mimeType, err = utils.StripMetadata(mimeType, fileBytes)
+			if err != nil {
+				return &Error{http.StatusUnsupportedMediaType, err.Error()}
+			}
+		}
+		w.Header().Set("Content-Disposition", utils.ContentDisposition("inline", file.FileName, req.ASCIIFileName))
+		if !req.Head {
+			w.Header().Set("Content-Type", mimeType)
+			w.WriteHeader(http.StatusOK)
+			_, err = w.Write(fileBytes)
+		}
+		return err
+	}
+
+	if req.Strip && strings.HasPrefix(file.MimeType, "image/") {
+		return s.serveStripped(ctx, req, source, file, w)
+	}
+	if req.Decompress {
+		return s.serveDecompressed(ctx, req, source, file, w)
+	}
+
+	file, err = previewed(req, entry, file)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	var start, end int64
+	status := http.StatusOK
+
+	if req.Range == "" {
+		start = 0
+		end = file.FileSize - 1
+	} else {
+		// clients may put spaces between the ranges
+		ranges, err := range_parser.Parse(file.FileSize, strings.ReplaceAll(req.Range, " ", ""))
+		if err != nil {
+			return &Error{http.StatusBadRequest, err.Error()}
+		}
+		if len(ranges) > 1 {
+			return s.serveMultipart(ctx, req, source, file, ranges, w)
+		}
+		start = ranges[0].Start
+		end = ranges[0].End
+		status = http.StatusPartialContent
+	}
+	if capped, ok := capResponse(req, w, start, end); ok {
+		end = capped
+		status = http.StatusPartialContent
+	}
+	if status == http.StatusPartialContent {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, file.FileSize))
+	}
+
+	contentLength := end - start + 1
+	mimeType := file.MimeType
+
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.FormatInt(contentLength, 10))
+
+	disposition := "inline"
+
+	if req.Download {
+		disposition = "attachment"
+	}
+
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, file.FileName, req.ASCIIFileName))
+	if req.Debug {
+		s.debugHeaders(req, source, start, end, w)
+	}
+	w.WriteHeader(status)
+
+	if req.Head {
+		return nil
+	}
+
+	var trace *utils.StreamTrace
+	if config.ValueOf.StreamTrace {
+		trace, err = utils.NewStreamTrace(utils.TraceHeader{
+			ChannelID: req.ChannelID,
+			MessageID: req.MessageID,
+			FileSize:  file.FileSize,
+			Start:     start,
+			End:       end,
+			Worker:    source.WorkerID(),
+		})
+		if err != nil {
+			log.Warn("Failed to create stream trace", zap.Error(err))
+		}
+	}
+	tracked := active.add(ActiveStream{
+		ChannelID:  req.ChannelID,
+		MessageID:  req.MessageID,
+		FileName:   file.FileName,
+		WorkerID:   source.WorkerID(),
+		Start:      start,
+		End:        end,
+		RemoteAddr: req.RemoteAddr,
+	})
+	defer active.remove(tracked.info.ID)
+	done := s.ranges.begin(req, start, end, file.FileSize)
+	defer func() { done(tracked.sent.Load()) }()
+	defer recordBytes(tracked)
+	fetcher := cached(req, tracked.countFetched(s.fetcher(ctx, req, source)))
+	if window := s.windows.get(req); window != nil {
+		fetcher = &windowFetcher{window: window, fetcher: fetcher}
+	}
+	lr, _ := NewTelegramReader(ctx, fetcher, source.ChunkSize(), file.Location, start, end, contentLength, trace)
+	defer lr.Close()
+	// Use a larger buffer (1MB instead of default 32KB) for faster streaming,
+	// while READ_AHEAD_MB allows
+	buf, release := copyBuffer()
+	defer release()
+	_, err = io.CopyBuffer(tracked.track(w), lr, buf)
+	return err
+}
@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxStripSize caps the images that are loaded into memory to strip their
+// metadata.
+const maxStripSize = 20 << 20
+
+// serveStripped serves an image document with its metadata removed. The
+// whole image has to be read to do so, so range requests aren't supported.
+func (s *Service) serveStripped(ctx context.Context, req *Request, source Source, file *types.File, w ResponseWriter) error {
+	if file.FileSize > maxStripSize {
+		return &Error{http.StatusRequestEntityTooLarge, "image is too large to strip its metadata"}
+	}
+	reader, _ := NewTelegramReader(ctx, source, source.ChunkSize(), file.Location, 0, file.FileSize-1, file.FileSize, nil)
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return &Error{http.StatusBadGateway, err.Error()}
+	}
+	data, mimeType, err := utils.StripMetadata(file.MimeType, data)
+	if err != nil {
+		return &Error{http.StatusUnsupportedMediaType, err.Error()}
+	}
+	disposition := "inline"
+	if req.Download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Disposition", utils.ContentDisposition(disposition, file.FileName, req.ASCIIFileName))
+	w.WriteHeader(http.StatusOK)
+	if req.Head {
+		return nil
+	}
+	_, err = w.Write(data)
+	return err
+}
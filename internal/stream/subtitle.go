@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"net/http"
+)
+
+// maxSubtitleSize caps the subtitle files that are converted in memory.
+const maxSubtitleSize = 5 << 20
+
+// Subtitle returns the subtitle paired with the video in req, converted to
+// WebVTT.
+func (s *Service) Subtitle(ctx context.Context, req *Request) ([]byte, error) {
+	source := s.source(req)
+
+	video, err := source.File(ctx, req.ChannelID, req.MessageID)
+	if err != nil {
+		return nil, lookupError(err)
+	}
+	entry := fileEntry(req)
+	if !checkHash(req, fileHash(req, entry, video)) {
+		return nil, &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if err := checkLink(req); err != nil {
+		return nil, err
+	}
+	if config.ValueOf.StrictMode && !sentByBot(entry, video) {
+		return nil, &Error{http.StatusNotFound, "file not found"}
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return nil, err
+	}
+
+	pair, err := store.GetStore().GetSubtitle(req.ChannelID, req.MessageID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, &Error{http.StatusNotFound, "no subtitle for this file"}
+	}
+	if err != nil {
+		return nil, &Error{http.StatusInternalServerError, err.Error()}
+	}
+	subtitle, err := source.File(ctx, pair.SubtitleChannelID, pair.SubtitleMessageID)
+	if err != nil {
+		return nil, &Error{http.StatusBadGateway, err.Error()}
+	}
+	if subtitle.FileSize > maxSubtitleSize {
+		return nil, &Error{http.StatusRequestEntityTooLarge, "subtitle is too large"}
+	}
+
+	data := make([]byte, 0, subtitle.FileSize)
+	for offset := int64(0); offset < subtitle.FileSize; offset += utils.MaxChunkSize {
+		chunk, err := source.FetchChunk(ctx, subtitle.Location, offset, utils.MaxChunkSize)
+		if err != nil {
+			return nil, &Error{http.StatusBadGateway, err.Error()}
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		data = append(data, chunk...)
+	}
+	vtt, err := utils.ToWebVTT(subtitle.FileName, data)
+	if err != nil {
+		return nil, &Error{http.StatusUnsupportedMediaType, err.Error()}
+	}
+	return vtt, nil
+}
@@ -0,0 +1,67 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/throttle"
+	"context"
+)
+
+// throttled caps how fast w is written to at the rate the file's links are
+// served at, set with LINK_RATE_LIMIT_KB or /throttle, and within
+// EGRESS_RATE_KB. The returned func releases the file's bucket once the
+// response is done.
+func throttled(ctx context.Context, req *Request, entry *store.FileEntry, w ResponseWriter) (ResponseWriter, func()) {
+	var fileRate int64
+	if entry != nil {
+		fileRate = entry.RateLimit
+	}
+	rate := throttle.Rate(fileRate)
+	if rate <= 0 {
+		return egressLimited(ctx, w), func() {}
+	}
+	bucket := throttle.Acquire(req.ChannelID, req.MessageID, rate)
+	return &throttledWriter{ResponseWriter: w, ctx: ctx, bucket: bucket}, bucket.Release
+}
+
+// egressLimited keeps writing to w within EGRESS_RATE_KB, for files whose
+// links aren't throttled.
+func egressLimited(ctx context.Context, w ResponseWriter) ResponseWriter {
+	if !throttle.EgressLimited() {
+		return w
+	}
+	return &throttledWriter{ResponseWriter: w, ctx: ctx}
+}
+
+type throttledWriter struct {
+	ResponseWriter
+	ctx context.Context
+	// bucket is nil for responses only limited by EGRESS_RATE_KB.
+	bucket *throttle.Bucket
+}
+
+// Write waits for the file's bucket and for EGRESS_RATE_KB before each part
+// of p they let through at once.
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n := len(p) - written
+		if throttle.EgressLimited() {
+			n = min(n, throttle.EgressQuantum)
+		}
+		var err error
+		if w.bucket != nil {
+			if n, err = w.bucket.Take(w.ctx, n); err != nil {
+				return written, err
+			}
+		}
+		if err := throttle.WaitEgress(w.ctx, n); err != nil {
+			return written, err
+		}
+		n, err = w.ResponseWriter.Write(p[written : written+n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
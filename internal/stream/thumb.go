@@ -0,0 +1,54 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"net/http"
+
+	"github.com/gotd/td/tg"
+)
+
+// Thumb returns the thumbnail Telegram made of the file in req, a JPEG.
+// Videos and documents have one when they were sent with it, photos always
+// do.
+func (s *Service) Thumb(ctx context.Context, req *Request) ([]byte, error) {
+	source := s.source(req)
+	file, err := source.File(ctx, req.ChannelID, req.MessageID)
+	if err != nil {
+		return nil, lookupError(err)
+	}
+	entry := fileEntry(req)
+	if !checkHash(req, fileHash(req, entry, file)) {
+		return nil, &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if err := checkLink(req); err != nil {
+		return nil, err
+	}
+	if config.ValueOf.StrictMode && !sentByBot(entry, file) {
+		return nil, &Error{http.StatusNotFound, "file not found"}
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return nil, err
+	}
+	var location tg.InputFileLocationClass
+	switch original := file.Location.(type) {
+	case *tg.InputDocumentFileLocation:
+		thumb := *original
+		thumb.ThumbSize = file.ThumbSize
+		location = &thumb
+	case *tg.InputPhotoFileLocation:
+		thumb := *original
+		thumb.ThumbSize = file.ThumbSize
+		location = &thumb
+	}
+	if location == nil || file.ThumbSize == "" {
+		return nil, &Error{http.StatusNotFound, "this file has no thumbnail"}
+	}
+	// thumbnails are a few KB, far below a chunk
+	thumb, err := source.FetchChunk(ctx, location, 0, utils.MaxChunkSize)
+	if err != nil {
+		return nil, &Error{http.StatusBadGateway, err.Error()}
+	}
+	return thumb, nil
+}
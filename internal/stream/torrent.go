@@ -0,0 +1,241 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/evict"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+const (
+	// minPieceLength and maxPieceLength bound the pieces of torrents, which
+	// are made as small as they can be without a torrent having more than
+	// maxPieces of them.
+	minPieceLength = 256 << 10
+	maxPieceLength = 16 << 20
+	maxPieces      = 2000
+	// hashTimeout caps how long the pieces of a file may take to hash.
+	hashTimeout = 30 * time.Minute
+)
+
+type fileKey struct {
+	channelID int64
+	messageID int
+}
+
+// pieceHashes are the SHA-1 hashes of a file's pieces, which make up the
+// info dictionary of its torrent along with its name and size.
+type pieceHashes struct {
+	length int64
+	hashes []byte
+}
+
+// hashCall is a file being hashed, which requests for the same file's
+// torrent wait on instead of reading the file again.
+type hashCall struct {
+	done   chan struct{}
+	pieces *pieceHashes
+	err    error
+}
+
+var torrents = struct {
+	mu       sync.Mutex
+	once     sync.Once
+	pieces   map[fileKey]*pieceHashes
+	inflight map[fileKey]*hashCall
+}{
+	pieces:   make(map[fileKey]*pieceHashes),
+	inflight: make(map[fileKey]*hashCall),
+}
+
+// Torrent returns a .torrent for the file in req and the file's name, with
+// webSeed as the web seed the torrent's clients fetch the file from when
+// there are no peers. The file is read once to hash its pieces, which are
+// kept in memory for later requests.
+func (s *Service) Torrent(ctx context.Context, req *Request, webSeed string) ([]byte, string, error) {
+	torrents.once.Do(func() {
+		evict.Subscribe(func(event evict.Event) {
+			torrents.mu.Lock()
+			defer torrents.mu.Unlock()
+			delete(torrents.pieces, fileKey{event.ChannelID, event.MessageID})
+		})
+	})
+	source := s.source(req)
+	file, err := source.File(ctx, req.ChannelID, req.MessageID)
+	if err != nil {
+		return nil, "", lookupError(err)
+	}
+	entry := fileEntry(req)
+	if !checkHash(req, fileHash(req, entry, file)) {
+		return nil, "", &Error{http.StatusBadRequest, "invalid hash"}
+	}
+	if err := checkLink(req); err != nil {
+		return nil, "", err
+	}
+	if config.ValueOf.StrictMode && !sentByBot(entry, file) {
+		return nil, "", &Error{http.StatusNotFound, "file not found"}
+	}
+	if err := checkPassword(req, entry); err != nil {
+		return nil, "", err
+	}
+	if file.FileSize <= 0 {
+		return nil, "", &Error{http.StatusUnprocessableEntity, "the file is empty"}
+	}
+
+	key := fileKey{req.ChannelID, req.MessageID}
+	torrents.mu.Lock()
+	pieces, ok := torrents.pieces[key]
+	var c *hashCall
+	if !ok {
+		c, ok = torrents.inflight[key]
+		if !ok {
+			c = &hashCall{done: make(chan struct{})}
+			torrents.inflight[key] = c
+			go s.hashPieces(c, key, source, file)
+		}
+	}
+	torrents.mu.Unlock()
+	if c != nil {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+		if c.err != nil {
+			return nil, "", &Error{http.StatusBadGateway, c.err.Error()}
+		}
+		pieces = c.pieces
+	}
+	return torrentFile(file, pieces, webSeed), file.FileName, nil
+}
+
+// hashPieces reads the file to hash its pieces. Like cutting HLS segments
+// it isn't tied to the request that started it, so the torrent is still
+// made for the next request if that one goes away.
+func (s *Service) hashPieces(c *hashCall, key fileKey, source Source, file *types.File) {
+	ctx, cancel := context.WithTimeout(bot.WithPriority(context.Background(), bot.PriorityBulk), hashTimeout)
+	defer cancel()
+	c.pieces, c.err = readPieces(ctx, source, file.Location, file.FileSize)
+	if c.err != nil {
+		s.log.Warn("Failed to hash the pieces of a file",
+			zap.Int64("channelID", key.channelID),
+			zap.Int("messageID", key.messageID),
+			zap.Error(c.err))
+	}
+	torrents.mu.Lock()
+	delete(torrents.inflight, key)
+	if c.err == nil {
+		torrents.pieces[key] = c.pieces
+	}
+	torrents.mu.Unlock()
+	close(c.done)
+}
+
+func readPieces(ctx context.Context, source ChunkFetcher, location tg.InputFileLocationClass, size int64) (*pieceHashes, error) {
+	length := int64(minPieceLength)
+	for size/length > maxPieces && length < maxPieceLength {
+		length *= 2
+	}
+	pieces := &pieceHashes{length: length}
+	piece := sha1.New()
+	var inPiece int64
+	for offset := int64(0); offset < size; offset += utils.MaxChunkSize {
+		chunk, err := source.FetchChunk(ctx, location, offset, utils.MaxChunkSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 {
+			return nil, fmt.Errorf("the file ended at %d of %d bytes", offset, size)
+		}
+		for len(chunk) > 0 {
+			n := min(int64(len(chunk)), length-inPiece)
+			piece.Write(chunk[:n])
+			chunk = chunk[n:]
+			inPiece += n
+			if inPiece == length {
+				pieces.hashes = piece.Sum(pieces.hashes)
+				piece.Reset()
+				inPiece = 0
+			}
+		}
+	}
+	if inPiece > 0 {
+		pieces.hashes = piece.Sum(pieces.hashes)
+	}
+	return pieces, nil
+}
+
+// torrentFile encodes the single file torrent of file. Trackers from
+// TORRENT_TRACKERS are announced, and webSeed is listed as a BEP 19 web
+// seed, which WebTorrent and most desktop clients download from.
+func torrentFile(file *types.File, pieces *pieceHashes, webSeed string) []byte {
+	torrent := map[string]any{
+		"created by":    "fsb",
+		"creation date": time.Now().Unix(),
+		"url-list":      []any{webSeed},
+		"info": map[string]any{
+			"name":         file.FileName,
+			"length":       file.FileSize,
+			"piece length": pieces.length,
+			"pieces":       string(pieces.hashes),
+		},
+	}
+	var trackers []any
+	for _, tracker := range strings.Split(config.ValueOf.TorrentTrackers, ",") {
+		if tracker = strings.TrimSpace(tracker); tracker != "" {
+			trackers = append(trackers, []any{tracker})
+		}
+	}
+	if len(trackers) > 0 {
+		torrent["announce"] = trackers[0].([]any)[0]
+		torrent["announce-list"] = trackers
+	}
+	var buf bytes.Buffer
+	bencode(&buf, torrent)
+	return buf.Bytes()
+}
+
+// bencode writes value in the encoding of .torrent files. Only the types
+// torrentFile uses are supported.
+func bencode(buf *bytes.Buffer, value any) {
+	switch v := value.(type) {
+	case string:
+		fmt.Fprintf(buf, "%d:%s", len(v), v)
+	case int64:
+		fmt.Fprintf(buf, "i%de", v)
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			bencode(buf, item)
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		// dictionaries have to be sorted by key
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, key := range keys {
+			bencode(buf, key)
+			bencode(buf, v[key])
+		}
+		buf.WriteByte('e')
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", value))
+	}
+}
@@ -0,0 +1,173 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/diskcache"
+	"EverythingSuckz/fsb/internal/evict"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+)
+
+// windowIdle is how long a window is kept after its client's last request.
+const windowIdle = 2 * time.Minute
+
+type windowKey struct {
+	remoteAddr string
+	channelID  int64
+	messageID  int
+}
+
+type chunkKey struct {
+	offset int64
+	limit  int64
+}
+
+// window keeps the chunks a client fetched most recently for a file, so that
+// players seeking back a little (to re-read the moov atom or init segment,
+// say) don't fetch the same chunks from Telegram again.
+type window struct {
+	mu       sync.Mutex
+	chunks   map[chunkKey][]byte
+	order    []chunkKey
+	size     int64
+	lastUsed time.Time
+}
+
+func (w *window) get(key chunkKey) ([]byte, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastUsed = time.Now()
+	data, ok := w.chunks[key]
+	return data, ok
+}
+
+func (w *window) put(key chunkKey, data []byte) {
+	limit := int64(config.ValueOf.SeekWindowMB) << 20
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.chunks[key]; ok {
+		return
+	}
+	// a jump further than the window can hold won't be followed by a seek
+	// back into it, so start over
+	if len(w.order) > 0 {
+		last := w.order[len(w.order)-1]
+		if key.offset > last.offset+limit || key.offset+limit < last.offset {
+			w.chunks = make(map[chunkKey][]byte)
+			w.order = nil
+			w.size = 0
+		}
+	}
+	w.chunks[key] = data
+	w.order = append(w.order, key)
+	w.size += int64(len(data))
+	for w.size > limit && len(w.order) > 0 {
+		oldest := w.order[0]
+		w.order = w.order[1:]
+		w.size -= int64(len(w.chunks[oldest]))
+		delete(w.chunks, oldest)
+	}
+}
+
+type windows struct {
+	mu      sync.Mutex
+	windows map[windowKey]*window
+}
+
+func newWindows() *windows {
+	return &windows{windows: make(map[windowKey]*window)}
+}
+
+// evict drops the windows of every client for a file.
+func (ws *windows) evict(event evict.Event) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for key := range ws.windows {
+		if key.channelID == event.ChannelID && key.messageID == event.MessageID {
+			delete(ws.windows, key)
+		}
+	}
+}
+
+// get returns the window of the client and file in req, or nil if
+// SEEK_WINDOW_MB is 0.
+func (ws *windows) get(req *Request) *window {
+	if config.ValueOf.SeekWindowMB <= 0 {
+		return nil
+	}
+	key := windowKey{req.RemoteAddr, req.ChannelID, req.MessageID}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if w, ok := ws.windows[key]; ok {
+		return w
+	}
+	for k, w := range ws.windows {
+		w.mu.Lock()
+		idle := time.Since(w.lastUsed) > windowIdle
+		w.mu.Unlock()
+		if idle {
+			delete(ws.windows, k)
+		}
+	}
+	w := &window{chunks: make(map[chunkKey][]byte), lastUsed: time.Now()}
+	ws.windows[key] = w
+	return w
+}
+
+// peek returns the window of the client and file in req, or nil if it has
+// none.
+func (ws *windows) peek(req *Request) *window {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.windows[windowKey{req.RemoteAddr, req.ChannelID, req.MessageID}]
+}
+
+// has reports whether the window holds the chunk at key.
+func (w *window) has(key chunkKey) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.chunks[key]
+	return ok
+}
+
+// windowFetcher serves chunks from a window before falling back to fetcher.
+type windowFetcher struct {
+	window  *window
+	fetcher ChunkFetcher
+}
+
+func (f *windowFetcher) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	key := chunkKey{offset, limit}
+	if data, ok := f.window.get(key); ok {
+		return data, nil
+	}
+	data, err := f.fetcher.FetchChunk(ctx, location, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	f.window.put(key, data)
+	return data, nil
+}
+
+// diskFetcher serves chunks from the disk cache before falling back to
+// fetcher, caching the chunks it fetches.
+type diskFetcher struct {
+	channelID int64
+	messageID int
+	fetcher   ChunkFetcher
+}
+
+func (f *diskFetcher) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	if data, ok := diskcache.Get(f.channelID, f.messageID, offset, limit); ok {
+		return data, nil
+	}
+	data, err := f.fetcher.FetchChunk(ctx, location, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	diskcache.Put(f.channelID, f.messageID, offset, limit, data)
+	return data, nil
+}
@@ -0,0 +1,58 @@
+package stream
+
+import (
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// NewWorkerService returns a Service streaming through the bot's workers.
+func NewWorkerService(log *zap.Logger) *Service {
+	return NewService(log, func(key string) Source {
+		return NewWorkerSource(bot.GetWorkerFor(key))
+	}, func(id int) Source {
+		return NewWorkerSource(bot.GetWorker(id))
+	})
+}
+
+type workerSource struct {
+	worker *bot.Worker
+}
+
+// NewWorkerSource returns a Source backed by a worker bot.
+func NewWorkerSource(worker *bot.Worker) Source {
+	return &workerSource{worker: worker}
+}
+
+func (s *workerSource) WorkerID() int {
+	return s.worker.ID
+}
+
+func (s *workerSource) ChunkSize() int64 {
+	return s.worker.ChunkSize()
+}
+
+func (s *workerSource) File(ctx context.Context, channelID int64, messageID int) (*types.File, error) {
+	return utils.FileFromMessage(ctx, s.worker.Client, channelID, messageID)
+}
+
+func (s *workerSource) FetchChunk(ctx context.Context, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	defer s.worker.BeginFetch(limit)()
+	started := time.Now()
+	data, err := utils.FetchChunk(ctx, s.worker.Client, location, offset, limit)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.worker.ReportFailure(err)
+		}
+		return nil, err
+	}
+	s.worker.ReportSuccess()
+	s.worker.RecordFetch(len(data), time.Since(started))
+	bandwidth.addFetched(s.worker.ID, int64(len(data)))
+	return data, nil
+}
@@ -0,0 +1,38 @@
+package throttle
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// EgressQuantum is the most bytes a response sends at once under
+// EGRESS_RATE_KB. Every response waits for its turn before each quantum, so
+// the streams sending at the same time share the rate evenly, and a stream
+// whose client reads slowly leaves its share to the others.
+const EgressQuantum = 64 << 10
+
+var egress struct {
+	once    sync.Once
+	limiter *rate.Limiter
+}
+
+// EgressLimited reports whether EGRESS_RATE_KB caps what the server sends.
+func EgressLimited() bool {
+	return config.ValueOf.EgressRateKB > 0
+}
+
+// WaitEgress waits until n bytes, at most EgressQuantum, may be sent within
+// EGRESS_RATE_KB.
+func WaitEgress(ctx context.Context, n int) error {
+	if !EgressLimited() {
+		return nil
+	}
+	egress.once.Do(func() {
+		bytesPerSecond := int64(config.ValueOf.EgressRateKB) << 10
+		egress.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), EgressQuantum)
+	})
+	return egress.limiter.WaitN(ctx, min(n, EgressQuantum))
+}
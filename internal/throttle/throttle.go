@@ -0,0 +1,143 @@
+// Package throttle caps how fast the links to a file are served, so a single
+// link shared widely can't take the whole uplink of the server. The cap is
+// a token bucket shared by every response streaming the file, so it holds
+// however many people download it at once. It also caps what the whole
+// server sends, with EGRESS_RATE_KB.
+package throttle
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Unlimited is the rate of files whose links are never throttled, even when
+// there is a default rate.
+const Unlimited = -1
+
+// defaultRate is the rate set with /throttle default, which replaces
+// LINK_RATE_LIMIT_KB until the bot restarts.
+var defaultRate = struct {
+	sync.RWMutex
+	rate int64
+	set  bool
+}{}
+
+// Default returns the rate in bytes per second files without a rate of
+// their own are served at, or 0 if they aren't throttled.
+func Default() int64 {
+	defaultRate.RLock()
+	defer defaultRate.RUnlock()
+	if defaultRate.set {
+		return defaultRate.rate
+	}
+	return int64(config.ValueOf.LinkRateLimitKB) << 10
+}
+
+// SetDefault sets the rate files without a rate of their own are served at,
+// 0 to not throttle them.
+func SetDefault(bytesPerSecond int64) {
+	defaultRate.Lock()
+	defaultRate.rate = max(bytesPerSecond, 0)
+	defaultRate.set = true
+	defaultRate.Unlock()
+}
+
+// Rate returns the rate in bytes per second a file with its own rate set to
+// fileRate is served at, or 0 if it isn't throttled. A fileRate of 0 is the
+// default rate.
+func Rate(fileRate int64) int64 {
+	switch {
+	case fileRate > 0:
+		return fileRate
+	case fileRate < 0:
+		return 0
+	}
+	return Default()
+}
+
+var rateUnits = map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+
+// ParseRate reads a rate like 2MB or 500KB/s, in bytes per second.
+func ParseRate(value string) (int64, error) {
+	rate, err := utils.ParseSize(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "/S"))
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't a rate like 2MB or 500KB", value)
+	}
+	return rate, nil
+}
+
+// FormatRate formats a rate in bytes per second like 2 MB/s.
+func FormatRate(bytesPerSecond int64) string {
+	for _, unit := range []string{"GB", "MB"} {
+		if bytesPerSecond >= rateUnits[unit] && bytesPerSecond%rateUnits[unit] == 0 {
+			return fmt.Sprintf("%d %s/s", bytesPerSecond/rateUnits[unit], unit)
+		}
+	}
+	return fmt.Sprintf("%d KB/s", bytesPerSecond>>10)
+}
+
+type fileKey struct {
+	channelID int64
+	messageID int
+}
+
+// Bucket is the token bucket of a file, shared by its responses.
+type Bucket struct {
+	limiter *rate.Limiter
+	key     fileKey
+	refs    int
+}
+
+var buckets = struct {
+	sync.Mutex
+	files map[fileKey]*Bucket
+}{files: make(map[fileKey]*Bucket)}
+
+// Acquire returns the bucket of a file served at bytesPerSecond, which
+// should be released when the response is done. The bucket lets through a
+// second's worth of bytes at once, and changing the file's rate applies to
+// responses already streaming it.
+func Acquire(channelID int64, messageID int, bytesPerSecond int64) *Bucket {
+	key := fileKey{channelID, messageID}
+	buckets.Lock()
+	defer buckets.Unlock()
+	bucket, ok := buckets.files[key]
+	if !ok {
+		bucket = &Bucket{limiter: rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond)), key: key}
+		buckets.files[key] = bucket
+	} else if bucket.limiter.Limit() != rate.Limit(bytesPerSecond) {
+		bucket.limiter.SetLimit(rate.Limit(bytesPerSecond))
+		bucket.limiter.SetBurst(int(bytesPerSecond))
+	}
+	bucket.refs++
+	return bucket
+}
+
+// Take waits until up to n bytes may be sent and returns how many, which is
+// less than n when n is more than the bucket lets through at once.
+func (b *Bucket) Take(ctx context.Context, n int) (int, error) {
+	for {
+		n := min(n, b.limiter.Burst())
+		err := b.limiter.WaitN(ctx, n)
+		if err == nil || ctx.Err() != nil {
+			return n, err
+		}
+		// the file's rate went down while waiting
+	}
+}
+
+// Release drops the bucket once no response is using it.
+func (b *Bucket) Release() {
+	buckets.Lock()
+	defer buckets.Unlock()
+	b.refs--
+	if b.refs == 0 {
+		delete(buckets.files, b.key)
+	}
+}
@@ -0,0 +1,144 @@
+// Package transcode re-encodes videos on the fly with ffmpeg, for files
+// browsers refuse to play, like HEVC videos in MKVs. The output is written
+// as ffmpeg produces it, so it can be played while it's being transcoded,
+// but it can't be seeked in past what was sent.
+package transcode
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/probe"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ErrBusy is returned when TRANSCODE_JOBS videos are being transcoded
+// already.
+var ErrBusy = errors.New("too many videos are being transcoded, try again later")
+
+// codec is an output format browsers can play.
+type codec struct {
+	contentType string
+	args        []string
+}
+
+var codecs = map[string]codec{
+	"h264": {"video/mp4", []string{
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		// a fragmented MP4 can be played before its end is written
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+	}},
+	"vp9": {"video/webm", []string{
+		"-c:v", "libvpx-vp9", "-deadline", "realtime", "-cpu-used", "8", "-row-mt", "1", "-b:v", "0", "-crf", "32",
+		"-c:a", "libopus", "-b:a", "128k", "-ac", "2",
+		"-f", "webm",
+	}},
+}
+
+// Options is what a video is transcoded to.
+type Options struct {
+	Codec string
+	// Height is the height the video is scaled down to, 0 keeps it.
+	Height int
+}
+
+// ParseOptions reads the codec and height a video was asked for, h264 at
+// the video's own height by default.
+func ParseOptions(codecName string, height string) (Options, error) {
+	opts := Options{Codec: "h264"}
+	if codecName != "" {
+		if _, ok := codecs[codecName]; !ok {
+			return opts, fmt.Errorf("unknown codec %q, use h264 or vp9", codecName)
+		}
+		opts.Codec = codecName
+	}
+	if height != "" {
+		parsed, err := strconv.Atoi(height)
+		if err != nil || parsed < 144 || parsed > 2160 {
+			return opts, errors.New("height must be between 144 and 2160")
+		}
+		opts.Height = parsed
+	}
+	return opts, nil
+}
+
+// ContentType is the type of the transcoded video.
+func (o Options) ContentType() string {
+	return codecs[o.Codec].contentType
+}
+
+// Enabled reports whether FFMPEG_PATH is set.
+func Enabled() bool {
+	return config.ValueOf.FFmpegPath != ""
+}
+
+var (
+	slots chan struct{}
+	log   *zap.Logger
+)
+
+// Init sets the number of videos transcoded at once to TRANSCODE_JOBS.
+func Init(logger *zap.Logger) {
+	log = logger.Named("Transcode")
+	slots = make(chan struct{}, config.ValueOf.TranscodeJobs)
+}
+
+// Run transcodes the video read from input, a URL ffmpeg can read, writing
+// it to w until it's done or ctx is canceled. begin is called before the
+// first byte is written, to let the caller send headers. When ffmpeg fails
+// before that, begin isn't called and the caller can still send an error.
+func Run(ctx context.Context, input string, opts Options, w io.Writer, begin func()) error {
+	select {
+	case slots <- struct{}{}:
+		defer func() { <-slots }()
+	default:
+		return ErrBusy
+	}
+	args := []string{"-v", "error"}
+	args = append(args, probe.HeaderArgs()...)
+	args = append(args, "-i", input, "-map", "0:v:0", "-map", "0:a:0?", "-sn")
+	if opts.Height > 0 {
+		// never scales up, and keeps the width even as encoders need
+		args = append(args, "-vf", fmt.Sprintf("scale=-2:'min(%d,ih)'", opts.Height))
+	}
+	args = append(args, codecs[opts.Codec].args...)
+	args = append(args, "pipe:1")
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, config.ValueOf.FFmpegPath, args...)
+	cmd.Stdout = &beginWriter{w: w, begin: begin}
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil && ctx.Err() == nil {
+		log.Warn("Failed to transcode",
+			zap.String("codec", opts.Codec),
+			zap.Int("height", opts.Height),
+			zap.String("stderr", strings.TrimSpace(stderr.String())),
+			zap.Error(err))
+	}
+	return err
+}
+
+// beginWriter calls begin before the first write to w.
+type beginWriter struct {
+	w     io.Writer
+	begin func()
+	began bool
+}
+
+func (b *beginWriter) Write(p []byte) (int, error) {
+	if !b.began {
+		b.began = true
+		b.begin()
+	}
+	return b.w.Write(p)
+}
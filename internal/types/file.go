@@ -15,6 +15,20 @@ type File struct {
 	FileName string
 	MimeType string
 	ID       int64
+	// AuthorID is the user who posted the message, only known for channels
+	// that sign messages.
+	AuthorID int64
+	// PhotoSizes are the sizes (thumb types) a photo is available in, from
+	// smallest to largest.
+	PhotoSizes []string
+	// ThumbSize is the thumb type of the thumbnail Telegram made of the
+	// file, or "" when it has none.
+	ThumbSize string
+	// Duration, in seconds, and Width and Height are taken from the
+	// document's video or audio attributes when it has them.
+	Duration float64
+	Width    int
+	Height   int
 }
 
 type HashableFileStruct struct {
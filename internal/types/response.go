@@ -1,8 +1,111 @@
 package types
 
+import "time"
+
 type RootResponse struct {
 	Message string `json:"message"`
 	Ok      bool   `json:"ok"`
 	Uptime  string `json:"uptime"`
 	Version string `json:"version"`
 }
+
+type ErrorResponse struct {
+	Ok      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+type LinkResponse struct {
+	Ok   bool   `json:"ok"`
+	Hash string `json:"hash"`
+	Link string `json:"link"`
+	// ID is set for one-time, geo-fenced and revocable links, which are
+	// saved in the store and can be revoked with DELETE /api/links/<id>.
+	ID string `json:"id,omitempty"`
+}
+
+// Page is returned by every listing endpoint. Next is an opaque cursor to
+// pass back as ?cursor= to get the following page, and is empty on the last
+// page.
+type Page[T any] struct {
+	Ok    bool   `json:"ok"`
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
+// FileInfo describes a file without streaming it.
+type FileInfo struct {
+	Ok          bool     `json:"ok"`
+	FileName    string   `json:"fileName"`
+	FileSize    int64    `json:"fileSize"`
+	MimeType    string   `json:"mimeType"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Duration is in seconds.
+	Duration   float64 `json:"duration,omitempty"`
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	VideoCodec string  `json:"videoCodec,omitempty"`
+	AudioCodec string  `json:"audioCodec,omitempty"`
+	// Thumbnail is set when /thumb serves a thumbnail of the file.
+	Thumbnail bool `json:"thumbnail,omitempty"`
+	// Subtitles are the subtitle tracks /subs serves from the file.
+	Subtitles []SubtitleTrack `json:"subtitles,omitempty"`
+}
+
+// BrowseEntry is a channel or a file listed by /browse. URL is relative to
+// the listing, and ends with a slash for channels.
+type BrowseEntry struct {
+	Name     string     `json:"name"`
+	Dir      bool       `json:"dir,omitempty"`
+	Size     int64      `json:"size,omitempty"`
+	MimeType string     `json:"mimeType,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+	URL      string     `json:"url"`
+}
+
+// ProbeInfo tells how soon a file could start streaming, without fetching
+// any of it.
+type ProbeInfo struct {
+	Ok bool `json:"ok"`
+	// Ready is set when the file can start streaming without waiting for a
+	// worker to recover or to wait out a FLOOD_WAIT.
+	Ready bool `json:"ready"`
+	// Worker is the worker that would serve the file, and CooldownMs how
+	// long it still waits out a FLOOD_WAIT.
+	Worker         int   `json:"worker,omitempty"`
+	CooldownMs     int64 `json:"cooldownMs,omitempty"`
+	ReadyWorkers   int   `json:"readyWorkers"`
+	TotalWorkers   int   `json:"totalWorkers"`
+	MetadataCached bool  `json:"metadataCached"`
+	// FirstChunkCached is set when the start of the file is in the shared
+	// chunk cache or the disk cache, and ObjectStore when the file is
+	// served from the object store instead of Telegram.
+	FirstChunkCached bool `json:"firstChunkCached"`
+	ObjectStore      bool `json:"objectStore,omitempty"`
+	// EstimatedStartMs is how long the first byte should take, and
+	// Estimate the same for people, like "ready in ~2s".
+	EstimatedStartMs int64  `json:"estimatedStartMs"`
+	Estimate         string `json:"estimate"`
+}
+
+// LinkStats are the hits of the links to a file, shown to its uploader.
+// Hits count downloads from the start of the file and Views loads of its
+// analytics pixel. UniqueIPs counts the addresses behind both, and
+// Countries how many of them came from each country.
+type LinkStats struct {
+	ChannelID  int64            `json:"channelId"`
+	MessageID  int              `json:"messageId"`
+	FileName   string           `json:"fileName"`
+	Hits       int64            `json:"hits"`
+	Views      int64            `json:"views"`
+	UniqueIPs  int64            `json:"uniqueIps"`
+	Countries  map[string]int64 `json:"countries,omitempty"`
+	LastAccess time.Time        `json:"lastAccess"`
+}
+
+// SubtitleTrack is a subtitle track embedded in a video, served at
+// /subs/<messageID>/<track>.
+type SubtitleTrack struct {
+	Track int    `json:"track"`
+	Label string `json:"label"`
+}
@@ -0,0 +1,250 @@
+// Package uploads stores files uploaded over HTTP in the storage channel and
+// tracks their progress.
+package uploads
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"sync"
+	"time"
+
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/plugins"
+	"EverythingSuckz/fsb/internal/probe"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/utils"
+
+	"github.com/celestix/gotgproto/storage"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+)
+
+// keepFinished is how long finished uploads can still be looked up.
+const keepFinished = 10 * time.Minute
+
+// Progress is the state of an upload.
+type Progress struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	// Size is the size of the file, -1 if the client didn't send it.
+	Size int64 `json:"size"`
+	// Parts is how many parts were pushed to Telegram so far, out of
+	// TotalParts if the size is known.
+	Parts      int   `json:"parts"`
+	TotalParts int   `json:"total_parts,omitempty"`
+	Uploaded   int64 `json:"uploaded"`
+	Done       bool  `json:"done"`
+	// ChannelID, MessageID and Link are set once the file was stored.
+	ChannelID int64  `json:"channel_id,omitempty"`
+	MessageID int    `json:"message_id,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Upload is an upload that was created and possibly started.
+type Upload struct {
+	mu          sync.Mutex
+	progress    Progress
+	started     bool
+	finishedAt  time.Time
+	subscribers map[chan Progress]struct{}
+}
+
+var uploads = struct {
+	sync.Mutex
+	byID map[string]*Upload
+}{byID: make(map[string]*Upload)}
+
+// Create registers an upload of a file with the given name.
+func Create(fileName string) *Upload {
+	id := make([]byte, 12)
+	rand.Read(id)
+	upload := &Upload{
+		progress:    Progress{ID: hex.EncodeToString(id), FileName: fileName, Size: -1},
+		subscribers: make(map[chan Progress]struct{}),
+	}
+	uploads.Lock()
+	defer uploads.Unlock()
+	for id, u := range uploads.byID {
+		u.mu.Lock()
+		expired := u.progress.Done && time.Since(u.finishedAt) > keepFinished
+		u.mu.Unlock()
+		if expired {
+			delete(uploads.byID, id)
+		}
+	}
+	uploads.byID[upload.progress.ID] = upload
+	return upload
+}
+
+// Get returns the upload with the given ID.
+func Get(id string) (*Upload, bool) {
+	uploads.Lock()
+	defer uploads.Unlock()
+	upload, ok := uploads.byID[id]
+	return upload, ok
+}
+
+func (u *Upload) Progress() Progress {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.progress
+}
+
+// Subscribe returns a channel receiving the progress of the upload whenever
+// it changes, closed once the upload is done.
+func (u *Upload) Subscribe() (<-chan Progress, func()) {
+	ch := make(chan Progress, 1)
+	u.mu.Lock()
+	if u.progress.Done {
+		ch <- u.progress
+		close(ch)
+		u.mu.Unlock()
+		return ch, func() {}
+	}
+	u.subscribers[ch] = struct{}{}
+	ch <- u.progress
+	u.mu.Unlock()
+	return ch, func() {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if _, ok := u.subscribers[ch]; ok {
+			delete(u.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// update changes the progress and notifies the subscribers, dropping stale
+// updates for slow ones since only the latest state matters.
+func (u *Upload) update(change func(p *Progress)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	change(&u.progress)
+	for ch := range u.subscribers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- u.progress
+		if u.progress.Done {
+			delete(u.subscribers, ch)
+			close(ch)
+		}
+	}
+	if u.progress.Done {
+		u.finishedAt = time.Now()
+	}
+}
+
+// Chunk implements uploader.Progress.
+func (u *Upload) Chunk(ctx context.Context, state uploader.ProgressState) error {
+	u.update(func(p *Progress) {
+		p.Parts++
+		p.Uploaded = state.Uploaded
+		if state.Total > 0 && state.PartSize > 0 {
+			p.TotalParts = int((state.Total + int64(state.PartSize) - 1) / int64(state.PartSize))
+		}
+	})
+	return nil
+}
+
+// Run uploads the file read from body to Telegram through api and posts it
+// in the current storage channel. Size is -1 if it isn't known in advance.
+func (u *Upload) Run(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, body io.Reader, size int64, mimeType string) error {
+	u.mu.Lock()
+	if u.started {
+		u.mu.Unlock()
+		return errors.New("upload was already started")
+	}
+	u.started = true
+	u.progress.Size = size
+	u.mu.Unlock()
+
+	err := u.run(ctx, api, peerStorage, body, mimeType)
+	u.update(func(p *Progress) {
+		p.Done = true
+		if err != nil {
+			p.Error = err.Error()
+		}
+	})
+	return err
+}
+
+func (u *Upload) run(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, body io.Reader, mimeType string) error {
+	progress := u.Progress()
+	file, err := uploader.NewUploader(api).
+		WithProgress(u).
+		Upload(ctx, uploader.NewUpload(progress.FileName, body, progress.Size))
+	if err != nil {
+		return err
+	}
+	channelID := channels.Current()
+	channel, err := utils.GetChannelPeer(ctx, api, peerStorage, channelID)
+	if err != nil {
+		return err
+	}
+	updates, err := api.MessagesSendMedia(ctx, &tg.MessagesSendMediaRequest{
+		Peer: &tg.InputPeerChannel{ChannelID: channel.ChannelID, AccessHash: channel.AccessHash},
+		Media: &tg.InputMediaUploadedDocument{
+			File:       file,
+			MimeType:   mimeType,
+			Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeFilename{FileName: progress.FileName}},
+			ForceFile:  true,
+		},
+		RandomID: mathrand.Int63(),
+	})
+	if err != nil {
+		return err
+	}
+	message, err := sentMessage(updates)
+	if err != nil {
+		return err
+	}
+	stored, err := utils.FileFromMedia(message.Media)
+	if err != nil {
+		return err
+	}
+	entry := &store.FileEntry{
+		ChannelID: channelID,
+		MessageID: message.ID,
+		FileName:  stored.FileName,
+		FileSize:  stored.FileSize,
+		MimeType:  stored.MimeType,
+		FileID:    stored.ID,
+		Backend:   store.BackendTelegram,
+		MediaInfo: store.MediaInfo{Duration: stored.Duration, Width: stored.Width, Height: stored.Height},
+	}
+	if err := store.GetStore().IndexFile(entry); err != nil {
+		return err
+	}
+	probe.Enqueue(*entry)
+	plugins.UploadStored(entry)
+	store.GetStore().IncrStat("uploads", 1)
+	hash := utils.GetShortHash(utils.PackFile(stored.FileName, stored.FileSize, stored.MimeType, stored.ID))
+	u.update(func(p *Progress) {
+		p.ChannelID = channelID
+		p.MessageID = message.ID
+		p.Link = utils.FileLink("stream", channelID, message.ID, hash)
+	})
+	return nil
+}
+
+func sentMessage(updates tg.UpdatesClass) (*tg.Message, error) {
+	all, ok := updates.(*tg.Updates)
+	if !ok {
+		return nil, errors.New("unexpected response to sending the file")
+	}
+	for _, update := range all.Updates {
+		if update, ok := update.(*tg.UpdateNewChannelMessage); ok {
+			if message, ok := update.Message.(*tg.Message); ok {
+				return message, nil
+			}
+		}
+	}
+	return nil, errors.New("the sent message is missing from the response")
+}
@@ -0,0 +1,75 @@
+package utils
+
+const (
+	// MaxChunkSize is the largest limit upload.getFile accepts.
+	MaxChunkSize int64 = 1024 * 1024
+	// MinChunkSize is the alignment every offset and limit must respect.
+	MinChunkSize int64 = 4 * 1024
+)
+
+// ChunkPlanner splits byte ranges into upload.getFile requests that respect
+// Telegram's limits: offset and limit must be multiples of 4 KB, limit must
+// divide 1 MB evenly and a single request may not cross a 1 MB boundary.
+// A power of two between MinChunkSize and MaxChunkSize satisfies all of them.
+type ChunkPlanner struct {
+	ChunkSize int64
+}
+
+var DefaultChunkPlanner = NewChunkPlanner(MaxChunkSize)
+
+// NewChunkPlanner returns a planner using the largest valid chunk size that
+// is not bigger than the requested one.
+func NewChunkPlanner(chunkSize int64) *ChunkPlanner {
+	size := MinChunkSize
+	for size*2 <= chunkSize && size*2 <= MaxChunkSize {
+		size *= 2
+	}
+	return &ChunkPlanner{ChunkSize: size}
+}
+
+// ChunkPlan describes the requests needed to read the inclusive byte range
+// [Start, End].
+type ChunkPlan struct {
+	Start     int64
+	End       int64
+	Offset    int64
+	ChunkSize int64
+	Parts     int
+}
+
+func (p *ChunkPlanner) Plan(start int64, end int64) ChunkPlan {
+	offset := start - (start % p.ChunkSize)
+	return ChunkPlan{
+		Start:     start,
+		End:       end,
+		Offset:    offset,
+		ChunkSize: p.ChunkSize,
+		Parts:     int((end - offset + p.ChunkSize) / p.ChunkSize),
+	}
+}
+
+// PartOffset returns the offset to request for the given 1-based part.
+func (c ChunkPlan) PartOffset(part int) int64 {
+	return c.Offset + int64(part-1)*c.ChunkSize
+}
+
+// Trim cuts the bytes returned for the given 1-based part down to the
+// requested range.
+func (c ChunkPlan) Trim(part int, data []byte) []byte {
+	partOffset := c.PartOffset(part)
+	from := int64(0)
+	if part == 1 {
+		from = c.Start - partOffset
+	}
+	to := int64(len(data))
+	if part == c.Parts {
+		to = c.End - partOffset + 1
+	}
+	if to > int64(len(data)) {
+		to = int64(len(data))
+	}
+	if from > to {
+		from = to
+	}
+	return data[from:to]
+}
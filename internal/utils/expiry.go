@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// ExpiryHash derives the full hash of links that stop working at expires
+// from the full hash of the file, like BindHash does for bound links, so
+// the expiry can't be pushed back without the file's own hash.
+func ExpiryHash(fullHash string, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("expires:" + strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ExpiringFileLink returns a stream link to a file that stops working at
+// expires, which it carries as a Unix timestamp.
+func ExpiringFileLink(channelID int64, messageID int, fullHash string, expires time.Time) string {
+	hash := GetShortHash(ExpiryHash(fullHash, expires))
+	return FileLink("stream", channelID, messageID, hash) + "&expires=" + strconv.FormatInt(expires.Unix(), 10)
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// transliterations covers the letters that don't decompose into an ASCII
+// letter and a combining mark.
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ł': "l", 'Ł': "L", 'þ': "th", 'Þ': "Th", 'ð': "d", 'Ð': "D",
+	'ı': "i", '–': "-", '—': "-", '‘': "'", '’': "'", '“': "", '”': "", '«': "", '»': "",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// ASCIIFileName transliterates a file name to ASCII for download clients
+// that mangle UTF-8 file names. Accents are dropped, common Latin and
+// Cyrillic letters are spelled out, and anything else becomes an underscore.
+func ASCIIFileName(name string) string {
+	var out strings.Builder
+	for _, r := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r < unicode.MaxASCII && unicode.IsPrint(r) && r != '"' && r != '\\':
+			out.WriteRune(r)
+		default:
+			lower := unicode.ToLower(r)
+			if ascii, ok := transliterations[r]; ok {
+				out.WriteString(ascii)
+			} else if ascii, ok := transliterations[lower]; ok && ascii != "" {
+				out.WriteString(strings.ToUpper(ascii[:1]) + ascii[1:])
+			} else if !ok {
+				out.WriteRune('_')
+			}
+		}
+	}
+	return out.String()
+}
+
+// ContentDisposition returns a Content-Disposition header for the file. With
+// ascii set, the filename parameter is transliterated to ASCII and the
+// original name is kept in filename* for clients that understand it.
+func ContentDisposition(disposition string, fileName string, ascii bool) string {
+	if !ascii {
+		return fmt.Sprintf("%s; filename=\"%s\"", disposition, fileName)
+	}
+	return fmt.Sprintf("%s; filename=\"%s\"; filename*=UTF-8''%s", disposition, ASCIIFileName(fileName), encodeExtValue(fileName))
+}
+
+// encodeExtValue percent-encodes a value for an RFC 5987 extended parameter.
+func encodeExtValue(value string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var out strings.Builder
+	for _, b := range []byte(value) {
+		if b < 0x80 && (b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || strings.IndexByte(attrChars, b) >= 0) {
+			out.WriteByte(b)
+		} else {
+			fmt.Fprintf(&out, "%%%02X", b)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+)
+
+// GeoHash derives the full hash of a link restricted to some countries from
+// the full hash of the file, like BindHash does for bound links. The
+// countries are kept with the link in the store, under id.
+func GeoHash(fullHash string, id string) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("geo:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GeoFileLink returns a stream link to a file that only works in the
+// countries of the link saved under id.
+func GeoFileLink(channelID int64, messageID int, fullHash string, id string) string {
+	hash := GetShortHash(GeoHash(fullHash, id))
+	return FileLink("stream", channelID, messageID, hash) + "&geo=" + url.QueryEscape(id)
+}
@@ -3,16 +3,154 @@ package utils
 import (
 	"EverythingSuckz/fsb/config"
 	"EverythingSuckz/fsb/internal/types"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
 )
 
 func PackFile(fileName string, fileSize int64, mimeType string, fileID int64) string {
 	return (&types.HashableFileStruct{FileName: fileName, FileSize: fileSize, MimeType: mimeType, FileID: fileID}).Pack()
 }
 
+// SaltFile mixes the salt a file's links were rotated to into its full hash.
+// Files that were never rotated have an empty salt and keep their hash.
+func SaltFile(fullHash string, salt string) string {
+	if salt == "" {
+		return fullHash
+	}
+	sum := sha256.Sum256([]byte(fullHash + ":" + salt))
+	return hex.EncodeToString(sum[:])
+}
+
+// FileLink returns the link to a file on one of the file routes (stream,
+// player, ...). Files in LOG_CHANNEL keep the short link format.
+func FileLink(route string, channelID int64, messageID int, hash string) string {
+	link := fmt.Sprintf("%s/%s/%d?hash=%s", config.ValueOf.Host, route, messageID, hash)
+	if channelID != config.ValueOf.LogChannelID {
+		link += fmt.Sprintf("&channel=%d", channelID)
+	}
+	return link
+}
+
 func GetShortHash(fullHash string) string {
 	return fullHash[:config.ValueOf.HashLength]
 }
 
+// CheckHash reports whether inputHash is the short hash of expectedHash. The
+// comparison takes the same time wherever the hashes differ, so guessing a
+// hash can't be sped up by timing the responses.
 func CheckHash(inputHash string, expectedHash string) bool {
-	return inputHash == GetShortHash(expectedHash)
+	return subtle.ConstantTimeCompare([]byte(inputHash), []byte(GetShortHash(expectedHash))) == 1
+}
+
+// NormalizeOrigin reduces a URL to its "scheme://host" form so that it can be
+// compared against the Origin and Referer headers sent by browsers.
+func NormalizeOrigin(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("origin must start with http:// or https://")
+	}
+	if u.Host == "" {
+		return "", errors.New("origin is missing a host")
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), nil
+}
+
+// EmbedHash derives the full hash of links locked to an embedding origin
+// from the full hash of the file, like BindHash does for bound links, so
+// the origin can't be dropped from the link to use it elsewhere.
+func EmbedHash(fullHash string, origin string) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("origin:" + origin))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EmbedFileLink returns a stream link to a file that only plays when
+// embedded on origin.
+func EmbedFileLink(channelID int64, messageID int, fullHash string, origin string) string {
+	hash := GetShortHash(EmbedHash(fullHash, origin))
+	return FileLink("stream", channelID, messageID, hash) + "&origin=" + url.QueryEscape(origin)
+}
+
+// NormalizeIPBinding parses the IP address or CIDR range a link is bound to,
+// turning single addresses into a /32 (or /128) range.
+func NormalizeIPBinding(value string) (string, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Masked().String(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", fmt.Errorf("%q is not an IP address or CIDR range", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()).String(), nil
+}
+
+// BindHash derives the full hash of links bound to the addresses in prefix
+// from the full hash of the file. Bound links don't reveal the file's own
+// hash, so they can't be turned back into unbound ones.
+func BindHash(fullHash string, prefix string) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("ip:" + prefix))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// BoundFileLink returns a stream link to a file that only works from the
+// addresses in prefix.
+func BoundFileLink(channelID int64, messageID int, fullHash string, prefix string) string {
+	hash := GetShortHash(BindHash(fullHash, prefix))
+	return FileLink("stream", channelID, messageID, hash) + "&ip=" + url.QueryEscape(prefix)
+}
+
+// IPInBinding reports whether ip is in the CIDR range a link is bound to.
+func IPInBinding(prefix string, ip string) bool {
+	p, err := netip.ParsePrefix(prefix)
+	if err != nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return p.Contains(addr.Unmap())
+}
+
+// SignMember signs the links to a group's file given to one of its members,
+// so the stream routes know whose membership to check.
+func SignMember(channelID int64, messageID int, userID int64) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.EmbedSecret))
+	mac.Write([]byte(fmt.Sprintf("member:%d:%d:%d", channelID, messageID, userID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CheckMember(channelID int64, messageID int, userID int64, signature string) bool {
+	expected := SignMember(channelID, messageID, userID)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// SignStats signs the link to a user's link stats, so only they can read
+// them.
+func SignStats(userID int64) string {
+	mac := hmac.New(sha256.New, []byte(config.ValueOf.EmbedSecret))
+	mac.Write([]byte(fmt.Sprintf("stats:%d", userID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func CheckStats(userID int64, signature string) bool {
+	expected := SignStats(userID)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
+
+// StatsLink returns the link to a user's link stats.
+func StatsLink(userID int64) string {
+	return fmt.Sprintf("%s/mystats/%d?sig=%s", config.ValueOf.Host, userID, SignStats(userID))
 }
@@ -26,9 +26,9 @@ func Contains[T comparable](s []T, e T) bool {
 	return false
 }
 
-func GetTGMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*tg.Message, error) {
+func GetTGMessage(ctx context.Context, client *gotgproto.Client, channelID int64, messageID int) (*tg.Message, error) {
 	inputMessageID := tg.InputMessageClass(&tg.InputMessageID{ID: messageID})
-	channel, err := GetLogChannelPeer(ctx, client.API(), client.PeerStorage)
+	channel, err := GetChannelPeer(ctx, client.API(), client.PeerStorage, channelID)
 	if err != nil {
 		return nil, err
 	}
@@ -46,27 +46,49 @@ func GetTGMessage(ctx context.Context, client *gotgproto.Client, messageID int)
 	}
 }
 
+var (
+	// ErrPaidMedia is returned for paid media that wasn't bought, of which
+	// only a blurred preview can be seen.
+	ErrPaidMedia = errors.New("this is paid media, only its buyers can download it")
+	// ErrProtectedContent is returned for messages from chats that don't
+	// allow their content to be forwarded or saved.
+	ErrProtectedContent = errors.New("this message is protected, its chat doesn't allow saving or forwarding its content")
+)
+
 func FileFromMedia(media tg.MessageMediaClass) (*types.File, error) {
 	switch media := media.(type) {
+	case *tg.MessageMediaInvoice:
+		// paid media is an invoice, which holds the media once it's bought
+		if bought, ok := media.ExtendedMedia.(*tg.MessageExtendedMedia); ok {
+			return FileFromMedia(bought.Media)
+		}
+		return nil, ErrPaidMedia
 	case *tg.MessageMediaDocument:
 		document, ok := media.Document.AsNotEmpty()
 		if !ok {
 			return nil, fmt.Errorf("unexpected type %T", media)
 		}
-		var fileName string
+		file := &types.File{
+			Location:  document.AsInputDocumentFileLocation(),
+			FileSize:  document.Size,
+			MimeType:  document.MimeType,
+			ID:        document.ID,
+			ThumbSize: thumbSize(document.Thumbs),
+		}
 		for _, attribute := range document.Attributes {
-			if name, ok := attribute.(*tg.DocumentAttributeFilename); ok {
-				fileName = name.FileName
-				break
+			switch attribute := attribute.(type) {
+			case *tg.DocumentAttributeFilename:
+				file.FileName = attribute.FileName
+			case *tg.DocumentAttributeVideo:
+				file.Duration = attribute.Duration
+				file.Width, file.Height = attribute.W, attribute.H
+			case *tg.DocumentAttributeAudio:
+				if file.Duration == 0 {
+					file.Duration = float64(attribute.Duration)
+				}
 			}
 		}
-		return &types.File{
-			Location: document.AsInputDocumentFileLocation(),
-			FileSize: document.Size,
-			FileName: fileName,
-			MimeType: document.MimeType,
-			ID:       document.ID,
-		}, nil
+		return file, nil
 	case *tg.MessageMediaPhoto:
 		photo, ok := media.Photo.AsNotEmpty()
 		if !ok {
@@ -86,19 +108,77 @@ func FileFromMedia(media tg.MessageMediaClass) (*types.File, error) {
 		location.AccessHash = photo.GetAccessHash()
 		location.FileReference = photo.GetFileReference()
 		location.ThumbSize = size.GetType()
+		var photoSizes []string
+		for _, size := range sizes {
+			switch size := size.(type) {
+			case *tg.PhotoSize:
+				photoSizes = append(photoSizes, size.Type)
+			case *tg.PhotoSizeProgressive:
+				photoSizes = append(photoSizes, size.Type)
+			}
+		}
 		return &types.File{
-			Location: location,
-			FileSize: 0, // caller should judge if this is a photo or not
-			FileName: fmt.Sprintf("photo_%d.jpg", photo.GetID()),
-			MimeType: "image/jpeg",
-			ID:       photo.GetID(),
+			Location:   location,
+			FileSize:   0, // caller should judge if this is a photo or not
+			FileName:   fmt.Sprintf("photo_%d.jpg", photo.GetID()),
+			MimeType:   "image/jpeg",
+			ID:         photo.GetID(),
+			PhotoSizes: photoSizes,
+			ThumbSize:  thumbSize(sizes),
 		}, nil
 	}
 	return nil, fmt.Errorf("unexpected type %T", media)
 }
 
-func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID int) (*types.File, error) {
-	key := fmt.Sprintf("file:%d:%d", messageID, client.Self.ID)
+// maxThumbSide is the longest side of the thumbnail served for a file, which
+// is what Telegram makes for documents.
+const maxThumbSide = 320
+
+// thumbSize picks the largest of sizes that fits in maxThumbSide, or the
+// smallest one when none does. Stripped and vector sizes are skipped, as
+// they aren't downloadable images.
+func thumbSize(sizes []tg.PhotoSizeClass) string {
+	var best, smallest string
+	var bestSide, smallestSide int
+	for _, size := range sizes {
+		var w, h int
+		switch size := size.(type) {
+		case *tg.PhotoSize:
+			w, h = size.W, size.H
+		case *tg.PhotoSizeProgressive:
+			w, h = size.W, size.H
+		default:
+			continue
+		}
+		side := max(w, h)
+		if side <= maxThumbSide && side > bestSide {
+			best, bestSide = size.GetType(), side
+		}
+		if smallest == "" || side < smallestSide {
+			smallest, smallestSide = size.GetType(), side
+		}
+	}
+	if best == "" {
+		return smallest
+	}
+	return best
+}
+
+// fileCacheKey is the key the file in a message is cached under for the
+// client that looked it up.
+func fileCacheKey(client *gotgproto.Client, channelID int64, messageID int) string {
+	return fmt.Sprintf("file:%d:%d:%d", channelID, messageID, client.Self.ID)
+}
+
+// FileCached reports whether FileFromMessage has the file in a message
+// cached for client, so looking it up doesn't call Telegram.
+func FileCached(client *gotgproto.Client, channelID int64, messageID int) bool {
+	var cachedMedia types.File
+	return cache.GetCache().Get(fileCacheKey(client, channelID, messageID), &cachedMedia) == nil
+}
+
+func FileFromMessage(ctx context.Context, client *gotgproto.Client, channelID int64, messageID int) (*types.File, error) {
+	key := fileCacheKey(client, channelID, messageID)
 	log := Logger.Named("GetMessageMedia")
 	var cachedMedia types.File
 	err := cache.GetCache().Get(key, &cachedMedia)
@@ -107,14 +187,21 @@ func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID in
 		return &cachedMedia, nil
 	}
 	log.Debug("Fetching file properties from message ID", zap.Int("messageID", messageID), zap.Int64("clientID", client.Self.ID))
-	message, err := GetTGMessage(ctx, client, messageID)
+	message, err := GetTGMessage(ctx, client, channelID, messageID)
 	if err != nil {
 		return nil, err
 	}
+	// serving it would get around the protection for anyone with a link
+	if message.Noforwards {
+		return nil, ErrProtectedContent
+	}
 	file, err := FileFromMedia(message.Media)
 	if err != nil {
 		return nil, err
 	}
+	if author, ok := message.FromID.(*tg.PeerUser); ok {
+		file.AuthorID = author.UserID
+	}
 	err = cache.GetCache().Set(
 		key,
 		file,
@@ -127,7 +214,11 @@ func FileFromMessage(ctx context.Context, client *gotgproto.Client, messageID in
 }
 
 func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage) (*tg.InputChannel, error) {
-	cachedInputPeer := peerStorage.GetInputPeerById(config.ValueOf.LogChannelID)
+	return GetChannelPeer(ctx, api, peerStorage, config.ValueOf.LogChannelID)
+}
+
+func GetChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage.PeerStorage, channelID int64) (*tg.InputChannel, error) {
+	cachedInputPeer := peerStorage.GetInputPeerById(channelID)
 
 	switch peer := cachedInputPeer.(type) {
 	case *tg.InputPeerEmpty:
@@ -141,7 +232,7 @@ func GetLogChannelPeer(ctx context.Context, api *tg.Client, peerStorage *storage
 		return nil, errors.New("unexpected type of input peer")
 	}
 	inputChannel := &tg.InputChannel{
-		ChannelID: config.ValueOf.LogChannelID,
+		ChannelID: channelID,
 	}
 	channels, err := api.ChannelsGetChannels(ctx, []tg.InputChannelClass{inputChannel})
 	if err != nil {
@@ -164,7 +255,7 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	if fromPeer.Zero() {
 		return nil, fmt.Errorf("fromChatId: %d is not a valid peer", fromChatId)
 	}
-	toPeer, err := GetLogChannelPeer(ctx, ctx.Raw, ctx.PeerStorage)
+	toPeer, err := GetChannelPeer(ctx, ctx.Raw, ctx.PeerStorage, toChatId)
 	if err != nil {
 		return nil, err
 	}
@@ -179,3 +270,30 @@ func ForwardMessages(ctx *ext.Context, fromChatId, toChatId int64, messageID int
 	}
 	return update.(*tg.Updates), nil
 }
+
+func FetchChunk(ctx context.Context, client *gotgproto.Client, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+	return FetchChunkWith(ctx, client.API(), location, offset, limit)
+}
+
+// FetchChunkWith is FetchChunk for callers that only have the raw API client.
+func FetchChunkWith(ctx context.Context, api *tg.Client, location tg.InputFileLocationClass, offset int64, limit int64) ([]byte, error) {
+
+	req := &tg.UploadGetFileRequest{
+		Offset:   offset,
+		Limit:    int(limit),
+		Location: location,
+	}
+
+	res, err := api.UploadGetFile(ctx, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch result := res.(type) {
+	case *tg.UploadFile:
+		return result.Bytes, nil
+	default:
+		return nil, fmt.Errorf("unexpected type %T", result)
+	}
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/ratelimit"
+	"net/netip"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// hashFailures are the messages an address sent wrong hashes for since
+// since.
+type hashFailures struct {
+	since    time.Time
+	messages map[int]struct{}
+}
+
+// lockouts tracks the addresses guessing at link hashes. A client with a
+// stale link fails on one message over and over, one guessing hashes fails
+// on many, so only distinct messages count towards HASH_FAILURES. They are
+// told apart here, and counted in the store, so an address guessing at
+// instances sharing a Redis or Postgres store is locked out of all of them.
+var lockouts = struct {
+	sync.Mutex
+	addrs map[string]*hashFailures
+	swept time.Time
+}{addrs: make(map[string]*hashFailures)}
+
+func lockoutKey(addr string) string {
+	return "hashfail:" + addr
+}
+
+// ClientKey returns the key the limits on a client address are kept under:
+// the address itself, or the /64 it's in for IPv6 addresses, as a single
+// client usually has a whole /64 to pick addresses from.
+func ClientKey(addr string) string {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return addr
+	}
+	ip = ip.Unmap()
+	if ip.Is4() {
+		return ip.String()
+	}
+	return netip.PrefixFrom(ip, 64).Masked().String()
+}
+
+// HashFailed records that addr sent a wrong hash for a message, locking it
+// out once it did for HASH_FAILURES different messages within the last
+// HASH_LOCKOUT. addr is the client address the router trusts, and IPv6
+// addresses are locked out with the rest of their /64.
+func HashFailed(addr string, messageID int) {
+	limit, window := config.ValueOf.HashFailures, config.ValueOf.HashLockout
+	if limit <= 0 || window <= 0 || addr == "" {
+		return
+	}
+	addr = ClientKey(addr)
+	now := time.Now()
+	lockouts.Lock()
+	sweepLockouts(now, window)
+	failures, ok := lockouts.addrs[addr]
+	if !ok || now.Sub(failures.since) > window {
+		failures = &hashFailures{since: now, messages: make(map[int]struct{})}
+		lockouts.addrs[addr] = failures
+	}
+	_, seen := failures.messages[messageID]
+	failures.messages[messageID] = struct{}{}
+	messages := len(failures.messages)
+	lockouts.Unlock()
+	if seen {
+		return
+	}
+	if err := ratelimit.Add(lockoutKey(addr), window); err != nil {
+		Logger.Warn("Failed to count wrong hash", zap.String("addr", addr), zap.Error(err))
+		return
+	}
+	if LockedOut(addr) > 0 {
+		Logger.Sugar().Warnf("Locked out %s for sending wrong hashes for %d messages", addr, messages)
+	}
+}
+
+// LockedOut returns how long addr stays locked out for, or 0 if it isn't.
+func LockedOut(addr string) time.Duration {
+	limit, window := config.ValueOf.HashFailures, config.ValueOf.HashLockout
+	if limit <= 0 || window <= 0 || addr == "" {
+		return 0
+	}
+	addr = ClientKey(addr)
+	wait, err := ratelimit.Reached(lockoutKey(addr), limit, window)
+	if err != nil {
+		// better to serve the link than to lock everyone out
+		Logger.Warn("Failed to check lockout", zap.String("addr", addr), zap.Error(err))
+		return 0
+	}
+	return wait
+}
+
+// sweepLockouts drops the addresses that didn't fail recently, at most once
+// a minute. lockouts must be held.
+func sweepLockouts(now time.Time, window time.Duration) {
+	if now.Sub(lockouts.swept) < time.Minute {
+		return
+	}
+	lockouts.swept = now
+	for addr, failures := range lockouts.addrs {
+		if now.Sub(failures.since) > window {
+			delete(lockouts.addrs, addr)
+		}
+	}
+}
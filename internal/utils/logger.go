@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"os"
 	"time"
 
@@ -46,3 +47,19 @@ func InitLogger(debugMode bool) {
 
 	Logger = zap.New(core, zap.AddStacktrace(zapcore.FatalLevel))
 }
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying log, to be retrieved with
+// LoggerFrom further down the request.
+func WithLogger(ctx context.Context, log *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// LoggerFrom returns the logger stored in ctx by WithLogger, or Logger.
+func LoggerFrom(ctx context.Context) *zap.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return log
+	}
+	return Logger
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	_ "image/gif"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// StripMetadata removes EXIF, XMP and other metadata (camera details, GPS
+// location, ...) from an image. JPEG and PNG files have their metadata
+// segments dropped as is. Other images the standard library can decode are
+// re-encoded as PNG, which also drops it, and the returned MIME type says
+// which format the result is in.
+func StripMetadata(mimeType string, data []byte) ([]byte, string, error) {
+	switch mimeType {
+	case "image/jpeg":
+		stripped, err := stripJPEG(data)
+		if err == nil {
+			return stripped, mimeType, nil
+		}
+		// unusual layout, fall back to re-encoding
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, "", err
+		}
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+		return buf.Bytes(), mimeType, err
+	case "image/png":
+		stripped, err := stripPNG(data)
+		return stripped, mimeType, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("can't strip metadata from %s: %w", mimeType, err)
+	}
+	var buf bytes.Buffer
+	err = png.Encode(&buf, img)
+	return buf.Bytes(), "image/png", err
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP13 (IPTC) and comment segments of
+// a JPEG, keeping the rest (including the ICC color profile in APP2).
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return nil, errors.New("invalid JPEG marker")
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			// start of scan, the image data follows
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+			out.Write(data[i : i+2+length])
+		}
+		i += 2 + length
+	}
+	return nil, errors.New("JPEG has no image data")
+}
+
+// stripPNG drops the eXIf and text chunks of a PNG.
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+	i := len(pngSignature)
+	for i+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf", "tEXt", "iTXt", "zTXt", "tIME":
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// NewLinkID returns a random ID for a link saved in the store, like a
+// one-time link.
+func NewLinkID() (string, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// OnceHash derives the full hash of a one-time link from the full hash of
+// the file, like BindHash does for bound links.
+func OnceHash(fullHash string, id string) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("once:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// OnceFileLink returns a stream link to a file that only works for the first
+// client that uses it.
+func OnceFileLink(channelID int64, messageID int, fullHash string, id string) string {
+	hash := GetShortHash(OnceHash(fullHash, id))
+	return FileLink("stream", channelID, messageID, hash) + "&once=" + url.QueryEscape(id)
+}
+
+// SignOnceSession returns the token of the download session that claimed a
+// one-time link, signed with the link's full hash so it can't be forged
+// without it.
+func SignOnceSession(linkHash string, session string) string {
+	mac := hmac.New(sha256.New, []byte(linkHash))
+	mac.Write([]byte("session:" + session))
+	return session + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckOnceSession returns the session of a token signed by SignOnceSession,
+// reporting whether its signature is valid.
+func CheckOnceSession(linkHash string, token string) (string, bool) {
+	session, _, ok := strings.Cut(token, ".")
+	if !ok || session == "" {
+		return "", false
+	}
+	return session, hmac.Equal([]byte(token), []byte(SignOnceSession(linkHash, session)))
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+// HashPassword salts and hashes the password of a password protected file,
+// so the password itself isn't kept.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(salt) + "$" + saltedHash(salt, password), nil
+}
+
+// CheckPassword reports whether password is the one hashed by HashPassword.
+func CheckPassword(hashed string, password string) bool {
+	encodedSalt, hash, ok := strings.Cut(hashed, "$")
+	if !ok {
+		return false
+	}
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(saltedHash(salt, password)), []byte(hash)) == 1
+}
+
+func saltedHash(salt []byte, password string) string {
+	sum := sha256.Sum256(append(salt, password...))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preview is how much of a file a preview link serves, either a size or a
+// length of playback.
+type Preview struct {
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Enabled reports whether the link is a preview link.
+func (p Preview) Enabled() bool {
+	return p.Bytes > 0 || p.Duration > 0
+}
+
+var previewUnits = map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+
+// ParseSize reads a size like 500KB, 50MB or 2GB.
+func ParseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	for unit, size := range previewUnits {
+		if number, ok := strings.CutSuffix(upper, unit); ok {
+			n, err := strconv.ParseInt(number, 10, 64)
+			if err != nil || n <= 0 {
+				break
+			}
+			return n * size, nil
+		}
+	}
+	return 0, fmt.Errorf("%q isn't a size like 500MB or 2GB", value)
+}
+
+// FormatSize formats a size in bytes with binary units, like "1.5 GiB".
+func FormatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// ParsePreview reads the limit of a preview link, a size like 50MB or a
+// length like 2m or 90s.
+func ParsePreview(value string) (Preview, error) {
+	value = strings.TrimSpace(value)
+	upper := strings.ToUpper(value)
+	for unit, size := range previewUnits {
+		if number, ok := strings.CutSuffix(upper, unit); ok {
+			n, err := strconv.ParseInt(number, 10, 64)
+			if err != nil || n <= 0 {
+				break
+			}
+			return Preview{Bytes: n * size}, nil
+		}
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return Preview{Duration: duration}, nil
+	}
+	return Preview{}, fmt.Errorf("%q is neither a size like 50MB nor a length like 2m", value)
+}
+
+// String returns the limit in the form ParsePreview reads, the same for
+// every way of writing it.
+func (p Preview) String() string {
+	if p.Duration > 0 {
+		return p.Duration.String()
+	}
+	for _, unit := range []string{"GB", "MB", "KB"} {
+		if p.Bytes%previewUnits[unit] == 0 {
+			return fmt.Sprintf("%d%s", p.Bytes/previewUnits[unit], unit)
+		}
+	}
+	return fmt.Sprintf("%dKB", p.Bytes>>10)
+}
+
+// Limit returns how many bytes of a file of size bytes, which plays for
+// length seconds, the preview serves. Limits in time need the length of the
+// file, and are cut at the same fraction of its bytes.
+func (p Preview) Limit(size int64, length float64) (int64, error) {
+	limit := p.Bytes
+	if p.Duration > 0 {
+		if length <= 0 {
+			return 0, fmt.Errorf("the length of the file isn't known, use a size instead")
+		}
+		limit = int64(float64(size) * p.Duration.Seconds() / length)
+	}
+	return min(max(limit, 1), size), nil
+}
+
+// PreviewHash derives the full hash of preview links from the full hash of
+// the file, like BindHash does for bound links.
+func PreviewHash(fullHash string, preview Preview) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("preview:" + preview.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PreviewFileLink returns a stream link to a file that only serves the
+// first part of it. Like bound links its hash is derived from the file's,
+// which the preview link doesn't reveal.
+func PreviewFileLink(channelID int64, messageID int, fullHash string, preview Preview) string {
+	hash := GetShortHash(PreviewHash(fullHash, preview))
+	return FileLink("stream", channelID, messageID, hash) + "&preview=" + url.QueryEscape(preview.String())
+}
@@ -0,0 +1,33 @@
+package utils
+
+import "strings"
+
+// previewAgents are substrings of the User-Agent of bots that fetch links to
+// render previews of them in chats and feeds.
+var previewAgents = []string{
+	"telegrambot",
+	"twitterbot",
+	"facebookexternalhit",
+	"facebookcatalog",
+	"slackbot",
+	"discordbot",
+	"whatsapp",
+	"linkedinbot",
+	"skypeuripreview",
+	"vkshare",
+	"redditbot",
+	"embedly",
+	"iframely",
+	"mastodon",
+}
+
+// IsLinkPreviewer reports whether a request comes from a link preview bot.
+func IsLinkPreviewer(userAgent string) bool {
+	userAgent = strings.ToLower(userAgent)
+	for _, agent := range previewAgents {
+		if strings.Contains(userAgent, agent) {
+			return true
+		}
+	}
+	return false
+}
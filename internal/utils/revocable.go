@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+)
+
+// RevocableHash derives the full hash of a revocable link from the full hash
+// of the file, like BindHash does for bound links. The link is saved in the
+// store under id, and stops working once it's revoked there.
+func RevocableHash(fullHash string, id string) string {
+	mac := hmac.New(sha256.New, []byte(fullHash))
+	mac.Write([]byte("link:" + id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RevocableFileLink returns a stream link to a file that works until the
+// link saved under id is revoked.
+func RevocableFileLink(channelID int64, messageID int, fullHash string, id string) string {
+	hash := GetShortHash(RevocableHash(fullHash, id))
+	return FileLink("stream", channelID, messageID, hash) + "&link=" + url.QueryEscape(id)
+}
+
+// LinkRecordID returns the ID of the saved link a link was made from, the
+// one-time, geo-fenced or revocable link it carries the ID of, or value
+// itself when it's an ID rather than a link.
+func LinkRecordID(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.RawQuery == "" {
+		return value
+	}
+	query := u.Query()
+	for _, name := range []string{"link", "once", "geo"} {
+		if id := query.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ShellQuote quotes s as a single argument for POSIX shells.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// DownloadCommands returns curl, wget and aria2c command lines that download
+// link to fileName in the current directory.
+func DownloadCommands(link string, fileName string) []string {
+	fileName = filepath.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	if fileName == "." || fileName == "/" || fileName == ".." {
+		fileName = "download"
+	}
+	link, fileName = ShellQuote(link), ShellQuote(fileName)
+	return []string{
+		"curl -L -o " + fileName + " " + link,
+		"wget -O " + fileName + " " + link,
+		"aria2c -x 4 -o " + fileName + " " + link,
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var ErrUnsupportedSubtitle = errors.New("unsupported subtitle format")
+
+// IsSubtitle reports whether fileName is a subtitle format that can be
+// converted to WebVTT.
+func IsSubtitle(fileName string) bool {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".srt", ".ass", ".ssa", ".vtt":
+		return true
+	}
+	return false
+}
+
+// ToWebVTT converts an SRT, ASS/SSA or WebVTT subtitle file to WebVTT, picking
+// the format from the file name.
+func ToWebVTT(fileName string, data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".vtt":
+		return []byte(text), nil
+	case ".srt":
+		return []byte(srtToWebVTT(text)), nil
+	case ".ass", ".ssa":
+		return assToWebVTT(text)
+	}
+	return nil, ErrUnsupportedSubtitle
+}
+
+func srtToWebVTT(text string) string {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n\n")
+	for _, line := range strings.Split(text, "\n") {
+		if strings.Contains(line, "-->") {
+			// SRT uses a comma as the decimal separator
+			line = strings.ReplaceAll(line, ",", ".")
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.String()
+}
+
+var assOverrideTags = regexp.MustCompile(`\{[^}]*\}`)
+
+func assToWebVTT(text string) ([]byte, error) {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n\n")
+	var inEvents bool
+	var format []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Format":
+			format = strings.Split(value, ",")
+			for i := range format {
+				format[i] = strings.TrimSpace(format[i])
+			}
+		case "Dialogue":
+			if format == nil {
+				return nil, errors.New("subtitle has no event format")
+			}
+			fields := strings.SplitN(strings.TrimSpace(value), ",", len(format))
+			if len(fields) != len(format) {
+				continue
+			}
+			var start, end, cue string
+			for i, name := range format {
+				switch name {
+				case "Start":
+					start = assTimestamp(fields[i])
+				case "End":
+					end = assTimestamp(fields[i])
+				case "Text":
+					cue = fields[i]
+				}
+			}
+			cue = assOverrideTags.ReplaceAllString(cue, "")
+			cue = strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(cue)
+			fmt.Fprintf(&out, "%s --> %s\n%s\n\n", start, end, cue)
+		}
+	}
+	return []byte(out.String()), nil
+}
+
+// assTimestamp converts an ASS timestamp (H:MM:SS.cc) to WebVTT
+// (HH:MM:SS.mmm).
+func assTimestamp(value string) string {
+	var h, m, s, cs int
+	fmt.Sscanf(strings.TrimSpace(value), "%d:%d:%d.%d", &h, &m, &s, &cs)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, cs*10)
+}
+
+// VTTText returns the text of the cues in a WebVTT file, one cue per line.
+func VTTText(vtt []byte) string {
+	var lines []string
+	for _, block := range strings.Split(strings.ReplaceAll(string(vtt), "\r\n", "\n"), "\n\n") {
+		cue := strings.Split(strings.TrimSpace(block), "\n")
+		for i, line := range cue {
+			if strings.Contains(line, "-->") {
+				if text := strings.TrimSpace(strings.Join(cue[i+1:], " ")); text != "" {
+					lines = append(lines, text)
+				}
+				break
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
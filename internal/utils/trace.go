@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const traceDir = "traces"
+
+type TraceHeader struct {
+	ChannelID int64     `json:"channel_id"`
+	MessageID int       `json:"message_id"`
+	FileSize  int64     `json:"file_size"`
+	Start     int64     `json:"start"`
+	End       int64     `json:"end"`
+	Worker    int       `json:"worker"`
+	Time      time.Time `json:"time"`
+}
+
+type TraceChunk struct {
+	Offset int64  `json:"offset"`
+	Limit  int    `json:"limit"`
+	Length int    `json:"length"`
+	SHA1   string `json:"sha1"`
+	Error  string `json:"error,omitempty"`
+}
+
+// StreamTrace records every chunk requested from Telegram for a single
+// stream, so that corrupted downloads can be replayed with `fsb replay`.
+// A nil *StreamTrace records nothing.
+type StreamTrace struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+func NewStreamTrace(header TraceHeader) (*StreamTrace, error) {
+	if err := os.MkdirAll(traceDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	header.Time = time.Now()
+	name := fmt.Sprintf("%s-%d-%d.jsonl", header.Time.Format("20060102-150405.000"), header.MessageID, header.Start)
+	file, err := os.Create(filepath.Join(traceDir, name))
+	if err != nil {
+		return nil, err
+	}
+	t := &StreamTrace{file: file, enc: json.NewEncoder(file)}
+	if err := t.enc.Encode(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *StreamTrace) Record(offset int64, limit int, data []byte, err error) {
+	if t == nil {
+		return
+	}
+	chunk := TraceChunk{Offset: offset, Limit: limit, Length: len(data), SHA1: ChunkChecksum(data)}
+	if err != nil {
+		chunk.Error = err.Error()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.enc.Encode(chunk)
+}
+
+func (t *StreamTrace) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file.Close()
+}
+
+func ChunkChecksum(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func ReadStreamTrace(path string) (*TraceHeader, []TraceChunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, nil, errors.New("trace file is empty")
+	}
+	var header TraceHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, nil, err
+	}
+	var chunks []TraceChunk
+	for scanner.Scan() {
+		var chunk TraceChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return nil, nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+	return &header, chunks, scanner.Err()
+}
@@ -0,0 +1,46 @@
+const history = 60;
+const colors = ["#4caf50", "#2196f3", "#ff9800", "#e91e63", "#9c27b0", "#00bcd4", "#cddc39", "#795548"];
+const samples = [];
+const canvas = document.getElementById("chart");
+const chart = canvas.getContext("2d");
+
+function human(bytes) {
+  const units = ["B/s", "KB/s", "MB/s", "GB/s"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) { bytes /= 1024; i++; }
+  return bytes.toFixed(1) + " " + units[i];
+}
+
+function draw() {
+  canvas.width = canvas.clientWidth;
+  canvas.height = canvas.clientHeight;
+  const workers = [...new Set(samples.flatMap(s => Object.keys(s.workers)))].sort((a, b) => a - b);
+  const peak = Math.max(1, ...samples.map(s => s.total));
+  const x = i => canvas.width - (samples.length - 1 - i) * canvas.width / (history - 1);
+  const y = v => canvas.height - v / peak * (canvas.height - 10);
+  const line = (color, value) => {
+    chart.strokeStyle = color;
+    chart.beginPath();
+    samples.forEach((s, i) => i ? chart.lineTo(x(i), y(value(s))) : chart.moveTo(x(i), y(value(s))));
+    chart.stroke();
+  };
+  chart.lineWidth = 2;
+  line("#eee", s => s.total);
+  chart.setLineDash([4, 4]);
+  line("#eee", s => s.fetched || 0);
+  chart.setLineDash([]);
+  chart.lineWidth = 1;
+  workers.forEach((id, i) => line(colors[i % colors.length], s => s.workers[id] || 0));
+  document.getElementById("legend").innerHTML = workers
+    .map((id, i) => `<span><i style="background:${colors[i % colors.length]}"></i>Worker ${id}</span>`)
+    .join("");
+}
+
+new EventSource("/events/bandwidth").addEventListener("bandwidth", event => {
+  const sample = JSON.parse(event.data);
+  samples.push(sample);
+  if (samples.length > history) samples.shift();
+  document.getElementById("total").textContent = human(sample.total);
+  document.getElementById("fetched").textContent = human(sample.fetched || 0);
+  draw();
+});
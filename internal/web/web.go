@@ -0,0 +1,223 @@
+// Package web renders the pages of the web UI and serves the assets they
+// load. Assets are built into the binary and served under names carrying a
+// hash of their content, like "status.3f2a9c1b.js", so browsers can cache
+// them for good and still get a new version as soon as it's deployed. With
+// WEB_DIR set, pages and assets are read from disk on every request instead,
+// and open pages reload when a file changes, for working on the web UI
+// without rebuilding.
+package web
+
+import (
+	"EverythingSuckz/fsb/config"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed pages assets
+var embedded embed.FS
+
+// AssetsPath is the path the assets are served under.
+const AssetsPath = "/assets/"
+
+// ReloadPath is the path of the server-sent events that tell pages to
+// reload, when WEB_DIR is set.
+const ReloadPath = "/events/reload"
+
+// hashLength is how many hex digits of an asset's hash are put in its name.
+const hashLength = 8
+
+// pollInterval is how often WEB_DIR is checked for changes.
+const pollInterval = 500 * time.Millisecond
+
+// Asset is a file the pages load.
+type Asset struct {
+	Name        string
+	ContentType string
+	Data        []byte
+	// Immutable is set when the asset was asked for by its hashed name, so
+	// it never changes.
+	Immutable bool
+}
+
+// bundle is the pages and assets read from a file system.
+type bundle struct {
+	pages map[string]*template.Template
+	// assets are by plain and hashed name.
+	assets map[string]*Asset
+	// hashed maps plain names to hashed ones.
+	hashed map[string]string
+}
+
+var (
+	builtIn     *bundle
+	builtInOnce sync.Once
+	builtInErr  error
+)
+
+// Dev reports whether WEB_DIR is set.
+func Dev() bool {
+	return config.ValueOf.WebDir != ""
+}
+
+// current returns the bundle to serve from, reading WEB_DIR again every time
+// when it's set.
+func current() (*bundle, error) {
+	if Dev() {
+		return load(os.DirFS(config.ValueOf.WebDir))
+	}
+	builtInOnce.Do(func() {
+		builtIn, builtInErr = load(embedded)
+	})
+	return builtIn, builtInErr
+}
+
+func load(files fs.FS) (*bundle, error) {
+	b := &bundle{
+		pages:  make(map[string]*template.Template),
+		assets: make(map[string]*Asset),
+		hashed: make(map[string]string),
+	}
+	assets, err := fs.ReadDir(files, "assets")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range assets {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		data, err := fs.ReadFile(files, "assets/"+name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		ext := path.Ext(name)
+		hashed := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:])[:hashLength] + ext
+		contentType := mime.TypeByExtension(ext)
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		b.assets[name] = &Asset{Name: name, ContentType: contentType, Data: data}
+		b.assets[hashed] = &Asset{Name: hashed, ContentType: contentType, Data: data, Immutable: true}
+		b.hashed[name] = hashed
+	}
+
+	pages, err := fs.ReadDir(files, "pages")
+	if err != nil {
+		return nil, err
+	}
+	funcs := template.FuncMap{
+		"asset": func(name string) (string, error) {
+			hashed, ok := b.hashed[name]
+			if !ok {
+				return "", fmt.Errorf("unknown asset %q", name)
+			}
+			return AssetsPath + hashed, nil
+		},
+		"liveReload": liveReload,
+	}
+	for _, entry := range pages {
+		if entry.IsDir() {
+			continue
+		}
+		page, err := template.New(entry.Name()).Funcs(funcs).ParseFS(files, "pages/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		b.pages[entry.Name()] = page
+	}
+	return b, nil
+}
+
+// liveReload returns the script that reloads the page when WEB_DIR changes,
+// nothing when it isn't set.
+func liveReload() template.HTML {
+	if !Dev() {
+		return ""
+	}
+	return template.HTML(`<script>new EventSource("` + ReloadPath + `").addEventListener("reload", () => location.reload());</script>`)
+}
+
+// Render writes the page with the given name, like "player.html", executed
+// with data.
+func Render(w io.Writer, name string, data any) error {
+	b, err := current()
+	if err != nil {
+		return err
+	}
+	page, ok := b.pages[name]
+	if !ok {
+		return fmt.Errorf("unknown page %q", name)
+	}
+	return page.Execute(w, data)
+}
+
+// GetAsset returns the asset with the given plain or hashed name.
+func GetAsset(name string) (*Asset, bool, error) {
+	b, err := current()
+	if err != nil {
+		return nil, false, err
+	}
+	asset, ok := b.assets[name]
+	return asset, ok, nil
+}
+
+// Changes sends on the returned channel whenever a file in WEB_DIR changes,
+// until ctx is done. It never sends when WEB_DIR isn't set.
+func Changes(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{})
+	if !Dev() {
+		return changes
+	}
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		last := lastChange()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			if changed := lastChange(); changed != last {
+				last = changed
+				select {
+				case changes <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return changes
+}
+
+// lastChange returns when a file in WEB_DIR last changed, along with the
+// number of files in it so removing one counts as a change too.
+func lastChange() string {
+	var latest time.Time
+	count := 0
+	fs.WalkDir(os.DirFS(config.ValueOf.WebDir), ".", func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		count++
+		if info, err := entry.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return fmt.Sprintf("%d/%d", latest.UnixNano(), count)
+}
@@ -0,0 +1,321 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Options struct {
+	URL      string
+	Output   string
+	Segments int
+	SHA256   string
+}
+
+type segment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Done  int64 `json:"done"`
+}
+
+// state is persisted next to the partial download so that an interrupted
+// download can pick up where each segment left off.
+type state struct {
+	URL      string     `json:"url"`
+	Size     int64      `json:"size"`
+	Segments []*segment `json:"segments"`
+	mu       sync.Mutex
+	path     string
+}
+
+func (s *state) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+func Download(ctx context.Context, opts Options) error {
+	if opts.Segments < 1 {
+		opts.Segments = 1
+	}
+	size, fileName, ranged, err := probe(ctx, opts.URL)
+	if err != nil {
+		return err
+	}
+	if opts.Output == "" {
+		opts.Output = fileName
+	}
+	if !ranged {
+		opts.Segments = 1
+	}
+	partPath := opts.Output + ".fsbpart"
+	st := loadState(partPath+".json", opts.URL, size)
+	if st == nil || !ranged {
+		st = newState(partPath+".json", opts.URL, size, opts.Segments)
+	} else {
+		fmt.Printf("Resuming download of %s\n", opts.Output)
+	}
+	file, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+
+	var written atomic.Int64
+	for _, seg := range st.Segments {
+		written.Add(seg.Done)
+	}
+	stopProgress := printProgress(opts.Output, size, &written)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(st.Segments))
+	for _, seg := range st.Segments {
+		if seg.Start+seg.Done > seg.End {
+			continue
+		}
+		wg.Add(1)
+		go func(seg *segment) {
+			defer wg.Done()
+			if err := fetchSegment(ctx, opts.URL, file, st, seg, ranged, &written); err != nil {
+				errs <- err
+			}
+		}(seg)
+	}
+	wg.Wait()
+	stopProgress()
+	close(errs)
+	closeErr := file.Close()
+	if err := <-errs; err != nil {
+		st.save()
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if written.Load() != size {
+		st.save()
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, written.Load())
+	}
+	if opts.SHA256 != "" {
+		if err := verifyChecksum(partPath, opts.SHA256); err != nil {
+			return err
+		}
+	}
+	os.Remove(st.path)
+	if err := os.Rename(partPath, opts.Output); err != nil {
+		return err
+	}
+	fmt.Printf("Saved to %s\n", opts.Output)
+	return nil
+}
+
+// probe requests the first byte of the file to learn its size, name and
+// whether the server honours range requests.
+func probe(ctx context.Context, link string) (int64, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return 0, "", false, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, "", false, err
+	}
+	defer res.Body.Close()
+	fileName := fileNameFromResponse(res, link)
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		contentRange := res.Header.Get("Content-Range")
+		slash := strings.LastIndex(contentRange, "/")
+		if slash == -1 {
+			return 0, "", false, fmt.Errorf("invalid Content-Range %q", contentRange)
+		}
+		size, err := strconv.ParseInt(contentRange[slash+1:], 10, 64)
+		if err != nil {
+			return 0, "", false, fmt.Errorf("invalid Content-Range %q", contentRange)
+		}
+		return size, fileName, true, nil
+	case http.StatusOK:
+		if res.ContentLength < 0 {
+			return 0, "", false, errors.New("server did not report the file size")
+		}
+		return res.ContentLength, fileName, false, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return 0, "", false, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+}
+
+func fileNameFromResponse(res *http.Response, link string) string {
+	if _, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(params["filename"]); name != "" && name != "." && name != "/" {
+			return name
+		}
+	}
+	if u, err := url.Parse(link); err == nil {
+		return path.Base(u.Path)
+	}
+	return "download"
+}
+
+func newState(statePath string, link string, size int64, segments int) *state {
+	st := &state{URL: link, Size: size, path: statePath}
+	segmentSize := size / int64(segments)
+	if segmentSize == 0 {
+		segmentSize = size
+		segments = 1
+	}
+	for i := 0; i < segments; i++ {
+		start := int64(i) * segmentSize
+		end := start + segmentSize - 1
+		if i == segments-1 {
+			end = size - 1
+		}
+		st.Segments = append(st.Segments, &segment{Start: start, End: end})
+	}
+	return st
+}
+
+func loadState(statePath string, link string, size int64) *state {
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil
+	}
+	st := &state{path: statePath}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil
+	}
+	if st.URL != link || st.Size != size || len(st.Segments) == 0 {
+		return nil
+	}
+	return st
+}
+
+func fetchSegment(ctx context.Context, link string, file *os.File, st *state, seg *segment, ranged bool, written *atomic.Int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return err
+	}
+	from := seg.Start + seg.Done
+	if ranged {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, seg.End))
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// a server or proxy that ignores the range sends the file from its
+	// start, which would be written over the segment
+	switch {
+	case ranged && res.StatusCode != http.StatusPartialContent:
+		return fmt.Errorf("segment %d-%d: expected a partial response, got %s", seg.Start, seg.End, res.Status)
+	case ranged && !strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", from)):
+		return fmt.Errorf("segment %d-%d: unexpected Content-Range %q", seg.Start, seg.End, res.Header.Get("Content-Range"))
+	case !ranged && res.StatusCode != http.StatusOK:
+		return fmt.Errorf("segment %d-%d: %s", seg.Start, seg.End, res.Status)
+	}
+	body := io.LimitReader(res.Body, seg.End-from+1)
+	buf := make([]byte, 256*1024)
+	lastSave := time.Now()
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := file.WriteAt(buf[:n], seg.Start+seg.Done); err != nil {
+				return err
+			}
+			st.mu.Lock()
+			seg.Done += int64(n)
+			st.mu.Unlock()
+			written.Add(int64(n))
+			if time.Since(lastSave) > time.Second {
+				st.save()
+				lastSave = time.Now()
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func verifyChecksum(filePath string, expected string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	fmt.Println("Checksum verified")
+	return nil
+}
+
+func printProgress(name string, size int64, written *atomic.Int64) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		report := func() {
+			current := written.Load()
+			percent := float64(100)
+			if size > 0 {
+				percent = float64(current) * 100 / float64(size)
+			}
+			fmt.Printf("\r%s: %d/%d bytes (%.1f%%)", name, current, size, percent)
+		}
+		for {
+			select {
+			case <-done:
+				report()
+				fmt.Println()
+				return
+			case <-ticker.C:
+				report()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,236 @@
+// Package fsb runs the bot inside another Go program, so a service can mount
+// the stream links under its own HTTP server instead of running the bot as a
+// separate process.
+//
+// The bot keeps its settings and workers in the process, so a program can
+// only start one server:
+//
+//	server, err := fsb.StartServer(ctx, fsb.Config{
+//		Env: map[string]string{
+//			"API_ID":      "...",
+//			"API_HASH":    "...",
+//			"BOT_TOKEN":   "...",
+//			"LOG_CHANNEL": "...",
+//			// where the handler is mounted, for the links the bot sends
+//			"HOST": "https://example.com/files",
+//		},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer server.Close()
+//	mux.Handle("/files/", http.StripPrefix("/files", server.Handler()))
+package fsb
+
+import (
+	"EverythingSuckz/fsb/config"
+	"EverythingSuckz/fsb/internal/analytics"
+	"EverythingSuckz/fsb/internal/audit"
+	"EverythingSuckz/fsb/internal/bot"
+	"EverythingSuckz/fsb/internal/cache"
+	"EverythingSuckz/fsb/internal/channels"
+	"EverythingSuckz/fsb/internal/commands"
+	"EverythingSuckz/fsb/internal/diskcache"
+	"EverythingSuckz/fsb/internal/federation"
+	"EverythingSuckz/fsb/internal/geoip"
+	"EverythingSuckz/fsb/internal/hls"
+	"EverythingSuckz/fsb/internal/jobs"
+	"EverythingSuckz/fsb/internal/objects"
+	"EverythingSuckz/fsb/internal/patterns"
+	"EverythingSuckz/fsb/internal/plugins"
+	"EverythingSuckz/fsb/internal/router"
+	"EverythingSuckz/fsb/internal/routes"
+	"EverythingSuckz/fsb/internal/store"
+	"EverythingSuckz/fsb/internal/stream"
+	"EverythingSuckz/fsb/internal/transcode"
+	"EverythingSuckz/fsb/internal/types"
+	"EverythingSuckz/fsb/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ErrStarted is returned by StartServer when a server was already started
+// in the process.
+var ErrStarted = errors.New("fsb: a server was already started")
+
+// Config configures the server started by StartServer.
+type Config struct {
+	// Env holds the settings described in the README by the names of their
+	// environment variables, like BOT_TOKEN or LOG_CHANNEL. They're set in
+	// the process's environment, so they take precedence over the ones in
+	// it and in fsb.env.
+	Env map[string]string
+	// Logger is what the bot logs to. The bot logs to the console and to
+	// logs/app.log when it's nil.
+	Logger *zap.Logger
+	// Version is reported by the handler's root route.
+	Version string
+}
+
+// Server is a running bot: its main bot and workers, and the handler
+// serving its routes.
+type Server struct {
+	handler   router.Router
+	admin     router.Router
+	log       *zap.Logger
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+}
+
+var started sync.Mutex
+
+// StartServer loads the settings in cfg, starts the bot and its workers,
+// and returns the server whose handler serves the stream links. The
+// background work of the bot stops when ctx is done or the server is
+// closed, and Close stops the bot's clients.
+func StartServer(ctx context.Context, cfg Config) (_ *Server, err error) {
+	if !started.TryLock() {
+		return nil, ErrStarted
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	// stops what was started, most recent first
+	var stops []func()
+	// a server that failed to start can be started again
+	defer func() {
+		if err != nil {
+			cancel()
+			for i := len(stops) - 1; i >= 0; i-- {
+				stops[i]()
+			}
+			started.Unlock()
+		}
+	}()
+	for name, value := range cfg.Env {
+		if err := os.Setenv(name, value); err != nil {
+			return nil, fmt.Errorf("failed to set %s: %w", name, err)
+		}
+	}
+	if cfg.Logger != nil {
+		utils.Logger = cfg.Logger
+	} else {
+		utils.InitLogger(config.ValueOf.Dev)
+	}
+	log := utils.Logger
+	mainLogger := log.Named("Main")
+	mainLogger.Info("Starting server")
+	if err := config.Load(log, nil); err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	// the routes of the patterns are registered with the others
+	if err := patterns.Init(log); err != nil {
+		return nil, fmt.Errorf("failed to set up URL patterns: %w", err)
+	}
+	handler, admin, err := newRouter(log, cfg.Version)
+	if err != nil {
+		return nil, err
+	}
+	server := &Server{handler: handler, admin: admin, log: mainLogger, cancel: cancel}
+
+	commands.UseUserSession(bot.UserBot.Client)
+	mainBot, err := bot.StartClient(log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start main bot: %w", err)
+	}
+	stops = append(stops, mainBot.Stop)
+	cache.InitCache(log)
+	if err := store.InitStore(log, config.ValueOf.DatabaseURL); err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	stops = append(stops, func() { store.GetStore().Close() })
+	if err := channels.Load(log); err != nil {
+		return nil, fmt.Errorf("failed to load storage channels: %w", err)
+	}
+	if err := diskcache.Init(log); err != nil {
+		return nil, fmt.Errorf("failed to open the disk cache: %w", err)
+	}
+	if err := objects.Init(log); err != nil {
+		return nil, fmt.Errorf("failed to set up the object store: %w", err)
+	}
+	if err := federation.Init(log); err != nil {
+		return nil, fmt.Errorf("failed to set up federation: %w", err)
+	}
+	if err := geoip.Init(log); err != nil {
+		return nil, fmt.Errorf("failed to load the GeoIP database: %w", err)
+	}
+	hls.Init(log)
+	transcode.Init(log)
+	plugins.Start(ctx, log)
+	workers, err := bot.StartWorkers(log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start workers: %w", err)
+	}
+	workers.AddDefaultClient(mainBot, mainBot.Self)
+	bot.StartWarmup(ctx)
+	bot.BalanceLoad(stream.WorkerStreams)
+	bot.StartScaler(ctx, stream.WorkerStreams)
+	jobs.Start(ctx, log)
+	bot.StartUserBot(log)
+	audit.Start(log, mainBot)
+	analytics.Start(log)
+	return server, nil
+}
+
+// Handler returns the handler serving the stream links, the player and the
+// other public routes, along with the admin API unless ADMIN_PORT is set.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// AdminHandler returns the handler serving the admin API and metrics when
+// ADMIN_PORT is set, which the program should serve on that port with
+// client certificate authentication, or nil when Handler serves them.
+func (s *Server) AdminHandler() http.Handler {
+	if s.admin == s.handler {
+		return nil
+	}
+	return s.admin
+}
+
+// Close stops the bot's background work and clients and closes its store.
+// The handler can't serve files afterwards, so the program should shut down
+// its HTTP server first.
+func (s *Server) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.cancel()
+		bot.StopClients()
+		err = store.GetStore().Close()
+		s.log.Info("Server stopped")
+	})
+	return err
+}
+
+// newRouter returns the public router and the one serving the admin API,
+// which is the same router unless ADMIN_PORT is set.
+func newRouter(log *zap.Logger, version string) (router.Router, router.Router, error) {
+	if err := router.SetTrustedProxies(config.ValueOf.TrustedProxies); err != nil {
+		return nil, nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	r, err := router.New(config.ValueOf.HTTPRouter, config.ValueOf.Dev)
+	if err != nil {
+		return nil, nil, err
+	}
+	adminRouter := r
+	if config.ValueOf.AdminPort != 0 {
+		adminRouter, _ = router.New(config.ValueOf.HTTPRouter, config.ValueOf.Dev)
+	}
+	routes.Load(log, r, adminRouter, stream.NewWorkerService(log.Named("stream")))
+	// registered after the routes, so it's behind their middleware too
+	startTime := time.Now()
+	r.GET("/", func(ctx *router.Context) {
+		ctx.JSON(http.StatusOK, types.RootResponse{
+			Message: "Server is running.",
+			Ok:      true,
+			Uptime:  utils.TimeFormat(uint64(time.Since(startTime).Seconds())),
+			Version: version,
+		})
+	})
+	return r, adminRouter, nil
+}
@@ -0,0 +1,123 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+// Package plugin is what plugins of the bot are built with. A plugin is a
+// program the bot starts when it's listed in PLUGINS, and calls the hooks of
+// over JSON-RPC on its stdin and stdout. Its main function calls Serve with
+// the hooks it implements, embedding Base for the others. Plugins must log
+// to stderr, which ends up in the bot's log, as stdout carries the calls.
+package plugin
+
+import (
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+)
+
+// LinkEvent is a link to a file that was handed out.
+type LinkEvent struct {
+	ChannelID int64
+	MessageID int
+	FileName  string
+	FileSize  int64
+	MimeType  string
+	// UserID is the user the bot sent the link to, 0 for links created with
+	// the admin API.
+	UserID int64
+	Link   string
+}
+
+// StreamEvent is a request for a file. Sent, DurationMs and Error are only
+// set when the stream finished.
+type StreamEvent struct {
+	ChannelID  int64
+	MessageID  int
+	FileName   string
+	FileSize   int64
+	MimeType   string
+	RemoteAddr string
+	// Range is the Range header of the request, "" for the whole file.
+	Range      string
+	Worker     int
+	Sent       int64
+	DurationMs int64
+	Error      string
+}
+
+// UploadEvent is a file that was stored, sent to the bot or uploaded
+// through the API.
+type UploadEvent struct {
+	ChannelID  int64
+	MessageID  int
+	FileName   string
+	FileSize   int64
+	MimeType   string
+	UploadedBy int64
+	// Backend is where the file is stored: telegram, s3 or both.
+	Backend string
+}
+
+// Hooks are called on the bot's lifecycle events. Errors returned by
+// StreamStarted deny the stream, with the error as the reason given to the
+// client. Errors returned by the other hooks are only logged.
+type Hooks interface {
+	LinkCreated(event LinkEvent) error
+	StreamStarted(event StreamEvent) error
+	StreamFinished(event StreamEvent) error
+	UploadStored(event UploadEvent) error
+}
+
+// Base implements every hook as a no-op.
+type Base struct{}
+
+func (Base) LinkCreated(LinkEvent) error      { return nil }
+func (Base) StreamStarted(StreamEvent) error  { return nil }
+func (Base) StreamFinished(StreamEvent) error { return nil }
+func (Base) UploadStored(UploadEvent) error   { return nil }
+
+// ServiceName is the name the hooks are called under, like
+// "Plugin.LinkCreated".
+const ServiceName = "Plugin"
+
+// Serve answers the bot's calls with hooks until the bot closes stdin.
+func Serve(hooks Hooks) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(ServiceName, &service{hooks}); err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(stdio{}))
+	return nil
+}
+
+// service adapts Hooks to the method signatures net/rpc expects.
+type service struct {
+	hooks Hooks
+}
+
+func (s *service) LinkCreated(event LinkEvent, _ *struct{}) error {
+	return s.hooks.LinkCreated(event)
+}
+
+func (s *service) StreamStarted(event StreamEvent, _ *struct{}) error {
+	return s.hooks.StreamStarted(event)
+}
+
+func (s *service) StreamFinished(event StreamEvent, _ *struct{}) error {
+	return s.hooks.StreamFinished(event)
+}
+
+func (s *service) UploadStored(event UploadEvent, _ *struct{}) error {
+	return s.hooks.UploadStored(event)
+}
+
+// stdio is the connection to the bot.
+type stdio struct{}
+
+var _ io.ReadWriteCloser = stdio{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return os.Stdin.Close() }
@@ -0,0 +1,95 @@
+// This file is a part of EverythingSuckz/TG-FileStreamBot
+// And is licenced under the Affero General Public License.
+// Any distributions of this code MUST be accompanied by a copy of the AGPL
+// with proper attribution to the original author(s).
+
+// Package whisper is a minimal client for speech to text endpoints that
+// follow OpenAI's audio transcription API, which Whisper servers like
+// whisper.cpp and faster-whisper-server implement as well.
+package whisper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Options struct {
+	// URL is the transcription endpoint, like
+	// https://api.openai.com/v1/audio/transcriptions.
+	URL    string
+	APIKey string
+	Model  string
+}
+
+type Client struct {
+	opts Options
+	http *http.Client
+}
+
+func New(opts Options) (*Client, error) {
+	endpoint, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", opts.URL)
+	}
+	if opts.Model == "" {
+		opts.Model = "whisper-1"
+	}
+	return &Client{opts: opts, http: http.DefaultClient}, nil
+}
+
+// Transcribe sends the audio read from r to the endpoint and returns the
+// transcript as WebVTT. fileName tells the endpoint the audio's format.
+func (c *Client) Transcribe(ctx context.Context, fileName string, r io.Reader) ([]byte, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeForm(form, c.opts.Model, fileName, r))
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.URL, pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if c.opts.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.opts.APIKey)
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transcription failed: %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func writeForm(form *multipart.Writer, model string, fileName string, r io.Reader) error {
+	if err := form.WriteField("model", model); err != nil {
+		return err
+	}
+	if err := form.WriteField("response_format", "vtt"); err != nil {
+		return err
+	}
+	part, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return err
+	}
+	return form.Close()
+}